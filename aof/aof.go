@@ -0,0 +1,321 @@
+// Package aof implements the append-only file, that logs the write commands
+// executed by the server so that they can be replayed at startup.
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/resp"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/golang/glog"
+)
+
+// FsyncPolicy defines when the append-only file is flushed to disk.
+type FsyncPolicy int
+
+// List of fsync policies.
+const (
+	// FsyncAlways flushes the file after each command.
+	FsyncAlways FsyncPolicy = iota
+
+	// FsyncEverySec flushes the file every second.
+	FsyncEverySec
+
+	// FsyncNo leaves it to the operating system to flush the file.
+	FsyncNo
+)
+
+// ParseFsyncPolicy parses the fsync policy s, one of "always", "everysec"
+// or "no", as for the appendfsync directive of Redis.
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch strings.ToLower(s) {
+	case "always":
+		return FsyncAlways, nil
+	case "everysec":
+		return FsyncEverySec, nil
+	case "no":
+		return FsyncNo, nil
+	}
+	return 0, errors.New("aof: invalid fsync policy: " + s)
+}
+
+// ErrRewriteInProgress is returned by Rewrite when a rewrite is already in
+// progress.
+var ErrRewriteInProgress = errors.New("aof: rewrite already in progress")
+
+// Default is the append-only file of the server, or nil if it is disabled.
+var Default *File
+
+// Static check to make sure *File implements the cmd.Propagator interface.
+var _ cmd.Propagator = (*File)(nil)
+
+// File is an append-only file. It is the propagator of the write commands,
+// and its lock is held while a write command executes, so the write
+// commands execute one at a time, on all databases. The commands are only
+// buffered under this lock, they are written to the file and flushed to
+// disk by Flush once it is released.
+type File struct {
+	sync.Mutex
+
+	path   string
+	policy FsyncPolicy
+	stop   chan struct{}
+	done   chan struct{}
+
+	// wmu serializes the writes to the file, and wbuf holds the commands
+	// being written. It is locked before fmu.
+	wmu  sync.Mutex
+	wbuf *bytes.Buffer
+
+	// fmu protects the fields below, so that the file can be written to
+	// by the rewrite while a write command executes.
+	fmu    sync.Mutex
+	f      *os.File
+	lastDB int
+
+	// the commands propagated but not yet written to the file
+	pend *bytes.Buffer
+
+	// the commands propagated while the file is rewritten, and the last
+	// database they selected
+	rwBuf  *bytes.Buffer
+	rwDB   int
+	rwDone chan struct{}
+}
+
+// Open opens the append-only file at path, creating it if it does not
+// exist. The commands are appended to the file and flushed according to
+// policy.
+func Open(path string, policy FsyncPolicy) (*File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	af := &File{
+		path:   path,
+		policy: policy,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		wbuf:   new(bytes.Buffer),
+		f:      f,
+		lastDB: -1,
+		pend:   new(bytes.Buffer),
+	}
+	go af.syncLoop()
+	return af, nil
+}
+
+// syncLoop flushes the file every second with the FsyncEverySec policy,
+// until the file is closed.
+func (f *File) syncLoop() {
+	defer close(f.done)
+	if f.policy != FsyncEverySec {
+		<-f.stop
+		return
+	}
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-t.C:
+			f.fmu.Lock()
+			if err := f.f.Sync(); err != nil {
+				glog.Errorf("append-only file: %s", err)
+			}
+			f.fmu.Unlock()
+		}
+	}
+}
+
+// Close waits for the rewrite in progress, if any, then flushes and closes
+// the file.
+func (f *File) Close() error {
+	f.fmu.Lock()
+	done := f.rwDone
+	f.fmu.Unlock()
+	if done != nil {
+		<-done
+	}
+
+	close(f.stop)
+	<-f.done
+
+	f.Flush()
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+	err := f.f.Sync()
+	if cerr := f.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Propagate buffers the write command args, executed on the database at
+// index dbix, to be appended to the file by Flush. Relative expirations
+// and non-deterministic commands are translated based on the response res,
+// so that the command has the same effect when it is replayed.
+func (f *File) Propagate(dbix int, args []string, res interface{}) {
+	args = translate(args, res)
+	if args == nil {
+		return
+	}
+
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+
+	encode(f.pend, dbix, &f.lastDB, args)
+	if f.rwBuf != nil {
+		encode(f.rwBuf, dbix, &f.rwDB, args)
+	}
+}
+
+// Flush appends the commands propagated so far to the file, in the order
+// in which they were propagated, and flushes it to disk with the
+// FsyncAlways policy. The commands propagated concurrently by other
+// connections are appended and flushed together.
+func (f *File) Flush() {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+
+	f.fmu.Lock()
+	f.pend, f.wbuf = f.wbuf, f.pend
+	file := f.f
+	f.fmu.Unlock()
+
+	// The commands may have been appended by a concurrent call
+	if f.wbuf.Len() == 0 {
+		return
+	}
+	defer f.wbuf.Reset()
+	if _, err := file.Write(f.wbuf.Bytes()); err != nil {
+		glog.Errorf("append-only file: %s", err)
+	} else if f.policy == FsyncAlways {
+		if err := file.Sync(); err != nil {
+			glog.Errorf("append-only file: %s", err)
+		}
+	}
+}
+
+// encode writes the command args to buf, preceded by a SELECT command if
+// dbix is not the last selected database, which is then updated.
+func encode(buf *bytes.Buffer, dbix int, lastDB *int, args []string) {
+	if dbix != *lastDB {
+		resp.Encode(buf, []string{"SELECT", strconv.Itoa(dbix)})
+		*lastDB = dbix
+	}
+	resp.Encode(buf, args)
+}
+
+// Rewrite starts the rewrite of the file in a new goroutine, so that it
+// holds the minimal set of commands to recreate the keyspace. The file
+// starts with a snapshot of the server, followed by the commands executed
+// while the rewrite was in progress. The write commands execute normally
+// during the rewrite. It returns ErrRewriteInProgress if a rewrite is
+// already in progress.
+func (f *File) Rewrite() error {
+	// No write command may execute while the snapshot is taken, so that
+	// the following ones are all in the rewrite buffer.
+	f.Lock()
+	defer f.Unlock()
+
+	f.fmu.Lock()
+	if f.rwBuf != nil {
+		f.fmu.Unlock()
+		return ErrRewriteInProgress
+	}
+	f.rwBuf, f.rwDB = new(bytes.Buffer), -1
+	f.rwDone = make(chan struct{})
+	f.fmu.Unlock()
+
+	srv.DefaultServer.Lock()
+	sn := srv.DefaultServer.Snapshot()
+	srv.DefaultServer.Unlock()
+
+	go func() {
+		if err := f.rewrite(sn); err != nil {
+			glog.Errorf("append-only file rewrite: %s", err)
+		}
+	}()
+	return nil
+}
+
+// rewrite writes the snapshot sn to a temporary file, followed by the
+// commands executed since it was taken, and replaces the file with it.
+func (f *File) rewrite(sn *srv.Snapshot) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), "temp-rewrite-*.aof")
+	if err != nil {
+		sn.Discard()
+		f.endRewrite()
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	err = sn.Write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		f.endRewrite()
+		return err
+	}
+
+	// The file is swapped while no command can be propagated or written.
+	// The commands not yet written are dropped, they are either in the
+	// snapshot or in the rewrite buffer.
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+	defer f.endRewriteLocked()
+
+	if _, err = tmp.Write(f.rwBuf.Bytes()); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), f.path); err != nil {
+		return err
+	}
+	if cerr := f.f.Close(); cerr != nil {
+		glog.Errorf("append-only file: %s", cerr)
+	}
+	f.f, f.lastDB = tmp, f.rwDB
+	f.pend.Reset()
+	glog.V(1).Infof("append-only file rewritten: %s", f.path)
+	return nil
+}
+
+// endRewrite ends the rewrite in progress.
+func (f *File) endRewrite() {
+	f.fmu.Lock()
+	defer f.fmu.Unlock()
+	f.endRewriteLocked()
+}
+
+// endRewriteLocked ends the rewrite in progress. The fmu lock must be held.
+func (f *File) endRewriteLocked() {
+	f.rwBuf = nil
+	close(f.rwDone)
+	f.rwDone = nil
+}
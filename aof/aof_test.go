@@ -0,0 +1,433 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	_ "github.com/PuerkitoBio/gred/cmd/connection"
+	_ "github.com/PuerkitoBio/gred/cmd/keys"
+	_ "github.com/PuerkitoBio/gred/cmd/lists"
+	_ "github.com/PuerkitoBio/gred/cmd/sets"
+	_ "github.com/PuerkitoBio/gred/cmd/streams"
+	_ "github.com/PuerkitoBio/gred/cmd/strings"
+	"github.com/PuerkitoBio/gred/resp"
+	"github.com/PuerkitoBio/gred/srv"
+)
+
+func TestTranslate(t *testing.T) {
+	defer func(fn func() time.Time) { now = fn }(now)
+	now = func() time.Time { return time.Unix(1000, 0) }
+
+	cases := []struct {
+		args []string
+		res  interface{}
+		exp  []string
+	}{
+		0:  {[]string{"set", "k", "v"}, cmd.OKVal, []string{"set", "k", "v"}},
+		1:  {[]string{"expire", "k", "10"}, true, []string{"PEXPIREAT", "k", "1010000"}},
+		2:  {[]string{"expire", "k", "10"}, false, nil},
+		3:  {[]string{"PEXPIRE", "k", "10"}, true, []string{"PEXPIREAT", "k", "1000010"}},
+		4:  {[]string{"expireat", "k", "2000"}, true, []string{"PEXPIREAT", "k", "2000000"}},
+		5:  {[]string{"pexpireat", "k", "2000"}, true, []string{"pexpireat", "k", "2000"}},
+		6:  {[]string{"setex", "k", "10", "v"}, cmd.OKVal, []string{"SET", "k", "v", "PXAT", "1010000"}},
+		7:  {[]string{"psetex", "k", "10", "v"}, cmd.OKVal, []string{"SET", "k", "v", "PXAT", "1000010"}},
+		8:  {[]string{"set", "k", "v", "NX", "ex", "10", "GET"}, nil, []string{"set", "k", "v", "NX", "PXAT", "1010000", "GET"}},
+		9:  {[]string{"set", "k", "v", "exat", "2000"}, cmd.OKVal, []string{"set", "k", "v", "PXAT", "2000000"}},
+		10: {[]string{"set", "k", "v", "keepttl"}, cmd.OKVal, []string{"set", "k", "v", "keepttl"}},
+		11: {[]string{"getex", "k"}, "v", nil},
+		12: {[]string{"getex", "k", "px", "10"}, "v", []string{"PEXPIREAT", "k", "1000010"}},
+		13: {[]string{"getex", "k", "px", "10"}, nil, nil},
+		14: {[]string{"getex", "k", "persist"}, "v", []string{"PERSIST", "k"}},
+		15: {[]string{"restore", "k", "10", "p"}, cmd.OKVal, []string{"restore", "k", "1000010", "p", "ABSTTL"}},
+		16: {[]string{"restore", "k", "0", "p", "replace"}, cmd.OKVal, []string{"restore", "k", "0", "p", "replace"}},
+		17: {[]string{"restore", "k", "2000", "p", "absttl"}, cmd.OKVal, []string{"restore", "k", "2000", "p", "absttl"}},
+		18: {[]string{"spop", "k"}, "a", []string{"SREM", "k", "a"}},
+		19: {[]string{"spop", "k", "2"}, []string{"a", "b"}, []string{"SREM", "k", "a", "b"}},
+		20: {[]string{"spop", "k", "2"}, []string{}, nil},
+		21: {[]string{"spop", "k"}, nil, nil},
+		22: {[]string{"xadd", "s", "*", "f", "v"}, "1-0", []string{"xadd", "s", "1-0", "f", "v"}},
+		23: {[]string{"xadd", "s", "nomkstream", "maxlen", "~", "10", "limit", "5", "1-*", "*", "v"}, "1-1", []string{"xadd", "s", "nomkstream", "maxlen", "~", "10", "limit", "5", "1-1", "*", "v"}},
+		24: {[]string{"xadd", "s", "minid", "5", "*", "f", "v"}, "6-0", []string{"xadd", "s", "minid", "5", "6-0", "f", "v"}},
+		25: {[]string{"xadd", "s", "nomkstream", "*", "f", "v"}, nil, nil},
+		26: {[]string{"xreadgroup", "group", "g", "c", "count", "1", "block", "0", "streams", "s", ">"}, nil, []string{"xreadgroup", "group", "g", "c", "count", "1", "streams", "s", ">"}},
+		27: {[]string{"xreadgroup", "group", "g", "c", "streams", "block", ">"}, nil, []string{"xreadgroup", "group", "g", "c", "streams", "block", ">"}},
+	}
+	for i, c := range cases {
+		got := translate(c.args, c.res)
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
+		}
+	}
+}
+
+// setup replaces the server with a new one, and returns the path of an
+// append-only file in a temporary directory.
+func setup(t testing.TB) string {
+	s := srv.DefaultServer
+	srv.DefaultServer = srv.NewServer(srv.DefaultDatabases)
+	t.Cleanup(func() {
+		srv.DefaultServer = s
+		cmd.SetPropagator(nil)
+	})
+	return filepath.Join(t.TempDir(), "appendonly.aof")
+}
+
+// reload replaces the server with a new one and loads the file at path.
+func reload(t *testing.T, path string, truncate bool) error {
+	cmd.SetPropagator(nil)
+	srv.DefaultServer = srv.NewServer(srv.DefaultDatabases)
+	return Load(path, truncate)
+}
+
+func exec(t *testing.T, conn srv.Conn, args ...string) interface{} {
+	res, err := cmd.Exec(conn, args)
+	if err != nil {
+		t.Fatalf("%v: %s", args, err)
+	}
+	return res
+}
+
+// records returns the commands in the file at path.
+func records(t *testing.T, path string) [][]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var recs [][]string
+	br := bufio.NewReader(f)
+	for {
+		ar, err := resp.DecodeRequest(br)
+		if err != nil {
+			return recs
+		}
+		recs = append(recs, ar)
+	}
+}
+
+func TestPropagateLoad(t *testing.T) {
+	path := setup(t)
+	f, err := Open(path, FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.SetPropagator(f)
+
+	var conn replayConn
+	exec(t, &conn, "set", "a", "1")
+	exec(t, &conn, "incr", "a")
+	exec(t, &conn, "set", "b", "x", "ex", "100")
+	exec(t, &conn, "select", "3")
+	exec(t, &conn, "rpush", "l", "1", "2", "3")
+	exec(t, &conn, "lpop", "l")
+	exec(t, &conn, "sadd", "s", "a", "b", "c")
+	exec(t, &conn, "spop", "s")
+	exec(t, &conn, "get", "nope")
+	cmd.Exec(&conn, []string{"incr", "l"})
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reads and failed commands are not logged
+	recs := records(t, path)
+	if len(recs) != 9 {
+		t.Fatalf("expected 9 records, got %d: %v", len(recs), recs)
+	}
+	if exp := []string{"SELECT", "3"}; !reflect.DeepEqual(recs[4], exp) {
+		t.Errorf("expected %v, got %v", exp, recs[4])
+	}
+	if recs[8][0] != "SREM" {
+		t.Errorf("expected SREM, got %v", recs[8])
+	}
+
+	smembers := exec(t, &conn, "smembers", "s").([]string)
+	sort.Strings(smembers)
+	if err := reload(t, path, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := exec(t, &replayConn{}, "get", "a"); got != "2" {
+		t.Errorf("expected a to be 2, got %v", got)
+	}
+	if got := exec(t, &replayConn{}, "pttl", "b").(int64); got <= 99000 || got > 100000 {
+		t.Errorf("expected b to expire in 100s, got %dms", got)
+	}
+	conn = replayConn{dbix: 3}
+	if got, exp := exec(t, &conn, "lrange", "l", "0", "-1"), []string{"2", "3"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+	got := exec(t, &conn, "smembers", "s").([]string)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, smembers) {
+		t.Errorf("expected %v, got %v", smembers, got)
+	}
+	if n := srv.DefaultServer.Dirty(); n != 0 {
+		t.Errorf("expected no change after load, got %d", n)
+	}
+}
+
+func TestBlockingPropagation(t *testing.T) {
+	path := setup(t)
+	f, err := Open(path, FsyncNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.SetPropagator(f)
+
+	done := make(chan interface{})
+	go func() {
+		res, _ := cmd.Exec(&replayConn{}, []string{"blmove", "src", "dst", "left", "right", "0"})
+		done <- res
+	}()
+	// wait for the blocked command to release the propagator
+	time.Sleep(50 * time.Millisecond)
+	exec(t, &replayConn{}, "rpush", "src", "a", "b")
+	if got := <-done; got != "a" {
+		t.Fatalf("expected a, got %v", got)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := [][]string{
+		{"SELECT", "0"},
+		{"rpush", "src", "a", "b"},
+		{"lmpop", "1", "src", "left", "count", "1"},
+		{"rpush", "dst", "a"},
+	}
+	if got := records(t, path); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestLoadTruncated(t *testing.T) {
+	path := setup(t)
+	data := "*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n*3\r\n$3\r\nset\r\n$1\r\nb\r\n$1"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reload(t, path, false); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected %v, got %v", ErrTruncated, err)
+	}
+	if err := reload(t, path, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := exec(t, &replayConn{}, "get", "a"); got != "1" {
+		t.Errorf("expected a to be 1, got %v", got)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if fi.Size() != 27 {
+		t.Errorf("expected the file to be truncated to 27 bytes, got %d", fi.Size())
+	}
+	// the truncated file is now complete
+	if err := reload(t, path, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte("*1\r\n$4\r\nnope\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reload(t, path, true); err == nil {
+		t.Error("expected an error for an unknown command")
+	}
+	if err := reload(t, filepath.Join(t.TempDir(), "none.aof"), false); err != nil {
+		t.Errorf("expected no error for a missing file, got %v", err)
+	}
+}
+
+func TestClaimPropagation(t *testing.T) {
+	path := setup(t)
+	f, err := Open(path, FsyncNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.SetPropagator(f)
+
+	conn := &replayConn{}
+	exec(t, conn, "xadd", "s", "1-1", "f", "v")
+	exec(t, conn, "xadd", "s", "2-1", "f", "v")
+	exec(t, conn, "xadd", "s", "3-1", "f", "v")
+	exec(t, conn, "xgroup", "create", "s", "g", "0")
+	exec(t, conn, "xreadgroup", "group", "g", "c1", "streams", "s", ">")
+	exec(t, conn, "xdel", "s", "3-1")
+	// The entries are claimed once idle for 10ms, which they would not be
+	// if the commands were replayed as is
+	time.Sleep(20 * time.Millisecond)
+	exec(t, conn, "xclaim", "s", "g", "c2", "10", "1-1")
+	exec(t, conn, "xautoclaim", "s", "g", "c3", "10", "2-1")
+	exec(t, conn, "xclaim", "s", "g", "c4", "10", "1-1")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pending := func() []interface{} {
+		var pes []interface{}
+		for _, pe := range exec(t, conn, "xpending", "s", "g", "-", "+", "10").([]interface{}) {
+			pe := pe.([]interface{})
+			pes = append(pes, []interface{}{pe[0], pe[1], pe[3]})
+		}
+		return pes
+	}
+	exp := []interface{}{
+		[]interface{}{"1-1", "c2", int64(2)},
+		[]interface{}{"2-1", "c3", int64(2)},
+	}
+	if got := pending(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+
+	if err := reload(t, path, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := pending(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v after load, got %v", exp, got)
+	}
+	consumers := exec(t, conn, "xinfo", "consumers", "s", "g").([]interface{})
+	if len(consumers) != 4 {
+		t.Errorf("expected 4 consumers after load, got %d", len(consumers))
+	}
+}
+
+func TestLoadExpired(t *testing.T) {
+	path := setup(t)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+	future := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+	for _, args := range [][]string{
+		// The keys written after they expired in the file existed when the
+		// commands were executed, so the writes do not create new keys
+		{"set", "k", "a", "pxat", past},
+		{"append", "k", "b"},
+		{"set", "k2", "a", "pxat", past},
+		{"set", "k2", "b", "keepttl"},
+		{"rpush", "l", "a"},
+		{"pexpireat", "l", past},
+		{"rename", "l", "l2"},
+		{"rpush", "l2", "b"},
+		{"set", "k3", "a", "pxat", future},
+	} {
+		if err := resp.Encode(f, args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reload(t, path, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, nm := range []string{"k", "k2", "l", "l2"} {
+		if got := exec(t, &replayConn{}, "pttl", nm); got != int64(-2) {
+			t.Errorf("expected %s to be expired, got pttl %v", nm, got)
+		}
+	}
+	if got := exec(t, &replayConn{}, "get", "k3"); got != "a" {
+		t.Errorf("expected k3 to be a, got %v", got)
+	}
+	db, _ := srv.DefaultServer.GetDB(0)
+	if n := len(db.Keys()); n != 1 {
+		t.Errorf("expected the expired keys to be deleted, got %d keys", n)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	path := setup(t)
+	f, err := Open(path, FsyncEverySec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.SetPropagator(f)
+
+	conn := replayConn{dbix: 2}
+	for i := 0; i < 100; i++ {
+		exec(t, &conn, "incr", "n")
+	}
+	exec(t, &conn, "set", "x", "y", "px", "100000")
+	if err := f.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	// writes continue during the rewrite
+	exec(t, &conn, "incr", "n")
+	exec(t, &replayConn{dbix: 1}, "rpush", "l", "a")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:len(snapshotPrefix)]) != snapshotPrefix {
+		t.Errorf("expected a snapshot preamble, got %q", b[:len(snapshotPrefix)])
+	}
+
+	if err := reload(t, path, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := exec(t, &conn, "get", "n"); got != "101" {
+		t.Errorf("expected n to be 101, got %v", got)
+	}
+	if got := exec(t, &conn, "pttl", "x").(int64); got <= 99000 {
+		t.Errorf("expected x to expire in 100s, got %dms", got)
+	}
+	if got := exec(t, &replayConn{dbix: 1}, "llen", "l"); got != int64(1) {
+		t.Errorf("expected l to have 1 value, got %v", got)
+	}
+}
+
+// benchmarkExec executes SET commands on distinct keys from parallel
+// connections, appending them to a file flushed according to policy if
+// aof is true.
+func benchmarkExec(b *testing.B, aof bool, policy FsyncPolicy) {
+	path := setup(b)
+	if aof {
+		f, err := Open(path, policy)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer f.Close()
+		cmd.SetPropagator(f)
+	}
+
+	var n int64
+	var mu sync.Mutex
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		mu.Lock()
+		n++
+		key := strconv.FormatInt(n, 10)
+		mu.Unlock()
+		for pb.Next() {
+			cmd.Exec(&replayConn{}, []string{"set", key, "v"})
+		}
+	})
+}
+
+func BenchmarkExec(b *testing.B) {
+	benchmarkExec(b, false, FsyncNo)
+}
+
+// The write commands execute one at a time when they are appended to a
+// file, so that they are appended in the order in which they are applied,
+// but they are written and flushed to disk in batches outside of the lock.
+func BenchmarkExecAppendOnly(b *testing.B) {
+	benchmarkExec(b, true, FsyncNo)
+}
+
+func BenchmarkExecAppendOnlyFsyncAlways(b *testing.B) {
+	benchmarkExec(b, true, FsyncAlways)
+}
@@ -0,0 +1,126 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/resp"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/golang/glog"
+)
+
+// snapshotPrefix starts the files rewritten with a snapshot preamble.
+const snapshotPrefix = "GRED"
+
+// ErrTruncated is returned by Load when the last command of the file is
+// truncated and the file must not be truncated.
+var ErrTruncated = errors.New("aof: truncated file")
+
+// Load replays the append-only file at path in the databases of the server,
+// executing its commands as if they were sent by a client. It does nothing
+// if the file does not exist. If the last command of the file is truncated,
+// such as after a crash, the file is truncated to the last complete command
+// if truncate is true, otherwise ErrTruncated is returned. The keys do not
+// expire while the file is loaded, those that expired are deleted once it
+// is loaded.
+func Load(path string, truncate bool) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	srv.SetLoading(true)
+	defer srv.SetLoading(false)
+
+	cr := &countReader{r: f}
+	br := bufio.NewReader(cr)
+	if p, _ := br.Peek(len(snapshotPrefix)); string(p) == snapshotPrefix {
+		srv.DefaultServer.Lock()
+		err := srv.DefaultServer.Load(br)
+		srv.DefaultServer.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	var conn replayConn
+	var n int
+	for {
+		off := cr.n - int64(br.Buffered())
+		ar, err := resp.DecodeRequest(br)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if cr.n-int64(br.Buffered()) == off {
+				// at a command boundary, the file is complete
+				break
+			}
+			if !truncate {
+				return fmt.Errorf("%w: last command at offset %d", ErrTruncated, off)
+			}
+			glog.Warningf("append-only file %s truncated at offset %d", path, off)
+			f.Close()
+			if err := os.Truncate(path, off); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("aof: bad file format at offset %d: %v", off, err)
+		}
+
+		if _, ok := cmd.Commands[strings.ToLower(ar[0])]; !ok {
+			return fmt.Errorf("aof: unknown command '%s' at offset %d", ar[0], off)
+		}
+		// The errors returned by the commands are ignored, as they were
+		// when the commands were first executed.
+		cmd.Exec(&conn, ar)
+		n++
+	}
+
+	// The keyspace is now the same as saved in the file, once the keys that
+	// expired are deleted
+	srv.SetLoading(false)
+	srv.DefaultServer.DelExpired()
+	srv.DefaultServer.AddDirty(-srv.DefaultServer.Dirty())
+	glog.V(1).Infof("loaded append-only file %s: %d commands", path, n)
+	return nil
+}
+
+// countReader counts the bytes read from its reader.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Static check to make sure *replayConn implements the srv.Conn interface.
+var _ srv.Conn = (*replayConn)(nil)
+
+// replayConn is the connection on behalf of which the commands of the file
+// are executed.
+type replayConn struct {
+	dbix int
+}
+
+// Select sets the connection's DB index to ix.
+func (c *replayConn) Select(ix int) {
+	c.dbix = ix
+}
+
+// DBIndex returns the connection's DB index.
+func (c *replayConn) DBIndex() int {
+	return c.dbix
+}
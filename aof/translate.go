@@ -0,0 +1,176 @@
+package aof
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/gred/srv"
+)
+
+// translate returns the command to log for the write command args, given
+// its response res, or nil if nothing must be logged. The relative
+// expirations are turned into absolute ones, and the non-deterministic
+// commands into the changes they made.
+func translate(args []string, res interface{}) []string {
+	switch strings.ToLower(args[0]) {
+	case "expire", "pexpire", "expireat":
+		if res != true {
+			return nil
+		}
+		n, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return args
+		}
+		var at int64
+		switch strings.ToLower(args[0]) {
+		case "expire":
+			at = nowMs() + n*1000
+		case "pexpire":
+			at = nowMs() + n
+		default:
+			at = n * 1000
+		}
+		return []string{"PEXPIREAT", args[1], strconv.FormatInt(at, 10)}
+
+	case "setex", "psetex":
+		n, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return args
+		}
+		if strings.ToLower(args[0]) == "setex" {
+			n *= 1000
+		}
+		return []string{"SET", args[1], args[3], "PXAT", strconv.FormatInt(nowMs()+n, 10)}
+
+	case "set":
+		for i := 3; i < len(args)-1; i++ {
+			if at, ok := absExpire(args[i], args[i+1]); ok {
+				out := append([]string(nil), args...)
+				out[i], out[i+1] = "PXAT", at
+				return out
+			}
+		}
+
+	case "getex":
+		// A missing key is not changed, and neither is a key without option
+		if res == nil {
+			return nil
+		}
+		switch {
+		case len(args) == 3 && strings.ToLower(args[2]) == "persist":
+			return []string{"PERSIST", args[1]}
+		case len(args) == 4:
+			if at, ok := absExpire(args[2], args[3]); ok {
+				return []string{"PEXPIREAT", args[1], at}
+			}
+		}
+		return nil
+
+	case "restore":
+		absttl := false
+		for _, arg := range args[4:] {
+			if strings.ToLower(arg) == "absttl" {
+				absttl = true
+			}
+		}
+		if n, err := strconv.ParseInt(args[2], 10, 64); err == nil && n > 0 && !absttl {
+			out := append([]string(nil), args...)
+			out[2] = strconv.FormatInt(nowMs()+n, 10)
+			return append(out, "ABSTTL")
+		}
+
+	case "spop":
+		switch res := res.(type) {
+		case string:
+			return []string{"SREM", args[1], res}
+		case []string:
+			if len(res) > 0 {
+				return append([]string{"SREM", args[1]}, res...)
+			}
+		}
+		return nil
+
+	case "xadd":
+		id, ok := res.(string)
+		if !ok {
+			return nil
+		}
+		out := append([]string(nil), args...)
+		out[xaddIDIndex(args)] = id
+		return out
+
+	case "xreadgroup":
+		for i := 4; i < len(args)-1; i++ {
+			switch strings.ToLower(args[i]) {
+			case "block":
+				out := append([]string(nil), args[:i]...)
+				return append(out, args[i+2:]...)
+			case "streams":
+				return args
+			}
+		}
+	}
+	return args
+}
+
+// absExpire returns the absolute time in milliseconds of the expiration
+// option opt with the argument arg, which is one of EX, PX, EXAT or PXAT,
+// and true, or false if opt is not an expiration option.
+func absExpire(opt, arg string) (string, bool) {
+	opt = strings.ToLower(opt)
+	switch opt {
+	case "ex", "px", "exat", "pxat":
+	default:
+		return "", false
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return arg, true
+	}
+	switch opt {
+	case "ex":
+		n = nowMs() + n*1000
+	case "px":
+		n = nowMs() + n
+	case "exat":
+		n *= 1000
+	}
+	return strconv.FormatInt(n, 10), true
+}
+
+// xaddIDIndex returns the index of the ID argument of the XADD command
+// args, that follows the key and the options.
+func xaddIDIndex(args []string) int {
+	i := 2
+	for i < len(args) {
+		switch strings.ToLower(args[i]) {
+		case "nomkstream":
+			i++
+			continue
+		case "maxlen", "minid":
+			i++
+			if i < len(args) && (args[i] == "=" || args[i] == "~") {
+				i++
+			}
+			i++
+			if i < len(args) && strings.ToLower(args[i]) == "limit" {
+				i += 2
+			}
+			continue
+		}
+		break
+	}
+	return i
+}
+
+// now returns the current time of the server, so that the expirations
+// logged match those of the keys. It is replaced by the tests.
+var now = func() time.Time {
+	return srv.DefaultServer.Clock().Now()
+}
+
+// nowMs returns the current unix time in milliseconds.
+func nowMs() int64 {
+	return now().UnixNano() / int64(time.Millisecond)
+}
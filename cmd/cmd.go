@@ -5,6 +5,7 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 
 	"github.com/PuerkitoBio/gred/resp"
@@ -33,6 +34,18 @@ var (
 	// cannot be parsed as a float.
 	ErrNotFloat = errors.New("ERR value is not a valid float")
 
+	// ErrIncrOverflow is returned when an integer increment or decrement would
+	// overflow.
+	ErrIncrOverflow = errors.New("ERR increment or decrement would overflow")
+
+	// ErrDecrOverflow is returned when the decrement is the minimum 64-bit
+	// integer, which cannot be negated.
+	ErrDecrOverflow = errors.New("ERR decrement would overflow")
+
+	// ErrIncrNaNOrInf is returned when a float increment would produce NaN or
+	// an infinity.
+	ErrIncrNaNOrInf = errors.New("ERR increment would produce NaN or Infinity")
+
 	// ErrInvalidValType is returned when the key's value is not of the expected type.
 	ErrInvalidValType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
 
@@ -63,11 +76,294 @@ var (
 	// ErrInvalidDBIndex is returned when a DB index outside the bounds of
 	// available DBs is requested.
 	ErrInvalidDBIndex = errors.New("ERR invalid DB index")
+
+	// ErrMinMaxNotFloat is returned when a score range argument cannot be
+	// parsed as a float bound.
+	ErrMinMaxNotFloat = errors.New("ERR min or max is not a float")
+
+	// ErrMinMaxNotLex is returned when a lexicographical range argument is
+	// not a valid string bound.
+	ErrMinMaxNotLex = errors.New("ERR min or max not valid string range item")
+
+	// ErrNaNScore is returned when an increment operation on a sorted set
+	// score would result in a NaN value.
+	ErrNaNScore = errors.New("ERR resulting score is not a number (NaN)")
+
+	// ErrXXAndNX is returned when both the XX and NX options are specified.
+	ErrXXAndNX = errors.New("ERR XX and NX options at the same time are not compatible")
+
+	// ErrGTLTAndNX is returned when more than one of the GT, LT and NX options
+	// are specified.
+	ErrGTLTAndNX = errors.New("ERR GT, LT, and/or NX options at the same time are not compatible")
+
+	// ErrIncrPair is returned when the INCR option of ZADD is used with more
+	// than one score-member pair.
+	ErrIncrPair = errors.New("ERR INCR option supports a single increment-element pair")
+
+	// ErrWeightNotFloat is returned when a WEIGHTS argument cannot be parsed
+	// as a float.
+	ErrWeightNotFloat = errors.New("ERR weight value is not a float")
+
+	// ErrNotPositive is returned when a count argument must be positive
+	// and is not.
+	ErrNotPositive = errors.New("ERR value is out of range, must be positive")
+
+	// ErrNumKeysNotPositive is returned when the numkeys argument of a
+	// command is not greater than 0.
+	ErrNumKeysNotPositive = errors.New("ERR numkeys should be greater than 0")
+
+	// ErrNumKeysTooMany is returned when the numkeys argument of a command
+	// is greater than the number of key arguments.
+	ErrNumKeysTooMany = errors.New("ERR Number of keys can't be greater than number of args")
+
+	// ErrNegativeLimit is returned when a LIMIT argument is not a positive
+	// integer.
+	ErrNegativeLimit = errors.New("ERR LIMIT can't be negative")
+
+	// ErrBitOffset is returned when a bit offset argument is not a valid
+	// integer or is out of range.
+	ErrBitOffset = errors.New("ERR bit offset is not an integer or out of range")
+
+	// ErrBitValue is returned when a bit value argument is not 0 or 1.
+	ErrBitValue = errors.New("ERR bit is not an integer or out of range")
+
+	// ErrBitPosBit is returned when the bit argument of BITPOS is not 0 or 1.
+	ErrBitPosBit = errors.New("ERR The bit argument must be 1 or 0.")
+
+	// ErrBitOpNot is returned when BITOP NOT is called with more than one
+	// source key.
+	ErrBitOpNot = errors.New("ERR BITOP NOT must be called with a single source key.")
+
+	// ErrBitFieldType is returned when a BITFIELD type argument is invalid.
+	ErrBitFieldType = errors.New("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+
+	// ErrOverflowType is returned when a BITFIELD OVERFLOW argument is invalid.
+	ErrOverflowType = errors.New("ERR Invalid OVERFLOW type specified")
+
+	// ErrInvalidStreamID is returned when a stream ID argument is invalid.
+	ErrInvalidStreamID = errors.New("ERR Invalid stream ID specified as stream command argument")
+
+	// ErrStreamIDTooSmall is returned when the ID of an entry added to a stream
+	// is not greater than the last ID of the stream.
+	ErrStreamIDTooSmall = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+
+	// ErrStreamIDZero is returned when the ID of an entry added to a stream is 0-0.
+	ErrStreamIDZero = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+
+	// ErrStreamExhausted is returned when no more entry can be added to a
+	// stream because its last ID is the greatest possible ID.
+	ErrStreamExhausted = errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+
+	// ErrMaxLenNegative is returned when the MAXLEN argument of a stream
+	// command is negative.
+	ErrMaxLenNegative = errors.New("ERR The MAXLEN argument must be >= 0.")
+
+	// ErrLimitNegative is returned when the LIMIT argument of a stream
+	// command is negative.
+	ErrLimitNegative = errors.New("ERR The LIMIT argument must be >= 0.")
+
+	// ErrLimitWithoutApprox is returned when the LIMIT option of a stream
+	// command is used without the "~" modifier.
+	ErrLimitWithoutApprox = errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+
+	// ErrUnbalancedStreams is returned when the number of stream keys and IDs
+	// of a XREAD command do not match.
+	ErrUnbalancedStreams = errors.New("ERR Unbalanced XREAD list of streams: for each stream key an ID or '$' must be specified.")
+
+	// ErrTimeoutNotInteger is returned when a timeout argument is not a valid
+	// integer.
+	ErrTimeoutNotInteger = errors.New("ERR timeout is not an integer or out of range")
+
+	// ErrTimeoutNotFloat is returned when a timeout argument in seconds is
+	// not a valid float.
+	ErrTimeoutNotFloat = errors.New("ERR timeout is not a float or out of range")
+
+	// ErrTimeoutNegative is returned when a timeout argument is negative.
+	ErrTimeoutNegative = errors.New("ERR timeout is negative")
+
+	// ErrUnbalancedGroupStreams is returned when the number of stream keys
+	// and IDs of XREADGROUP do not match.
+	ErrUnbalancedGroupStreams = errors.New("ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID must be specified.")
+
+	// ErrGroupReadDollar is returned when the "$" ID is used with XREADGROUP.
+	ErrGroupReadDollar = errors.New("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+
+	// ErrNoGroup is returned when a consumer group command is attempted
+	// against a non-existing stream or consumer group.
+	ErrNoGroup = errors.New("NOGROUP No such key or consumer group")
+
+	// ErrGroupExists is returned when XGROUP CREATE is called with the name
+	// of an existing consumer group.
+	ErrGroupExists = errors.New("BUSYGROUP Consumer Group name already exists")
+
+	// ErrXGroupNoKey is returned when XGROUP is called on a non-existing key.
+	ErrXGroupNoKey = errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+
+	// ErrUnknownSubcommand is returned when the sub-command of a command is
+	// not supported or has the wrong number of arguments.
+	ErrUnknownSubcommand = errors.New("ERR unknown subcommand or wrong number of arguments")
+
+	// ErrInvalidLonLat is returned when a longitude or latitude argument is
+	// outside the valid bounds of a geo position.
+	ErrInvalidLonLat = errors.New("ERR invalid longitude,latitude pair")
+
+	// ErrUnsupportedUnit is returned when a distance unit argument is not
+	// one of M, KM, FT or MI.
+	ErrUnsupportedUnit = errors.New("ERR unsupported unit provided. please use M, KM, FT, MI")
+
+	// ErrGeoFrom is returned when GEOSEARCH is called without exactly one
+	// of the FROMMEMBER and FROMLONLAT options.
+	ErrGeoFrom = errors.New("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+
+	// ErrGeoBy is returned when GEOSEARCH is called without exactly one of
+	// the BYRADIUS and BYBOX options.
+	ErrGeoBy = errors.New("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+
+	// ErrGeoMember is returned when the FROMMEMBER member of GEOSEARCH is
+	// not in the sorted set.
+	ErrGeoMember = errors.New("ERR could not decode requested zset member")
+
+	// ErrAnyWithoutCount is returned when the ANY option is used without
+	// the COUNT option.
+	ErrAnyWithoutCount = errors.New("ERR the ANY argument requires COUNT argument")
+
+	// ErrCountNotPositive is returned when a COUNT argument must be greater
+	// than 0.
+	ErrCountNotPositive = errors.New("ERR COUNT must be > 0")
+
+	// ErrRadiusNegative is returned when a radius argument is negative.
+	ErrRadiusNegative = errors.New("ERR radius cannot be negative")
+
+	// ErrBoxNegative is returned when a box width or height argument is
+	// negative.
+	ErrBoxNegative = errors.New("ERR height or width cannot be negative")
+
+	// ErrInvalidExpire is returned when an expiration argument is not a
+	// positive time.
+	ErrInvalidExpire = errors.New("ERR invalid expire time in command")
+
+	// ErrInvalidCursor is returned when the cursor argument of a SCAN
+	// command is not a valid unsigned integer.
+	ErrInvalidCursor = errors.New("ERR invalid cursor")
+
+	// ErrSameObject is returned when the source and destination of a
+	// command are the same key.
+	ErrSameObject = errors.New("ERR source and destination objects are the same")
+
+	// ErrBusyKey is returned when RESTORE would replace an existing key
+	// without the REPLACE option.
+	ErrBusyKey = errors.New("BUSYKEY Target key name already exists.")
+
+	// ErrDumpPayload is returned when the payload of RESTORE has a bad
+	// checksum or an unknown version.
+	ErrDumpPayload = errors.New("ERR DUMP payload version or checksum are wrong")
+
+	// ErrBadDataFormat is returned when the payload of RESTORE cannot be
+	// decoded.
+	ErrBadDataFormat = errors.New("ERR Bad data format")
+
+	// ErrInvalidTTL is returned when the TTL argument of RESTORE is
+	// negative.
+	ErrInvalidTTL = errors.New("ERR Invalid TTL value, must be >= 0")
+
+	// ErrInvalidIdleTime is returned when the IDLETIME argument of RESTORE
+	// is negative.
+	ErrInvalidIdleTime = errors.New("ERR Invalid IDLETIME value, must be >= 0")
+
+	// ErrInvalidFreq is returned when the FREQ argument of RESTORE is not
+	// between 0 and 255.
+	ErrInvalidFreq = errors.New("ERR Invalid FREQ value, must be >= 0 and <= 255")
+
+	// ErrSortNotDouble is returned when SORT sorts numerically and a sort
+	// value cannot be parsed as a float.
+	ErrSortNotDouble = errors.New("ERR One or more scores can't be converted into double")
+
+	// ErrRankZero is returned when the RANK option of LPOS is 0.
+	ErrRankZero = errors.New("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the last match")
+
+	// ErrNegativeCount is returned when the COUNT option of LPOS is
+	// negative.
+	ErrNegativeCount = errors.New("ERR COUNT can't be negative")
+
+	// ErrNegativeMaxLen is returned when the MAXLEN option of LPOS is
+	// negative.
+	ErrNegativeMaxLen = errors.New("ERR MAXLEN can't be negative")
+
+	// ErrPopCountNotPositive is returned when the COUNT option of a
+	// command that pops multiple values is not greater than 0.
+	ErrPopCountNotPositive = errors.New("ERR count should be greater than 0")
+
+	// ErrNotHLL is returned when a HyperLogLog command is attempted on a
+	// string value that is not a HyperLogLog.
+	ErrNotHLL = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+
+	// ErrCorruptHLL is returned when a HyperLogLog command is attempted on
+	// a HyperLogLog whose registers cannot be decoded.
+	ErrCorruptHLL = errors.New("INVALIDOBJ Corrupted HLL object detected")
+
+	// ErrNoStoreInput is returned when ZUNIONSTORE or ZINTERSTORE is called
+	// without any source key.
+	ErrNoStoreInput = errors.New("ERR at least 1 input key is needed for ZUNIONSTORE/ZINTERSTORE")
+
+	// ErrBgSaveInProgress is returned when SAVE or BGSAVE is called while a
+	// background save is in progress.
+	ErrBgSaveInProgress = errors.New("ERR Background save already in progress")
+
+	// ErrSaveFailed is returned when SAVE or BGSAVE cannot save the
+	// snapshot.
+	ErrSaveFailed = errors.New("ERR error saving the snapshot")
+
+	// BgSaveStartedVal is the response of BGSAVE when the background save
+	// is started.
+	BgSaveStartedVal = resp.SimpleString("Background saving started")
+
+	// ErrRewriteInProgress is returned when BGREWRITEAOF is called while a
+	// rewrite of the append-only file is in progress.
+	ErrRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+
+	// ErrAOFDisabled is returned when BGREWRITEAOF is called while the
+	// append-only file is disabled.
+	ErrAOFDisabled = errors.New("ERR Append only file is disabled")
+
+	// ErrDebugTimeDisabled is returned when DEBUG SET-TIME or DEBUG ADVANCE
+	// is called while the server uses the system time.
+	ErrDebugTimeDisabled = errors.New("ERR DEBUG SET-TIME and ADVANCE are disabled, start the server with -debug-time")
+
+	// ErrClockBackwards is returned when DEBUG SET-TIME or DEBUG ADVANCE
+	// would move the clock of the server backwards.
+	ErrClockBackwards = errors.New("ERR the clock of the server cannot move backwards")
+
+	// BgRewriteAOFStartedVal is the response of BGREWRITEAOF when the
+	// rewrite is started.
+	BgRewriteAOFStartedVal = resp.SimpleString("Background append only file rewriting started")
 )
 
+// FormatFloat returns the string representation of the float value f, as
+// returned by Redis for sorted set scores: the shortest representation that
+// reads back as f, using the exponent notation only for very large or very
+// small values.
+func FormatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case f == 0:
+		return "0"
+	}
+	if exp := math.Log10(math.Abs(f)); exp < -4 || exp >= 21 {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
 // Commands holds the list of registered commands.
 var Commands = make(map[string]Cmd)
 
+// Writes holds the names of the registered commands that may modify the
+// keyspace.
+var Writes = make(map[string]bool)
+
 // Register registers a command name with an implementation.
 func Register(name string, c Cmd) {
 	if name == "" {
@@ -79,6 +375,36 @@ func Register(name string, c Cmd) {
 	Commands[name] = c
 }
 
+// RegisterWrite registers a command name with an implementation that may
+// modify the keyspace.
+func RegisterWrite(name string, c Cmd) {
+	Register(name, c)
+	Writes[name] = true
+}
+
+// ReadyFn defines the function signature required to serve the clients
+// blocked on a key.
+type ReadyFn func(db srv.DB, name string)
+
+// readyFns holds the functions registered by RegisterReady.
+var readyFns []ReadyFn
+
+// RegisterReady registers a function that serves the clients blocked on a
+// key of a given type, called by Ready.
+func RegisterReady(fn ReadyFn) {
+	readyFns = append(readyFns, fn)
+}
+
+// Ready serves the clients blocked on the key name of db, once it is
+// created or replaced by a command that does not serve them itself, such
+// as RENAME. The DB must be exclusively locked, and the key must not be
+// locked.
+func Ready(db srv.DB, name string) {
+	for _, fn := range readyFns {
+		fn(db, name)
+	}
+}
+
 // Cmd defines the common method required to implement a basic command.
 // It is insufficient to implement this sole interface. A command must also
 // implement one of the more specific {Srv,DB}Cmd interfaces.
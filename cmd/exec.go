@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/gred/srv"
+)
+
+// Propagator defines the methods required to receive the write commands
+// once executed, such as to log them to an append-only file. Its lock is
+// held while a write command executes and is propagated, so that the
+// commands are propagated in the order in which they are applied. The
+// write commands thus execute one at a time when a propagator is set, so
+// the slow part of the propagation must be done by Flush.
+type Propagator interface {
+	sync.Locker
+
+	// Propagate receives the write command args, executed on the database
+	// at index dbix, and its response res. The response is nil for the
+	// commands recorded by Propagate on behalf of another command.
+	Propagate(dbix int, args []string, res interface{})
+
+	// Flush completes the propagation of the commands received so far. It
+	// is called without the lock, once a write command is propagated and
+	// before its response is returned.
+	Flush()
+}
+
+var (
+	// propagator receives the write commands, if set.
+	propagator Propagator
+
+	// current is the state of the write command being executed, while it
+	// holds the lock of the propagator.
+	current *execState
+)
+
+// execState holds the propagation state of a write command being executed.
+type execState struct {
+	dbix  int
+	skip  bool
+	extra [][]string
+}
+
+// SetPropagator sets the propagator that receives the write commands
+// executed by Exec. It must be called before the commands are executed.
+func SetPropagator(p Propagator) {
+	propagator = p
+}
+
+// Exec parses and executes the command ar[0] with the arguments ar[1:], on
+// behalf of the connection conn. A successful write command is sent to the
+// propagator, if any.
+func Exec(conn srv.Conn, ar []string) (interface{}, error) {
+	name := strings.ToLower(ar[0])
+	cd, ok := Commands[name]
+	if !ok {
+		return nil, fmt.Errorf("ERR unknown command '%s'", ar[0])
+	}
+	args, ints, floats, err := cd.Parse(ar[0], ar[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	write := Writes[name]
+	var st *execState
+	if write && propagator != nil {
+		// Deferred calls run in reverse order, so the commands are flushed
+		// once the next write command can execute
+		defer propagator.Flush()
+		propagator.Lock()
+		defer propagator.Unlock()
+		st = &execState{dbix: conn.DBIndex()}
+		current = st
+		defer func() { current = nil }()
+	}
+
+	var res interface{}
+	switch cd := cd.(type) {
+	case DBCmd:
+		// Get the connection's current database, the server being locked
+		// as FLUSHALL may replace it concurrently
+		srv.DefaultServer.RLock()
+		db, ok := srv.DefaultServer.GetDB(conn.DBIndex())
+		srv.DefaultServer.RUnlock()
+		if !ok {
+			panic(fmt.Sprintf("invalid database index: %d", conn.DBIndex()))
+		}
+		res, err = cd.ExecWithDB(db, args, ints, floats)
+	case SrvCmd:
+		res, err = cd.Exec(args, ints, floats)
+	case ConnCmd:
+		res, err = cd.ExecWithConn(conn, args, ints, floats)
+	default:
+		panic(fmt.Sprintf("unsupported command type: %T", cd))
+	}
+
+	if st != nil {
+		if err == nil && !st.skip {
+			propagator.Propagate(st.dbix, ar, res)
+		}
+		for _, args := range st.extra {
+			propagator.Propagate(st.dbix, args, nil)
+		}
+	}
+	return res, err
+}
+
+// Block must be called by a write command before it blocks waiting for
+// other commands, so that the write commands can execute in the meantime.
+// It returns the function to call once it is unblocked, before it modifies
+// the keyspace again.
+func Block() func() {
+	st := current
+	if st == nil {
+		return func() {}
+	}
+	current = nil
+	propagator.Unlock()
+	return func() {
+		propagator.Lock()
+		current = st
+	}
+}
+
+// Dirty records that the write command being executed changed n keys, for
+// the save rules. It must only be called by write commands, once the keys
+// are changed.
+func Dirty(n int) {
+	if n > 0 {
+		srv.DefaultServer.AddDirty(int64(n))
+	}
+}
+
+// Propagate records the write command args, executed on behalf of the
+// write command being executed, to be propagated after it. It must only
+// be called by write commands.
+func Propagate(args ...string) {
+	if current != nil {
+		current.extra = append(current.extra, args)
+	}
+}
+
+// NoPropagate indicates that the write command being executed must not be
+// propagated itself, its changes being propagated by the commands recorded
+// by Propagate. It must only be called by write commands.
+func NoPropagate() {
+	if current != nil {
+		current.skip = true
+	}
+}
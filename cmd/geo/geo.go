@@ -0,0 +1,563 @@
+package geo
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+func init() {
+	cmd.RegisterWrite("geoadd", geoadd)
+	cmd.Register("geodist", geodist)
+	cmd.Register("geohash", geohash)
+	cmd.Register("geopos", geopos)
+	cmd.Register("geosearch", geosearch)
+	cmd.RegisterWrite("geosearchstore", geosearchstore)
+}
+
+// units maps the distance units to their value in meters.
+var units = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"ft": 0.3048,
+	"mi": 1609.34,
+}
+
+var geoadd = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 4,
+		MaxArgs: -1,
+	},
+	geoaddFn)
+
+func geoaddFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// Parse the options
+	var nx, xx, ch bool
+	i := 1
+loop:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ch":
+			ch = true
+		default:
+			break loop
+		}
+	}
+	triplets := args[i:]
+	if len(triplets) == 0 || len(triplets)%3 != 0 {
+		return nil, cmd.ErrSyntax
+	}
+	if nx && xx {
+		return nil, cmd.ErrXXAndNX
+	}
+
+	// Parse all positions before touching the sorted set
+	scores := make([]float64, len(triplets)/3)
+	for j := range scores {
+		p, err := parseLonLat(triplets[3*j], triplets[3*j+1])
+		if err != nil {
+			return nil, err
+		}
+		h, _ := types.GeoEncode(p)
+		scores[j] = float64(h)
+	}
+
+	// Only create the key if values may be added
+	flag := srv.NoKeyCreateSortedSet
+	if xx {
+		flag = srv.NoKeyNone
+	}
+	k, unl := db.LockGetKey(args[0], flag)
+	defer unl()
+
+	if k == nil {
+		return int64(0), nil
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	v, ok := k.Val().(types.SortedSet)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+
+	var added, changed int64
+	for j, score := range scores {
+		member := triplets[3*j+2]
+		cur, exists := v.ZScore(member)
+		switch {
+		case exists && !nx:
+			if score != cur {
+				v.ZAdd(score, member)
+				changed++
+			}
+		case !exists && !xx:
+			v.ZAdd(score, member)
+			added++
+		}
+	}
+	if added+changed > 0 {
+		cmd.Dirty(1)
+	}
+	if ch {
+		return added + changed, nil
+	}
+	return added, nil
+}
+
+var geodist = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 4,
+	},
+	srv.NoKeyDefaultVal,
+	geodistFn)
+
+func geodistFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	unit := "m"
+	if len(args) == 4 {
+		unit = args[3]
+	}
+	conv, err := parseUnit(unit)
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v, ok := k.Val().(types.SortedSet)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+	s1, ok1 := v.ZScore(args[1])
+	s2, ok2 := v.ZScore(args[2])
+	if !ok1 || !ok2 {
+		return nil, nil
+	}
+	d := types.GeoDistance(types.GeoDecode(uint64(s1)), types.GeoDecode(uint64(s2)))
+	return formatDist(d / conv), nil
+}
+
+var geohash = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	srv.NoKeyDefaultVal,
+	geohashFn)
+
+func geohashFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	v, ok := k.Val().(types.SortedSet)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+	ret := make([]interface{}, len(args)-1)
+	for i, m := range args[1:] {
+		if score, ok := v.ZScore(m); ok {
+			ret[i] = types.GeoHashString(types.GeoDecode(uint64(score)))
+		}
+	}
+	return ret, nil
+}
+
+var geopos = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	srv.NoKeyDefaultVal,
+	geoposFn)
+
+func geoposFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	v, ok := k.Val().(types.SortedSet)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+	ret := make([]interface{}, len(args)-1)
+	for i, m := range args[1:] {
+		if score, ok := v.ZScore(m); ok {
+			ret[i] = formatPoint(types.GeoDecode(uint64(score)))
+		}
+	}
+	return ret, nil
+}
+
+var geosearch = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 5,
+		MaxArgs: -1,
+	},
+	srv.NoKeyDefaultVal,
+	geosearchFn)
+
+func geosearchFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	opts, err := parseSearch(args[1:], false)
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v, ok := k.Val().(types.SortedSet)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+	res, err := search(v, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.withCoord && !opts.withDist && !opts.withHash {
+		ret := make([]string, len(res))
+		for i, r := range res {
+			ret[i] = r.member
+		}
+		return ret, nil
+	}
+	ret := make([]interface{}, len(res))
+	for i, r := range res {
+		item := []interface{}{r.member}
+		if opts.withDist {
+			item = append(item, formatDist(r.dist/opts.unit))
+		}
+		if opts.withHash {
+			item = append(item, int64(r.hash))
+		}
+		if opts.withCoord {
+			item = append(item, formatPoint(r.p))
+		}
+		ret[i] = item
+	}
+	return ret, nil
+}
+
+var geosearchstore = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 6,
+		MaxArgs: -1,
+	},
+	geosearchstoreFn)
+
+func geosearchstoreFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	opts, err := parseSearch(args[2:], true)
+	if err != nil {
+		return nil, err
+	}
+
+	// In every case, the destination is replaced, so must have an exclusive lock
+	db.Lock()
+	defer db.Unlock()
+
+	// Read-lock the source key, it is unlocked once the result is computed
+	// so that the destination can be locked even if it is also the source.
+	keys := db.Keys()
+	unl := db.LockKeys(false, args[1])
+	var res []result
+	if k, ok := db.GetKey(args[1]); ok {
+		v, ok := k.Val().(types.SortedSet)
+		if !ok {
+			unl()
+			return nil, cmd.ErrInvalidValType
+		}
+		if res, err = search(v, opts); err != nil {
+			unl()
+			return nil, err
+		}
+	}
+	unl()
+
+	zs := types.NewSortedSet()
+	for _, r := range res {
+		score := float64(r.hash)
+		if opts.storeDist {
+			score = r.dist / opts.unit
+		}
+		zs.ZAdd(score, r.member)
+	}
+
+	// Replace the destination key
+	dst, exists := keys[args[0]]
+	if exists {
+		dst.Lock()
+		db.DelKey(args[0])
+		dst.Unlock()
+	}
+	if zs.ZCard() > 0 {
+		db.SetKey(args[0], zs, -1)
+	}
+	if exists || zs.ZCard() > 0 {
+		cmd.Dirty(1)
+	}
+	return zs.ZCard(), nil
+}
+
+// searchOpts holds the options of GEOSEARCH and GEOSEARCHSTORE.
+type searchOpts struct {
+	member    *string
+	center    *types.GeoPoint
+	radius    *float64
+	box       *[2]float64
+	unit      float64
+	sort      int
+	count     int64
+	any       bool
+	withCoord bool
+	withDist  bool
+	withHash  bool
+	storeDist bool
+}
+
+// parseSearch parses the options of GEOSEARCH, or of GEOSEARCHSTORE if
+// store is true.
+func parseSearch(args []string, store bool) (searchOpts, error) {
+	var opts searchOpts
+
+	// need returns an error if there are less than n arguments after i
+	need := func(i, n int) error {
+		if i+n >= len(args) {
+			return cmd.ErrSyntax
+		}
+		return nil
+	}
+
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "frommember":
+			if err := need(i, 1); err != nil {
+				return opts, err
+			}
+			if opts.member != nil || opts.center != nil {
+				return opts, cmd.ErrGeoFrom
+			}
+			opts.member = &args[i+1]
+			i++
+		case opt == "fromlonlat":
+			if err := need(i, 2); err != nil {
+				return opts, err
+			}
+			if opts.member != nil || opts.center != nil {
+				return opts, cmd.ErrGeoFrom
+			}
+			p, err := parseLonLat(args[i+1], args[i+2])
+			if err != nil {
+				return opts, err
+			}
+			opts.center = &p
+			i += 2
+		case opt == "byradius":
+			if err := need(i, 2); err != nil {
+				return opts, err
+			}
+			if opts.radius != nil || opts.box != nil {
+				return opts, cmd.ErrGeoBy
+			}
+			r, err := strconv.ParseFloat(args[i+1], 64)
+			if err != nil {
+				return opts, cmd.ErrNotFloat
+			}
+			if r < 0 {
+				return opts, cmd.ErrRadiusNegative
+			}
+			if opts.unit, err = parseUnit(args[i+2]); err != nil {
+				return opts, err
+			}
+			opts.radius = &r
+			i += 2
+		case opt == "bybox":
+			if err := need(i, 3); err != nil {
+				return opts, err
+			}
+			if opts.radius != nil || opts.box != nil {
+				return opts, cmd.ErrGeoBy
+			}
+			var box [2]float64
+			for j := range box {
+				f, err := strconv.ParseFloat(args[i+1+j], 64)
+				if err != nil {
+					return opts, cmd.ErrNotFloat
+				}
+				if f < 0 {
+					return opts, cmd.ErrBoxNegative
+				}
+				box[j] = f
+			}
+			var err error
+			if opts.unit, err = parseUnit(args[i+3]); err != nil {
+				return opts, err
+			}
+			opts.box = &box
+			i += 3
+		case opt == "asc":
+			opts.sort = 1
+		case opt == "desc":
+			opts.sort = -1
+		case opt == "count":
+			if err := need(i, 1); err != nil {
+				return opts, err
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return opts, cmd.ErrNotInteger
+			}
+			if n <= 0 {
+				return opts, cmd.ErrCountNotPositive
+			}
+			opts.count = n
+			i++
+			if i+1 < len(args) && strings.ToLower(args[i+1]) == "any" {
+				opts.any = true
+				i++
+			}
+		case opt == "any":
+			return opts, cmd.ErrAnyWithoutCount
+		case opt == "withcoord" && !store:
+			opts.withCoord = true
+		case opt == "withdist" && !store:
+			opts.withDist = true
+		case opt == "withhash" && !store:
+			opts.withHash = true
+		case opt == "storedist" && store:
+			opts.storeDist = true
+		default:
+			return opts, cmd.ErrSyntax
+		}
+	}
+
+	if opts.member == nil && opts.center == nil {
+		return opts, cmd.ErrGeoFrom
+	}
+	if opts.radius == nil && opts.box == nil {
+		return opts, cmd.ErrGeoBy
+	}
+	// COUNT without ANY implies sorting by distance
+	if opts.count > 0 && !opts.any && opts.sort == 0 {
+		opts.sort = 1
+	}
+	return opts, nil
+}
+
+// result is a member of a sorted set found by a geo search.
+type result struct {
+	member string
+	dist   float64
+	hash   uint64
+	p      types.GeoPoint
+}
+
+// search returns the members of the sorted set that are in the shape
+// described by the options.
+func search(v types.SortedSet, opts searchOpts) ([]result, error) {
+	if v.ZCard() == 0 {
+		// The key does not exist
+		return []result{}, nil
+	}
+
+	var shape types.GeoShape
+	if opts.member != nil {
+		score, ok := v.ZScore(*opts.member)
+		if !ok {
+			return nil, cmd.ErrGeoMember
+		}
+		shape.Center = types.GeoDecode(uint64(score))
+	} else {
+		shape.Center = *opts.center
+	}
+	if opts.radius != nil {
+		shape.Radius = *opts.radius * opts.unit
+	} else {
+		shape.Box = true
+		shape.Width, shape.Height = opts.box[0]*opts.unit, opts.box[1]*opts.unit
+	}
+
+	res := []result{}
+	for _, r := range shape.ScoreRanges() {
+		for _, sm := range v.ZRangeByScore(r, false, 0, -1) {
+			h := uint64(sm.Score)
+			p := types.GeoDecode(h)
+			d, ok := shape.Contains(p)
+			if !ok {
+				continue
+			}
+			res = append(res, result{sm.Member, d, h, p})
+			if opts.any && int64(len(res)) == opts.count {
+				break
+			}
+		}
+		if opts.any && int64(len(res)) == opts.count {
+			break
+		}
+	}
+
+	if opts.sort != 0 {
+		sort.Sort(byDist(res))
+		if opts.sort < 0 {
+			for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+				res[i], res[j] = res[j], res[i]
+			}
+		}
+	}
+	if opts.count > 0 && int64(len(res)) > opts.count {
+		res = res[:opts.count]
+	}
+	return res, nil
+}
+
+// byDist sorts the results by distance.
+type byDist []result
+
+func (b byDist) Len() int           { return len(b) }
+func (b byDist) Less(i, j int) bool { return b[i].dist < b[j].dist }
+func (b byDist) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// parseLonLat parses a longitude and a latitude as a geo position.
+func parseLonLat(lon, lat string) (types.GeoPoint, error) {
+	var p types.GeoPoint
+	var err error
+
+	if p.Lon, err = strconv.ParseFloat(lon, 64); err != nil {
+		return p, cmd.ErrNotFloat
+	}
+	if p.Lat, err = strconv.ParseFloat(lat, 64); err != nil {
+		return p, cmd.ErrNotFloat
+	}
+	if _, ok := types.GeoEncode(p); !ok {
+		return p, cmd.ErrInvalidLonLat
+	}
+	return p, nil
+}
+
+// parseUnit returns the value in meters of the distance unit.
+func parseUnit(s string) (float64, error) {
+	if u, ok := units[strings.ToLower(s)]; ok {
+		return u, nil
+	}
+	return 0, cmd.ErrUnsupportedUnit
+}
+
+// formatDist formats a distance with a precision of 4 decimals.
+func formatDist(d float64) string {
+	return strconv.FormatFloat(d, 'f', 4, 64)
+}
+
+// formatPoint returns the reply for a geo position, as an array holding
+// its longitude and latitude.
+func formatPoint(p types.GeoPoint) []string {
+	return []string{cmd.FormatFloat(p.Lon), cmd.FormatFloat(p.Lat)}
+}
@@ -2,6 +2,7 @@ package hashes
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/PuerkitoBio/gred/cmd"
 	"github.com/PuerkitoBio/gred/srv"
@@ -9,18 +10,19 @@ import (
 )
 
 func init() {
-	cmd.Register("hdel", hdel)
+	cmd.RegisterWrite("hdel", hdel)
 	cmd.Register("hexists", hexists)
 	cmd.Register("hget", hget)
 	cmd.Register("hgetall", hgetall)
-	cmd.Register("hincrby", hincrby)
-	cmd.Register("hincrbyfloat", hincrbyfloat)
+	cmd.RegisterWrite("hincrby", hincrby)
+	cmd.RegisterWrite("hincrbyfloat", hincrbyfloat)
 	cmd.Register("hkeys", hkeys)
 	cmd.Register("hlen", hlen)
 	cmd.Register("hmget", hmget)
-	cmd.Register("hmset", hmset)
-	cmd.Register("hset", hset)
-	cmd.Register("hsetnx", hsetnx)
+	cmd.RegisterWrite("hmset", hmset)
+	cmd.Register("hscan", hscan)
+	cmd.RegisterWrite("hset", hset)
+	cmd.RegisterWrite("hsetnx", hsetnx)
 	cmd.Register("hvals", hvals)
 }
 
@@ -51,6 +53,7 @@ func hdelFn(db srv.DB, args []string, ints []int64, floats []float64) (interface
 			if v.HLen() == 0 {
 				db.DelKey(args[0])
 			}
+			cmd.Dirty(1)
 		}
 		return ret, nil
 	}
@@ -135,6 +138,7 @@ func hincrbyFn(k srv.Key, args []string, ints []int64, floats []float64) (interf
 	if v, ok := v.(types.IncHash); ok {
 		val, ok := v.HIncrBy(args[1], ints[0])
 		if ok {
+			cmd.Dirty(1)
 			return val, nil
 		}
 		return nil, cmd.ErrHashFieldNotInt
@@ -159,6 +163,7 @@ func hincrbyfloatFn(k srv.Key, args []string, ints []int64, floats []float64) (i
 	if v, ok := v.(types.IncHash); ok {
 		val, ok := v.HIncrByFloat(args[1], floats[0])
 		if ok {
+			cmd.Dirty(1)
 			return val, nil
 		}
 		return nil, cmd.ErrHashFieldNotFloat
@@ -245,11 +250,43 @@ func hmsetFn(k srv.Key, args []string, ints []int64, floats []float64) (interfac
 	v := k.Val()
 	if v, ok := v.(types.Hash); ok {
 		v.HMSet(args[1:]...)
+		cmd.Dirty(1)
 		return cmd.OKVal, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
 
+var hscan = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: -1,
+	},
+	srv.NoKeyDefaultVal,
+	hscanFn)
+
+func hscanFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	opts, err := cmd.ParseScanOpts("hscan", args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.Hash); ok {
+		cur, vals := v.HScan(opts.Cursor, opts.Count, opts.Match)
+		if opts.NoValues {
+			for i := 0; i < len(vals)/2; i++ {
+				vals[i] = vals[2*i]
+			}
+			vals = vals[:len(vals)/2]
+		}
+		return []interface{}{strconv.FormatUint(cur, 10), vals}, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
 var hset = cmd.NewSingleKeyCmd(
 	&cmd.ArgDef{
 		MinArgs: 3,
@@ -264,7 +301,9 @@ func hsetFn(k srv.Key, args []string, ints []int64, floats []float64) (interface
 
 	v := k.Val()
 	if v, ok := v.(types.Hash); ok {
-		return v.HSet(args[1], args[2]), nil
+		added := v.HSet(args[1], args[2])
+		cmd.Dirty(1)
+		return added, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -283,7 +322,11 @@ func hsetnxFn(k srv.Key, args []string, ints []int64, floats []float64) (interfa
 
 	v := k.Val()
 	if v, ok := v.(types.Hash); ok {
-		return v.HSetNx(args[1], args[2]), nil
+		set := v.HSetNx(args[1], args[2])
+		if set {
+			cmd.Dirty(1)
+		}
+		return set, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
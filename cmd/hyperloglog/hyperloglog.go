@@ -0,0 +1,172 @@
+package hyperloglog
+
+import (
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+func init() {
+	cmd.RegisterWrite("pfadd", pfadd)
+	cmd.Register("pfcount", pfcount)
+	cmd.RegisterWrite("pfmerge", pfmerge)
+}
+
+var pfadd = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	pfaddFn)
+
+func pfaddFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.XLockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
+	if k == nil {
+		h := types.NewHyperLogLog()
+		h.PFAdd(args[1:]...)
+		db.SetKey(args[0], types.NewIncString(h.String()), -1)
+		cmd.Dirty(1)
+		return int64(1), nil
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	s, h, err := getHLL(k)
+	if err != nil {
+		return nil, err
+	}
+	if h.PFAdd(args[1:]...) {
+		s.Set(h.String())
+		cmd.Dirty(1)
+		return int64(1), nil
+	}
+	return int64(0), nil
+}
+
+var pfcount = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	pfcountFn)
+
+func pfcountFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	if len(args) == 1 {
+		return pfcountKey(db, args[0])
+	}
+
+	// With multiple keys, merge the HyperLogLogs in a temporary one, so
+	// that the sources are not modified.
+	db.RLock()
+	unl := db.LockKeys(false, args...)
+	hlls, err := getHLLs(db, args)
+	unl()
+	db.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	h := types.NewHyperLogLog()
+	h.PFMerge(hlls...)
+	return h.PFCount(), nil
+}
+
+// pfcountKey returns the cardinality of a single HyperLogLog. Like Redis,
+// it stores the computed cardinality in the header of the value, so the
+// key is locked exclusively.
+func pfcountKey(db srv.DB, name string) (interface{}, error) {
+	k, unl := db.LockGetKey(name, srv.NoKeyNone)
+	defer unl()
+
+	if k == nil {
+		return int64(0), nil
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	s, h, err := getHLL(k)
+	if err != nil {
+		return nil, err
+	}
+	cnt := h.PFCount()
+	s.Set(h.String())
+	return cnt, nil
+}
+
+var pfmerge = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	pfmergeFn)
+
+func pfmergeFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	db.Lock()
+	defer db.Unlock()
+	unl := db.LockKeys(true, args...)
+	defer unl()
+
+	hlls, err := getHLLs(db, args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	// The destination is part of the merge if it exists
+	dst, ok := db.GetKey(args[0])
+	if !ok {
+		h := types.NewHyperLogLog()
+		h.PFMerge(hlls...)
+		db.SetKey(args[0], types.NewIncString(h.String()), -1)
+		cmd.Dirty(1)
+		return cmd.OKVal, nil
+	}
+	s, h, err := getHLL(dst)
+	if err != nil {
+		return nil, err
+	}
+	h.PFMerge(hlls...)
+	s.Set(h.String())
+	cmd.Dirty(1)
+	return cmd.OKVal, nil
+}
+
+// getHLLs returns the HyperLogLogs stored in the keys identified by names,
+// skipping the non-existing keys. The caller must hold the DB lock and the
+// locks on the keys.
+func getHLLs(db srv.DB, names []string) ([]types.HyperLogLog, error) {
+	hlls := make([]types.HyperLogLog, 0, len(names))
+	for _, nm := range names {
+		k, ok := db.GetKey(nm)
+		if !ok {
+			continue
+		}
+		_, h, err := getHLL(k)
+		if err != nil {
+			return nil, err
+		}
+		hlls = append(hlls, h)
+	}
+	return hlls, nil
+}
+
+// getHLL returns the String value of the key k and the HyperLogLog it
+// represents. The caller must hold the lock on the key.
+func getHLL(k srv.Key) (types.String, types.HyperLogLog, error) {
+	s, ok := k.Val().(types.String)
+	if !ok {
+		return nil, nil, cmd.ErrInvalidValType
+	}
+	h, err := types.ParseHyperLogLog(s.Get())
+	switch err {
+	case nil:
+		return s, h, nil
+	case types.ErrCorruptHLL:
+		return nil, nil, cmd.ErrCorruptHLL
+	default:
+		return nil, nil, cmd.ErrNotHLL
+	}
+}
@@ -0,0 +1,155 @@
+package dbcmds
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+var dump = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 1,
+	},
+	dumpFn)
+
+func dumpFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k, unl := db.LockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
+	if k == nil {
+		return nil, nil
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+	return string(types.Dump(k.Val())), nil
+}
+
+var restore = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:    3,
+		MaxArgs:    8,
+		IntIndices: []int{1},
+	},
+	restoreFn)
+
+// restoreOpts holds the options of the RESTORE command.
+type restoreOpts struct {
+	replace, absTTL bool
+	idleTime, freq  int64
+}
+
+// parseRestoreOpts parses the options of the RESTORE command. The IDLETIME
+// and FREQ options are -1 if they are not set.
+func parseRestoreOpts(args []string) (*restoreOpts, error) {
+	opts := &restoreOpts{idleTime: -1, freq: -1}
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); opt {
+		case "replace":
+			opts.replace = true
+		case "absttl":
+			opts.absTTL = true
+		case "idletime", "freq":
+			if i+1 >= len(args) || opts.idleTime >= 0 || opts.freq >= 0 {
+				// IDLETIME and FREQ are mutually exclusive
+				return nil, cmd.ErrSyntax
+			}
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return nil, cmd.ErrNotInteger
+			}
+			if opt == "idletime" {
+				if n < 0 {
+					return nil, cmd.ErrInvalidIdleTime
+				}
+				opts.idleTime = n
+			} else {
+				if n < 0 || n > 255 {
+					return nil, cmd.ErrInvalidFreq
+				}
+				opts.freq = n
+			}
+		default:
+			return nil, cmd.ErrSyntax
+		}
+	}
+	return opts, nil
+}
+
+func restoreFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	opts, err := parseRestoreOpts(args[3:])
+	if err != nil {
+		return nil, err
+	}
+	if ints[0] < 0 {
+		return nil, cmd.ErrInvalidTTL
+	}
+	v, err := types.Restore([]byte(args[2]))
+	switch err {
+	case nil:
+	case types.ErrDumpPayload:
+		return nil, cmd.ErrDumpPayload
+	default:
+		return nil, cmd.ErrBadDataFormat
+	}
+
+	// The TTL is in milliseconds, 0 means no expiration
+	ttl, expired := time.Duration(-1), false
+	if ints[0] > 0 {
+		// The absolute time is computed in milliseconds so that it does
+		// not overflow
+		now := db.Clock().Now()
+		switch ms := ints[0]; {
+		case opts.absTTL:
+			ttl = time.UnixMilli(ms).Sub(now)
+			if ttl <= 0 {
+				// While loading, the key is created to expire once loaded
+				ttl, expired = 0, !srv.Loading()
+			}
+		case ms <= math.MaxInt64/int64(time.Millisecond):
+			ttl = time.Duration(ms) * time.Millisecond
+		case ms <= math.MaxInt64-now.UnixMilli():
+			ttl = time.UnixMilli(now.UnixMilli() + ms).Sub(now)
+		default:
+			return nil, cmd.ErrInvalidExpire
+		}
+	}
+
+	db.Lock()
+	defer db.Unlock()
+
+	k, exists := db.GetKey(args[0])
+	if exists && !opts.replace {
+		return nil, cmd.ErrBusyKey
+	}
+	if exists {
+		k.Lock()
+		db.DelKey(args[0])
+		k.Unlock()
+	}
+	// An absolute TTL in the past means the key is already expired, so it
+	// is not created, but the existing key is still replaced.
+	if expired {
+		if exists {
+			cmd.Dirty(1)
+		}
+		return cmd.OKVal, nil
+	}
+	k = db.SetKey(args[0], v, ttl)
+	if opts.idleTime >= 0 {
+		k.SetIdleTime(time.Duration(opts.idleTime) * time.Second)
+	}
+	if opts.freq >= 0 {
+		k.SetFreq(int(opts.freq))
+	}
+	cmd.Dirty(1)
+	cmd.Ready(db, args[0])
+	return cmd.OKVal, nil
+}
@@ -1,25 +1,146 @@
 package dbcmds
 
 import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/PuerkitoBio/gred/cmd"
 	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
 )
 
 func init() {
-	cmd.Register("del", del)
+	cmd.RegisterWrite("copy", copyƒ)
+	cmd.RegisterWrite("del", del)
+	cmd.Register("dump", dump)
 	cmd.Register("exists", exists)
-	cmd.Register("expire", expire)
-	cmd.Register("expireat", expireat)
-	cmd.Register("persist", persist)
-	cmd.Register("pexpire", pexpire)
-	cmd.Register("pexpireat", pexpireat)
-	cmd.Register("psetex", psetex)
+	cmd.RegisterWrite("expire", expire)
+	cmd.RegisterWrite("expireat", expireat)
+	cmd.Register("keys", keys)
+	cmd.RegisterWrite("move", move)
+	cmd.Register("object", object)
+	cmd.RegisterWrite("persist", persist)
+	cmd.RegisterWrite("pexpire", pexpire)
+	cmd.RegisterWrite("pexpireat", pexpireat)
+	cmd.RegisterWrite("psetex", psetex)
 	cmd.Register("pttl", pttl)
-	cmd.Register("setex", setex)
+	cmd.Register("randomkey", randomkey)
+	cmd.RegisterWrite("rename", rename)
+	cmd.RegisterWrite("renamenx", renamenx)
+	cmd.RegisterWrite("restore", restore)
+	cmd.Register("scan", scan)
+	cmd.RegisterWrite("setex", setex)
+	cmd.RegisterWrite("sort", sortƒ)
+	cmd.Register("sort_ro", sortRO)
+	cmd.Register("touch", touch)
 	cmd.Register("ttl", ttl)
 	cmd.Register("type", typeƒ)
+	cmd.RegisterWrite("unlink", unlink)
+}
+
+var copyƒ = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 5,
+	},
+	copyFn)
+
+func copyFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	dstDB, replace := db, false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "replace":
+			replace = true
+		case "db":
+			if i+1 >= len(args) {
+				return nil, cmd.ErrSyntax
+			}
+			i++
+			ix, err := strconv.Atoi(args[i])
+			if err != nil {
+				return nil, cmd.ErrNotInteger
+			}
+			srv.DefaultServer.Lock()
+			d, ok := srv.DefaultServer.GetDB(ix)
+			srv.DefaultServer.Unlock()
+			if !ok {
+				return nil, cmd.ErrInvalidDBIndex
+			}
+			dstDB = d
+		default:
+			return nil, cmd.ErrSyntax
+		}
+	}
+	if dstDB == db && args[0] == args[1] {
+		return nil, cmd.ErrSameObject
+	}
+
+	unl := lockDBs(db, dstDB)
+	defer unl()
+
+	k, ok := db.GetKey(args[0])
+	if !ok {
+		return false, nil
+	}
+	k.Touch()
+	dk, exists := dstDB.GetKey(args[1])
+	if exists && !replace {
+		return false, nil
+	}
+
+	k.RLock()
+	v, ttl := k.Val().Clone(), k.TTL()
+	k.RUnlock()
+
+	if exists {
+		dk.Lock()
+		dstDB.DelKey(args[1])
+		dk.Unlock()
+	}
+	dstDB.SetKey(args[1], v, ttl)
+	cmd.Dirty(1)
+	cmd.Ready(dstDB, args[1])
+	return true, nil
+}
+
+// lockDBs exclusively locks the distinct DBs, in ID order so that
+// commands that lock multiple DBs cannot deadlock each other. It returns
+// the function to call to unlock the DBs.
+func lockDBs(dbs ...srv.DB) func() {
+	sorted := make([]srv.DB, 0, len(dbs))
+	for _, db := range dbs {
+		var dup bool
+		for _, s := range sorted {
+			if s == db {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			sorted = append(sorted, db)
+		}
+	}
+	sort.Sort(byID(sorted))
+
+	for _, db := range sorted {
+		db.Lock()
+	}
+	return func() {
+		for i := len(sorted) - 1; i >= 0; i-- {
+			sorted[i].Unlock()
+		}
+	}
 }
 
+// byID sorts DBs by ID.
+type byID []srv.DB
+
+func (b byID) Len() int           { return len(b) }
+func (b byID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byID) Less(i, j int) bool { return b[i].ID() < b[j].ID() }
+
 var del = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 1,
@@ -31,13 +152,9 @@ func delFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{
 	db.Lock()
 	defer db.Unlock()
 
-	return db.Del(args...), nil
-}
-
-func delExpFn(db srv.DB, nm string) {
-	db.Lock()
-	defer db.Unlock()
-	db.Del(nm)
+	n := db.Del(args...)
+	cmd.Dirty(int(n))
+	return n, nil
 }
 
 var exists = cmd.NewDBCmd(
@@ -66,7 +183,7 @@ func expireFn(db srv.DB, args []string, ints []int64, floats []float64) (interfa
 	db.RLock()
 	defer db.RUnlock()
 
-	return db.Expire(args[0], ints[0], func() { delExpFn(db, args[0]) }), nil
+	return changed(db.Expire(args[0], ints[0])), nil
 }
 
 var expireat = cmd.NewDBCmd(
@@ -81,7 +198,75 @@ func expireatFn(db srv.DB, args []string, ints []int64, floats []float64) (inter
 	db.RLock()
 	defer db.RUnlock()
 
-	return db.ExpireAt(args[0], ints[0], func() { delExpFn(db, args[0]) }), nil
+	return changed(db.ExpireAt(args[0], ints[0])), nil
+}
+
+// changed records the change of a key if ok is true, and returns ok.
+func changed(ok bool) bool {
+	if ok {
+		cmd.Dirty(1)
+	}
+	return ok
+}
+
+var keys = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 1,
+	},
+	keysFn)
+
+func keysFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	ret := []string{}
+	for nm, k := range db.Keys() {
+		if !k.Expired() && types.MatchGlob(args[0], nm) {
+			ret = append(ret, nm)
+		}
+	}
+	return ret, nil
+}
+
+var move = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:    2,
+		MaxArgs:    2,
+		IntIndices: []int{1},
+	},
+	moveFn)
+
+func moveFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	srv.DefaultServer.Lock()
+	dstDB, ok := srv.DefaultServer.GetDB(int(ints[0]))
+	srv.DefaultServer.Unlock()
+	if !ok {
+		return nil, cmd.ErrInvalidDBIndex
+	}
+	if dstDB == db {
+		return nil, cmd.ErrSameObject
+	}
+
+	unl := lockDBs(db, dstDB)
+	defer unl()
+
+	k, ok := db.GetKey(args[0])
+	if !ok || dstDB.Exists(args[0]) {
+		return false, nil
+	}
+
+	k.Lock()
+	ttl := k.TTL()
+	db.DelKey(args[0])
+	k.Unlock()
+
+	nk := dstDB.SetKey(args[0], k.Val(), ttl)
+	nk.SetIdleTime(k.IdleTime())
+	nk.SetFreq(k.Freq())
+	cmd.Dirty(2)
+	cmd.Ready(dstDB, args[0])
+	return true, nil
 }
 
 var persist = cmd.NewDBCmd(
@@ -95,7 +280,7 @@ func persistFn(db srv.DB, args []string, ints []int64, floats []float64) (interf
 	db.RLock()
 	defer db.RUnlock()
 
-	return db.Persist(args[0]), nil
+	return changed(db.Persist(args[0])), nil
 }
 
 var pexpire = cmd.NewDBCmd(
@@ -110,7 +295,7 @@ func pexpireFn(db srv.DB, args []string, ints []int64, floats []float64) (interf
 	db.RLock()
 	defer db.RUnlock()
 
-	return db.PExpire(args[0], ints[0], func() { delExpFn(db, args[0]) }), nil
+	return changed(db.PExpire(args[0], ints[0])), nil
 }
 
 var pexpireat = cmd.NewDBCmd(
@@ -125,7 +310,7 @@ func pexpireatFn(db srv.DB, args []string, ints []int64, floats []float64) (inte
 	db.RLock()
 	defer db.RUnlock()
 
-	return db.PExpireAt(args[0], ints[0], func() { delExpFn(db, args[0]) }), nil
+	return changed(db.PExpireAt(args[0], ints[0])), nil
 }
 
 var psetex = cmd.NewDBCmd(
@@ -137,9 +322,7 @@ var psetex = cmd.NewDBCmd(
 	psetexFn)
 
 func psetexFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
-	// db locking is done inside db.PSetEx
-	db.PSetEx(args[0], ints[0], args[2], func() { delExpFn(db, args[0]) })
-	return cmd.OKVal, nil
+	return setExDuration(db, args[0], args[2], time.Duration(ints[0])*time.Millisecond)
 }
 
 var pttl = cmd.NewDBCmd(
@@ -156,6 +339,101 @@ func pttlFn(db srv.DB, args []string, ints []int64, floats []float64) (interface
 	return db.PTTL(args[0]), nil
 }
 
+var randomkey = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 0,
+		MaxArgs: 0,
+	},
+	randomkeyFn)
+
+func randomkeyFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	if nm, ok := db.RandomKey(); ok {
+		return nm, nil
+	}
+	return nil, nil
+}
+
+var rename = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 2,
+	},
+	renameFn)
+
+func renameFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	if !db.Exists(args[0]) {
+		return nil, cmd.ErrNoSuchKey
+	}
+	if args[0] != args[1] {
+		db.Rename(args[0], args[1])
+		cmd.Dirty(2)
+		cmd.Ready(db, args[1])
+	}
+	return cmd.OKVal, nil
+}
+
+var renamenx = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 2,
+	},
+	renamenxFn)
+
+func renamenxFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	if !db.Exists(args[0]) {
+		return nil, cmd.ErrNoSuchKey
+	}
+	if db.Exists(args[1]) {
+		return false, nil
+	}
+	db.Rename(args[0], args[1])
+	cmd.Dirty(2)
+	cmd.Ready(db, args[1])
+	return true, nil
+}
+
+var scan = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 7,
+	},
+	scanFn)
+
+func scanFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	opts, err := cmd.ParseScanOpts("scan", args)
+	if err != nil {
+		return nil, err
+	}
+
+	db.RLock()
+	defer db.RUnlock()
+
+	cur, page := db.Scan(opts.Cursor, opts.Count)
+
+	// The filters are applied after the page is selected, so that the
+	// cursor only depends on the keys in the DB.
+	ret := []string{}
+	for _, nm := range page {
+		if opts.Match != "" && !types.MatchGlob(opts.Match, nm) {
+			continue
+		}
+		if opts.Type != "" && db.Type(nm) != opts.Type {
+			continue
+		}
+		ret = append(ret, nm)
+	}
+	return []interface{}{strconv.FormatUint(cur, 10), ret}, nil
+}
+
 var setex = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs:    3,
@@ -165,11 +443,45 @@ var setex = cmd.NewDBCmd(
 	setexFn)
 
 func setexFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
-	// db locking is done inside db.SetEx
-	db.SetEx(args[0], ints[0], args[2], func() { delExpFn(db, args[0]) })
+	return setExDuration(db, args[0], args[2], time.Duration(ints[0])*time.Second)
+}
+
+// setExDuration implements SETEX and PSETEX, setting the key to the string
+// value v that expires after dur.
+func setExDuration(db srv.DB, name, v string, dur time.Duration) (interface{}, error) {
+	if dur <= 0 {
+		return nil, cmd.ErrInvalidExpire
+	}
+
+	db.Lock()
+	defer db.Unlock()
+
+	db.Set(name, v, srv.SetOpts{ExpireAt: db.Clock().Now().Add(dur)})
+	cmd.Dirty(1)
 	return cmd.OKVal, nil
 }
 
+var touch = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	touchFn)
+
+func touchFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	var cnt int64
+	for _, nm := range args {
+		if k, ok := db.GetKey(nm); ok {
+			k.Touch()
+			cnt++
+		}
+	}
+	return cnt, nil
+}
+
 var ttl = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 1,
@@ -197,3 +509,12 @@ func typeFn(db srv.DB, args []string, ints []int64, floats []float64) (interface
 
 	return db.Type(args[0]), nil
 }
+
+// unlink is the same as DEL: neither frees the values of the keys, which
+// are reclaimed by the garbage collector once the keys are removed.
+var unlink = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	delFn)
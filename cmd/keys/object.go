@@ -0,0 +1,73 @@
+package dbcmds
+
+import (
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+// objectHelp is the reply of the OBJECT HELP command.
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+var object = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 2,
+	},
+	objectFn)
+
+func objectFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	sub := strings.ToLower(args[0])
+	switch {
+	case sub == "help" && len(args) == 1:
+		return objectHelp, nil
+	case len(args) != 2:
+		return nil, cmd.ErrUnknownSubcommand
+	}
+	switch sub {
+	case "encoding", "freq", "idletime", "refcount":
+	default:
+		return nil, cmd.ErrUnknownSubcommand
+	}
+
+	// The key is looked up directly so that it is not recorded as accessed
+	db.RLock()
+	defer db.RUnlock()
+
+	k, ok := db.GetKey(args[1])
+	if !ok {
+		return nil, nil
+	}
+
+	switch sub {
+	case "freq":
+		return int64(k.Freq()), nil
+	case "idletime":
+		return int64(k.IdleTime() / time.Second), nil
+	case "refcount":
+		// Values are never shared between keys
+		return int64(1), nil
+	}
+	k.RLock()
+	defer k.RUnlock()
+	return types.Encoding(k.Val()), nil
+}
@@ -0,0 +1,367 @@
+package dbcmds
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+var sortƒ = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	sortFn)
+
+func sortFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return sortCmd(db, args, true)
+}
+
+var sortRO = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	sortROFn)
+
+func sortROFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return sortCmd(db, args, false)
+}
+
+// sortOpts holds the options of the SORT command.
+type sortOpts struct {
+	by            string
+	noSort        bool
+	offset, count int64
+	get           []string
+	desc, alpha   bool
+	store         string
+}
+
+// parseSortOpts parses the options of the SORT command, the STORE option
+// being accepted only if store is true.
+func parseSortOpts(args []string, store bool) (*sortOpts, error) {
+	opts := &sortOpts{count: -1}
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); opt {
+		case "asc":
+			opts.desc = false
+		case "desc":
+			opts.desc = true
+		case "alpha":
+			opts.alpha = true
+		case "by", "get", "store":
+			if i+1 >= len(args) || (opt == "store" && !store) {
+				return nil, cmd.ErrSyntax
+			}
+			i++
+			switch opt {
+			case "by":
+				opts.by = args[i]
+				// A pattern without "*" references the same key for all the
+				// elements, so they are not sorted
+				opts.noSort = strings.IndexByte(args[i], '*') < 0
+			case "get":
+				opts.get = append(opts.get, args[i])
+			case "store":
+				opts.store = args[i]
+			}
+		case "limit":
+			if i+2 >= len(args) {
+				return nil, cmd.ErrSyntax
+			}
+			ofs, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return nil, cmd.ErrNotInteger
+			}
+			cnt, err := strconv.ParseInt(args[i+2], 10, 64)
+			if err != nil {
+				return nil, cmd.ErrNotInteger
+			}
+			opts.offset, opts.count = ofs, cnt
+			i += 2
+		default:
+			return nil, cmd.ErrSyntax
+		}
+	}
+	return opts, nil
+}
+
+// sortCmd implements SORT and SORT_RO.
+func sortCmd(db srv.DB, args []string, store bool) (interface{}, error) {
+	opts, err := parseSortOpts(args[1:], store)
+	if err != nil {
+		return nil, err
+	}
+
+	// With STORE, the destination is replaced, so must have an exclusive lock
+	if opts.store != "" {
+		db.Lock()
+		defer db.Unlock()
+	} else {
+		db.RLock()
+		defer db.RUnlock()
+	}
+
+	// The keys referenced by the patterns depend on the elements, so they
+	// are only known once the source key is read. Read the elements, then
+	// lock the source and the referenced keys together, and read the
+	// elements again, until all the referenced keys are locked.
+	names := []string{args[0]}
+	var vals []interface{}
+	for {
+		unl := db.LockKeys(false, names...)
+		elems, err := sortElems(db, args[0], opts.noSort && opts.desc)
+		if err != nil {
+			unl()
+			return nil, err
+		}
+		refs := opts.refs(elems)
+		if !lockedAll(names, refs) {
+			unl()
+			names = append(names[:1], refs...)
+			continue
+		}
+		vals, err = opts.sort(db, elems)
+		unl()
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	if opts.store == "" {
+		return vals, nil
+	}
+
+	// If destination exists, remove any expiration and delete
+	dst, exists := db.Keys()[opts.store]
+	if exists {
+		dst.Lock()
+		db.DelKey(opts.store)
+		dst.Unlock()
+	}
+	if len(vals) == 0 {
+		if exists {
+			cmd.Dirty(1)
+		}
+		return int64(0), nil
+	}
+
+	// Then create the destination key, missing values are stored as empty
+	// strings
+	strs := make([]string, len(vals))
+	for i, v := range vals {
+		if v != nil {
+			strs[i] = v.(string)
+		}
+	}
+	l := types.NewList()
+	db.SetKey(opts.store, l, -1)
+	n := l.RPush(strs...)
+	cmd.Dirty(1)
+	cmd.Ready(db, opts.store)
+	return n, nil
+}
+
+// sortElems returns the elements of the list, set or sorted set held by
+// the key identified by name, or an empty list if the key does not exist.
+// The elements of a sorted set are in reverse order if rev is true.
+func sortElems(db srv.DB, name string, rev bool) ([]string, error) {
+	k, ok := db.GetKey(name)
+	if !ok {
+		return nil, nil
+	}
+	switch v := k.Val().(type) {
+	case types.List:
+		return v.LRange(0, -1), nil
+	case types.Set:
+		return v.SMembers(), nil
+	case types.SortedSet:
+		sms := v.ZRange(0, -1, rev)
+		elems := make([]string, len(sms))
+		for i, sm := range sms {
+			elems[i] = sm.Member
+		}
+		return elems, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+// lockedAll returns true if all refs are in names.
+func lockedAll(names, refs []string) bool {
+	set := make(map[string]bool, len(names))
+	for _, nm := range names {
+		set[nm] = true
+	}
+	for _, ref := range refs {
+		if !set[ref] {
+			return false
+		}
+	}
+	return true
+}
+
+// refs returns the names of the keys referenced by the BY and GET patterns
+// for the elements.
+func (o *sortOpts) refs(elems []string) []string {
+	patterns := o.get
+	if o.by != "" && !o.noSort {
+		patterns = append([]string{o.by}, patterns...)
+	}
+
+	var refs []string
+	for _, p := range patterns {
+		for _, e := range elems {
+			if name, _, ok := patternKey(p, e); ok {
+				refs = append(refs, name)
+			}
+		}
+	}
+	return refs
+}
+
+// sortElem is an element to sort along with its sort value.
+type sortElem struct {
+	elem  string
+	by    string
+	hasBy bool
+	score float64
+}
+
+// sort sorts the elements according to the options, and returns the values
+// to return for the requested window of elements: the elements themselves,
+// or the values referenced by the GET patterns. All the keys referenced by
+// the patterns must be locked.
+func (o *sortOpts) sort(db srv.DB, elems []string) ([]interface{}, error) {
+	ses := make([]sortElem, len(elems))
+	for i, e := range elems {
+		ses[i] = sortElem{elem: e, by: e, hasBy: true}
+		if o.noSort {
+			continue
+		}
+		if o.by != "" {
+			ses[i].by, ses[i].hasBy = lookupPattern(db, o.by, e)
+		}
+		if !o.alpha && ses[i].hasBy {
+			f, err := strconv.ParseFloat(ses[i].by, 64)
+			if err != nil || math.IsNaN(f) {
+				return nil, cmd.ErrSortNotDouble
+			}
+			ses[i].score = f
+		}
+	}
+	if !o.noSort {
+		sort.Stable(bySortOpts{ses, o})
+	}
+
+	// Apply the LIMIT window
+	start, end := o.offset, int64(len(ses))
+	if start < 0 {
+		start = 0
+	}
+	if start > end {
+		start = end
+	}
+	if o.count >= 0 && start+o.count < end {
+		end = start + o.count
+	}
+	ses = ses[start:end]
+
+	if len(o.get) == 0 {
+		vals := make([]interface{}, len(ses))
+		for i, se := range ses {
+			vals[i] = se.elem
+		}
+		return vals, nil
+	}
+	vals := make([]interface{}, 0, len(ses)*len(o.get))
+	for _, se := range ses {
+		for _, p := range o.get {
+			if v, ok := lookupPattern(db, p, se.elem); ok {
+				vals = append(vals, v)
+			} else {
+				vals = append(vals, nil)
+			}
+		}
+	}
+	return vals, nil
+}
+
+// bySortOpts sorts elements according to the sort options.
+type bySortOpts struct {
+	ses  []sortElem
+	opts *sortOpts
+}
+
+func (s bySortOpts) Len() int      { return len(s.ses) }
+func (s bySortOpts) Swap(i, j int) { s.ses[i], s.ses[j] = s.ses[j], s.ses[i] }
+func (s bySortOpts) Less(i, j int) bool {
+	a, b := s.ses[i], s.ses[j]
+	if s.opts.desc {
+		a, b = b, a
+	}
+	if !s.opts.alpha {
+		if a.score != b.score {
+			return a.score < b.score
+		}
+		// Same score, compare the elements so that the order is defined
+		return a.elem < b.elem
+	}
+	// Missing sort values are before the others
+	if !a.hasBy || !b.hasBy {
+		return !a.hasBy && b.hasBy
+	}
+	return a.by < b.by
+}
+
+// patternKey returns the name of the key referenced by the pattern for the
+// element elem, and the hash field if the pattern references one using
+// "->". It returns false if the pattern does not reference a key.
+func patternKey(pattern, elem string) (string, string, bool) {
+	star := strings.IndexByte(pattern, '*')
+	if pattern == "#" || star < 0 {
+		return "", "", false
+	}
+
+	rest, field := pattern[star+1:], ""
+	if arrow := strings.Index(rest, "->"); arrow >= 0 && arrow+2 < len(rest) {
+		rest, field = rest[:arrow], rest[arrow+2:]
+	}
+	return pattern[:star] + elem + rest, field, true
+}
+
+// lookupPattern returns the value referenced by the pattern for the element
+// elem: the element itself if the pattern is "#", the value of a string
+// key, or the value of a field of a hash key. It returns false if there is
+// no such value. The key must be locked.
+func lookupPattern(db srv.DB, pattern, elem string) (string, bool) {
+	if pattern == "#" {
+		return elem, true
+	}
+	name, field, ok := patternKey(pattern, elem)
+	if !ok {
+		return "", false
+	}
+	k, ok := db.GetKey(name)
+	if !ok {
+		return "", false
+	}
+
+	switch v := k.Val().(type) {
+	case types.String:
+		if field == "" {
+			return v.Get(), true
+		}
+	case types.Hash:
+		if field != "" {
+			return v.HGet(field)
+		}
+	}
+	return "", false
+}
@@ -1,6 +1,7 @@
 package lists
 
 import (
+	"strconv"
 	"time"
 
 	"github.com/PuerkitoBio/gred/cmd"
@@ -9,7 +10,8 @@ import (
 )
 
 // unblock unblocks as many waiters as possible that are blocked waiting
-// for a value from this key. Both the DB and the key must be under an
+// for a value from this key. The values popped for the waiters are
+// propagated as LMPOP commands. Both the DB and the key must be under an
 // exclusive lock.
 func unblock(db srv.DB, k srv.Key, v types.List) int {
 	var cnt int
@@ -17,53 +19,115 @@ func unblock(db srv.DB, k srv.Key, v types.List) int {
 	// While there are values...
 	for v.LLen() > 0 {
 		// Get a waiter
-		ch, r := db.NextWaiter(k.Name())
+		ch, flag, count := db.NextWaiter(k.Name(), srv.WaitLPop, srv.WaitRPop)
 		if ch == nil {
 			// No more waiter, return
 			return cnt
 		}
 		sendch, ok := <-ch
 
-		// Was the waiting channel closed? If not, send it a value.
+		// Was the waiting channel closed? If not, send it the values.
 		if ok {
-			// Has to return a value, because LLen is checked first
-			var val string
-			if r {
-				val, _ = v.RPop()
-			} else {
-				val, _ = v.LPop()
-			}
+			// Has to return at least a value, because LLen is checked first
+			vals := pop(v, flag == srv.WaitRPop, count)
+			propagatePop(k.Name(), flag == srv.WaitRPop, len(vals))
 			cnt++
-			sendch <- [2]string{k.Name(), val}
+			sendch <- append([]string{k.Name()}, vals...)
 		}
 	}
 	return cnt
 }
 
-func blockPop(db srv.DB, secs int64, rpop bool, lists ...string) ([]string, error) {
+// ready unblocks the waiters on the key name if it holds a List, and
+// deletes it if they pop all of its values. The DB must be exclusively
+// locked.
+func ready(db srv.DB, name string) {
+	k, ok := db.GetKey(name)
+	if !ok {
+		return
+	}
+	k.Lock()
+	defer k.Unlock()
+
+	if v, ok := k.Val().(types.List); ok && unblock(db, k, v) > 0 && v.LLen() == 0 {
+		db.DelKey(name)
+	}
+}
+
+// pop pops at most count values from the list, from the tail if rpop is
+// true, from the head otherwise.
+func pop(v types.List, rpop bool, count int64) []string {
+	var vals []string
+	for ; count > 0; count-- {
+		var val string
+		var ok bool
+		if rpop {
+			val, ok = v.RPop()
+		} else {
+			val, ok = v.LPop()
+		}
+		if !ok {
+			break
+		}
+		vals = append(vals, val)
+	}
+	return vals
+}
+
+// propagatePop propagates the pop of n values from the list key, from the
+// tail if rpop is true, from the head otherwise, as an LMPOP command, and
+// records the change of the key.
+func propagatePop(key string, rpop bool, n int) {
+	dir := "left"
+	if rpop {
+		dir = "right"
+	}
+	cmd.Propagate("lmpop", "1", key, dir, "count", strconv.Itoa(n))
+	cmd.Dirty(1)
+}
+
+// parseTimeout parses the timeout argument of a blocking command, expressed
+// in seconds.
+func parseTimeout(arg string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return 0, cmd.ErrTimeoutNotFloat
+	}
+	if secs < 0 {
+		return 0, cmd.ErrTimeoutNegative
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// blockPop pops at most count values from the first non-empty list, from
+// the tail if rpop is true, from the head otherwise. If all lists are
+// empty, it waits for at most timeout (or forever if timeout is 0) for a
+// value to be pushed on one of the lists, or returns immediately if
+// timeout is negative. It returns the name of the key followed by the
+// values, or nil if it timed out. The values popped are propagated as an
+// LMPOP command, whether they are popped by this call or pushed to it by
+// another command, instead of the calling command.
+func blockPop(db srv.DB, timeout time.Duration, rpop bool, count int64, lists ...string) ([]string, error) {
+	cmd.NoPropagate()
+
 	db.Lock()
 	unlocks := make([]func(), 0)
 	unlocks = append(unlocks, db.Unlock)
 
-	keys := db.Keys()
 	for _, nm := range lists {
-		k, ok := keys[nm]
+		k, ok := db.GetKey(nm)
 		// Ignore non-existing keys in non-blocking portion
 		if ok {
 			// Lock the key
+			k.Touch()
 			k.Lock()
 			unlocks = append(unlocks, k.Unlock)
 
-			// Get the value, if possible
+			// Get the values, if possible
 			v := k.Val()
 			if v, ok := v.(types.List); ok {
-				var val string
-				if rpop {
-					val, ok = v.RPop()
-				} else {
-					val, ok = v.LPop()
-				}
-				if ok {
+				if vals := pop(v, rpop, count); len(vals) > 0 {
+					propagatePop(k.Name(), rpop, len(vals))
 					// Delete the key if there are no more values
 					if v.LLen() == 0 {
 						db.DelKey(k.Name())
@@ -73,7 +137,7 @@ func blockPop(db srv.DB, secs int64, rpop bool, lists ...string) ([]string, erro
 					for i := len(unlocks) - 1; i >= 0; i-- {
 						unlocks[i]()
 					}
-					return []string{k.Name(), val}, nil
+					return append([]string{k.Name()}, vals...), nil
 				}
 			} else {
 				// Unlock all keys in reverse order
@@ -86,35 +150,44 @@ func blockPop(db srv.DB, secs int64, rpop bool, lists ...string) ([]string, erro
 		}
 	}
 
-	// If no value was readily available, now all keys are locked, enter
-	// the waiting workflow.
-	ch := make(chan chan<- [2]string)
-	for _, nm := range lists {
-		if rpop {
-			db.WaitRPop(nm, ch)
-		} else {
-			db.WaitLPop(nm, ch)
+	// If no value was readily available and the call must not block,
+	// return nil.
+	if timeout < 0 {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
 		}
+		return nil, nil
+	}
+
+	// Otherwise, now all keys are locked, enter the waiting workflow.
+	ch := make(chan chan<- []string)
+	flag := srv.WaitLPop
+	if rpop {
+		flag = srv.WaitRPop
+	}
+	for _, nm := range lists {
+		db.Wait(nm, ch, flag, count)
 	}
 
 	// Prepare channels (timeout and receive values)
 	var timeoutCh <-chan time.Time
-	if secs > 0 {
-		timeoutCh = time.After(time.Duration(secs) * time.Second)
+	if timeout > 0 {
+		timeoutCh = time.After(timeout)
 	}
-	recCh := make(chan [2]string)
+	recCh := make(chan []string)
 
 	// Unlock all locks so that other connections can proceed
 	for i := len(unlocks) - 1; i >= 0; i-- {
 		unlocks[i]()
 	}
 
-	// Wait for a value
+	// Wait for a value, letting other write commands execute meanwhile
+	resume := cmd.Block()
+	defer resume()
 	select {
-	case ch <- (chan<- [2]string)(recCh):
+	case ch <- (chan<- []string)(recCh):
 		close(ch)
-		vals := <-recCh
-		return vals[:], nil
+		return <-recCh, nil
 	case <-timeoutCh:
 		close(ch)
 		return nil, nil
@@ -1,7 +1,9 @@
 package lists
 
 import (
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/PuerkitoBio/gred/cmd"
 	"github.com/PuerkitoBio/gred/srv"
@@ -9,23 +11,66 @@ import (
 )
 
 func init() {
-	cmd.Register("blpop", blpop)
-	cmd.Register("brpop", brpop)
-	cmd.Register("brpoplpush", brpoplpush)
+	cmd.RegisterWrite("blmove", blmove)
+	cmd.RegisterWrite("blmpop", blmpop)
+	cmd.RegisterWrite("blpop", blpop)
+	cmd.RegisterWrite("brpop", brpop)
+	cmd.RegisterWrite("brpoplpush", brpoplpush)
 	cmd.Register("lindex", lindex)
-	cmd.Register("linsert", linsert)
+	cmd.RegisterWrite("linsert", linsert)
 	cmd.Register("llen", llen)
-	cmd.Register("lpop", lpop)
-	cmd.Register("lpush", lpush)
-	cmd.Register("lpushx", lpushx)
+	cmd.RegisterWrite("lmove", lmove)
+	cmd.RegisterWrite("lmpop", lmpop)
+	cmd.RegisterWrite("lpop", lpop)
+	cmd.Register("lpos", lpos)
+	cmd.RegisterWrite("lpush", lpush)
+	cmd.RegisterWrite("lpushx", lpushx)
 	cmd.Register("lrange", lrange)
-	cmd.Register("lrem", lrem)
-	cmd.Register("lset", lset)
-	cmd.Register("ltrim", ltrim)
-	cmd.Register("rpop", rpop)
-	cmd.Register("rpoplpush", rpoplpush)
-	cmd.Register("rpush", rpush)
-	cmd.Register("rpushx", rpushx)
+	cmd.RegisterWrite("lrem", lrem)
+	cmd.RegisterWrite("lset", lset)
+	cmd.RegisterWrite("ltrim", ltrim)
+	cmd.RegisterWrite("rpop", rpop)
+	cmd.RegisterWrite("rpoplpush", rpoplpush)
+	cmd.RegisterWrite("rpush", rpush)
+	cmd.RegisterWrite("rpushx", rpushx)
+	cmd.RegisterReady(ready)
+}
+
+var blmove = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:    5,
+		MaxArgs:    5,
+		ValidateFn: validateMoveDirs,
+	},
+	blmoveFn)
+
+func blmoveFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	timeout, err := parseTimeout(args[4])
+	if err != nil {
+		return nil, err
+	}
+	return blockMove(db, timeout, args[0], args[1], args[2] == "right", args[3] == "right")
+}
+
+var blmpop = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:    4,
+		MaxArgs:    -1,
+		IntIndices: []int{1},
+	},
+	blmpopFn)
+
+func blmpopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	timeout, err := parseTimeout(args[0])
+	if err != nil {
+		return nil, err
+	}
+	lists, rpop, count, err := parseMPop(args[2:], ints[0])
+	if err != nil {
+		return nil, err
+	}
+	vals, err := blockPop(db, timeout, rpop, count, lists...)
+	return mpopReply(vals, err)
 }
 
 var blpop = cmd.NewDBCmd(
@@ -37,7 +82,10 @@ var blpop = cmd.NewDBCmd(
 	blpopFn)
 
 func blpopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
-	ar, err := blockPop(db, ints[0], false, args[:len(args)-1]...)
+	if ints[0] < 0 {
+		return nil, cmd.ErrTimeoutNegative
+	}
+	ar, err := blockPop(db, time.Duration(ints[0])*time.Second, false, 1, args[:len(args)-1]...)
 	if ar == nil {
 		return nil, err
 	}
@@ -53,7 +101,10 @@ var brpop = cmd.NewDBCmd(
 	brpopFn)
 
 func brpopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
-	ar, err := blockPop(db, ints[0], true, args[:len(args)-1]...)
+	if ints[0] < 0 {
+		return nil, cmd.ErrTimeoutNegative
+	}
+	ar, err := blockPop(db, time.Duration(ints[0])*time.Second, true, 1, args[:len(args)-1]...)
 	if ar == nil {
 		return nil, err
 	}
@@ -69,23 +120,99 @@ var brpoplpush = cmd.NewDBCmd(
 	brpoplpushFn)
 
 func brpoplpushFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
-	// First do the brpop part
-	vals, err := blockPop(db, ints[0], true, args[0])
+	if ints[0] < 0 {
+		return nil, cmd.ErrTimeoutNegative
+	}
+	return blockMove(db, time.Duration(ints[0])*time.Second, args[0], args[1], true, false)
+}
+
+// blockMove implements BRPOPLPUSH and BLMOVE. It pops a value from the
+// src list, blocking for at most timeout if it is empty, and pushes it
+// on the dst list. The value is popped from the tail if fromRight is true,
+// and pushed on the tail if toRight is true.
+func blockMove(db srv.DB, timeout time.Duration, src, dst string, fromRight, toRight bool) (interface{}, error) {
+	// If the src list is not empty, move the value atomically, so that it
+	// is not popped if dst does not hold a list. Otherwise fail early if
+	// dst does not hold a list, before blocking.
+	db.Lock()
+	if _, ok := db.GetKey(src); ok {
+		defer db.Unlock()
+		return moveLocked(db, src, dst, fromRight, toRight)
+	}
+	ok := canPush(db, dst)
+	db.Unlock()
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+
+	// Then do the blocking pop part
+	vals, err := blockPop(db, timeout, fromRight, 1, src)
 	if vals == nil {
 		// Return either an error, or the nil timeout value
 		return nil, err
 	}
 
-	// Then proceed with lpush
-	_, err = lpushFn(db, []string{args[1], vals[1]}, nil, nil)
-	if err != nil {
-		return nil, err
+	// Then proceed with the push. The dst key may have been replaced while
+	// blocked, in which case the value is pushed back on src so that it is
+	// not lost.
+	db.Lock()
+	defer db.Unlock()
+	if !canPush(db, dst) {
+		if canPush(db, src) {
+			pushMoved(db, src, fromRight, vals[1])
+		}
+		return nil, cmd.ErrInvalidValType
 	}
+	pushMoved(db, dst, toRight, vals[1])
 
 	// Return the value popped and pushed
 	return vals[1], nil
 }
 
+// canPush returns true if the key name does not exist or holds a List, so
+// that a value can be pushed on it. The DB must be locked.
+func canPush(db srv.DB, name string) bool {
+	k, ok := db.GetKey(name)
+	if !ok {
+		return true
+	}
+	k.RLock()
+	defer k.RUnlock()
+	_, ok = k.Val().(types.List)
+	return ok
+}
+
+// pushMoved pushes the value popped by a blocking move on the list name,
+// creating it if it does not exist, on the tail if right is true. It is
+// propagated as an LPUSH or RPUSH command before any value it unblocks.
+// The DB must be exclusively locked, and the key must hold a List if it
+// exists.
+func pushMoved(db srv.DB, name string, right bool, val string) {
+	if right {
+		cmd.Propagate("rpush", name, val)
+	} else {
+		cmd.Propagate("lpush", name, val)
+	}
+
+	k, ok := db.GetKey(name)
+	if !ok {
+		k = db.SetKey(name, types.NewList(), -1)
+	}
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val().(types.List)
+	pushTo(v, right, val)
+	cmd.Dirty(1)
+	// Unblock any waiters on this key
+	if unblock(db, k, v) > 0 {
+		// If the list is now empty, delete the key
+		if v.LLen() == 0 {
+			db.DelKey(name)
+		}
+	}
+}
+
 var lindex = cmd.NewSingleKeyCmd(
 	&cmd.ArgDef{
 		MinArgs:    2,
@@ -132,10 +259,16 @@ func linsertFn(k srv.Key, args []string, ints []int64, floats []float64) (interf
 
 	v := k.Val()
 	if v, ok := v.(types.List); ok {
+		var n int64
 		if args[1] == "before" {
-			return v.LInsertBefore(args[2], args[3]), nil
+			n = v.LInsertBefore(args[2], args[3])
+		} else {
+			n = v.LInsertAfter(args[2], args[3])
+		}
+		if n > 0 {
+			cmd.Dirty(1)
 		}
-		return v.LInsertAfter(args[2], args[3]), nil
+		return n, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -159,6 +292,95 @@ func llenFn(k srv.Key, args []string, ints []int64, floats []float64) (interface
 	return nil, cmd.ErrInvalidValType
 }
 
+var lmove = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:    4,
+		MaxArgs:    4,
+		ValidateFn: validateMoveDirs,
+	},
+	lmoveFn)
+
+func lmoveFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return move(db, args[0], args[1], args[2] == "right", args[3] == "right")
+}
+
+// validateMoveDirs validates the LEFT and RIGHT directions of LMOVE and
+// BLMOVE, and turns them to lowercase.
+func validateMoveDirs(args []string, ints []int64, floats []float64) error {
+	for _, i := range []int{2, 3} {
+		dir := strings.ToLower(args[i])
+		if dir != "left" && dir != "right" {
+			return cmd.ErrSyntax
+		}
+		args[i] = dir
+	}
+	return nil
+}
+
+var lmpop = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:    3,
+		MaxArgs:    -1,
+		IntIndices: []int{0},
+	},
+	lmpopFn)
+
+func lmpopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	lists, rpop, count, err := parseMPop(args[1:], ints[0])
+	if err != nil {
+		return nil, err
+	}
+	// A negative timeout never blocks
+	vals, err := blockPop(db, -1, rpop, count, lists...)
+	return mpopReply(vals, err)
+}
+
+// parseMPop parses the arguments of LMPOP and BLMPOP that follow numkeys:
+// the keys, the direction and the COUNT option. It returns the keys, true
+// if the values are popped from the tail, and the count.
+func parseMPop(args []string, numkeys int64) ([]string, bool, int64, error) {
+	if numkeys <= 0 {
+		return nil, false, 0, cmd.ErrNumKeysNotPositive
+	}
+	// The direction must follow the keys
+	if numkeys >= int64(len(args)) {
+		return nil, false, 0, cmd.ErrSyntax
+	}
+
+	lists, opts := args[:numkeys], args[numkeys:]
+	var rpop bool
+	switch strings.ToLower(opts[0]) {
+	case "left":
+	case "right":
+		rpop = true
+	default:
+		return nil, false, 0, cmd.ErrSyntax
+	}
+
+	count := int64(1)
+	switch {
+	case len(opts) == 1:
+	case len(opts) == 3 && strings.ToLower(opts[1]) == "count":
+		n, err := strconv.ParseInt(opts[2], 10, 64)
+		if err != nil || n <= 0 {
+			return nil, false, 0, cmd.ErrPopCountNotPositive
+		}
+		count = n
+	default:
+		return nil, false, 0, cmd.ErrSyntax
+	}
+	return lists, rpop, count, nil
+}
+
+// mpopReply returns the reply of LMPOP and BLMPOP for the values returned
+// by blockPop: the name of the key and the array of values, or nil.
+func mpopReply(vals []string, err error) (interface{}, error) {
+	if vals == nil {
+		return nil, err
+	}
+	return []interface{}{vals[0], vals[1:]}, nil
+}
+
 var lpop = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 1,
@@ -186,6 +408,7 @@ func lpopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface
 			if v.LLen() == 0 {
 				db.DelKey(args[0])
 			}
+			cmd.Dirty(1)
 			return val, nil
 		}
 		return nil, nil
@@ -193,6 +416,67 @@ func lpopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface
 	return nil, cmd.ErrInvalidValType
 }
 
+var lpos = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 8,
+	},
+	srv.NoKeyDefaultVal,
+	lposFn)
+
+func lposFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// Parse the options
+	rank, count, maxLen, hasCount := int64(1), int64(1), int64(0), false
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, cmd.ErrSyntax
+		}
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			return nil, cmd.ErrNotInteger
+		}
+		switch strings.ToLower(args[i]) {
+		case "rank":
+			if n == 0 {
+				return nil, cmd.ErrRankZero
+			}
+			rank = n
+		case "count":
+			if n < 0 {
+				return nil, cmd.ErrNegativeCount
+			}
+			count, hasCount = n, true
+		case "maxlen":
+			if n < 0 {
+				return nil, cmd.ErrNegativeMaxLen
+			}
+			maxLen = n
+		default:
+			return nil, cmd.ErrSyntax
+		}
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.List); ok {
+		ixs := v.LPos(args[1], rank, count, maxLen)
+		if !hasCount {
+			if len(ixs) == 0 {
+				return nil, nil
+			}
+			return ixs[0], nil
+		}
+		ret := make([]interface{}, len(ixs))
+		for i, ix := range ixs {
+			ret[i] = ix
+		}
+		return ret, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
 var lpush = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 2,
@@ -212,6 +496,7 @@ func lpushFn(db srv.DB, args []string, ints []int64, floats []float64) (interfac
 	v := k.Val()
 	if v, ok := v.(types.List); ok {
 		val := v.LPush(args[1:]...)
+		cmd.Dirty(1)
 		// Unblock any waiters on this key
 		if unblock(db, k, v) > 0 {
 			// If the list is now empty, delete the key
@@ -244,7 +529,9 @@ func lpushxFn(k srv.Key, args []string, ints []int64, floats []float64) (interfa
 
 	v := k.Val()
 	if v, ok := v.(types.List); ok {
-		return v.LPush(args[1:]...), nil
+		n := v.LPush(args[1:]...)
+		cmd.Dirty(1)
+		return n, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -297,6 +584,7 @@ func lremFn(db srv.DB, args []string, ints []int64, floats []float64) (interface
 			if v.LLen() == 0 {
 				db.DelKey(args[0])
 			}
+			cmd.Dirty(1)
 		}
 		return val, nil
 	}
@@ -324,6 +612,7 @@ func lsetFn(k srv.Key, args []string, ints []int64, floats []float64) (interface
 	if v, ok := v.(types.List); ok {
 		ok := v.LSet(ints[0], args[2])
 		if ok {
+			cmd.Dirty(1)
 			return cmd.OKVal, nil
 		}
 		return nil, cmd.ErrOutOfRange
@@ -353,11 +642,15 @@ func ltrimFn(db srv.DB, args []string, ints []int64, floats []float64) (interfac
 	// Trim the value
 	v := k.Val()
 	if v, ok := v.(types.List); ok {
+		n := v.LLen()
 		v.LTrim(ints[0], ints[1])
 		// If the list is now empty, delete the key
 		if v.LLen() == 0 {
 			db.DelKey(args[0])
 		}
+		if v.LLen() < n {
+			cmd.Dirty(1)
+		}
 		return cmd.OKVal, nil
 	}
 	return nil, cmd.ErrInvalidValType
@@ -390,6 +683,7 @@ func rpopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface
 			if v.LLen() == 0 {
 				db.DelKey(args[0])
 			}
+			cmd.Dirty(1)
 			return val, nil
 		}
 		return nil, nil
@@ -405,87 +699,110 @@ var rpoplpush = cmd.NewDBCmd(
 	rpoplpushFn)
 
 func rpoplpushFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
-	// Since RPOPLPUSH may delete the key (if the src list is empty), must get an exclusive
+	return move(db, args[0], args[1], true, false)
+}
+
+// move implements RPOPLPUSH and LMOVE. It pops a value from the src list
+// and pushes it on the dst list. The value is popped from the tail if
+// fromRight is true, and pushed on the tail if toRight is true.
+func move(db srv.DB, src, dst string, fromRight, toRight bool) (interface{}, error) {
+	// Since the move may delete the key (if the src list is empty), must get an exclusive
 	// DB lock right away (can't think of a sane way to upgrade the lock without restartint
 	// the whole operation).
 	db.Lock()
 	defer db.Unlock()
 
+	return moveLocked(db, src, dst, fromRight, toRight)
+}
+
+// moveLocked implements move, the DB being exclusively locked.
+func moveLocked(db srv.DB, src, dst string, fromRight, toRight bool) (interface{}, error) {
 	// Get the source key
-	keys := db.Keys()
-	src, ok := keys[args[0]]
+	sk, ok := db.GetKey(src)
 	if !ok {
 		// Source key does not exist, return nil
 		return nil, nil
 	}
+	sk.Touch()
 
 	// If source exists, and source and destination are the same
-	if args[0] == args[1] {
-		src.Lock()
-		defer src.Unlock()
+	if src == dst {
+		sk.Lock()
+		defer sk.Unlock()
 
-		// Simply rotate the value (pop at tail, push at head)
+		// Simply rotate the value (e.g. pop at tail, push at head)
 		// Do not check if list is empty to delete it, since we push back
 		// to the same list.
-		v := src.Val()
+		v := sk.Val()
 		if v, ok := v.(types.List); ok {
-			val, ok := v.RPop()
-			if ok {
-				v.LPush(val)
-				return val, nil
+			vals := pop(v, fromRight, 1)
+			if len(vals) > 0 {
+				pushTo(v, toRight, vals[0])
+				cmd.Dirty(1)
+				return vals[0], nil
 			}
 			return nil, nil
 		}
 		return nil, cmd.ErrInvalidValType
 	}
 
-	src.Lock()
-	defer src.Unlock()
+	sk.Lock()
+	defer sk.Unlock()
 	// Exit early if the source key does not hold a List so that the dst
 	// is not created.
-	vs := src.Val()
+	vs := sk.Val()
 	vsrc, ok := vs.(types.List)
 	if !ok {
 		return nil, cmd.ErrInvalidValType
 	}
 
 	// Otherwise get the destination key, and create it if it doesn't exist
-	dst, ok := keys[args[1]]
+	dk, ok := db.GetKey(dst)
 	if !ok {
 		// Destination does not exist, create it
-		dst = srv.NewKey(args[1], types.NewList())
-		keys[args[1]] = dst
+		dk = db.SetKey(dst, types.NewList(), -1)
 	}
 
-	dst.Lock()
-	defer dst.Unlock()
+	dk.Lock()
+	defer dk.Unlock()
 	// Get the dst value, make sure it is a List
-	vd := dst.Val()
+	vd := dk.Val()
 	vdst, ok := vd.(types.List)
 	if !ok {
 		return nil, cmd.ErrInvalidValType
 	}
 
 	// Both are lists, proceed
-	val, ok := vsrc.RPop()
-	if ok {
+	vals := pop(vsrc, fromRight, 1)
+	if len(vals) > 0 {
 		// Check if the src is now empty, if so delete the key
 		if vsrc.LLen() == 0 {
-			db.DelKey(args[0])
+			db.DelKey(src)
 		}
-		vdst.LPush(val)
+		pushTo(vdst, toRight, vals[0])
+		cmd.Dirty(2)
 		// Unblock any waiters on the dst key
-		if unblock(db, dst, vdst) > 0 {
+		if unblock(db, dk, vdst) > 0 {
 			// If the list is now empty, delete the key
 			if vdst.LLen() == 0 {
-				db.DelKey(args[1])
+				db.DelKey(dst)
 			}
 		}
-		return val, nil
+		return vals[0], nil
 	}
 	return nil, nil
 }
 
+// pushTo pushes val on the tail of the list if right is true, on the head
+// otherwise.
+func pushTo(v types.List, right bool, val string) {
+	if right {
+		v.RPush(val)
+	} else {
+		v.LPush(val)
+	}
+}
+
 var rpush = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 2,
@@ -505,6 +822,7 @@ func rpushFn(db srv.DB, args []string, ints []int64, floats []float64) (interfac
 	v := k.Val()
 	if v, ok := v.(types.List); ok {
 		val := v.RPush(args[1:]...)
+		cmd.Dirty(1)
 		// Unblock any waiters on this key
 		if unblock(db, k, v) > 0 {
 			// If the list is now empty, delete the key
@@ -537,7 +855,9 @@ func rpushxFn(k srv.Key, args []string, ints []int64, floats []float64) (interfa
 
 	v := k.Val()
 	if v, ok := v.(types.List); ok {
-		return v.RPush(args[1:]...), nil
+		n := v.RPush(args[1:]...)
+		cmd.Dirty(1)
+		return n, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ScanOpts holds the options of the SCAN family of commands.
+type ScanOpts struct {
+	// Cursor is the position where the iteration resumes, 0 to start a
+	// new iteration.
+	Cursor uint64
+
+	// Match is the glob-style pattern that the returned elements must
+	// match, or an empty string to return all elements.
+	Match string
+
+	// Count is the number of elements to examine in this call.
+	Count int64
+
+	// Type is the type of the keys to return, or an empty string to return
+	// keys of any type. It is only supported by SCAN.
+	Type string
+
+	// NoValues indicates that only the fields are returned. It is only
+	// supported by HSCAN.
+	NoValues bool
+}
+
+// ParseScanOpts parses the cursor and options of a SCAN-style command from
+// args, which must start with the cursor. The TYPE option is accepted only
+// by the "scan" command, and the NOVALUES option only by "hscan".
+func ParseScanOpts(name string, args []string) (ScanOpts, error) {
+	cur, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return ScanOpts{}, ErrInvalidCursor
+	}
+
+	opts := ScanOpts{Cursor: cur, Count: 10}
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		if opt == "novalues" && name == "hscan" {
+			opts.NoValues = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return ScanOpts{}, ErrSyntax
+		}
+		i++
+		switch {
+		case opt == "match":
+			opts.Match = args[i]
+		case opt == "count":
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return ScanOpts{}, ErrNotInteger
+			}
+			if n < 1 {
+				return ScanOpts{}, ErrSyntax
+			}
+			opts.Count = n
+		case opt == "type" && name == "scan":
+			opts.Type = strings.ToLower(args[i])
+		default:
+			return ScanOpts{}, ErrSyntax
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import "testing"
+
+func TestParseScanOpts(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		exp  ScanOpts
+		err  error
+	}{
+		0:  {"sscan", []string{"0"}, ScanOpts{0, "", 10, "", false}, nil},
+		1:  {"sscan", []string{"12", "MATCH", "a*", "count", "5"}, ScanOpts{12, "a*", 5, "", false}, nil},
+		2:  {"scan", []string{"0", "type", "HASH"}, ScanOpts{0, "", 10, "hash", false}, nil},
+		3:  {"zscan", []string{"0", "type", "hash"}, ScanOpts{}, ErrSyntax},
+		4:  {"hscan", []string{"0", "novalues", "count", "2"}, ScanOpts{0, "", 2, "", true}, nil},
+		5:  {"hscan", []string{"0", "count", "2", "NOVALUES"}, ScanOpts{0, "", 2, "", true}, nil},
+		6:  {"scan", []string{"0", "novalues"}, ScanOpts{}, ErrSyntax},
+		7:  {"scan", []string{"-1"}, ScanOpts{}, ErrInvalidCursor},
+		8:  {"scan", []string{"a"}, ScanOpts{}, ErrInvalidCursor},
+		9:  {"scan", []string{"0", "count", "0"}, ScanOpts{}, ErrSyntax},
+		10: {"scan", []string{"0", "count", "a"}, ScanOpts{}, ErrNotInteger},
+		11: {"scan", []string{"0", "count"}, ScanOpts{}, ErrSyntax},
+		12: {"scan", []string{"0", "foo", "bar"}, ScanOpts{}, ErrSyntax},
+	}
+	for i, c := range cases {
+		got, err := ParseScanOpts(c.name, c.args)
+		if err != c.err {
+			t.Errorf("%d: expected error %v, got %v", i, c.err, err)
+		}
+		if got != c.exp {
+			t.Errorf("%d: expected %+v, got %+v", i, c.exp, got)
+		}
+	}
+}
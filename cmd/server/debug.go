@@ -0,0 +1,47 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+)
+
+var debugƒ = cmd.NewSrvCmd(
+	&cmd.ArgDef{
+		IntIndices: []int{1},
+		MinArgs:    2,
+		MaxArgs:    2,
+	},
+	debugFn)
+
+// debugFn implements the DEBUG SET-TIME and DEBUG ADVANCE subcommands, that
+// move the clock of the server forward and delete the keys that expired, so
+// that the expiration can be tested deterministically. They require the
+// server to run with a fake clock.
+func debugFn(args []string, ints []int64, floats []float64) (interface{}, error) {
+	sub := strings.ToLower(args[0])
+	if sub != "set-time" && sub != "advance" {
+		return nil, cmd.ErrUnknownSubcommand
+	}
+	clock, ok := srv.DefaultServer.Clock().(*srv.FakeClock)
+	if !ok {
+		return nil, cmd.ErrDebugTimeDisabled
+	}
+
+	if sub == "set-time" {
+		t := time.Unix(0, 0).Add(time.Duration(ints[0]) * time.Millisecond)
+		if t.Before(clock.Now()) {
+			return nil, cmd.ErrClockBackwards
+		}
+		clock.Set(t)
+	} else {
+		if ints[0] < 0 {
+			return nil, cmd.ErrClockBackwards
+		}
+		clock.Advance(time.Duration(ints[0]) * time.Millisecond)
+	}
+	srv.DefaultServer.DelExpired()
+	return cmd.OKVal, nil
+}
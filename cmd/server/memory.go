@@ -0,0 +1,153 @@
+package server
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+)
+
+// defaultSamples is the number of elements of an aggregate value sampled by
+// MEMORY USAGE when the SAMPLES option is not set.
+const defaultSamples = 5
+
+// startupAllocated is the number of bytes allocated on the heap when the
+// server started.
+var startupAllocated int64
+
+func init() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	startupAllocated = int64(ms.HeapAlloc)
+}
+
+// memoryHelp is the reply of the MEMORY HELP command.
+var memoryHelp = []string{
+	"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"PURGE",
+	"    Return unused memory to the operating system.",
+	"STATS",
+	"    Return information about the memory usage of the server.",
+	"USAGE <key> [SAMPLES <count>]",
+	"    Return memory in bytes used by <key> and its value. Nested values are",
+	"    sampled up to <count> times (default: 5, 0 means sample all).",
+	"HELP",
+	"    Print this help.",
+}
+
+var memory = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 4,
+	},
+	memoryFn)
+
+func memoryFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "usage" && len(args) >= 2:
+		return memoryUsage(db, args[1], args[2:])
+	case sub == "stats" && len(args) == 1:
+		return memoryStats(), nil
+	case sub == "purge" && len(args) == 1:
+		debug.FreeOSMemory()
+		return cmd.OKVal, nil
+	case sub == "help" && len(args) == 1:
+		return memoryHelp, nil
+	}
+	return nil, cmd.ErrUnknownSubcommand
+}
+
+// memoryUsage returns the estimated number of bytes used by the key name and
+// its value, or nil if the key does not exist.
+func memoryUsage(db srv.DB, name string, opts []string) (interface{}, error) {
+	samples := defaultSamples
+	if len(opts) > 0 {
+		if len(opts) != 2 || strings.ToLower(opts[0]) != "samples" {
+			return nil, cmd.ErrSyntax
+		}
+		n, err := strconv.Atoi(opts[1])
+		if err != nil {
+			return nil, cmd.ErrNotInteger
+		}
+		if n < 0 {
+			return nil, cmd.ErrSyntax
+		}
+		samples = n
+	}
+
+	// The key is looked up directly so that it is not recorded as accessed
+	db.RLock()
+	defer db.RUnlock()
+
+	k, ok := db.GetKey(name)
+	if !ok {
+		return nil, nil
+	}
+	k.RLock()
+	defer k.RUnlock()
+	return k.MemoryUsage(samples), nil
+}
+
+// memoryStats returns the memory statistics of the server, as a list of
+// name-value pairs. The allocator statistics are those of the Go heap. The
+// overhead of the keys is counted by the databases as keys are added and
+// deleted, and the size of the dataset is the rest of the heap allocated
+// since startup, as Redis does, so that the keys are not scanned.
+func memoryStats() []interface{} {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	srv.DefaultServer.RLock()
+	defer srv.DefaultServer.RUnlock()
+
+	var stats []interface{}
+	var keys, overhead int64
+	for i := 0; i < srv.DefaultServer.Databases(); i++ {
+		db, _ := srv.DefaultServer.GetDB(i)
+		db.RLock()
+		dbKeys, dbOverhead, dbExpires := db.MemoryStats()
+		db.RUnlock()
+		if dbKeys == 0 {
+			continue
+		}
+		stats = append(stats, "db."+strconv.Itoa(i), []interface{}{
+			"overhead.hashtable.main", dbOverhead,
+			"overhead.hashtable.expires", dbExpires,
+		})
+		keys += dbKeys
+		overhead += dbOverhead + dbExpires
+	}
+
+	total := int64(ms.HeapAlloc)
+	perKey, dataset, datasetPct := int64(0), int64(0), 0.0
+	if total > startupAllocated {
+		if keys > 0 {
+			perKey = (total - startupAllocated) / keys
+		}
+		if dataset = total - startupAllocated - overhead; dataset < 0 {
+			dataset = 0
+		}
+		datasetPct = float64(dataset) * 100 / float64(total-startupAllocated)
+	}
+	fragmentation := 0.0
+	if ms.HeapAlloc > 0 {
+		fragmentation = float64(ms.HeapInuse) / float64(ms.HeapAlloc)
+	}
+
+	return append([]interface{}{
+		"total.allocated", total,
+		"startup.allocated", startupAllocated,
+		"allocator.allocated", int64(ms.HeapAlloc),
+		"allocator.active", int64(ms.HeapInuse),
+		"allocator.resident", int64(ms.HeapSys),
+		"allocator.fragmentation.ratio", strconv.FormatFloat(fragmentation, 'f', 3, 64),
+		"overhead.total", startupAllocated + overhead,
+		"keys.count", keys,
+		"keys.bytes-per-key", perKey,
+		"dataset.bytes", dataset,
+		"dataset.percentage", strconv.FormatFloat(datasetPct, 'f', 3, 64),
+	}, stats...)
+}
@@ -3,14 +3,58 @@ package server
 import (
 	"strconv"
 
+	"github.com/PuerkitoBio/gred/aof"
 	"github.com/PuerkitoBio/gred/cmd"
 	"github.com/PuerkitoBio/gred/srv"
 )
 
 func init() {
-	cmd.Register("flushdb", flushdb)
-	cmd.Register("flushall", flushall)
-	cmd.Register("time", time)
+	cmd.Register("bgrewriteaof", bgrewriteaof)
+	cmd.Register("bgsave", bgsave)
+	cmd.Register("debug", debugƒ)
+	cmd.RegisterWrite("flushdb", flushdb)
+	cmd.RegisterWrite("flushall", flushall)
+	cmd.Register("lastsave", lastsave)
+	cmd.Register("memory", memory)
+	cmd.Register("save", save)
+	cmd.RegisterWrite("swapdb", swapdb)
+	cmd.Register("time", timeƒ)
+}
+
+var bgrewriteaof = cmd.NewSrvCmd(
+	&cmd.ArgDef{
+		MinArgs: 0,
+		MaxArgs: 0,
+	},
+	bgrewriteaofFn)
+
+func bgrewriteaofFn(args []string, ints []int64, floats []float64) (interface{}, error) {
+	if aof.Default == nil {
+		return nil, cmd.ErrAOFDisabled
+	}
+	// The rewrite itself runs in the background, it can only fail to
+	// start if one is already in progress.
+	if err := aof.Default.Rewrite(); err != nil {
+		return nil, cmd.ErrRewriteInProgress
+	}
+	return cmd.BgRewriteAOFStartedVal, nil
+}
+
+var bgsave = cmd.NewSrvCmd(
+	&cmd.ArgDef{
+		MinArgs: 0,
+		MaxArgs: 0,
+	},
+	bgsaveFn)
+
+func bgsaveFn(args []string, ints []int64, floats []float64) (interface{}, error) {
+	srv.DefaultServer.Lock()
+	defer srv.DefaultServer.Unlock()
+
+	if err := srv.DefaultServer.BgSave(srv.SnapshotFile); err != nil {
+		return nil, saveErr(err)
+	}
+	return cmd.BgSaveStartedVal, nil
 }
 
 var flushdb = cmd.NewDBCmd(
@@ -24,7 +68,9 @@ func flushdbFn(db srv.DB, args []string, ints []int64, floats []float64) (interf
 	db.Lock()
 	defer db.Unlock()
 
+	n := len(db.Keys())
 	db.FlushDB()
+	cmd.Dirty(n)
 	return cmd.OKVal, nil
 }
 
@@ -39,11 +85,106 @@ func flushallFn(args []string, ints []int64, floats []float64) (interface{}, err
 	srv.DefaultServer.Lock()
 	defer srv.DefaultServer.Unlock()
 
+	var n int
+	for i := 0; i < srv.DefaultServer.Databases(); i++ {
+		db, _ := srv.DefaultServer.GetDB(i)
+		n += dbSize(db)
+	}
 	srv.DefaultServer.FlushAll()
+	cmd.Dirty(n)
 	return cmd.OKVal, nil
 }
 
-var time = cmd.NewSrvCmd(
+var lastsave = cmd.NewSrvCmd(
+	&cmd.ArgDef{
+		MinArgs: 0,
+		MaxArgs: 0,
+	},
+	lastsaveFn)
+
+func lastsaveFn(args []string, ints []int64, floats []float64) (interface{}, error) {
+	return srv.DefaultServer.LastSave().Unix(), nil
+}
+
+var save = cmd.NewSrvCmd(
+	&cmd.ArgDef{
+		MinArgs: 0,
+		MaxArgs: 0,
+	},
+	saveFn)
+
+func saveFn(args []string, ints []int64, floats []float64) (interface{}, error) {
+	srv.DefaultServer.Lock()
+	defer srv.DefaultServer.Unlock()
+
+	if err := srv.DefaultServer.Save(srv.SnapshotFile); err != nil {
+		return nil, saveErr(err)
+	}
+	return cmd.OKVal, nil
+}
+
+// saveErr returns the command error corresponding to the error returned by
+// a save of the server.
+func saveErr(err error) error {
+	if err == srv.ErrBgSaveInProgress {
+		return cmd.ErrBgSaveInProgress
+	}
+	return cmd.ErrSaveFailed
+}
+
+var swapdb = cmd.NewSrvCmd(
+	&cmd.ArgDef{
+		MinArgs:    2,
+		MaxArgs:    2,
+		IntIndices: []int{0, 1},
+	},
+	swapdbFn)
+
+func swapdbFn(args []string, ints []int64, floats []float64) (interface{}, error) {
+	srv.DefaultServer.Lock()
+	defer srv.DefaultServer.Unlock()
+
+	db1, ok1 := srv.DefaultServer.GetDB(int(ints[0]))
+	db2, ok2 := srv.DefaultServer.GetDB(int(ints[1]))
+	if !ok1 || !ok2 {
+		return nil, cmd.ErrInvalidDBIndex
+	}
+	if db1 == db2 {
+		return cmd.OKVal, nil
+	}
+
+	// Lock the databases in ID order, as the commands that lock multiple
+	// databases do
+	if db1.ID() > db2.ID() {
+		db1, db2 = db2, db1
+	}
+	db1.Lock()
+	defer db1.Unlock()
+	db2.Lock()
+	defer db2.Unlock()
+
+	srv.DefaultServer.SwapDB(int(ints[0]), int(ints[1]))
+
+	// The keys of both databases are now under the other index, and the
+	// clients blocked on either database may be served by them
+	cmd.Dirty(len(db1.Keys()) + len(db2.Keys()))
+	for _, db := range []srv.DB{db1, db2} {
+		for _, nm := range db.WaitKeys() {
+			cmd.Ready(db, nm)
+		}
+	}
+	return cmd.OKVal, nil
+}
+
+// dbSize returns the number of keys in the database db, including the
+// expired keys not yet deleted.
+func dbSize(db srv.DB) int {
+	db.RLock()
+	defer db.RUnlock()
+	return len(db.Keys())
+}
+
+var timeƒ = cmd.NewSrvCmd(
 	&cmd.ArgDef{
 		MinArgs: 0,
 		MaxArgs: 0,
@@ -1,18 +1,33 @@
 package sets
 
 import (
+	"sort"
+	"strconv"
+	"strings"
+
 	"github.com/PuerkitoBio/gred/cmd"
 	"github.com/PuerkitoBio/gred/srv"
 	"github.com/PuerkitoBio/gred/types"
 )
 
 func init() {
-	cmd.Register("sadd", sadd)
+	cmd.RegisterWrite("sadd", sadd)
 	cmd.Register("scard", scard)
 	cmd.Register("sdiff", sdiff)
-	cmd.Register("sdiffstore", sdiffstore)
+	cmd.RegisterWrite("sdiffstore", sdiffstore)
+	cmd.Register("sinter", sinter)
+	cmd.Register("sintercard", sintercard)
+	cmd.RegisterWrite("sinterstore", sinterstore)
 	cmd.Register("sismember", sismember)
 	cmd.Register("smembers", smembers)
+	cmd.Register("smismember", smismember)
+	cmd.RegisterWrite("smove", smove)
+	cmd.RegisterWrite("spop", spop)
+	cmd.Register("srandmember", srandmember)
+	cmd.RegisterWrite("srem", srem)
+	cmd.Register("sscan", sscan)
+	cmd.Register("sunion", sunion)
+	cmd.RegisterWrite("sunionstore", sunionstore)
 }
 
 var sadd = cmd.NewSingleKeyCmd(
@@ -29,7 +44,11 @@ func saddFn(k srv.Key, args []string, ints []int64, floats []float64) (interface
 
 	v := k.Val()
 	if v, ok := v.(types.Set); ok {
-		return v.SAdd(args[1:]...), nil
+		n := v.SAdd(args[1:]...)
+		if n > 0 {
+			cmd.Dirty(1)
+		}
+		return n, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -61,35 +80,7 @@ var sdiff = cmd.NewDBCmd(
 	sdiffFn)
 
 func sdiffFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
-	db.RLock()
-	defer db.RUnlock()
-
-	// Get and rlock all keys
-	keys := db.Keys()
-	diffSets := make([]types.Set, 0, len(args))
-	first := true
-	for _, nm := range args {
-		// Check if key exists
-		if k, ok := keys[nm]; ok {
-			// It does, rlock the key
-			k.RLock()
-			defer k.RUnlock()
-
-			// Get the value, make sure it is a Set
-			v := k.Val()
-			if v, ok := v.(types.Set); ok {
-				diffSets = append(diffSets, v)
-			} else {
-				return nil, cmd.ErrInvalidValType
-			}
-		} else if first {
-			// If first key does not exist, insert an empty set
-			diffSets = append(diffSets, types.NewSet())
-		}
-		first = false
-	}
-
-	return diffSets[0].SDiff(diffSets[1:]...), nil
+	return opFn(db, args, diff)
 }
 
 var sdiffstore = cmd.NewDBCmd(
@@ -100,48 +91,79 @@ var sdiffstore = cmd.NewDBCmd(
 	sdiffstoreFn)
 
 func sdiffstoreFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
-	// In every case, a new key is created at destination, so must have a lock
-	db.Lock()
-	defer db.Unlock()
+	return storeFn(db, args, diff)
+}
 
-	keys := db.Keys()
+var sinter = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	sinterFn)
 
-	diffSets := make([]types.Set, 0, len(args)-1)
-	first := true
-	for _, nm := range args[1:] {
-		// Check if key exists
-		if k, ok := keys[nm]; ok {
-			// It does, rlock the key
-			k.RLock()
-			defer k.RUnlock()
-
-			// Get the value, make sure it is a Set
-			v := k.Val()
-			if v, ok := v.(types.Set); ok {
-				diffSets = append(diffSets, v)
-			} else {
-				return nil, cmd.ErrInvalidValType
-			}
-		} else if first {
-			// If first key does not exist, insert an empty set
-			diffSets = append(diffSets, types.NewSet())
+func sinterFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return opFn(db, args, inter)
+}
+
+var sintercard = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:    2,
+		MaxArgs:    -1,
+		IntIndices: []int{0},
+	},
+	sintercardFn)
+
+func sintercardFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	n := ints[0]
+	if n <= 0 {
+		return nil, cmd.ErrNumKeysNotPositive
+	}
+	if n > int64(len(args)-1) {
+		return nil, cmd.ErrNumKeysTooMany
+	}
+
+	// Parse the LIMIT option
+	var limit int64
+	opts := args[1+n:]
+	switch {
+	case len(opts) == 0:
+	case len(opts) == 2 && strings.ToLower(opts[0]) == "limit":
+		var err error
+		limit, err = strconv.ParseInt(opts[1], 10, 64)
+		if err != nil || limit < 0 {
+			return nil, cmd.ErrNegativeLimit
 		}
-		first = false
+	default:
+		return nil, cmd.ErrSyntax
 	}
 
-	val := diffSets[0].SDiff(diffSets[1:]...)
-	// If destination exists, remove any expiration and delete
-	if dst, ok := keys[args[0]]; ok {
-		dst.Lock()
-		dst.Abort()
-		delete(keys, args[0])
-		dst.Unlock()
+	db.RLock()
+	defer db.RUnlock()
+
+	names := args[1 : 1+n]
+	unl := db.LockKeys(false, names...)
+	defer unl()
+
+	sets, err := getSets(db, names)
+	if err != nil {
+		return nil, err
 	}
-	// Then create the destination key
-	newSet := types.NewSet()
-	dst := srv.NewKey(args[0], newSet)
-	keys[args[0]] = dst
-	return newSet.SAdd(val...), nil
+	sets, ok := sortByCard(sets)
+	if !ok {
+		return int64(0), nil
+	}
+	return sets[0].SInterCard(limit, sets[1:]...), nil
+}
+
+var sinterstore = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: -1,
+	},
+	sinterstoreFn)
+
+func sinterstoreFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return storeFn(db, args, inter)
 }
 
 var sismember = cmd.NewSingleKeyCmd(
@@ -181,3 +203,378 @@ func smembersFn(k srv.Key, args []string, ints []int64, floats []float64) (inter
 	}
 	return nil, cmd.ErrInvalidValType
 }
+
+var smismember = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: -1,
+	},
+	srv.NoKeyDefaultVal,
+	smismemberFn)
+
+func smismemberFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.Set); ok {
+		ret := make([]interface{}, len(args)-1)
+		for i, m := range args[1:] {
+			ret[i] = v.SIsMember(m)
+		}
+		return ret, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var smove = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 3,
+	},
+	smoveFn)
+
+func smoveFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// SMOVE may create the destination and delete the source, so it
+	// requires an exclusive DB lock.
+	db.Lock()
+	defer db.Unlock()
+
+	// Lock both keys in a consistent order
+	unl := db.LockKeys(true, args[0], args[1])
+	defer unl()
+
+	src, ok := db.GetKey(args[0])
+	if !ok {
+		return false, nil
+	}
+	vsrc, ok := src.Val().(types.Set)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+	var vdst types.Set
+	if dst, ok := db.GetKey(args[1]); ok {
+		if vdst, ok = dst.Val().(types.Set); !ok {
+			return nil, cmd.ErrInvalidValType
+		}
+	}
+
+	// If source and destination are the same, nothing to move
+	if args[0] == args[1] {
+		return vsrc.SIsMember(args[2]), nil
+	}
+
+	if vsrc.SRem(args[2]) == 0 {
+		return false, nil
+	}
+	if vsrc.SCard() == 0 {
+		db.DelKey(args[0])
+	}
+	if vdst == nil {
+		// The new key is not locked, but no other connection can access it
+		// while the DB is exclusively locked.
+		vdst = types.NewSet()
+		db.SetKey(args[1], vdst, -1)
+	}
+	vdst.SAdd(args[2])
+	cmd.Dirty(2)
+	return true, nil
+}
+
+var spop = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 2,
+	},
+	spopFn)
+
+func spopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	cnt, err := parseCount(args)
+	if err != nil {
+		return nil, err
+	}
+	if cnt < 0 {
+		return nil, cmd.ErrNotPositive
+	}
+
+	// Since SPOP may delete the key (if the set is empty), must get an exclusive
+	// DB lock right away.
+	k, unl := db.XLockGetKey(args[0], srv.NoKeyDefaultVal)
+	defer unl()
+
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val()
+	if v, ok := v.(types.Set); ok {
+		vals := v.SPop(cnt)
+		if len(vals) > 0 {
+			if v.SCard() == 0 {
+				db.DelKey(args[0])
+			}
+			cmd.Dirty(1)
+		}
+		if len(args) > 1 {
+			return vals, nil
+		}
+		if len(vals) == 0 {
+			return nil, nil
+		}
+		return vals[0], nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var srandmember = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 2,
+	},
+	srv.NoKeyDefaultVal,
+	srandmemberFn)
+
+func srandmemberFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	cnt, err := parseCount(args)
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.Set); ok {
+		vals := v.SRandMember(cnt)
+		if len(args) > 1 {
+			return vals, nil
+		}
+		if len(vals) == 0 {
+			return nil, nil
+		}
+		return vals[0], nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var srem = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: -1,
+	},
+	sremFn)
+
+func sremFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// Since SREM may delete the key (if the set is empty), must get an exclusive
+	// DB lock right away.
+	k, unl := db.XLockGetKey(args[0], srv.NoKeyDefaultVal)
+	defer unl()
+
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val()
+	if v, ok := v.(types.Set); ok {
+		ret := v.SRem(args[1:]...)
+		if ret > 0 {
+			if v.SCard() == 0 {
+				db.DelKey(args[0])
+			}
+			cmd.Dirty(1)
+		}
+		return ret, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var sscan = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: -1,
+	},
+	srv.NoKeyDefaultVal,
+	sscanFn)
+
+func sscanFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	opts, err := cmd.ParseScanOpts("sscan", args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.Set); ok {
+		cur, mbrs := v.SScan(opts.Cursor, opts.Count, opts.Match)
+		return []interface{}{strconv.FormatUint(cur, 10), mbrs}, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var sunion = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	sunionFn)
+
+func sunionFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return opFn(db, args, union)
+}
+
+var sunionstore = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: -1,
+	},
+	sunionstoreFn)
+
+func sunionstoreFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return storeFn(db, args, union)
+}
+
+// setOpFn defines the function signature of an operation across multiple
+// sets. A nil set in the list represents a non-existing key.
+type setOpFn func([]types.Set) []string
+
+// opFn implements the SDIFF, SINTER and SUNION commands, applying op to
+// the sets identified by args.
+func opFn(db srv.DB, args []string, op setOpFn) (interface{}, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	unl := db.LockKeys(false, args...)
+	defer unl()
+
+	sets, err := getSets(db, args)
+	if err != nil {
+		return nil, err
+	}
+	return op(sets), nil
+}
+
+// storeFn implements the SDIFFSTORE, SINTERSTORE and SUNIONSTORE commands,
+// applying op to the sets identified by args[1:] and storing the result
+// in args[0].
+func storeFn(db srv.DB, args []string, op setOpFn) (interface{}, error) {
+	// In every case, the destination is replaced, so must have an exclusive lock
+	db.Lock()
+	defer db.Unlock()
+
+	// Read-lock the source keys, they are unlocked once the result is computed
+	// so that the destination can be locked even if it is also a source.
+	keys := db.Keys()
+	unl := db.LockKeys(false, args[1:]...)
+	sets, err := getSets(db, args[1:])
+	if err != nil {
+		unl()
+		return nil, err
+	}
+	vals := op(sets)
+	unl()
+
+	// If destination exists, remove any expiration and delete
+	dst, exists := keys[args[0]]
+	if exists {
+		dst.Lock()
+		db.DelKey(args[0])
+		dst.Unlock()
+	}
+	if len(vals) == 0 {
+		if exists {
+			cmd.Dirty(1)
+		}
+		return int64(0), nil
+	}
+
+	// Then create the destination key
+	newSet := types.NewSet()
+	db.SetKey(args[0], newSet, -1)
+	cmd.Dirty(1)
+	return newSet.SAdd(vals...), nil
+}
+
+// getSets returns the Set values of the keys identified by names, with
+// a nil Set for non-existing keys. It returns an error if a key holds
+// a value that is not a Set. The caller must hold the DB lock and the locks
+// on the keys.
+func getSets(db srv.DB, names []string) ([]types.Set, error) {
+	sets := make([]types.Set, len(names))
+	for i, nm := range names {
+		if k, ok := db.GetKey(nm); ok {
+			v, ok := k.Val().(types.Set)
+			if !ok {
+				return nil, cmd.ErrInvalidValType
+			}
+			sets[i] = v
+		}
+	}
+	return sets, nil
+}
+
+// diff returns the members of the first set that are not in the other sets.
+func diff(sets []types.Set) []string {
+	if sets[0] == nil {
+		return []string{}
+	}
+	return sets[0].SDiff(nonNil(sets[1:])...)
+}
+
+// inter returns the members that are in all sets.
+func inter(sets []types.Set) []string {
+	sets, ok := sortByCard(sets)
+	if !ok {
+		return []string{}
+	}
+	return sets[0].SInter(sets[1:]...)
+}
+
+// union returns the members that are in any of the sets.
+func union(sets []types.Set) []string {
+	sets = nonNil(sets)
+	if len(sets) == 0 {
+		return []string{}
+	}
+	return sets[0].SUnion(sets[1:]...)
+}
+
+// nonNil returns the non-nil sets.
+func nonNil(sets []types.Set) []types.Set {
+	ret := make([]types.Set, 0, len(sets))
+	for _, s := range sets {
+		if s != nil {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// sortByCard returns a copy of the sets sorted by cardinality, so that an
+// intersection iterates over the smallest set. It returns false if any of
+// the sets is nil, in which case the intersection is empty.
+func sortByCard(sets []types.Set) ([]types.Set, bool) {
+	sorted := make([]types.Set, len(sets))
+	for i, s := range sets {
+		if s == nil {
+			return nil, false
+		}
+		sorted[i] = s
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].SCard() < sorted[j].SCard()
+	})
+	return sorted, true
+}
+
+// parseCount parses the optional count argument of SPOP and SRANDMEMBER,
+// which defaults to 1.
+func parseCount(args []string) (int64, error) {
+	if len(args) < 2 {
+		return 1, nil
+	}
+	cnt, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return 0, cmd.ErrNotInteger
+	}
+	return cnt, nil
+}
@@ -0,0 +1,281 @@
+package streams
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+var xread = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: -1,
+	},
+	xreadFn)
+
+func xreadFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	opts, err := parseReadOpts(args, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.streams)%2 != 0 {
+		return nil, cmd.ErrUnbalancedStreams
+	}
+	names, sids := opts.streams[:len(opts.streams)/2], opts.streams[len(opts.streams)/2:]
+
+	// Parse the IDs, "$" is resolved once the keys are locked
+	ids := make([]types.StreamID, len(sids))
+	for j, s := range sids {
+		if s == "$" {
+			continue
+		}
+		id, err := types.ParseStreamID(s, 0)
+		if err != nil {
+			return nil, cmd.ErrInvalidStreamID
+		}
+		ids[j] = id
+	}
+
+	first := true
+	return blockRead(db, opts.block, false, names, func(db srv.DB) ([]interface{}, error) {
+		if first {
+			// Resolve the "$" IDs to the last ID of the stream
+			for i, s := range sids {
+				if k, ok := db.GetKey(names[i]); ok && s == "$" {
+					if v, ok := k.Val().(types.Stream); ok {
+						ids[i] = v.LastID()
+					}
+				}
+			}
+			first = false
+		}
+		return readStreams(db, names, ids, opts.count)
+	})
+}
+
+// readOpts holds the options of XREAD and XREADGROUP.
+type readOpts struct {
+	count   int64
+	block   time.Duration
+	noack   bool
+	streams []string
+}
+
+// parseReadOpts parses the options of XREAD, or XREADGROUP if group is
+// true, up to the STREAMS argument. The keys and IDs following STREAMS
+// are returned in the streams field.
+func parseReadOpts(args []string, group bool) (readOpts, error) {
+	opts := readOpts{count: -1, block: -1}
+
+	i := 0
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		if opt == "streams" {
+			break
+		}
+		if group && opt == "noack" {
+			opts.noack = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return opts, cmd.ErrSyntax
+		}
+		switch opt {
+		case "count":
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return opts, cmd.ErrNotInteger
+			}
+			if n > 0 {
+				opts.count = n
+			}
+		case "block":
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return opts, cmd.ErrTimeoutNotInteger
+			}
+			if n < 0 {
+				return opts, cmd.ErrTimeoutNegative
+			}
+			opts.block = time.Duration(n) * time.Millisecond
+		default:
+			return opts, cmd.ErrSyntax
+		}
+		i++
+	}
+	if i >= len(args) || i+1 == len(args) {
+		return opts, cmd.ErrSyntax
+	}
+	opts.streams = args[i+1:]
+	return opts, nil
+}
+
+// readFn reads from the streams held by the keys of db. It is called with
+// the DB lock and the locks on the stream keys.
+type readFn func(db srv.DB) ([]interface{}, error)
+
+// blockRead calls read to read from the streams identified by names. If
+// there is nothing to read and block is not negative, it waits for new
+// entries for at most block (or forever if block is 0) and tries again.
+// The stream keys are exclusively locked if excl is true, and the calling
+// command is then a write command that lets the other write commands
+// execute while it waits.
+func blockRead(db srv.DB, block time.Duration, excl bool, names []string, read readFn) (interface{}, error) {
+	var timeoutCh <-chan time.Time
+	if block > 0 {
+		timeoutCh = time.After(block)
+	}
+
+	for {
+		db.Lock()
+		unl := db.LockKeys(excl, names...)
+
+		res, err := read(db)
+		if err != nil || len(res) > 0 || block < 0 {
+			unl()
+			db.Unlock()
+			if len(res) == 0 {
+				return nil, err
+			}
+			return res, err
+		}
+
+		// Nothing to read, wait for new entries
+		ch := make(chan chan<- []string)
+		for _, nm := range names {
+			db.Wait(nm, ch, srv.WaitStream, 0)
+		}
+		recCh := make(chan []string)
+		unl()
+		db.Unlock()
+
+		resume := func() {}
+		if excl {
+			resume = cmd.Block()
+		}
+		select {
+		case ch <- (chan<- []string)(recCh):
+			close(ch)
+			<-recCh
+			resume()
+		case <-timeoutCh:
+			close(ch)
+			resume()
+			return nil, nil
+		}
+	}
+}
+
+// readStreams returns the reply of XREAD, with the entries of each stream
+// that have an ID greater than the corresponding ID in ids. Only the
+// streams with such entries are part of the reply. The caller must hold
+// the DB lock and the locks on the keys.
+func readStreams(db srv.DB, names []string, ids []types.StreamID, count int64) ([]interface{}, error) {
+	var res []interface{}
+	for i, nm := range names {
+		k, ok := db.GetKey(nm)
+		if !ok {
+			continue
+		}
+		v, ok := k.Val().(types.Stream)
+		if !ok {
+			return nil, cmd.ErrInvalidValType
+		}
+		start, ok := ids[i].Next()
+		if !ok {
+			continue
+		}
+		if ents := v.XRange(start, types.MaxStreamID, count, false); len(ents) > 0 {
+			res = append(res, []interface{}{nm, entries(ents)})
+		}
+	}
+	return res, nil
+}
+
+var xreadgroup = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 6,
+		MaxArgs: -1,
+	},
+	xreadgroupFn)
+
+func xreadgroupFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	if strings.ToLower(args[0]) != "group" {
+		return nil, cmd.ErrSyntax
+	}
+	group, consumer := args[1], args[2]
+	opts, err := parseReadOpts(args[3:], true)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.streams)%2 != 0 {
+		return nil, cmd.ErrUnbalancedGroupStreams
+	}
+	names, sids := opts.streams[:len(opts.streams)/2], opts.streams[len(opts.streams)/2:]
+
+	// Parse the IDs, ">" reads the entries never delivered to the group,
+	// any other ID reads the history of the consumer's pending entries.
+	ids := make([]types.StreamID, len(sids))
+	for j, s := range sids {
+		switch s {
+		case ">":
+			continue
+		case "$":
+			return nil, cmd.ErrGroupReadDollar
+		}
+		id, err := types.ParseStreamID(s, 0)
+		if err != nil {
+			return nil, cmd.ErrInvalidStreamID
+		}
+		ids[j] = id
+	}
+
+	return blockRead(db, opts.block, true, names, func(db srv.DB) ([]interface{}, error) {
+		return readGroups(db, names, sids, ids, group, consumer, opts)
+	})
+}
+
+// readGroups returns the reply of XREADGROUP. For the streams with the
+// ">" ID, the entries never delivered to the group are returned and only
+// the streams with such entries are part of the reply. For the other
+// streams, the pending entries of the consumer with an ID greater than
+// the corresponding ID in ids are returned, possibly none. The caller must
+// hold the DB lock and the exclusive locks on the keys.
+func readGroups(db srv.DB, names, sids []string, ids []types.StreamID, group, consumer string, opts readOpts) ([]interface{}, error) {
+	// All streams and groups must exist before anything is read
+	groups := make([]types.StreamGroup, len(names))
+	for i, nm := range names {
+		k, ok := db.GetKey(nm)
+		if !ok {
+			return nil, cmd.ErrNoGroup
+		}
+		v, ok := k.Val().(types.Stream)
+		if !ok {
+			return nil, cmd.ErrInvalidValType
+		}
+		if groups[i], ok = v.Group(group); !ok {
+			return nil, cmd.ErrNoGroup
+		}
+	}
+
+	var res []interface{}
+	var changed int
+	now := nowMs()
+	for i, g := range groups {
+		if sids[i] != ">" {
+			ents := g.ReadHistory(consumer, ids[i], opts.count, now)
+			res = append(res, []interface{}{names[i], entries(ents)})
+			continue
+		}
+		if ents := g.ReadNew(consumer, opts.count, opts.noack, now); len(ents) > 0 {
+			res = append(res, []interface{}{names[i], entries(ents)})
+			changed++
+		}
+	}
+	cmd.Dirty(changed)
+	return res, nil
+}
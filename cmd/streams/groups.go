@@ -0,0 +1,559 @@
+package streams
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+// defaultAutoClaimCount is the default number of entries claimed by
+// XAUTOCLAIM.
+const defaultAutoClaimCount = 100
+
+var xgroup = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 5,
+	},
+	xgroupFn)
+
+func xgroupFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	sub := strings.ToLower(args[0])
+	switch {
+	case sub == "create" && len(args) >= 4:
+		if len(args) == 5 && strings.ToLower(args[4]) != "mkstream" {
+			return nil, cmd.ErrSyntax
+		}
+		return xgroupCreate(db, args[1], args[2], args[3], len(args) == 5)
+	case sub == "setid" && len(args) == 4,
+		sub == "destroy" && len(args) == 3,
+		sub == "createconsumer" && len(args) == 4,
+		sub == "delconsumer" && len(args) == 4:
+	default:
+		return nil, cmd.ErrUnknownSubcommand
+	}
+
+	k, unl := db.LockGetKey(args[1], srv.NoKeyNone)
+	defer unl()
+	if k == nil {
+		return nil, cmd.ErrXGroupNoKey
+	}
+	k.Lock()
+	defer k.Unlock()
+
+	v, ok := k.Val().(types.Stream)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+	if sub == "destroy" {
+		return changed(v.DestroyGroup(args[2])), nil
+	}
+	g, ok := v.Group(args[2])
+	if !ok {
+		return nil, cmd.ErrNoGroup
+	}
+	switch sub {
+	case "setid":
+		id, err := parseGroupID(args[3], v)
+		if err != nil {
+			return nil, err
+		}
+		g.SetLastID(id)
+		cmd.Dirty(1)
+		return cmd.OKVal, nil
+	case "createconsumer":
+		return changed(g.CreateConsumer(args[3], nowMs())), nil
+	default:
+		// The consumer may exist without pending entries
+		var exists bool
+		for _, c := range g.Consumers() {
+			exists = exists || c.Name == args[3]
+		}
+		n := g.DelConsumer(args[3])
+		changed(exists)
+		return n, nil
+	}
+}
+
+// changed records the change of a key if ok is true, and returns ok.
+func changed(ok bool) bool {
+	if ok {
+		cmd.Dirty(1)
+	}
+	return ok
+}
+
+// xgroupCreate implements XGROUP CREATE, creating the stream if it does
+// not exist and mkstream is true.
+func xgroupCreate(db srv.DB, name, group, sid string, mkstream bool) (interface{}, error) {
+	k, unl := db.XLockGetKey(name, srv.NoKeyNone)
+	defer unl()
+
+	var v types.Stream
+	if k == nil {
+		if !mkstream {
+			return nil, cmd.ErrXGroupNoKey
+		}
+		v = types.NewStream()
+	} else {
+		k.Lock()
+		defer k.Unlock()
+
+		var ok bool
+		if v, ok = k.Val().(types.Stream); !ok {
+			return nil, cmd.ErrInvalidValType
+		}
+	}
+
+	id, err := parseGroupID(sid, v)
+	if err != nil {
+		return nil, err
+	}
+	if !v.CreateGroup(group, id) {
+		return nil, cmd.ErrGroupExists
+	}
+	if k == nil {
+		db.SetKey(name, v, -1)
+	}
+	cmd.Dirty(1)
+	return cmd.OKVal, nil
+}
+
+// parseGroupID parses the ID of the last entry delivered to a consumer
+// group, which may be "$" for the last ID of the stream.
+func parseGroupID(s string, v types.Stream) (types.StreamID, error) {
+	if s == "$" {
+		return v.LastID(), nil
+	}
+	id, err := types.ParseStreamID(s, 0)
+	if err != nil {
+		return id, cmd.ErrInvalidStreamID
+	}
+	return id, nil
+}
+
+// getGroup returns the consumer group with the specified name of the
+// stream held by the key.
+func getGroup(k srv.Key, name string) (types.StreamGroup, error) {
+	v, ok := k.Val().(types.Stream)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+	g, ok := v.Group(name)
+	if !ok {
+		return nil, cmd.ErrNoGroup
+	}
+	return g, nil
+}
+
+// parseIDs parses a list of stream IDs.
+func parseIDs(args []string) ([]types.StreamID, error) {
+	ids := make([]types.StreamID, len(args))
+	for i, s := range args {
+		id, err := types.ParseStreamID(s, 0)
+		if err != nil {
+			return nil, cmd.ErrInvalidStreamID
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+var xack = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: -1,
+	},
+	srv.NoKeyDefaultVal,
+	xackFn)
+
+func xackFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	ids, err := parseIDs(args[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	g, err := getGroup(k, args[1])
+	if err == cmd.ErrNoGroup {
+		return int64(0), nil
+	} else if err != nil {
+		return nil, err
+	}
+	n := g.Ack(ids...)
+	changed(n > 0)
+	return n, nil
+}
+
+var xpending = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 7,
+	},
+	srv.NoKeyDefaultVal,
+	xpendingFn)
+
+func xpendingFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// Parse the extended form: [IDLE min-idle-time] start end count [consumer]
+	var minIdle int64
+	ext := args[2:]
+	if len(ext) > 0 && strings.ToLower(ext[0]) == "idle" {
+		if len(ext) < 2 {
+			return nil, cmd.ErrSyntax
+		}
+		var err error
+		if minIdle, err = strconv.ParseInt(ext[1], 10, 64); err != nil {
+			return nil, cmd.ErrNotInteger
+		}
+		ext = ext[2:]
+	}
+	if len(args) > 2 && len(ext) != 3 && len(ext) != 4 {
+		return nil, cmd.ErrSyntax
+	}
+	var start, end types.StreamID
+	var count int64
+	var consumer string
+	if len(ext) > 0 {
+		var err error
+		if start, err = parseRangeID(ext[0], false); err != nil {
+			return nil, err
+		}
+		if end, err = parseRangeID(ext[1], true); err != nil {
+			return nil, err
+		}
+		if count, err = strconv.ParseInt(ext[2], 10, 64); err != nil {
+			return nil, cmd.ErrNotInteger
+		}
+		if count < 0 {
+			count = 0
+		}
+		if len(ext) == 4 {
+			consumer = ext[3]
+		}
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	g, err := getGroup(k, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 2 {
+		n, min, max, cs := g.PendingSummary()
+		if n == 0 {
+			return []interface{}{int64(0), nil, nil, nil}, nil
+		}
+		ret := make([]interface{}, len(cs))
+		for i, c := range cs {
+			ret[i] = []interface{}{c.Name, strconv.FormatInt(c.Pending, 10)}
+		}
+		return []interface{}{n, min.String(), max.String(), ret}, nil
+	}
+
+	now := nowMs()
+	pes := g.Pending(start, end, count, consumer, minIdle, now)
+	ret := make([]interface{}, len(pes))
+	for i, pe := range pes {
+		ret[i] = []interface{}{pe.ID.String(), pe.Consumer, now - pe.DeliveryTime, pe.DeliveryCount}
+	}
+	return ret, nil
+}
+
+var xclaim = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 5,
+		MaxArgs: -1,
+	},
+	srv.NoKeyDefaultVal,
+	xclaimFn)
+
+func xclaimFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	minIdle, err := parseMinIdle(args[3])
+	if err != nil {
+		return nil, err
+	}
+
+	// The IDs are followed by the options
+	now := nowMs()
+	var ids []types.StreamID
+	i := 4
+	for ; i < len(args); i++ {
+		id, err := types.ParseStreamID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, cmd.ErrInvalidStreamID
+	}
+
+	opts := types.ClaimOptions{DeliveryTime: -1, RetryCount: -1}
+	var lastID *types.StreamID
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch opt {
+		case "force":
+			opts.Force = true
+			continue
+		case "justid":
+			opts.JustID = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, cmd.ErrSyntax
+		}
+		i++
+		switch opt {
+		case "idle", "time", "retrycount":
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return nil, cmd.ErrNotInteger
+			}
+			switch opt {
+			case "idle":
+				opts.DeliveryTime = now - n
+			case "time":
+				opts.DeliveryTime = n
+			default:
+				opts.RetryCount = n
+			}
+		case "lastid":
+			id, err := types.ParseStreamID(args[i], 0)
+			if err != nil {
+				return nil, cmd.ErrInvalidStreamID
+			}
+			lastID = &id
+		default:
+			return nil, cmd.ErrSyntax
+		}
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	g, err := getGroup(k, args[1])
+	if err != nil {
+		return nil, err
+	}
+	var setID bool
+	if lastID != nil && g.LastID().Less(*lastID) {
+		g.SetLastID(*lastID)
+		setID = true
+	}
+	created := g.CreateConsumer(args[2], now)
+	ents, deleted := g.Claim(args[2], ids, minIdle, opts, now)
+	changed(setID || created || len(ents) > 0 || len(deleted) > 0)
+	propagateClaims(args[0], g, args[2], created, ents, deleted, now)
+	if setID && len(ents) == 0 {
+		cmd.Propagate("xgroup", "setid", args[0], args[1], lastID.String())
+	}
+	if opts.JustID {
+		return entryIDs(ents), nil
+	}
+	return entries(ents), nil
+}
+
+var xautoclaim = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 5,
+		MaxArgs: 8,
+	},
+	srv.NoKeyDefaultVal,
+	xautoclaimFn)
+
+func xautoclaimFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	minIdle, err := parseMinIdle(args[3])
+	if err != nil {
+		return nil, err
+	}
+	start, err := parseRangeID(args[4], false)
+	if err != nil {
+		return nil, err
+	}
+
+	count := int64(defaultAutoClaimCount)
+	var justID bool
+	for i := 5; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "justid":
+			justID = true
+		case "count":
+			if i+1 >= len(args) {
+				return nil, cmd.ErrSyntax
+			}
+			i++
+			if count, err = strconv.ParseInt(args[i], 10, 64); err != nil {
+				return nil, cmd.ErrNotInteger
+			}
+			if count <= 0 {
+				return nil, cmd.ErrNotPositive
+			}
+		default:
+			return nil, cmd.ErrSyntax
+		}
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	g, err := getGroup(k, args[1])
+	if err != nil {
+		return nil, err
+	}
+	now := nowMs()
+	created := g.CreateConsumer(args[2], now)
+	next, ents, deleted := g.AutoClaim(args[2], start, minIdle, count, justID, now)
+	changed(created || len(ents) > 0 || len(deleted) > 0)
+	propagateClaims(args[0], g, args[2], created, ents, deleted, now)
+	dels := make([]string, len(deleted))
+	for i, id := range deleted {
+		dels[i] = id.String()
+	}
+	if justID {
+		return []interface{}{next.String(), entryIDs(ents), dels}, nil
+	}
+	return []interface{}{next.String(), entries(ents), dels}, nil
+}
+
+// propagateClaims propagates the changes made by XCLAIM or XAUTOCLAIM to the
+// group g of the stream key instead of the command, as whether an entry is
+// claimed depends on the time. Each claimed entry is propagated as an
+// XCLAIM that forces its claim by the consumer with its new delivery time
+// and count, as Redis does, and the pending entries removed because they
+// were deleted from the stream are propagated as an XACK.
+func propagateClaims(key string, g types.StreamGroup, consumer string, created bool, ents []types.StreamEntry, deleted []types.StreamID, now int64) {
+	cmd.NoPropagate()
+	if created {
+		cmd.Propagate("xgroup", "createconsumer", key, g.Name(), consumer)
+	}
+	lastID := g.LastID().String()
+	for _, e := range ents {
+		pe := g.Pending(e.ID, e.ID, 1, "", 0, now)[0]
+		cmd.Propagate("xclaim", key, g.Name(), consumer, "0", e.ID.String(),
+			"time", strconv.FormatInt(pe.DeliveryTime, 10),
+			"retrycount", strconv.FormatInt(pe.DeliveryCount, 10),
+			"force", "justid", "lastid", lastID)
+	}
+	if len(deleted) > 0 {
+		ack := []string{"xack", key, g.Name()}
+		for _, id := range deleted {
+			ack = append(ack, id.String())
+		}
+		cmd.Propagate(ack...)
+	}
+}
+
+// parseMinIdle parses the min-idle-time argument of XCLAIM and XAUTOCLAIM.
+// A negative value is the same as 0.
+func parseMinIdle(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, cmd.ErrNotInteger
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n, nil
+}
+
+// entryIDs returns the reply for the IDs of a list of stream entries.
+func entryIDs(ents []types.StreamEntry) []string {
+	ret := make([]string, len(ents))
+	for i, e := range ents {
+		ret[i] = e.ID.String()
+	}
+	return ret
+}
+
+var xinfo = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 3,
+	},
+	xinfoFn)
+
+func xinfoFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	sub := strings.ToLower(args[0])
+	switch {
+	case sub == "stream" && len(args) == 2,
+		sub == "groups" && len(args) == 2,
+		sub == "consumers" && len(args) == 3:
+	default:
+		return nil, cmd.ErrUnknownSubcommand
+	}
+
+	k, unl := db.LockGetKey(args[1], srv.NoKeyNone)
+	defer unl()
+	if k == nil {
+		return nil, cmd.ErrNoSuchKey
+	}
+	k.RLock()
+	defer k.RUnlock()
+
+	v, ok := k.Val().(types.Stream)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+
+	switch sub {
+	case "stream":
+		info := v.Info()
+		var first, last interface{}
+		if ents := v.XRange(types.StreamID{}, types.MaxStreamID, 1, false); len(ents) > 0 {
+			first = entries(ents)[0]
+		}
+		if ents := v.XRange(types.StreamID{}, types.MaxStreamID, 1, true); len(ents) > 0 {
+			last = entries(ents)[0]
+		}
+		return []interface{}{
+			"length", info.Length,
+			"radix-tree-keys", info.Nodes,
+			"radix-tree-nodes", info.Nodes,
+			"last-generated-id", info.LastID.String(),
+			"max-deleted-entry-id", info.MaxDeletedID.String(),
+			"entries-added", info.EntriesAdded,
+			"groups", info.Groups,
+			"first-entry", first,
+			"last-entry", last,
+		}, nil
+
+	case "groups":
+		gs := v.Groups()
+		ret := make([]interface{}, len(gs))
+		for i, g := range gs {
+			n, _, _, _ := g.PendingSummary()
+			ret[i] = []interface{}{
+				"name", g.Name(),
+				"consumers", int64(len(g.Consumers())),
+				"pending", n,
+				"last-delivered-id", g.LastID().String(),
+			}
+		}
+		return ret, nil
+
+	default:
+		g, ok := v.Group(args[2])
+		if !ok {
+			return nil, cmd.ErrNoGroup
+		}
+		now := nowMs()
+		cs := g.Consumers()
+		ret := make([]interface{}, len(cs))
+		for i, c := range cs {
+			ret[i] = []interface{}{
+				"name", c.Name,
+				"pending", c.Pending,
+				"idle", now - c.SeenTime,
+			}
+		}
+		return ret, nil
+	}
+}
@@ -0,0 +1,455 @@
+package streams
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+func init() {
+	cmd.RegisterWrite("xack", xack)
+	cmd.RegisterWrite("xadd", xadd)
+	cmd.RegisterWrite("xautoclaim", xautoclaim)
+	cmd.RegisterWrite("xclaim", xclaim)
+	cmd.RegisterWrite("xdel", xdel)
+	cmd.RegisterWrite("xgroup", xgroup)
+	cmd.Register("xinfo", xinfo)
+	cmd.Register("xlen", xlen)
+	cmd.Register("xpending", xpending)
+	cmd.Register("xrange", xrange)
+	cmd.Register("xread", xread)
+	cmd.RegisterWrite("xreadgroup", xreadgroup)
+	cmd.Register("xrevrange", xrevrange)
+	cmd.RegisterWrite("xtrim", xtrim)
+	cmd.RegisterReady(ready)
+}
+
+// defaultTrimLimit is the default maximum number of entries removed by an
+// approximate trim, as in Redis (100 times the entries of a stream node).
+const defaultTrimLimit = 10000
+
+var xadd = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 4,
+		MaxArgs: -1,
+	},
+	xaddFn)
+
+func xaddFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	var nomk bool
+	var trim *trimOpts
+
+	// Parse the options, up to the ID argument
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nomkstream":
+			nomk = true
+			continue
+		case "maxlen", "minid":
+			t, n, err := parseTrim(args[i:])
+			if err != nil {
+				return nil, err
+			}
+			trim = &t
+			i += n - 1
+			continue
+		}
+		break
+	}
+	fields := args[i+1:]
+	if i >= len(args) || len(fields) == 0 || len(fields)%2 != 0 {
+		return nil, fmt.Errorf(cmd.WrongNumberOfArgsFmt, "xadd")
+	}
+
+	// The DB must be exclusively locked, because of the unblock behaviour.
+	k, unl := db.XLockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
+	var v types.Stream
+	if k == nil {
+		if nomk {
+			return nil, nil
+		}
+		v = types.NewStream()
+	} else {
+		k.Lock()
+		defer k.Unlock()
+
+		var ok bool
+		if v, ok = k.Val().(types.Stream); !ok {
+			return nil, cmd.ErrInvalidValType
+		}
+	}
+
+	id, err := parseAddID(args[i], v.LastID())
+	if err != nil {
+		return nil, err
+	}
+	if !v.XAdd(id, fields) {
+		return nil, cmd.ErrStreamIDTooSmall
+	}
+	if k == nil {
+		db.SetKey(args[0], v, -1)
+	}
+	if trim != nil {
+		trim.apply(v)
+	}
+	cmd.Dirty(1)
+	signal(db, args[0])
+	return id.String(), nil
+}
+
+// parseAddID parses the ID argument of XADD, which may be "*" to generate
+// an ID from the current time, "ms-*" to generate the sequence number, or
+// an explicit ID.
+func parseAddID(s string, last types.StreamID) (types.StreamID, error) {
+	if s == "*" {
+		ms := uint64(nowMs())
+		if ms > last.Ms {
+			return types.StreamID{Ms: ms}, nil
+		}
+		id, ok := last.Next()
+		if !ok {
+			return id, cmd.ErrStreamExhausted
+		}
+		return id, nil
+	}
+
+	if strings.HasSuffix(s, "-*") {
+		ms, err := strconv.ParseUint(s[:len(s)-2], 10, 64)
+		if err != nil {
+			return types.StreamID{}, cmd.ErrInvalidStreamID
+		}
+		switch {
+		case ms > last.Ms:
+			return types.StreamID{Ms: ms}, nil
+		case ms == last.Ms && last.Seq < types.MaxStreamID.Seq:
+			return types.StreamID{Ms: ms, Seq: last.Seq + 1}, nil
+		}
+		return types.StreamID{}, cmd.ErrStreamIDTooSmall
+	}
+
+	id, err := types.ParseStreamID(s, 0)
+	if err != nil {
+		return id, cmd.ErrInvalidStreamID
+	}
+	if id == (types.StreamID{}) {
+		return id, cmd.ErrStreamIDZero
+	}
+	return id, nil
+}
+
+// nowMs returns the current time of the server clock in milliseconds since
+// the Unix epoch, so that the stream IDs and the idle times of the pending
+// entries follow the time set by DEBUG SET-TIME, as the key expiration does.
+func nowMs() int64 {
+	return srv.DefaultServer.Clock().Now().UnixNano() / int64(time.Millisecond)
+}
+
+// signal notifies the waiters blocked on reading new entries from the
+// stream. The DB must be exclusively locked.
+func signal(db srv.DB, name string) {
+	for {
+		ch, _, _ := db.NextWaiter(name, srv.WaitStream)
+		if ch == nil {
+			return
+		}
+		// If the waiter is still waiting, notify it, it reads the entries itself
+		if sendch, ok := <-ch; ok {
+			sendch <- []string{name}
+		}
+	}
+}
+
+// ready notifies the waiters blocked on reading new entries from the key
+// name if it holds a Stream. The DB must be exclusively locked.
+func ready(db srv.DB, name string) {
+	k, ok := db.GetKey(name)
+	if !ok {
+		return
+	}
+	k.RLock()
+	_, ok = k.Val().(types.Stream)
+	k.RUnlock()
+	if ok {
+		signal(db, name)
+	}
+}
+
+var xdel = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: -1,
+	},
+	srv.NoKeyDefaultVal,
+	xdelFn)
+
+func xdelFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	ids := make([]types.StreamID, len(args)-1)
+	for i, s := range args[1:] {
+		id, err := types.ParseStreamID(s, 0)
+		if err != nil {
+			return nil, cmd.ErrInvalidStreamID
+		}
+		ids[i] = id
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val()
+	if v, ok := v.(types.Stream); ok {
+		n := v.XDel(ids...)
+		if n > 0 {
+			cmd.Dirty(1)
+		}
+		return n, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var xlen = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 1,
+	},
+	srv.NoKeyDefaultVal,
+	xlenFn)
+
+func xlenFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.Stream); ok {
+		return v.XLen(), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var xrange = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 5,
+	},
+	srv.NoKeyDefaultVal,
+	xrangeFn)
+
+func xrangeFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return rangeFn(k, args[1], args[2], args[3:], false)
+}
+
+var xrevrange = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 5,
+	},
+	srv.NoKeyDefaultVal,
+	xrevrangeFn)
+
+func xrevrangeFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return rangeFn(k, args[2], args[1], args[3:], true)
+}
+
+// rangeFn implements XRANGE and XREVRANGE, with start and end being the
+// lowest and highest IDs of the range, and opts the optional COUNT
+// arguments.
+func rangeFn(k srv.Key, start, end string, opts []string, rev bool) (interface{}, error) {
+	sid, err := parseRangeID(start, false)
+	if err != nil {
+		return nil, err
+	}
+	eid, err := parseRangeID(end, true)
+	if err != nil {
+		return nil, err
+	}
+
+	count := int64(-1)
+	switch {
+	case len(opts) == 0:
+	case len(opts) == 2 && strings.ToLower(opts[0]) == "count":
+		if count, err = strconv.ParseInt(opts[1], 10, 64); err != nil {
+			return nil, cmd.ErrNotInteger
+		}
+		if count < 0 {
+			count = 0
+		}
+	default:
+		return nil, cmd.ErrSyntax
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.Stream); ok {
+		return entries(v.XRange(sid, eid, count, rev)), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+// parseRangeID parses a bound of a range of IDs. "-" and "+" are the
+// lowest and highest possible IDs, a "(" prefix indicates an exclusive
+// bound, and a missing sequence number is set to the lowest (for the start
+// bound) or the highest (for the end bound) sequence number.
+func parseRangeID(s string, end bool) (types.StreamID, error) {
+	switch s {
+	case "-":
+		return types.StreamID{}, nil
+	case "+":
+		return types.MaxStreamID, nil
+	}
+
+	excl := strings.HasPrefix(s, "(")
+	if excl {
+		s = s[1:]
+	}
+	var defSeq uint64
+	if end {
+		defSeq = types.MaxStreamID.Seq
+	}
+	id, err := types.ParseStreamID(s, defSeq)
+	if err != nil {
+		return id, cmd.ErrInvalidStreamID
+	}
+	if excl {
+		var ok bool
+		if end {
+			id, ok = id.Prev()
+		} else {
+			id, ok = id.Next()
+		}
+		if !ok {
+			return id, cmd.ErrInvalidStreamID
+		}
+	}
+	return id, nil
+}
+
+var xtrim = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 6,
+	},
+	srv.NoKeyDefaultVal,
+	xtrimFn)
+
+func xtrimFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	switch strings.ToLower(args[1]) {
+	case "maxlen", "minid":
+	default:
+		return nil, cmd.ErrSyntax
+	}
+	t, n, err := parseTrim(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	if n != len(args)-1 {
+		return nil, cmd.ErrSyntax
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val()
+	if v, ok := v.(types.Stream); ok {
+		n := t.apply(v)
+		if n > 0 {
+			cmd.Dirty(1)
+		}
+		return n, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+// trimOpts holds the trimming options of XADD and XTRIM.
+type trimOpts struct {
+	minID  bool
+	approx bool
+	maxLen int64
+	id     types.StreamID
+	limit  int64
+}
+
+// apply trims the stream and returns the number of entries removed.
+func (t trimOpts) apply(v types.Stream) int64 {
+	if t.minID {
+		return v.XTrimMinID(t.id, t.approx, t.limit)
+	}
+	return v.XTrimMaxLen(t.maxLen, t.approx, t.limit)
+}
+
+// parseTrim parses the trimming options, starting with the MAXLEN or MINID
+// strategy: MAXLEN|MINID [=|~] threshold [LIMIT count]. It returns the
+// number of arguments parsed.
+func parseTrim(args []string) (trimOpts, int, error) {
+	var t trimOpts
+
+	t.minID = strings.ToLower(args[0]) == "minid"
+	i := 1
+	if i < len(args) && (args[i] == "=" || args[i] == "~") {
+		t.approx = args[i] == "~"
+		i++
+	}
+	if i >= len(args) {
+		return t, 0, cmd.ErrSyntax
+	}
+	if t.minID {
+		id, err := types.ParseStreamID(args[i], 0)
+		if err != nil {
+			return t, 0, cmd.ErrInvalidStreamID
+		}
+		t.id = id
+	} else {
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			return t, 0, cmd.ErrNotInteger
+		}
+		if n < 0 {
+			return t, 0, cmd.ErrMaxLenNegative
+		}
+		t.maxLen = n
+	}
+	i++
+
+	if t.approx {
+		t.limit = defaultTrimLimit
+	}
+	if i+1 < len(args) && strings.ToLower(args[i]) == "limit" {
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			return t, 0, cmd.ErrNotInteger
+		}
+		if n < 0 {
+			return t, 0, cmd.ErrLimitNegative
+		}
+		if !t.approx {
+			return t, 0, cmd.ErrLimitWithoutApprox
+		}
+		t.limit = n
+		i += 2
+	}
+	return t, i, nil
+}
+
+// entries returns the reply for a list of stream entries, each entry being
+// an array holding its ID and the array of its field-value pairs. The
+// array is nil for an entry with nil fields, such as a pending entry that
+// was deleted from the stream.
+func entries(ents []types.StreamEntry) []interface{} {
+	ret := make([]interface{}, len(ents))
+	for i, e := range ents {
+		if e.Fields == nil {
+			ret[i] = []interface{}{e.ID.String(), nil}
+			continue
+		}
+		ret[i] = []interface{}{e.ID.String(), e.Fields}
+	}
+	return ret
+}
@@ -0,0 +1,429 @@
+package strings
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+// maxBitOffset is the maximum bit offset allowed in a string, so that
+// strings are limited to 512MB like in Redis.
+const maxBitOffset = 1<<32 - 1
+
+var bitcount = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 4,
+	},
+	srv.NoKeyDefaultVal,
+	bitcountFn)
+
+func bitcountFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	r := types.BitRange{Start: 0, End: -1}
+	switch len(args) {
+	case 1:
+	case 2:
+		return nil, cmd.ErrSyntax
+	default:
+		var err error
+		if r, err = parseBitRange(args[1:]); err != nil {
+			return nil, err
+		}
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.String); ok {
+		return v.BitCount(r), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var bitfield = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	bitfieldFn)
+
+// bitfieldOp is a sub-command of BITFIELD.
+type bitfieldOp struct {
+	op string
+	f  types.BitField
+	v  int64
+	ow types.BitFieldOverflow
+}
+
+func bitfieldFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	ops, write, err := parseBitfieldOps(args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	// Only create the key if there is a write operation
+	var k srv.Key
+	var unl func()
+	if write {
+		k, unl = db.LockGetKey(args[0], srv.NoKeyCreateString)
+		defer unl()
+		k.Lock()
+		defer k.Unlock()
+	} else {
+		k, unl = db.LockGetKey(args[0], srv.NoKeyDefaultVal)
+		defer unl()
+		k.RLock()
+		defer k.RUnlock()
+	}
+
+	v, ok := k.Val().(types.String)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+	res := make([]interface{}, len(ops))
+	var changed bool
+	for i, op := range ops {
+		var val int64
+		ok := true
+		switch op.op {
+		case "get":
+			val = v.BitFieldGet(op.f)
+		case "set":
+			val, ok = v.BitFieldSet(op.f, op.v, op.ow)
+			changed = changed || ok
+		case "incrby":
+			val, ok = v.BitFieldIncrBy(op.f, op.v, op.ow)
+			changed = changed || ok
+		}
+		if ok {
+			res[i] = val
+		}
+	}
+	if changed {
+		cmd.Dirty(1)
+	}
+	return res, nil
+}
+
+// parseBitfieldOps parses the sub-commands of BITFIELD. It returns true as
+// second value if at least one sub-command writes to the string.
+func parseBitfieldOps(args []string) ([]bitfieldOp, bool, error) {
+	var ops []bitfieldOp
+	var write bool
+
+	ow := types.OverflowWrap
+	for i := 0; i < len(args); {
+		op := strings.ToLower(args[i])
+		switch op {
+		case "overflow":
+			if i+1 >= len(args) {
+				return nil, false, cmd.ErrSyntax
+			}
+			switch strings.ToLower(args[i+1]) {
+			case "wrap":
+				ow = types.OverflowWrap
+			case "sat":
+				ow = types.OverflowSat
+			case "fail":
+				ow = types.OverflowFail
+			default:
+				return nil, false, cmd.ErrOverflowType
+			}
+			i += 2
+			continue
+
+		case "get", "set", "incrby":
+			n := 3
+			if op == "get" {
+				n = 2
+			}
+			if i+n >= len(args) {
+				return nil, false, cmd.ErrSyntax
+			}
+			f, err := parseBitField(args[i+1], args[i+2])
+			if err != nil {
+				return nil, false, err
+			}
+			bop := bitfieldOp{op: op, f: f, ow: ow}
+			if op != "get" {
+				write = true
+				if bop.v, err = strconv.ParseInt(args[i+3], 10, 64); err != nil {
+					return nil, false, cmd.ErrNotInteger
+				}
+			}
+			ops = append(ops, bop)
+			i += n + 1
+
+		default:
+			return nil, false, cmd.ErrSyntax
+		}
+	}
+	return ops, write, nil
+}
+
+// parseBitField parses the type (e.g. i8 or u16) and the offset of a bit
+// field. The offset may be prefixed with "#" to be multiplied by the size
+// of the field.
+func parseBitField(typ, ofs string) (types.BitField, error) {
+	var f types.BitField
+
+	if len(typ) < 2 || (typ[0] != 'i' && typ[0] != 'u' && typ[0] != 'I' && typ[0] != 'U') {
+		return f, cmd.ErrBitFieldType
+	}
+	f.Signed = typ[0] == 'i' || typ[0] == 'I'
+	n, err := strconv.ParseUint(typ[1:], 10, 8)
+	if err != nil || n < 1 || (f.Signed && n > 64) || (!f.Signed && n > 63) {
+		return f, cmd.ErrBitFieldType
+	}
+	f.Bits = uint(n)
+
+	mul := int64(1)
+	if strings.HasPrefix(ofs, "#") {
+		mul = int64(f.Bits)
+		ofs = ofs[1:]
+	}
+	if f.Offset, err = parseBitOffset(ofs); err != nil {
+		return f, err
+	}
+	f.Offset *= mul
+	if f.Offset+int64(f.Bits)-1 > maxBitOffset {
+		return f, cmd.ErrBitOffset
+	}
+	return f, nil
+}
+
+var bitop = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: -1,
+	},
+	bitopFn)
+
+func bitopFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	op := strings.ToLower(args[0])
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(args) != 3 {
+			return nil, cmd.ErrBitOpNot
+		}
+	default:
+		return nil, cmd.ErrSyntax
+	}
+
+	// In every case, the destination is replaced, so must have an exclusive lock
+	db.Lock()
+	defer db.Unlock()
+
+	// Read-lock the source keys, they are unlocked once the result is computed
+	// so that the destination can be locked even if it is also a source.
+	unl := db.LockKeys(false, args[2:]...)
+	vals := make([]string, len(args)-2)
+	for i, nm := range args[2:] {
+		if k, ok := db.GetKey(nm); ok {
+			v, ok := k.Val().(types.String)
+			if !ok {
+				unl()
+				return nil, cmd.ErrInvalidValType
+			}
+			vals[i] = v.Get()
+		}
+	}
+	unl()
+	res := bitOp(op, vals)
+
+	// If destination exists, remove any expiration and delete
+	dst := args[1]
+	k, exists := db.Keys()[dst]
+	if exists {
+		k.Lock()
+		db.DelKey(dst)
+		k.Unlock()
+	}
+	if len(res) > 0 {
+		db.SetKey(dst, types.NewIncString(string(res)), -1)
+	}
+	if exists || len(res) > 0 {
+		cmd.Dirty(1)
+	}
+	return int64(len(res)), nil
+}
+
+// bitOp applies the bitwise operation op to the values. The shorter values
+// are considered padded with 0 bytes.
+func bitOp(op string, vals []string) []byte {
+	var max int
+	for _, v := range vals {
+		if len(v) > max {
+			max = len(v)
+		}
+	}
+	res := make([]byte, max)
+	copy(res, vals[0])
+	if op == "not" {
+		for i := range res {
+			res[i] = ^res[i]
+		}
+		return res
+	}
+
+	for _, v := range vals[1:] {
+		for i := range res {
+			var b byte
+			if i < len(v) {
+				b = v[i]
+			}
+			switch op {
+			case "and":
+				res[i] &= b
+			case "or":
+				res[i] |= b
+			case "xor":
+				res[i] ^= b
+			}
+		}
+	}
+	return res
+}
+
+var bitpos = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 5,
+	},
+	srv.NoKeyDefaultVal,
+	bitposFn)
+
+func bitposFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	var bit int
+	switch args[1] {
+	case "0":
+	case "1":
+		bit = 1
+	default:
+		return nil, cmd.ErrBitPosBit
+	}
+
+	r := types.BitRange{Start: 0, End: -1}
+	var endSet bool
+	switch len(args) {
+	case 2:
+	case 3:
+		start, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return nil, cmd.ErrNotInteger
+		}
+		r.Start = start
+	default:
+		var err error
+		if r, err = parseBitRange(args[2:]); err != nil {
+			return nil, err
+		}
+		endSet = true
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.String); ok {
+		return v.BitPos(bit, r, endSet), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+// parseBitRange parses the start, end and optional BYTE or BIT unit
+// arguments of a bit range.
+func parseBitRange(args []string) (types.BitRange, error) {
+	var r types.BitRange
+	var err error
+
+	if r.Start, err = strconv.ParseInt(args[0], 10, 64); err != nil {
+		return r, cmd.ErrNotInteger
+	}
+	if r.End, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+		return r, cmd.ErrNotInteger
+	}
+	if len(args) > 2 {
+		switch strings.ToLower(args[2]) {
+		case "byte":
+		case "bit":
+			r.Bit = true
+		default:
+			return r, cmd.ErrSyntax
+		}
+	}
+	return r, nil
+}
+
+var getbit = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs:    2,
+		MaxArgs:    2,
+		ValidateFn: validateBitOffset,
+	},
+	srv.NoKeyDefaultVal,
+	getbitFn)
+
+func getbitFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	ofs, _ := parseBitOffset(args[1])
+	v := k.Val()
+	if v, ok := v.(types.String); ok {
+		return int64(v.GetBit(ofs)), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var setbit = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 3,
+		ValidateFn: func(args []string, ints []int64, floats []float64) error {
+			if err := validateBitOffset(args, ints, floats); err != nil {
+				return err
+			}
+			if args[2] != "0" && args[2] != "1" {
+				return cmd.ErrBitValue
+			}
+			return nil
+		},
+	},
+	srv.NoKeyCreateString,
+	setbitFn)
+
+func setbitFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	ofs, _ := parseBitOffset(args[1])
+	v := k.Val()
+	if v, ok := v.(types.String); ok {
+		old := v.SetBit(ofs, int(args[2][0]-'0'))
+		cmd.Dirty(1)
+		return int64(old), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+// validateBitOffset validates that the second argument is a valid bit offset.
+func validateBitOffset(args []string, ints []int64, floats []float64) error {
+	_, err := parseBitOffset(args[1])
+	return err
+}
+
+// parseBitOffset parses a bit offset, which must be a positive integer
+// lower than or equal to maxBitOffset.
+func parseBitOffset(s string) (int64, error) {
+	ofs, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ofs < 0 || ofs > maxBitOffset {
+		return 0, cmd.ErrBitOffset
+	}
+	return ofs, nil
+}
@@ -1,23 +1,41 @@
 package strings
 
 import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/PuerkitoBio/gred/cmd"
 	"github.com/PuerkitoBio/gred/srv"
 	"github.com/PuerkitoBio/gred/types"
 )
 
 func init() {
-	cmd.Register("append", appendƒ)
-	cmd.Register("decr", decr)
-	cmd.Register("decrby", decrby)
+	cmd.RegisterWrite("append", appendƒ)
+	cmd.Register("bitcount", bitcount)
+	cmd.RegisterWrite("bitfield", bitfield)
+	cmd.RegisterWrite("bitop", bitop)
+	cmd.Register("bitpos", bitpos)
+	cmd.RegisterWrite("decr", decr)
+	cmd.RegisterWrite("decrby", decrby)
 	cmd.Register("get", get)
+	cmd.Register("getbit", getbit)
+	cmd.RegisterWrite("getdel", getdel)
+	cmd.RegisterWrite("getex", getex)
 	cmd.Register("getrange", getrange)
-	cmd.Register("getset", getset)
-	cmd.Register("incr", incr)
-	cmd.Register("incrby", incrby)
-	cmd.Register("incrbyfloat", incrbyfloat)
-	cmd.Register("set", set)
-	cmd.Register("setrange", setrange)
+	cmd.RegisterWrite("getset", getset)
+	cmd.RegisterWrite("incr", incr)
+	cmd.RegisterWrite("incrby", incrby)
+	cmd.RegisterWrite("incrbyfloat", incrbyfloat)
+	cmd.Register("mget", mget)
+	cmd.RegisterWrite("mset", mset)
+	cmd.RegisterWrite("msetnx", msetnx)
+	cmd.RegisterWrite("set", set)
+	cmd.RegisterWrite("setbit", setbit)
+	cmd.RegisterWrite("setnx", setnx)
+	cmd.RegisterWrite("setrange", setrange)
 	cmd.Register("strlen", strlen)
 }
 
@@ -35,7 +53,9 @@ func appendFn(k srv.Key, args []string, ints []int64, floats []float64) (interfa
 
 	v := k.Val()
 	if v, ok := v.(types.String); ok {
-		return v.Append(args[1]), nil
+		n := v.Append(args[1])
+		cmd.Dirty(1)
+		return n, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -54,11 +74,12 @@ func decrFn(k srv.Key, args []string, ints []int64, floats []float64) (interface
 
 	v := k.Val()
 	if v, ok := v.(types.IncString); ok {
-		val, ok := v.Decr()
-		if ok {
-			return val, nil
+		val, err := v.Decr()
+		if err != nil {
+			return nil, incrErr(err)
 		}
-		return nil, cmd.ErrNotInteger
+		cmd.Dirty(1)
+		return val, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -76,13 +97,18 @@ func decrbyFn(k srv.Key, args []string, ints []int64, floats []float64) (interfa
 	k.Lock()
 	defer k.Unlock()
 
+	if ints[0] == math.MinInt64 {
+		return nil, cmd.ErrDecrOverflow
+	}
+
 	v := k.Val()
 	if v, ok := v.(types.IncString); ok {
-		val, ok := v.DecrBy(ints[0])
-		if ok {
-			return val, nil
+		val, err := v.DecrBy(ints[0])
+		if err != nil {
+			return nil, incrErr(err)
 		}
-		return nil, cmd.ErrNotInteger
+		cmd.Dirty(1)
+		return val, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -110,6 +136,90 @@ func getFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{
 	return nil, cmd.ErrInvalidValType
 }
 
+var getdel = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 1,
+	},
+	getdelFn)
+
+func getdelFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// The key is deleted, so must have an exclusive lock
+	k, unl := db.XLockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
+	if k == nil {
+		return nil, nil
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val()
+	if v, ok := v.(types.String); ok {
+		// DelKey also aborts the expiration of the key
+		db.DelKey(args[0])
+		cmd.Dirty(1)
+		return v.Get(), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var getex = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 3,
+	},
+	getexFn)
+
+func getexFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	var expAt time.Time
+	var persist bool
+	switch {
+	case len(args) == 1:
+	case len(args) == 2 && strings.ToLower(args[1]) == "persist":
+		persist = true
+	case len(args) == 3:
+		opt := strings.ToLower(args[1])
+		switch opt {
+		case "ex", "px", "exat", "pxat":
+		default:
+			return nil, cmd.ErrSyntax
+		}
+		var err error
+		if expAt, err = parseExpire(opt, args[2], db.Clock().Now()); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, cmd.ErrSyntax
+	}
+
+	k, unl := db.LockGetKey(args[0], srv.NoKeyNone)
+	defer unl()
+
+	if k == nil {
+		return nil, nil
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val()
+	if v, ok := v.(types.String); ok {
+		switch {
+		case persist:
+			if k.Abort() {
+				cmd.Dirty(1)
+			}
+		case !expAt.IsZero():
+			k.Expire(expAt.Sub(db.Clock().Now()))
+			cmd.Dirty(1)
+		}
+		return v.Get(), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
 var getrange = cmd.NewSingleKeyCmd(
 	&cmd.ArgDef{
 		MinArgs:    3,
@@ -145,7 +255,9 @@ func getsetFn(k srv.Key, args []string, ints []int64, floats []float64) (interfa
 	v := k.Val()
 	if v, ok := v.(types.String); ok {
 		k.Abort()
-		return v.GetSet(args[1]), nil
+		old := v.GetSet(args[1])
+		cmd.Dirty(1)
+		return old, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -164,11 +276,12 @@ func incrFn(k srv.Key, args []string, ints []int64, floats []float64) (interface
 
 	v := k.Val()
 	if v, ok := v.(types.IncString); ok {
-		val, ok := v.Incr()
-		if ok {
-			return val, nil
+		val, err := v.Incr()
+		if err != nil {
+			return nil, incrErr(err)
 		}
-		return nil, cmd.ErrNotInteger
+		cmd.Dirty(1)
+		return val, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -188,20 +301,20 @@ func incrbyFn(k srv.Key, args []string, ints []int64, floats []float64) (interfa
 
 	v := k.Val()
 	if v, ok := v.(types.IncString); ok {
-		val, ok := v.IncrBy(ints[0])
-		if ok {
-			return val, nil
+		val, err := v.IncrBy(ints[0])
+		if err != nil {
+			return nil, incrErr(err)
 		}
-		return nil, cmd.ErrNotInteger
+		cmd.Dirty(1)
+		return val, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
 
 var incrbyfloat = cmd.NewSingleKeyCmd(
 	&cmd.ArgDef{
-		MinArgs:      2,
-		MaxArgs:      2,
-		FloatIndices: []int{1},
+		MinArgs: 2,
+		MaxArgs: 2,
 	},
 	srv.NoKeyCreateStringInt,
 	incrbyfloatFn)
@@ -212,35 +325,251 @@ func incrbyfloatFn(k srv.Key, args []string, ints []int64, floats []float64) (in
 
 	v := k.Val()
 	if v, ok := v.(types.IncString); ok {
-		val, ok := v.IncrByFloat(floats[0])
-		if ok {
-			return val, nil
+		// The increment is parsed as a long double along with the value
+		val, err := v.IncrByFloat(args[1])
+		if err != nil {
+			return nil, incrErr(err)
 		}
-		return nil, cmd.ErrNotFloat
+		cmd.Dirty(1)
+		return val, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
 
-// TODO : This doesn't handle the extra optional args (EX, NX, PX)
-var set = cmd.NewSingleKeyCmd(
+// incrErr returns the command error corresponding to the error returned
+// by an IncString increment.
+func incrErr(err error) error {
+	switch err {
+	case types.ErrIncrOverflow:
+		return cmd.ErrIncrOverflow
+	case types.ErrIncrNaNOrInf:
+		return cmd.ErrIncrNaNOrInf
+	case types.ErrNotFloat:
+		return cmd.ErrNotFloat
+	default:
+		return cmd.ErrNotInteger
+	}
+}
+
+var mget = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: -1,
+	},
+	mgetFn)
+
+func mgetFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	unl := db.LockKeys(false, args...)
+	defer unl()
+
+	// Keys that do not exist or do not hold a string return nil
+	ret := make([]interface{}, len(args))
+	for i, nm := range args {
+		if k, ok := db.GetKey(nm); ok {
+			if v, ok := k.Val().(types.String); ok {
+				ret[i] = v.Get()
+			}
+		}
+	}
+	return ret, nil
+}
+
+var mset = cmd.NewDBCmd(
 	&cmd.ArgDef{
 		MinArgs: 2,
-		MaxArgs: 2,
+		MaxArgs: -1,
+	},
+	msetFn)
+
+func msetFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	if _, err := msetFnNX(db, args, false); err != nil {
+		return nil, err
+	}
+	return cmd.OKVal, nil
+}
+
+var msetnx = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: -1,
+	},
+	msetnxFn)
+
+func msetnxFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return msetFnNX(db, args, true)
+}
+
+// msetFnNX implements MSET and MSETNX. The keys are set atomically, if nx
+// is true they are only set if none of them exist. It returns 1 if the
+// keys were set, 0 otherwise.
+func msetFnNX(db srv.DB, args []string, nx bool) (int64, error) {
+	name := "mset"
+	if nx {
+		name = "msetnx"
+	}
+	if len(args)%2 != 0 {
+		return 0, fmt.Errorf(cmd.WrongNumberOfArgsFmt, name)
+	}
+
+	// All keys may be created, so must have an exclusive lock
+	db.Lock()
+	defer db.Unlock()
+
+	// With NX, check all keys before setting any of them. Otherwise the
+	// existing keys are replaced, whatever the type of their value.
+	if nx {
+		for i := 0; i < len(args); i += 2 {
+			if db.Exists(args[i]) {
+				return 0, nil
+			}
+		}
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		db.Set(args[i], args[i+1], srv.SetOpts{})
+	}
+	cmd.Dirty(len(args) / 2)
+	return 1, nil
+}
+
+var set = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: -1,
 	},
-	srv.NoKeyCreateString,
 	setFn)
 
-func setFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
-	k.Lock()
-	defer k.Unlock()
+func setFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	opts, get, err := parseSetOpts(args[2:], db.Clock().Now())
+	if err != nil {
+		return nil, err
+	}
 
-	v := k.Val()
-	if v, ok := v.(types.String); ok {
-		k.Abort()
-		v.Set(args[1])
+	// The key may be created, so must have an exclusive lock
+	db.Lock()
+	defer db.Unlock()
+
+	// Get the old value before it is replaced, a value of another type is
+	// only an error if it must be returned
+	var old interface{}
+	if k, ok := db.GetKey(args[0]); ok {
+		k.RLock()
+		v, ok := k.Val().(types.String)
+		if ok {
+			old = v.Get()
+		}
+		k.RUnlock()
+		if !ok && get {
+			return nil, cmd.ErrInvalidValType
+		}
+	}
+
+	set := db.Set(args[0], args[1], opts)
+	if set {
+		cmd.Dirty(1)
+	}
+	switch {
+	case get:
+		return old, nil
+	case set:
 		return cmd.OKVal, nil
 	}
-	return nil, cmd.ErrInvalidValType
+	return nil, nil
+}
+
+// parseSetOpts parses the options of SET, the relative expirations being
+// relative to time now. It returns true as second value if the GET option
+// is set.
+func parseSetOpts(args []string, now time.Time) (srv.SetOpts, bool, error) {
+	var opts srv.SetOpts
+	var get, exp bool
+
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); opt {
+		case "nx":
+			opts.NX = true
+		case "xx":
+			opts.XX = true
+		case "get":
+			get = true
+		case "keepttl":
+			if exp {
+				return opts, false, cmd.ErrSyntax
+			}
+			opts.KeepTTL, exp = true, true
+		case "ex", "px", "exat", "pxat":
+			if exp || i+1 >= len(args) {
+				return opts, false, cmd.ErrSyntax
+			}
+			t, err := parseExpire(opt, args[i+1], now)
+			if err != nil {
+				return opts, false, err
+			}
+			opts.ExpireAt, exp = t, true
+			i++
+		default:
+			return opts, false, cmd.ErrSyntax
+		}
+	}
+	if opts.NX && opts.XX {
+		return opts, false, cmd.ErrSyntax
+	}
+	return opts, get, nil
+}
+
+// parseExpire parses the argument of the expiration option opt, which is
+// one of EX, PX, EXAT or PXAT, and returns the corresponding expiration
+// time, the relative expirations being relative to time now.
+func parseExpire(opt, arg string, now time.Time) (time.Time, error) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return time.Time{}, cmd.ErrNotInteger
+	}
+	if n <= 0 {
+		return time.Time{}, cmd.ErrInvalidExpire
+	}
+
+	// Like Redis, the expiration is computed in milliseconds, and is
+	// invalid if it overflows
+	if opt == "ex" || opt == "exat" {
+		if n > math.MaxInt64/1000 {
+			return time.Time{}, cmd.ErrInvalidExpire
+		}
+		n *= 1000
+	}
+	if opt == "ex" || opt == "px" {
+		ms := now.UnixMilli()
+		if n > math.MaxInt64-ms {
+			return time.Time{}, cmd.ErrInvalidExpire
+		}
+		if n <= math.MaxInt64/int64(time.Millisecond) {
+			return now.Add(time.Duration(n) * time.Millisecond), nil
+		}
+		n += ms
+	}
+	return time.UnixMilli(n), nil
+}
+
+var setnx = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 2,
+	},
+	setnxFn)
+
+func setnxFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// The key may be created, so must have an exclusive lock
+	db.Lock()
+	defer db.Unlock()
+
+	if !db.Set(args[0], args[1], srv.SetOpts{NX: true}) {
+		return false, nil
+	}
+	cmd.Dirty(1)
+	return true, nil
 }
 
 var setrange = cmd.NewSingleKeyCmd(
@@ -264,7 +593,11 @@ func setrangeFn(k srv.Key, args []string, ints []int64, floats []float64) (inter
 
 	v := k.Val()
 	if v, ok := v.(types.String); ok {
-		return v.SetRange(ints[0], args[2]), nil
+		n := v.SetRange(ints[0], args[2])
+		if args[2] != "" {
+			cmd.Dirty(1)
+		}
+		return n, nil
 	}
 	return nil, cmd.ErrInvalidValType
 }
@@ -1,21 +1,31 @@
 package test
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/PuerkitoBio/gred/cmd"
 	_ "github.com/PuerkitoBio/gred/cmd/connection"
+	_ "github.com/PuerkitoBio/gred/cmd/geo"
 	_ "github.com/PuerkitoBio/gred/cmd/hashes"
+	_ "github.com/PuerkitoBio/gred/cmd/hyperloglog"
 	_ "github.com/PuerkitoBio/gred/cmd/keys"
 	_ "github.com/PuerkitoBio/gred/cmd/lists"
 	_ "github.com/PuerkitoBio/gred/cmd/server"
 	_ "github.com/PuerkitoBio/gred/cmd/sets"
+	_ "github.com/PuerkitoBio/gred/cmd/streams"
 	_ "github.com/PuerkitoBio/gred/cmd/strings"
+	_ "github.com/PuerkitoBio/gred/cmd/zsets"
 	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
 )
 
 func TestCommand(t *testing.T) {
+	dump := string(types.Dump(types.NewIncString("abc")))
 	cases := []struct {
 		name string
 		args []string
@@ -41,6 +51,156 @@ func TestCommand(t *testing.T) {
 		{"type", []string{"l"}, "list", nil},
 		{"sadd", []string{"t", "v1"}, int64(1), nil},
 		{"type", []string{"t"}, "set", nil},
+		{"keys", []string{"[^hst]"}, []string{"l"}, nil},
+		{"keys", []string{"s*"}, []string{"s"}, nil},
+		{"keys", []string{"x*"}, []string{}, nil},
+		{"scan", []string{"0", "match", "[h]", "count", "100"}, []interface{}{"0", []string{"h"}}, nil},
+		{"scan", []string{"0", "type", "list", "count", "100"}, []interface{}{"0", []string{"l"}}, nil},
+		{"scan", []string{"0", "match", "x*", "type", "hash"}, []interface{}{"0", []string{}}, nil},
+		{"scan", []string{"a"}, nil, cmd.ErrInvalidCursor},
+		{"scan", []string{"0", "count", "0"}, nil, cmd.ErrSyntax},
+		{"rename", []string{"s", "s2"}, cmd.OKVal, nil},
+		{"exists", []string{"s"}, false, nil},
+		{"get", []string{"s2"}, "val", nil},
+		{"rename", []string{"s2", "s2"}, cmd.OKVal, nil},
+		{"rename", []string{"s2", "s"}, cmd.OKVal, nil},
+		{"rename", []string{"z", "s"}, nil, cmd.ErrNoSuchKey},
+		{"renamenx", []string{"s", "h"}, false, nil},
+		{"renamenx", []string{"z", "s"}, nil, cmd.ErrNoSuchKey},
+		{"setex", []string{"rn", "100", "v"}, cmd.OKVal, nil},
+		{"renamenx", []string{"rn", "rn2"}, true, nil},
+		{"ttl", []string{"rn2"}, int64(99), nil},
+		{"set", []string{"rn", "v"}, cmd.OKVal, nil},
+		{"rename", []string{"rn", "rn2"}, cmd.OKVal, nil},
+		{"ttl", []string{"rn2"}, int64(-1), nil},
+		{"copy", []string{"h", "h2"}, true, nil},
+		{"copy", []string{"s", "h2"}, false, nil},
+		{"hset", []string{"h2", "f1", "x"}, false, nil},
+		{"hget", []string{"h", "f1"}, "v1", nil},
+		{"copy", []string{"s", "h2", "replace"}, true, nil},
+		{"type", []string{"h2"}, "string", nil},
+		{"copy", []string{"s", "s", "db", "2"}, true, nil},
+		{"copy", []string{"s", "s"}, nil, cmd.ErrSameObject},
+		{"copy", []string{"s", "s", "db", "99"}, nil, cmd.ErrInvalidDBIndex},
+		{"copy", []string{"s", "s", "db"}, nil, cmd.ErrSyntax},
+		{"copy", []string{"s", "s", "foo"}, nil, cmd.ErrSyntax},
+		{"copy", []string{"z", "s2"}, false, nil},
+		{"touch", []string{"s", "h", "z", "s"}, int64(3), nil},
+		{"unlink", []string{"rn2", "h2", "z"}, int64(2), nil},
+		{"exists", []string{"h2"}, false, nil},
+		{"move", []string{"s", "2"}, false, nil},
+		{"setex", []string{"mv", "100", "v"}, cmd.OKVal, nil},
+		{"move", []string{"mv", "2"}, true, nil},
+		{"exists", []string{"mv"}, false, nil},
+		{"move", []string{"mv", "2"}, false, nil},
+		{"move", []string{"s", "0"}, nil, cmd.ErrSameObject},
+		{"move", []string{"s", "99"}, nil, cmd.ErrInvalidDBIndex},
+		{"swapdb", []string{"0", "2"}, cmd.OKVal, nil},
+		{"ttl", []string{"mv"}, int64(99), nil},
+		{"exists", []string{"h"}, false, nil},
+		{"swapdb", []string{"0", "2"}, cmd.OKVal, nil},
+		{"exists", []string{"h"}, true, nil},
+		{"swapdb", []string{"0", "99"}, nil, cmd.ErrInvalidDBIndex},
+		{"rpush", []string{"ids", "3", "1", "2", "10"}, int64(4), nil},
+		{"mset", []string{"weight_1", "30", "weight_2", "20", "weight_3", "10"}, cmd.OKVal, nil},
+		{"hmset", []string{"obj_1", "name", "one"}, cmd.OKVal, nil},
+		{"hmset", []string{"obj_2", "name", "two"}, cmd.OKVal, nil},
+		{"sort", []string{"ids"}, []interface{}{"1", "2", "3", "10"}, nil},
+		{"sort", []string{"ids", "desc", "limit", "1", "2"}, []interface{}{"3", "2"}, nil},
+		{"sort", []string{"ids", "alpha"}, []interface{}{"1", "10", "2", "3"}, nil},
+		{"sort", []string{"ids", "limit", "-1", "-1"}, []interface{}{"1", "2", "3", "10"}, nil},
+		{"sort", []string{"ids", "limit", "10", "2"}, []interface{}{}, nil},
+		{"sort", []string{"ids", "by", "weight_*"}, []interface{}{"10", "3", "2", "1"}, nil},
+		{"sort", []string{"ids", "by", "nosort"}, []interface{}{"3", "1", "2", "10"}, nil},
+		{"sort", []string{"ids", "by", "weight_*", "get", "#", "get", "obj_*->name", "limit", "0", "3", "alpha"}, []interface{}{"10", nil, "3", nil, "2", "two"}, nil},
+		{"sort", []string{"ids", "by", "obj_*->name", "alpha", "desc", "get", "weight_*"}, []interface{}{"20", "30", "10", nil}, nil},
+		{"sort", []string{"ids", "by", "obj_*->name"}, nil, cmd.ErrSortNotDouble},
+		{"sort", []string{"ids", "store", "sorted"}, int64(4), nil},
+		{"lrange", []string{"sorted", "0", "-1"}, []string{"1", "2", "3", "10"}, nil},
+		{"sort", []string{"ids", "get", "obj_*->name", "store", "sorted"}, int64(4), nil},
+		{"lrange", []string{"sorted", "0", "-1"}, []string{"one", "two", "", ""}, nil},
+		{"sort", []string{"z", "store", "sorted"}, int64(0), nil},
+		{"exists", []string{"sorted"}, false, nil},
+		{"sadd", []string{"sortset", "b", "c", "a"}, int64(3), nil},
+		{"sort", []string{"sortset", "alpha", "desc"}, []interface{}{"c", "b", "a"}, nil},
+		{"sort", []string{"sortset"}, nil, cmd.ErrSortNotDouble},
+		{"zadd", []string{"sortzset", "1", "b", "2", "a"}, int64(2), nil},
+		{"sort", []string{"sortzset", "by", "nosort"}, []interface{}{"b", "a"}, nil},
+		{"sort", []string{"sortzset", "by", "nosort", "desc"}, []interface{}{"a", "b"}, nil},
+		{"sort", []string{"sortzset", "by", "nosort", "desc", "limit", "1", "1"}, []interface{}{"b"}, nil},
+		{"sort", []string{"s"}, nil, cmd.ErrInvalidValType},
+		{"sort", []string{"ids", "limit", "0"}, nil, cmd.ErrSyntax},
+		{"sort", []string{"ids", "limit", "a", "1"}, nil, cmd.ErrNotInteger},
+		{"sort_ro", []string{"ids", "desc"}, []interface{}{"10", "3", "2", "1"}, nil},
+		{"sort_ro", []string{"ids", "store", "sorted"}, nil, cmd.ErrSyntax},
+		{"dump", []string{"r1"}, nil, nil},
+		{"restore", []string{"r1", "0", dump}, cmd.OKVal, nil},
+		{"get", []string{"r1"}, "abc", nil},
+		{"incr", []string{"r1"}, nil, cmd.ErrNotInteger},
+		{"dump", []string{"r1"}, dump, nil},
+		{"restore", []string{"r1", "0", dump}, nil, cmd.ErrBusyKey},
+		{"restore", []string{"r1", "0", dump, "replace", "idletime", "10"}, cmd.OKVal, nil},
+		{"restore", []string{"r1", "-1", dump}, nil, cmd.ErrInvalidTTL},
+		{"restore", []string{"r2", "0", "abc"}, nil, cmd.ErrDumpPayload},
+		{"restore", []string{"r2", "0", dump[:1] + "\x05" + dump[2:]}, nil, cmd.ErrDumpPayload},
+		{"restore", []string{"r2", "0", dump, "idletime", "1", "freq", "2"}, nil, cmd.ErrSyntax},
+		{"restore", []string{"r2", "0", dump, "idletime", "-1"}, nil, cmd.ErrInvalidIdleTime},
+		{"restore", []string{"r2", "0", dump, "freq", "256"}, nil, cmd.ErrInvalidFreq},
+		{"restore", []string{"r2", "0", dump, "foo"}, nil, cmd.ErrSyntax},
+		{"restore", []string{"r2", "100000", dump}, cmd.OKVal, nil},
+		{"ttl", []string{"r2"}, int64(99), nil},
+		{"restore", []string{"r2", "1", dump, "absttl", "replace"}, cmd.OKVal, nil},
+		{"exists", []string{"r2"}, false, nil},
+		{"restore", []string{"r2", "9223372036854775807", dump, "absttl"}, cmd.OKVal, nil},
+		{"exists", []string{"r2"}, true, nil},
+		{"restore", []string{"r3", "9223372036854775807", dump}, nil, cmd.ErrInvalidExpire},
+		{"del", []string{"r1", "r2"}, int64(2), nil},
+		{"object", []string{"encoding", "o1"}, nil, nil},
+		{"set", []string{"o1", "12"}, cmd.OKVal, nil},
+		{"object", []string{"ENCODING", "o1"}, "int", nil},
+		{"append", []string{"o1", "a"}, int64(3), nil},
+		{"object", []string{"encoding", "o1"}, "embstr", nil},
+		{"set", []string{"o1", strings.Repeat("a", 45)}, cmd.OKVal, nil},
+		{"object", []string{"encoding", "o1"}, "raw", nil},
+		{"sadd", []string{"o2", "1", "2"}, int64(2), nil},
+		{"object", []string{"encoding", "o2"}, "intset", nil},
+		{"sadd", []string{"o2", "a"}, int64(1), nil},
+		{"object", []string{"encoding", "o2"}, "listpack", nil},
+		{"sadd", []string{"o2", strings.Repeat("a", 65)}, int64(1), nil},
+		{"object", []string{"encoding", "o2"}, "hashtable", nil},
+		{"hset", []string{"o3", "f", "v"}, true, nil},
+		{"object", []string{"encoding", "o3"}, "listpack", nil},
+		{"hset", []string{"o3", "f", strings.Repeat("a", 65)}, false, nil},
+		{"object", []string{"encoding", "o3"}, "hashtable", nil},
+		{"rpush", []string{"o4", "a"}, int64(1), nil},
+		{"object", []string{"encoding", "o4"}, "listpack", nil},
+		{"zadd", []string{"o5", "1", "a"}, int64(1), nil},
+		{"object", []string{"encoding", "o5"}, "skiplist", nil},
+		{"object", []string{"foo", "o1"}, nil, cmd.ErrUnknownSubcommand},
+		{"object", []string{"encoding"}, nil, cmd.ErrUnknownSubcommand},
+		{"set", []string{"o8", "v"}, cmd.OKVal, nil},
+		{"object", []string{"freq", "o8"}, int64(5), nil},
+		{"get", []string{"o8"}, "v", nil},
+		{"object", []string{"freq", "o8"}, int64(6), nil},
+		{"object", []string{"idletime", "o8"}, int64(0), nil},
+		{"object", []string{"refcount", "o8"}, int64(1), nil},
+		{"object", []string{"idletime", "o6"}, nil, nil},
+		{"restore", []string{"o6", "0", dump, "idletime", "100"}, cmd.OKVal, nil},
+		{"object", []string{"idletime", "o6"}, int64(100), nil},
+		{"restore", []string{"o6", "0", dump, "freq", "42", "replace"}, cmd.OKVal, nil},
+		{"object", []string{"freq", "o6"}, int64(42), nil},
+		{"object", []string{"idletime", "o6"}, int64(0), nil},
+		{"memory", []string{"usage", "o6"}, int64(srv.KeyOverhead + 2 + 16 + 16 + 16 + 3), nil},
+		{"memory", []string{"usage", "o6", "samples", "0"}, int64(srv.KeyOverhead + 2 + 16 + 16 + 16 + 3), nil},
+		{"memory", []string{"usage", "o7"}, nil, nil},
+		{"memory", []string{"usage", "o6", "samples"}, nil, cmd.ErrSyntax},
+		{"memory", []string{"usage", "o6", "samples", "-1"}, nil, cmd.ErrSyntax},
+		{"memory", []string{"usage", "o6", "samples", "a"}, nil, cmd.ErrNotInteger},
+		{"memory", []string{"usage", "o6", "foo", "1"}, nil, cmd.ErrSyntax},
+		{"memory", []string{"foo"}, nil, cmd.ErrUnknownSubcommand},
+		{"del", []string{"o6", "o8"}, int64(2), nil},
+		{"del", []string{"o1", "o2", "o3", "o4", "o5"}, int64(5), nil},
+		{"del", []string{"ids", "weight_1", "weight_2", "weight_3", "obj_1", "obj_2", "sortset", "sortzset"}, int64(8), nil},
 
 		// Strings
 		{"append", []string{"k", "a"}, int64(1), nil},
@@ -58,13 +218,136 @@ func TestCommand(t *testing.T) {
 		{"getset", []string{"z", "efg"}, "", nil},
 		{"del", []string{"z"}, int64(1), nil},
 		{"getset", []string{"h", "efg"}, nil, cmd.ErrInvalidValType},
-		{"set", []string{"h", "efg"}, nil, cmd.ErrInvalidValType},
+		{"sadd", []string{"st", "a"}, int64(1), nil},
+		{"expire", []string{"st", "100"}, true, nil},
+		{"set", []string{"st", "efg", "keepttl"}, cmd.OKVal, nil},
+		{"get", []string{"st"}, "efg", nil},
+		{"ttl", []string{"st"}, int64(99), nil},
+		{"set", []string{"lock", "tok", "nx", "px", "30000"}, cmd.OKVal, nil},
+		{"set", []string{"lock", "other", "nx", "px", "30000"}, nil, nil},
+		{"get", []string{"lock"}, "tok", nil},
+		{"set", []string{"lock", "tok2", "xx", "get"}, "tok", nil},
+		{"ttl", []string{"lock"}, int64(-1), nil},
+		{"set", []string{"lock", "tok3", "ex", "100"}, cmd.OKVal, nil},
+		{"set", []string{"lock", "tok4", "keepttl", "get"}, "tok3", nil},
+		{"ttl", []string{"lock"}, int64(99), nil},
+		{"set", []string{"lock", "tok5", "exat", "99999999999"}, cmd.OKVal, nil},
+		{"set", []string{"lock", "tok6", "pxat", "99999999999000", "xx"}, cmd.OKVal, nil},
+		{"get", []string{"lock"}, "tok6", nil},
+		{"set", []string{"nolock", "v", "xx"}, nil, nil},
+		{"set", []string{"nolock", "v", "xx", "get"}, nil, nil},
+		{"exists", []string{"nolock"}, false, nil},
+		{"set", []string{"nolock", "v", "nx", "get"}, nil, nil},
+		{"get", []string{"nolock"}, "v", nil},
+		{"set", []string{"h", "efg", "nx"}, nil, nil},
+		{"set", []string{"h", "efg", "nx", "get"}, nil, cmd.ErrInvalidValType},
+		{"set", []string{"lock", "v", "nx", "xx"}, nil, cmd.ErrSyntax},
+		{"set", []string{"lock", "v", "ex", "10", "px", "100"}, nil, cmd.ErrSyntax},
+		{"set", []string{"lock", "v", "ex", "10", "keepttl"}, nil, cmd.ErrSyntax},
+		{"set", []string{"lock", "v", "ex"}, nil, cmd.ErrSyntax},
+		{"set", []string{"lock", "v", "foo"}, nil, cmd.ErrSyntax},
+		{"set", []string{"lock", "v", "ex", "0"}, nil, cmd.ErrInvalidExpire},
+		{"set", []string{"lock", "v", "px", "a"}, nil, cmd.ErrNotInteger},
+		{"set", []string{"lock", "v", "exat", "9223372036854776"}, nil, cmd.ErrInvalidExpire},
+		{"set", []string{"lock", "v", "ex", "9223372036854775"}, nil, cmd.ErrInvalidExpire},
+		{"set", []string{"lock", "v", "px", "9223372036854775807"}, nil, cmd.ErrInvalidExpire},
+		{"set", []string{"lock", "v", "pxat", "9223372036854775807"}, cmd.OKVal, nil},
+		{"get", []string{"lock"}, "v", nil},
+		{"setex", []string{"lock", "100", "v"}, cmd.OKVal, nil},
+		{"ttl", []string{"lock"}, int64(99), nil},
+		{"setex", []string{"lock", "0", "v"}, nil, cmd.ErrInvalidExpire},
+		{"rpush", []string{"lt", "a"}, int64(1), nil},
+		{"psetex", []string{"lt", "100000", "v"}, cmd.OKVal, nil},
+		{"get", []string{"lt"}, "v", nil},
+		{"del", []string{"st", "lt"}, int64(2), nil},
+		{"mset", []string{"m1", "a", "m2", "b"}, cmd.OKVal, nil},
+		{"mget", []string{"m1", "z", "h", "m2"}, []interface{}{"a", nil, nil, "b"}, nil},
+		{"rpush", []string{"m3", "a"}, int64(1), nil},
+		{"mset", []string{"m2", "c", "m3", "d"}, cmd.OKVal, nil},
+		{"mget", []string{"m2", "m3"}, []interface{}{"c", "d"}, nil},
+		{"del", []string{"m3"}, int64(1), nil},
+		{"msetnx", []string{"m3", "c", "m1", "d"}, int64(0), nil},
+		{"mget", []string{"m1", "m3"}, []interface{}{"a", nil}, nil},
+		{"msetnx", []string{"m3", "c", "m4", "d"}, int64(1), nil},
+		{"mget", []string{"m3", "m4"}, []interface{}{"c", "d"}, nil},
+		{"setnx", []string{"m1", "x"}, false, nil},
+		{"setnx", []string{"m5", "x"}, true, nil},
+		{"getex", []string{"m5", "ex", "100"}, "x", nil},
+		{"ttl", []string{"m5"}, int64(99), nil},
+		{"getex", []string{"m5", "persist"}, "x", nil},
+		{"ttl", []string{"m5"}, int64(-1), nil},
+		{"getex", []string{"m5", "pxat", "99999999999000"}, "x", nil},
+		{"getex", []string{"m5"}, "x", nil},
+		{"getex", []string{"m5", "ex", "0"}, nil, cmd.ErrInvalidExpire},
+		{"getex", []string{"m5", "foo", "1"}, nil, cmd.ErrSyntax},
+		{"getex", []string{"z", "ex", "10"}, nil, nil},
+		{"getex", []string{"h"}, nil, cmd.ErrInvalidValType},
+		{"getdel", []string{"m5"}, "x", nil},
+		{"exists", []string{"m5"}, false, nil},
+		{"getdel", []string{"m5"}, nil, nil},
+		{"getdel", []string{"h"}, nil, cmd.ErrInvalidValType},
+		{"del", []string{"m1", "m2", "m3", "m4"}, int64(4), nil},
 		{"setrange", []string{"k", "1", "zzzz"}, int64(5), nil},
 		{"setrange", []string{"k", "10", "aa"}, int64(12), nil},
 		{"setrange", []string{"t", "10", "aa"}, nil, cmd.ErrInvalidValType},
 		{"strlen", []string{"k"}, int64(12), nil},
 		{"strlen", []string{"z"}, int64(0), nil},
 		{"strlen", []string{"l"}, nil, cmd.ErrInvalidValType},
+
+		// Bits
+		{"setbit", []string{"b1", "7", "1"}, int64(0), nil},
+		{"setbit", []string{"b1", "7", "0"}, int64(1), nil},
+		{"setbit", []string{"b1", "7", "1"}, int64(0), nil},
+		{"setbit", []string{"l", "7", "1"}, nil, cmd.ErrInvalidValType},
+		{"getbit", []string{"b1", "7"}, int64(1), nil},
+		{"getbit", []string{"b1", "100"}, int64(0), nil},
+		{"getbit", []string{"z", "0"}, int64(0), nil},
+		{"getbit", []string{"l", "0"}, nil, cmd.ErrInvalidValType},
+		{"set", []string{"b2", "foobar"}, cmd.OKVal, nil},
+		{"bitcount", []string{"b2"}, int64(26), nil},
+		{"bitcount", []string{"b2", "1", "1"}, int64(6), nil},
+		{"bitcount", []string{"b2", "5", "30", "bit"}, int64(17), nil},
+		{"bitcount", []string{"z"}, int64(0), nil},
+		{"bitcount", []string{"b2", "1"}, nil, cmd.ErrSyntax},
+		{"bitcount", []string{"b2", "0", "1", "foo"}, nil, cmd.ErrSyntax},
+		{"bitcount", []string{"b2", "a", "1"}, nil, cmd.ErrNotInteger},
+		{"bitcount", []string{"l"}, nil, cmd.ErrInvalidValType},
+		{"set", []string{"b3", "\xff\xf0\x00"}, cmd.OKVal, nil},
+		{"bitpos", []string{"b3", "0"}, int64(12), nil},
+		{"bitpos", []string{"b3", "1"}, int64(0), nil},
+		{"bitpos", []string{"b3", "1", "2"}, int64(-1), nil},
+		{"bitpos", []string{"b3", "0", "0", "0"}, int64(-1), nil},
+		{"bitpos", []string{"b3", "0", "8", "15", "bit"}, int64(12), nil},
+		{"bitpos", []string{"z", "0"}, int64(0), nil},
+		{"bitpos", []string{"z", "1"}, int64(-1), nil},
+		{"bitpos", []string{"b3", "2"}, nil, cmd.ErrBitPosBit},
+		{"bitpos", []string{"l", "1"}, nil, cmd.ErrInvalidValType},
+		{"bitop", []string{"and", "bd", "b2", "b1"}, int64(6), nil},
+		{"get", []string{"bd"}, "\x00\x00\x00\x00\x00\x00", nil},
+		{"bitop", []string{"or", "bd", "b2", "b1"}, int64(6), nil},
+		{"get", []string{"bd"}, "goobar", nil},
+		{"bitop", []string{"xor", "bd", "b1", "b1"}, int64(1), nil},
+		{"get", []string{"bd"}, "\x00", nil},
+		{"bitop", []string{"not", "bd", "b1"}, int64(1), nil},
+		{"get", []string{"bd"}, "\xfe", nil},
+		{"bitop", []string{"not", "bd", "b1", "b2"}, nil, cmd.ErrBitOpNot},
+		{"bitop", []string{"foo", "bd", "b1"}, nil, cmd.ErrSyntax},
+		{"bitop", []string{"and", "bd", "l"}, nil, cmd.ErrInvalidValType},
+		{"bitop", []string{"and", "bd", "z"}, int64(0), nil},
+		{"exists", []string{"bd"}, false, nil},
+		{"bitfield", []string{"bf", "set", "u8", "0", "255", "get", "u8", "0", "incrby", "u8", "0", "1"}, []interface{}{int64(0), int64(255), int64(0)}, nil},
+		{"bitfield", []string{"bf", "overflow", "sat", "incrby", "u8", "0", "-1"}, []interface{}{int64(0)}, nil},
+		{"bitfield", []string{"bf", "overflow", "fail", "incrby", "i8", "#1", "200"}, []interface{}{nil}, nil},
+		{"get", []string{"bf"}, "\x00\x00", nil},
+		{"bitfield", []string{"bf", "set", "i4", "#3", "-1", "get", "u16", "0"}, []interface{}{int64(0), int64(15)}, nil},
+		{"bitfield", []string{"z", "get", "u8", "0"}, []interface{}{int64(0)}, nil},
+		{"exists", []string{"z"}, false, nil},
+		{"bitfield", []string{"bf", "get", "u64", "0"}, nil, cmd.ErrBitFieldType},
+		{"bitfield", []string{"bf", "get", "u8", "-1"}, nil, cmd.ErrBitOffset},
+		{"bitfield", []string{"bf", "overflow", "foo"}, nil, cmd.ErrOverflowType},
+		{"bitfield", []string{"bf", "foo"}, nil, cmd.ErrSyntax},
+		{"bitfield", []string{"bf", "set", "u8", "0", "x"}, nil, cmd.ErrNotInteger},
+		{"bitfield", []string{"l", "get", "u8", "0"}, nil, cmd.ErrInvalidValType},
 		{"del", []string{"k"}, int64(1), nil},
 		{"incr", []string{"k"}, int64(1), nil},
 		{"incr", []string{"k"}, int64(2), nil},
@@ -81,6 +364,25 @@ func TestCommand(t *testing.T) {
 		{"del", []string{"k"}, int64(1), nil},
 		{"incrbyfloat", []string{"k", "0.2"}, "0.2", nil},
 		{"incrbyfloat", []string{"l", "0.2"}, nil, cmd.ErrInvalidValType},
+		{"incrbyfloat", []string{"k", "inf"}, nil, cmd.ErrIncrNaNOrInf},
+		{"incrbyfloat", []string{"k", "0.1"}, "0.3", nil},
+		{"incrbyfloat", []string{"z", "1"}, nil, cmd.ErrNotFloat},
+		{"set", []string{"k", "9223372036854775806"}, cmd.OKVal, nil},
+		{"incr", []string{"k"}, int64(9223372036854775807), nil},
+		{"object", []string{"encoding", "k"}, "int", nil},
+		{"incr", []string{"k"}, nil, cmd.ErrIncrOverflow},
+		{"get", []string{"k"}, "9223372036854775807", nil},
+		{"decrby", []string{"k", "-9223372036854775808"}, nil, cmd.ErrDecrOverflow},
+		{"incrbyfloat", []string{"k", "1"}, "9223372036854775808", nil},
+		{"set", []string{"k", "+1"}, cmd.OKVal, nil},
+		{"incr", []string{"k"}, nil, cmd.ErrNotInteger},
+		{"set", []string{"k", "01"}, cmd.OKVal, nil},
+		{"decr", []string{"k"}, nil, cmd.ErrNotInteger},
+		{"set", []string{"k", "10"}, cmd.OKVal, nil},
+		{"incr", []string{"k"}, int64(11), nil},
+		{"append", []string{"k", "0"}, int64(3), nil},
+		{"incr", []string{"k"}, int64(111), nil},
+		{"getrange", []string{"k", "1", "-1"}, "11", nil},
 
 		// Hashes
 		{"del", []string{"k"}, int64(1), nil},
@@ -133,6 +435,12 @@ func TestCommand(t *testing.T) {
 		{"hvals", []string{"z"}, []string{}, nil},
 		{"hvals", []string{"k"}, []string{"v1", "v2", "v3", "v4", "v5"}, nil},
 		{"hvals", []string{"s"}, nil, cmd.ErrInvalidValType},
+		{"hscan", []string{"k", "0", "match", "f[1]", "count", "100"}, []interface{}{"0", []string{"f1", "v1"}}, nil},
+		{"hscan", []string{"k", "0", "novalues", "match", "*5", "count", "100"}, []interface{}{"0", []string{"f5"}}, nil},
+		{"hscan", []string{"z", "0"}, []interface{}{"0", []string{}}, nil},
+		{"hscan", []string{"k", "x"}, nil, cmd.ErrInvalidCursor},
+		{"hscan", []string{"k", "0", "type", "hash"}, nil, cmd.ErrSyntax},
+		{"hscan", []string{"s", "0"}, nil, cmd.ErrInvalidValType},
 		{"hincrby", []string{"z", "i1", "3"}, int64(3), nil},
 		{"hincrby", []string{"k", "i1", "3"}, int64(3), nil},
 		{"hincrby", []string{"k", "i1", "-7"}, int64(-4), nil},
@@ -269,6 +577,53 @@ func TestCommand(t *testing.T) {
 		{"blpop", []string{"l1", "1"}, nil, nil},
 		{"brpop", []string{"l1", "1"}, nil, nil},
 		{"brpoplpush", []string{"l1", "l2", "1"}, nil, nil},
+		{"lpos", []string{"k", "a"}, int64(2), nil},
+		{"lpos", []string{"k", "a", "rank", "2"}, int64(5), nil},
+		{"lpos", []string{"k", "a", "RANK", "-1"}, int64(5), nil},
+		{"lpos", []string{"k", "a", "count", "0"}, []interface{}{int64(2), int64(5)}, nil},
+		{"lpos", []string{"k", "c", "count", "0", "maxlen", "3"}, []interface{}{int64(0)}, nil},
+		{"lpos", []string{"k", "c", "rank", "-1", "count", "1"}, []interface{}{int64(4)}, nil},
+		{"lpos", []string{"k", "x"}, nil, nil},
+		{"lpos", []string{"z", "a", "count", "0"}, []interface{}{}, nil},
+		{"lpos", []string{"k", "a", "rank", "0"}, nil, cmd.ErrRankZero},
+		{"lpos", []string{"k", "a", "count", "-1"}, nil, cmd.ErrNegativeCount},
+		{"lpos", []string{"k", "a", "maxlen", "-1"}, nil, cmd.ErrNegativeMaxLen},
+		{"lpos", []string{"k", "a", "rank", "a"}, nil, cmd.ErrNotInteger},
+		{"lpos", []string{"k", "a", "rank"}, nil, cmd.ErrSyntax},
+		{"lpos", []string{"k", "a", "foo", "1"}, nil, cmd.ErrSyntax},
+		{"lpos", []string{"t", "a"}, nil, cmd.ErrInvalidValType},
+		{"lmove", []string{"k", "lm", "right", "left"}, "a", nil},
+		{"lmove", []string{"k", "lm", "LEFT", "RIGHT"}, "c", nil},
+		{"lrange", []string{"lm", "0", "-1"}, []string{"a", "c"}, nil},
+		{"lmove", []string{"k", "k", "left", "right"}, "d", nil},
+		{"lrange", []string{"k", "0", "-1"}, []string{"a", "b", "c", "d"}, nil},
+		{"lmove", []string{"z", "lm", "left", "left"}, nil, nil},
+		{"lmove", []string{"t", "lm", "left", "left"}, nil, cmd.ErrInvalidValType},
+		{"lmove", []string{"k", "t", "left", "left"}, nil, cmd.ErrInvalidValType},
+		{"blmove", []string{"k", "lm", "right", "left", "0.01"}, "d", nil},
+		{"lrange", []string{"lm", "0", "-1"}, []string{"d", "a", "c"}, nil},
+		{"blmove", []string{"l1", "lm", "left", "left", "0.01"}, nil, nil},
+		{"blmove", []string{"k", "t", "left", "left", "0"}, nil, cmd.ErrInvalidValType},
+		{"brpoplpush", []string{"k", "t", "0"}, nil, cmd.ErrInvalidValType},
+		{"blmove", []string{"z", "t", "left", "left", "0"}, nil, cmd.ErrInvalidValType},
+		{"lrange", []string{"k", "0", "-1"}, []string{"a", "b", "c"}, nil},
+		{"blmove", []string{"k", "lm", "left", "left", "-1"}, nil, cmd.ErrTimeoutNegative},
+		{"blmove", []string{"k", "lm", "left", "left", "a"}, nil, cmd.ErrTimeoutNotFloat},
+		{"lmpop", []string{"2", "l1", "lm", "left"}, []interface{}{"lm", []string{"d"}}, nil},
+		{"lmpop", []string{"2", "l1", "lm", "right", "count", "5"}, []interface{}{"lm", []string{"c", "a"}}, nil},
+		{"exists", []string{"lm"}, false, nil},
+		{"lmpop", []string{"1", "l1", "left"}, nil, nil},
+		{"lmpop", []string{"0", "l1", "left"}, nil, cmd.ErrNumKeysNotPositive},
+		{"lmpop", []string{"2", "l1", "left"}, nil, cmd.ErrSyntax},
+		{"lmpop", []string{"1", "l1", "up"}, nil, cmd.ErrSyntax},
+		{"lmpop", []string{"1", "l1", "left", "count", "0"}, nil, cmd.ErrPopCountNotPositive},
+		{"lmpop", []string{"1", "l1", "left", "count"}, nil, cmd.ErrSyntax},
+		{"lmpop", []string{"2", "t", "l1", "left"}, nil, cmd.ErrInvalidValType},
+		{"rpush", []string{"mp", "a", "b", "c"}, int64(3), nil},
+		{"blmpop", []string{"0.01", "2", "l1", "mp", "right", "count", "2"}, []interface{}{"mp", []string{"c", "b"}}, nil},
+		{"blmpop", []string{"0.01", "1", "l1", "left"}, nil, nil},
+		{"blmpop", []string{"-1", "1", "l1", "left"}, nil, cmd.ErrTimeoutNegative},
+		{"del", []string{"mp"}, int64(1), nil},
 
 		// Sets
 		{"del", []string{"k"}, int64(1), nil},
@@ -287,7 +642,343 @@ func TestCommand(t *testing.T) {
 		{"sdiff", []string{"s", "k2", "k3"}, nil, cmd.ErrInvalidValType},
 		{"sdiff", []string{"k", "l", "k3"}, nil, cmd.ErrInvalidValType},
 		{"sdiffstore", []string{"j", "k", "k2", "k3"}, int64(2), nil},
-		{"sdiffstore", []string{"k3", "k", "k2", "k3"}, int64(2), nil}, // TODO : Triggers deadlock
+		{"sdiffstore", []string{"k3", "k", "k2", "k3"}, int64(2), nil},
+		{"sinter", []string{"k", "k2"}, []string{"a", "d"}, nil},
+		{"sinter", []string{"k", "z"}, []string{}, nil},
+		{"sinter", []string{"k", "s"}, nil, cmd.ErrInvalidValType},
+		{"sinterstore", []string{"j", "k", "k3"}, int64(2), nil},
+		{"smembers", []string{"j"}, []string{"b", "c"}, nil},
+		{"sscan", []string{"j", "0", "match", "b", "count", "100"}, []interface{}{"0", []string{"b"}}, nil},
+		{"sscan", []string{"z", "0"}, []interface{}{"0", []string{}}, nil},
+		{"sscan", []string{"j", "0", "novalues"}, nil, cmd.ErrSyntax},
+		{"sscan", []string{"s", "0"}, nil, cmd.ErrInvalidValType},
+		{"sinterstore", []string{"j", "k", "z"}, int64(0), nil},
+		{"exists", []string{"j"}, false, nil},
+		{"sinterstore", []string{"j", "k", "l"}, nil, cmd.ErrInvalidValType},
+		{"sintercard", []string{"2", "k", "k2"}, int64(2), nil},
+		{"sintercard", []string{"2", "k", "k2", "limit", "1"}, int64(1), nil},
+		{"sintercard", []string{"2", "k", "z"}, int64(0), nil},
+		{"sintercard", []string{"0", "k"}, nil, cmd.ErrNumKeysNotPositive},
+		{"sintercard", []string{"3", "k"}, nil, cmd.ErrNumKeysTooMany},
+		{"sintercard", []string{"1", "k", "limit", "-1"}, nil, cmd.ErrNegativeLimit},
+		{"sintercard", []string{"1", "k", "foo"}, nil, cmd.ErrSyntax},
+		{"sintercard", []string{"1", "h"}, nil, cmd.ErrInvalidValType},
+		{"sunion", []string{"k2", "z", "k3"}, []string{"a", "d", "b", "c"}, nil},
+		{"sunion", []string{"z"}, []string{}, nil},
+		{"sunion", []string{"k2", "h"}, nil, cmd.ErrInvalidValType},
+		{"sunionstore", []string{"j", "k2", "z", "k3"}, int64(4), nil},
+		{"sunionstore", []string{"j", "j", "k"}, int64(4), nil},
+		{"srem", []string{"j", "a", "z"}, int64(1), nil},
+		{"srem", []string{"j", "b", "c", "d"}, int64(3), nil},
+		{"exists", []string{"j"}, false, nil},
+		{"srem", []string{"z", "a"}, int64(0), nil},
+		{"srem", []string{"s", "a"}, nil, cmd.ErrInvalidValType},
+		{"smove", []string{"k2", "k4", "a"}, true, nil},
+		{"smembers", []string{"k4"}, []string{"a"}, nil},
+		{"smove", []string{"k2", "k4", "x"}, false, nil},
+		{"smove", []string{"z", "k4", "a"}, false, nil},
+		{"smove", []string{"k2", "k4", "d"}, true, nil},
+		{"exists", []string{"k2"}, false, nil},
+		{"smove", []string{"k4", "k4", "a"}, true, nil},
+		{"srem", []string{"k4", "d"}, int64(1), nil},
+		{"smove", []string{"k4", "s", "a"}, nil, cmd.ErrInvalidValType},
+		{"smove", []string{"s", "k4", "a"}, nil, cmd.ErrInvalidValType},
+		{"smismember", []string{"k4", "a", "b"}, []interface{}{true, false}, nil},
+		{"smismember", []string{"z", "a"}, []interface{}{false}, nil},
+		{"smismember", []string{"s", "a"}, nil, cmd.ErrInvalidValType},
+		{"spop", []string{"k4"}, "a", nil},
+		{"exists", []string{"k4"}, false, nil},
+		{"spop", []string{"z"}, nil, nil},
+		{"spop", []string{"z", "2"}, []string{}, nil},
+		{"sadd", []string{"k5", "x"}, int64(1), nil},
+		{"spop", []string{"k5", "3"}, []string{"x"}, nil},
+		{"exists", []string{"k5"}, false, nil},
+		{"spop", []string{"k", "-1"}, nil, cmd.ErrNotPositive},
+		{"spop", []string{"k", "x"}, nil, cmd.ErrNotInteger},
+		{"spop", []string{"s"}, nil, cmd.ErrInvalidValType},
+		{"srandmember", []string{"z"}, nil, nil},
+		{"srandmember", []string{"z", "3"}, []string{}, nil},
+		{"sadd", []string{"k6", "y"}, int64(1), nil},
+		{"srandmember", []string{"k6"}, "y", nil},
+		{"srandmember", []string{"k6", "2"}, []string{"y"}, nil},
+		{"srandmember", []string{"k6", "-3"}, []string{"y", "y", "y"}, nil},
+		{"srandmember", []string{"k6", "x"}, nil, cmd.ErrNotInteger},
+		{"srandmember", []string{"s"}, nil, cmd.ErrInvalidValType},
+
+		// Sorted sets
+		{"zadd", []string{"zs", "1", "a", "2", "b", "3", "c"}, int64(3), nil},
+		{"type", []string{"zs"}, "zset", nil},
+		{"zadd", []string{"zs", "4", "a", "5", "d"}, int64(1), nil},
+		{"zadd", []string{"zs", "ch", "1", "a", "5", "d"}, int64(1), nil},
+		{"zadd", []string{"zs", "nx", "10", "a", "6", "e"}, int64(1), nil},
+		{"zadd", []string{"zs", "xx", "0", "f"}, int64(0), nil},
+		{"zadd", []string{"zx", "xx", "0", "f"}, int64(0), nil},
+		{"exists", []string{"zx"}, false, nil},
+		{"zadd", []string{"zs", "gt", "ch", "0", "a", "7", "e"}, int64(1), nil},
+		{"zadd", []string{"zs", "lt", "ch", "0", "a", "9", "e"}, int64(1), nil},
+		{"zadd", []string{"zs", "incr", "2", "a"}, "2", nil},
+		{"zadd", []string{"zs", "nx", "incr", "2", "a"}, nil, nil},
+		{"zadd", []string{"zs", "nx", "xx", "2", "a"}, nil, cmd.ErrXXAndNX},
+		{"zadd", []string{"zs", "gt", "lt", "2", "a"}, nil, cmd.ErrGTLTAndNX},
+		{"zadd", []string{"zs", "incr", "2", "a", "3", "b"}, nil, cmd.ErrIncrPair},
+		{"zadd", []string{"zs", "x", "a"}, nil, cmd.ErrNotFloat},
+		{"zadd", []string{"zs", "1", "a", "2"}, nil, cmd.ErrSyntax},
+		{"zadd", []string{"s", "1", "a"}, nil, cmd.ErrInvalidValType},
+		{"zrange", []string{"zs", "0", "-1", "withscores"}, []string{"a", "2", "b", "2", "c", "3", "d", "5", "e", "7"}, nil},
+		{"zrevrange", []string{"zs", "0", "1"}, []string{"e", "d"}, nil},
+		{"zrange", []string{"zs", "-2", "10"}, []string{"d", "e"}, nil},
+		{"zrange", []string{"zs", "3", "1"}, []string{}, nil},
+		{"zrange", []string{"z", "0", "-1"}, []string{}, nil},
+		{"zrange", []string{"l", "0", "-1"}, nil, cmd.ErrInvalidValType},
+		{"zcard", []string{"zs"}, int64(5), nil},
+		{"zcard", []string{"z"}, int64(0), nil},
+		{"zcard", []string{"s"}, nil, cmd.ErrInvalidValType},
+		{"zcount", []string{"zs", "2", "5"}, int64(4), nil},
+		{"zcount", []string{"zs", "(2", "(5"}, int64(1), nil},
+		{"zcount", []string{"zs", "-inf", "+inf"}, int64(5), nil},
+		{"zcount", []string{"zs", "5", "2"}, int64(0), nil},
+		{"zcount", []string{"zs", "a", "2"}, nil, cmd.ErrMinMaxNotFloat},
+		{"zincrby", []string{"zs", "1.5", "c"}, "4.5", nil},
+		{"zincrby", []string{"zs", "-1.5", "c"}, "3", nil},
+		{"zincrby", []string{"zn", "3", "x"}, "3", nil},
+		{"zincrby", []string{"zn", "inf", "x"}, "inf", nil},
+		{"zincrby", []string{"zn", "-inf", "x"}, nil, cmd.ErrNaNScore},
+		{"zincrby", []string{"h", "1", "x"}, nil, cmd.ErrInvalidValType},
+		{"zscore", []string{"zs", "d"}, "5", nil},
+		{"zscore", []string{"zs", "z"}, nil, nil},
+		{"zscore", []string{"z", "d"}, nil, nil},
+		{"zscore", []string{"l", "d"}, nil, cmd.ErrInvalidValType},
+		{"zscan", []string{"zs", "0", "match", "d", "count", "100"}, []interface{}{"0", []string{"d", "5"}}, nil},
+		{"zscan", []string{"z", "0"}, []interface{}{"0", []string{}}, nil},
+		{"zscan", []string{"zs", "0", "count", "0"}, nil, cmd.ErrSyntax},
+		{"zscan", []string{"l", "0"}, nil, cmd.ErrInvalidValType},
+		{"zrank", []string{"zs", "a"}, int64(0), nil},
+		{"zrank", []string{"zs", "e"}, int64(4), nil},
+		{"zrank", []string{"zs", "z"}, nil, nil},
+		{"zrevrank", []string{"zs", "a"}, int64(4), nil},
+		{"zrevrank", []string{"zs", "e"}, int64(0), nil},
+		{"zrevrank", []string{"t", "e"}, nil, cmd.ErrInvalidValType},
+		{"zrangebyscore", []string{"zs", "2", "5"}, []string{"a", "b", "c", "d"}, nil},
+		{"zrangebyscore", []string{"zs", "(2", "+inf", "withscores"}, []string{"c", "3", "d", "5", "e", "7"}, nil},
+		{"zrangebyscore", []string{"zs", "-inf", "+inf", "limit", "1", "2"}, []string{"b", "c"}, nil},
+		{"zrangebyscore", []string{"zs", "-inf", "+inf", "limit", "3", "-1"}, []string{"d", "e"}, nil},
+		{"zrangebyscore", []string{"zs", "-inf", "+inf", "limit", "a", "-1"}, nil, cmd.ErrNotInteger},
+		{"zrangebyscore", []string{"zs", "-inf", "+inf", "limit", "1"}, nil, cmd.ErrSyntax},
+		{"zrevrangebyscore", []string{"zs", "5", "(2"}, []string{"d", "c"}, nil},
+		{"zrevrangebyscore", []string{"zs", "+inf", "-inf", "withscores", "limit", "0", "1"}, []string{"e", "7"}, nil},
+		{"zadd", []string{"zl", "0", "a", "0", "b", "0", "c", "0", "d", "0", "e"}, int64(5), nil},
+		{"zrangebylex", []string{"zl", "-", "+"}, []string{"a", "b", "c", "d", "e"}, nil},
+		{"zrangebylex", []string{"zl", "[b", "(d"}, []string{"b", "c"}, nil},
+		{"zrangebylex", []string{"zl", "(b", "+", "limit", "1", "2"}, []string{"d", "e"}, nil},
+		{"zrangebylex", []string{"zl", "b", "+"}, nil, cmd.ErrMinMaxNotLex},
+		{"zrevrangebylex", []string{"zl", "[c", "-"}, []string{"c", "b", "a"}, nil},
+		{"zlexcount", []string{"zl", "[b", "[d"}, int64(3), nil},
+		{"zlexcount", []string{"zl", "+", "-"}, int64(0), nil},
+		{"zremrangebylex", []string{"zl", "[b", "(d"}, int64(2), nil},
+		{"zrange", []string{"zl", "0", "-1"}, []string{"a", "d", "e"}, nil},
+		{"zremrangebyrank", []string{"zl", "0", "1"}, int64(2), nil},
+		{"zrange", []string{"zl", "0", "-1"}, []string{"e"}, nil},
+		{"zremrangebyscore", []string{"zl", "-inf", "(0"}, int64(0), nil},
+		{"zremrangebyscore", []string{"zl", "-inf", "0"}, int64(1), nil},
+		{"exists", []string{"zl"}, false, nil},
+		{"zrem", []string{"zs", "b", "z", "b"}, int64(1), nil},
+		{"zrem", []string{"z", "b"}, int64(0), nil},
+		{"zrem", []string{"s", "b"}, nil, cmd.ErrInvalidValType},
+		{"zadd", []string{"zs2", "1", "a", "2", "d", "3", "x"}, int64(3), nil},
+		{"zunionstore", []string{"zd", "2", "zs", "zs2"}, int64(5), nil},
+		{"zrange", []string{"zd", "0", "-1", "withscores"}, []string{"a", "3", "c", "3", "x", "3", "d", "7", "e", "7"}, nil},
+		{"zunionstore", []string{"zd", "2", "zs", "zs2", "weights", "2", "1", "aggregate", "max"}, int64(5), nil},
+		{"zrange", []string{"zd", "0", "-1", "withscores"}, []string{"x", "3", "a", "4", "c", "6", "d", "10", "e", "14"}, nil},
+		{"zinterstore", []string{"zd", "2", "zs", "zs2", "aggregate", "min"}, int64(2), nil},
+		{"zrange", []string{"zd", "0", "-1", "withscores"}, []string{"a", "1", "d", "2"}, nil},
+		{"zinterstore", []string{"zd", "2", "zd", "z"}, int64(0), nil},
+		{"exists", []string{"zd"}, false, nil},
+		{"zunionstore", []string{"zs2", "2", "zs2", "t"}, int64(4), nil},
+		{"zrange", []string{"zs2", "0", "-1", "withscores"}, []string{"a", "1", "v1", "1", "d", "2", "x", "3"}, nil},
+		{"zunionstore", []string{"zd", "0", "zs"}, nil, cmd.ErrNoStoreInput},
+		{"zunionstore", []string{"zd", "3", "zs"}, nil, cmd.ErrSyntax},
+		{"zunionstore", []string{"zd", "1", "zs", "weights", "a"}, nil, cmd.ErrWeightNotFloat},
+		{"zunionstore", []string{"zd", "1", "zs", "aggregate", "avg"}, nil, cmd.ErrSyntax},
+		{"zunionstore", []string{"zd", "2", "zs", "s"}, nil, cmd.ErrInvalidValType},
+
+		// HyperLogLog
+		{"pfadd", []string{"hll", "a", "b", "c"}, int64(1), nil},
+		{"pfadd", []string{"hll", "a"}, int64(0), nil},
+		{"pfcount", []string{"hll"}, int64(3), nil},
+		{"type", []string{"hll"}, "string", nil},
+		{"pfadd", []string{"hll2", "c", "d"}, int64(1), nil},
+		{"pfcount", []string{"hll", "hll2", "z"}, int64(4), nil},
+		{"pfcount", []string{"hll"}, int64(3), nil},
+		{"pfmerge", []string{"hll3", "hll", "hll2"}, cmd.OKVal, nil},
+		{"pfcount", []string{"hll3"}, int64(4), nil},
+		{"pfmerge", []string{"hll2", "hll"}, cmd.OKVal, nil},
+		{"pfcount", []string{"hll2"}, int64(4), nil},
+		{"pfcount", []string{"z"}, int64(0), nil},
+		{"pfadd", []string{"hll4"}, int64(1), nil},
+		{"pfcount", []string{"hll4"}, int64(0), nil},
+		{"pfadd", []string{"s", "a"}, nil, cmd.ErrNotHLL},
+		{"pfadd", []string{"l", "a"}, nil, cmd.ErrInvalidValType},
+		{"pfcount", []string{"hll", "s"}, nil, cmd.ErrNotHLL},
+		{"pfcount", []string{"l"}, nil, cmd.ErrInvalidValType},
+		{"pfmerge", []string{"hll", "l"}, nil, cmd.ErrInvalidValType},
+		{"pfmerge", []string{"s", "hll"}, nil, cmd.ErrNotHLL},
+		{"append", []string{"hll4", "x"}, int64(19), nil},
+		{"pfcount", []string{"hll4"}, nil, cmd.ErrCorruptHLL},
+		{"pfadd", []string{"hll4", "a"}, nil, cmd.ErrCorruptHLL},
+
+		// Streams
+		{"xadd", []string{"st", "1-1", "f", "v"}, "1-1", nil},
+		{"xadd", []string{"st", "1-*", "f", "v"}, "1-2", nil},
+		{"xadd", []string{"st", "1-1", "f", "v"}, nil, cmd.ErrStreamIDTooSmall},
+		{"xadd", []string{"st", "0-*", "f", "v"}, nil, cmd.ErrStreamIDTooSmall},
+		{"xadd", []string{"st", "0-0", "f", "v"}, nil, cmd.ErrStreamIDZero},
+		{"xadd", []string{"st", "abc", "f", "v"}, nil, cmd.ErrInvalidStreamID},
+		{"xadd", []string{"st", "maxlen", "2", "3-0", "f", "v"}, "3-0", nil},
+		{"xlen", []string{"st"}, int64(2), nil},
+		{"xadd", []string{"st2", "nomkstream", "1-1", "f", "v"}, nil, nil},
+		{"exists", []string{"st2"}, false, nil},
+		{"xadd", []string{"st2", "0-*", "f", "v"}, "0-1", nil},
+		{"del", []string{"st2"}, int64(1), nil},
+		{"xadd", []string{"s", "1-1", "f", "v"}, nil, cmd.ErrInvalidValType},
+		{"xadd", []string{"st", "minid", "=", "3", "4-0", "a", "b"}, "4-0", nil},
+		{"xadd", []string{"st", "maxlen", "1", "limit", "10", "5-0", "a", "b"}, nil, cmd.ErrLimitWithoutApprox},
+		{"xlen", []string{"st"}, int64(2), nil},
+		{"xlen", []string{"z"}, int64(0), nil},
+		{"xlen", []string{"l"}, nil, cmd.ErrInvalidValType},
+		{"type", []string{"st"}, "stream", nil},
+		{"xrange", []string{"st", "-", "+"}, []interface{}{[]interface{}{"3-0", []string{"f", "v"}}, []interface{}{"4-0", []string{"a", "b"}}}, nil},
+		{"xrange", []string{"st", "-", "+", "count", "1"}, []interface{}{[]interface{}{"3-0", []string{"f", "v"}}}, nil},
+		{"xrange", []string{"st", "(3-0", "+"}, []interface{}{[]interface{}{"4-0", []string{"a", "b"}}}, nil},
+		{"xrange", []string{"st", "4", "4"}, []interface{}{[]interface{}{"4-0", []string{"a", "b"}}}, nil},
+		{"xrevrange", []string{"st", "+", "-"}, []interface{}{[]interface{}{"4-0", []string{"a", "b"}}, []interface{}{"3-0", []string{"f", "v"}}}, nil},
+		{"xrevrange", []string{"st", "+", "-", "count", "1"}, []interface{}{[]interface{}{"4-0", []string{"a", "b"}}}, nil},
+		{"xrevrange", []string{"st", "(4-0", "-"}, []interface{}{[]interface{}{"3-0", []string{"f", "v"}}}, nil},
+		{"xrange", []string{"z", "-", "+"}, []interface{}{}, nil},
+		{"xrange", []string{"st", "foo", "+"}, nil, cmd.ErrInvalidStreamID},
+		{"xrange", []string{"st", "-", "+", "count", "x"}, nil, cmd.ErrNotInteger},
+		{"xrange", []string{"st", "-", "+", "foo", "1"}, nil, cmd.ErrSyntax},
+		{"xrange", []string{"l", "-", "+"}, nil, cmd.ErrInvalidValType},
+		{"xdel", []string{"st", "3-0", "9-9"}, int64(1), nil},
+		{"xdel", []string{"st", "foo"}, nil, cmd.ErrInvalidStreamID},
+		{"xdel", []string{"l", "1-1"}, nil, cmd.ErrInvalidValType},
+		{"xlen", []string{"st"}, int64(1), nil},
+		{"xadd", []string{"st", "5-0", "c", "d"}, "5-0", nil},
+		{"xadd", []string{"st", "6-0", "e", "f"}, "6-0", nil},
+		{"xtrim", []string{"st", "maxlen", "2"}, int64(1), nil},
+		{"xtrim", []string{"st", "minid", "6"}, int64(1), nil},
+		{"xtrim", []string{"st", "maxlen", "~", "0", "limit", "0"}, int64(1), nil},
+		{"xlen", []string{"st"}, int64(0), nil},
+		{"exists", []string{"st"}, true, nil},
+		{"xadd", []string{"st", "6-0", "e", "f"}, nil, cmd.ErrStreamIDTooSmall},
+		{"xtrim", []string{"st", "maxlen", "1", "limit", "10"}, nil, cmd.ErrLimitWithoutApprox},
+		{"xtrim", []string{"st", "maxlen", "-1"}, nil, cmd.ErrMaxLenNegative},
+		{"xtrim", []string{"st", "maxlen", "~", "1", "limit", "-1"}, nil, cmd.ErrLimitNegative},
+		{"xtrim", []string{"st", "foo", "1"}, nil, cmd.ErrSyntax},
+		{"xtrim", []string{"st", "maxlen", "1", "foo"}, nil, cmd.ErrSyntax},
+		{"xtrim", []string{"z", "maxlen", "1"}, int64(0), nil},
+		{"xtrim", []string{"l", "maxlen", "1"}, nil, cmd.ErrInvalidValType},
+		{"xread", []string{"streams", "st2", "0"}, nil, nil},
+		{"xadd", []string{"st2", "1-0", "a", "b"}, "1-0", nil},
+		{"xread", []string{"count", "1", "streams", "st", "st2", "0", "0"}, []interface{}{[]interface{}{"st2", []interface{}{[]interface{}{"1-0", []string{"a", "b"}}}}}, nil},
+		{"xread", []string{"streams", "st2", "$"}, nil, nil},
+		{"xread", []string{"block", "10", "streams", "st2", "$"}, nil, nil},
+		{"xread", []string{"streams", "st2", "0", "1"}, nil, cmd.ErrUnbalancedStreams},
+		{"xread", []string{"count", "1", "streams"}, nil, cmd.ErrSyntax},
+		{"xread", []string{"block", "-1", "streams", "st2", "0"}, nil, cmd.ErrTimeoutNegative},
+		{"xread", []string{"streams", "l", "0"}, nil, cmd.ErrInvalidValType},
+		{"xread", []string{"streams", "st2", "foo"}, nil, cmd.ErrInvalidStreamID},
+		{"xgroup", []string{"create", "cg", "g1", "$"}, nil, cmd.ErrXGroupNoKey},
+		{"xgroup", []string{"create", "cg", "g1", "$", "mkstream"}, cmd.OKVal, nil},
+		{"xgroup", []string{"create", "cg", "g1", "0"}, nil, cmd.ErrGroupExists},
+		{"xgroup", []string{"create", "l", "g1", "0"}, nil, cmd.ErrInvalidValType},
+		{"xgroup", []string{"create", "cg", "g2", "foo"}, nil, cmd.ErrInvalidStreamID},
+		{"xgroup", []string{"foo", "cg", "g1"}, nil, cmd.ErrUnknownSubcommand},
+		{"xadd", []string{"cg", "1-0", "a", "1"}, "1-0", nil},
+		{"xadd", []string{"cg", "2-0", "b", "2"}, "2-0", nil},
+		{"xadd", []string{"cg", "3-0", "c", "3"}, "3-0", nil},
+		{"xreadgroup", []string{"group", "g1", "c1", "count", "1", "streams", "cg", ">"}, []interface{}{[]interface{}{"cg", []interface{}{[]interface{}{"1-0", []string{"a", "1"}}}}}, nil},
+		{"xreadgroup", []string{"group", "g1", "c2", "streams", "cg", ">"}, []interface{}{[]interface{}{"cg", []interface{}{[]interface{}{"2-0", []string{"b", "2"}}, []interface{}{"3-0", []string{"c", "3"}}}}}, nil},
+		{"xreadgroup", []string{"group", "g1", "c2", "streams", "cg", ">"}, nil, nil},
+		{"xreadgroup", []string{"group", "g1", "c2", "block", "10", "streams", "cg", ">"}, nil, nil},
+		{"xreadgroup", []string{"group", "g1", "c1", "streams", "cg", "0"}, []interface{}{[]interface{}{"cg", []interface{}{[]interface{}{"1-0", []string{"a", "1"}}}}}, nil},
+		{"xreadgroup", []string{"group", "g1", "c3", "streams", "cg", "0"}, []interface{}{[]interface{}{"cg", []interface{}{}}}, nil},
+		{"xreadgroup", []string{"group", "g2", "c1", "streams", "cg", ">"}, nil, cmd.ErrNoGroup},
+		{"xreadgroup", []string{"group", "g1", "c1", "streams", "nosuchkey", ">"}, nil, cmd.ErrNoGroup},
+		{"xreadgroup", []string{"group", "g1", "c1", "streams", "cg", "$"}, nil, cmd.ErrGroupReadDollar},
+		{"xreadgroup", []string{"group", "g1", "c1", "streams", "cg", ">", "0"}, nil, cmd.ErrUnbalancedGroupStreams},
+		{"xreadgroup", []string{"foo", "g1", "c1", "streams", "cg", ">"}, nil, cmd.ErrSyntax},
+		{"xpending", []string{"cg", "g1"}, []interface{}{int64(3), "1-0", "3-0", []interface{}{[]interface{}{"c1", "1"}, []interface{}{"c2", "2"}}}, nil},
+		{"xpending", []string{"cg", "g1", "-", "+", "0"}, []interface{}{}, nil},
+		{"xpending", []string{"cg", "g1", "idle", "100000", "-", "+", "10"}, []interface{}{}, nil},
+		{"xpending", []string{"cg", "g1", "-", "+"}, nil, cmd.ErrSyntax},
+		{"xpending", []string{"cg", "g2"}, nil, cmd.ErrNoGroup},
+		{"xclaim", []string{"cg", "g1", "c1", "100000", "2-0"}, []interface{}{}, nil},
+		{"xclaim", []string{"cg", "g1", "c1", "0", "2-0", "justid"}, []string{"2-0"}, nil},
+		{"xclaim", []string{"cg", "g1", "c1", "0", "2-0", "foo"}, nil, cmd.ErrSyntax},
+		{"xclaim", []string{"cg", "g2", "c1", "0", "2-0"}, nil, cmd.ErrNoGroup},
+		{"xautoclaim", []string{"cg", "g1", "c3", "0", "-", "count", "1"}, []interface{}{"2-0", []interface{}{[]interface{}{"1-0", []string{"a", "1"}}}, []string{}}, nil},
+		{"xautoclaim", []string{"cg", "g1", "c3", "0", "2-0", "justid"}, []interface{}{"0-0", []string{"2-0", "3-0"}, []string{}}, nil},
+		{"xautoclaim", []string{"cg", "g1", "c3", "0", "-", "count", "0"}, nil, cmd.ErrNotPositive},
+		{"xack", []string{"cg", "g1", "1-0", "2-0", "9-0"}, int64(2), nil},
+		{"xack", []string{"cg", "g2", "1-0"}, int64(0), nil},
+		{"xack", []string{"cg", "g1", "foo"}, nil, cmd.ErrInvalidStreamID},
+		{"xpending", []string{"cg", "g1"}, []interface{}{int64(1), "3-0", "3-0", []interface{}{[]interface{}{"c3", "1"}}}, nil},
+		{"xinfo", []string{"groups", "cg"}, []interface{}{[]interface{}{"name", "g1", "consumers", int64(3), "pending", int64(1), "last-delivered-id", "3-0"}}, nil},
+		{"xinfo", []string{"stream", "cg"}, []interface{}{"length", int64(3), "radix-tree-keys", int64(1), "radix-tree-nodes", int64(1), "last-generated-id", "3-0", "max-deleted-entry-id", "0-0", "entries-added", int64(3), "groups", int64(1), "first-entry", []interface{}{"1-0", []string{"a", "1"}}, "last-entry", []interface{}{"3-0", []string{"c", "3"}}}, nil},
+		{"xinfo", []string{"stream", "nosuchkey"}, nil, cmd.ErrNoSuchKey},
+		{"xinfo", []string{"consumers", "cg", "g2"}, nil, cmd.ErrNoGroup},
+		{"xgroup", []string{"createconsumer", "cg", "g1", "c4"}, true, nil},
+		{"xgroup", []string{"createconsumer", "cg", "g1", "c4"}, false, nil},
+		{"xgroup", []string{"delconsumer", "cg", "g1", "c3"}, int64(1), nil},
+		{"xgroup", []string{"setid", "cg", "g1", "0"}, cmd.OKVal, nil},
+		{"xgroup", []string{"setid", "cg", "g2", "0"}, nil, cmd.ErrNoGroup},
+		{"xreadgroup", []string{"group", "g1", "c4", "noack", "count", "1", "streams", "cg", ">"}, []interface{}{[]interface{}{"cg", []interface{}{[]interface{}{"1-0", []string{"a", "1"}}}}}, nil},
+		{"xpending", []string{"cg", "g1"}, []interface{}{int64(0), nil, nil, nil}, nil},
+		{"xgroup", []string{"destroy", "cg", "g1"}, true, nil},
+		{"xgroup", []string{"destroy", "cg", "g1"}, false, nil},
+		{"xgroup", []string{"destroy", "nosuchkey", "g1"}, nil, cmd.ErrXGroupNoKey},
+		{"geoadd", []string{"geo", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"}, int64(2), nil},
+		{"geoadd", []string{"geo", "nx", "ch", "0", "0", "Palermo", "2.349014", "48.864716", "Paris"}, int64(1), nil},
+		{"geoadd", []string{"geo", "xx", "ch", "2.349014", "48.864716", "Paris", "0", "0", "Nowhere"}, int64(0), nil},
+		{"geoadd", []string{"geo", "181", "0", "Nowhere"}, nil, cmd.ErrInvalidLonLat},
+		{"geoadd", []string{"geo", "0", "86", "Nowhere"}, nil, cmd.ErrInvalidLonLat},
+		{"geoadd", []string{"geo", "a", "0", "Nowhere"}, nil, cmd.ErrNotFloat},
+		{"geoadd", []string{"geo", "0", "0", "a", "b"}, nil, cmd.ErrSyntax},
+		{"geoadd", []string{"geo", "nx", "xx", "0", "0", "Nowhere"}, nil, cmd.ErrXXAndNX},
+		{"geoadd", []string{"l", "0", "0", "Nowhere"}, nil, cmd.ErrInvalidValType},
+		{"zscore", []string{"geo", "Palermo"}, "3479099956230698", nil},
+		{"zrem", []string{"geo", "Paris"}, int64(1), nil},
+		{"geodist", []string{"geo", "Palermo", "Catania"}, "166274.1516", nil},
+		{"geodist", []string{"geo", "Palermo", "Catania", "KM"}, "166.2742", nil},
+		{"geodist", []string{"geo", "Palermo", "Catania", "mi"}, "103.3182", nil},
+		{"geodist", []string{"geo", "Palermo", "Nowhere"}, nil, nil},
+		{"geodist", []string{"geo", "Palermo", "Catania", "yd"}, nil, cmd.ErrUnsupportedUnit},
+		{"geohash", []string{"geo", "Palermo", "Catania", "Nowhere"}, []interface{}{"sqc8b49rny0", "sqdtr74hyu0", nil}, nil},
+		{"geopos", []string{"geo", "Palermo", "Nowhere"}, []interface{}{[]string{"13.361389338970184", "38.1155563954963"}, nil}, nil},
+		{"geopos", []string{"nosuchkey", "Palermo"}, []interface{}{nil}, nil},
+		{"geosearch", []string{"geo", "fromlonlat", "15", "37", "byradius", "200", "km", "asc"}, []string{"Catania", "Palermo"}, nil},
+		{"geosearch", []string{"geo", "fromlonlat", "15", "37", "byradius", "100", "km"}, []string{"Catania"}, nil},
+		{"geosearch", []string{"geo", "fromlonlat", "15", "37", "byradius", "200", "km", "desc", "withdist"}, []interface{}{[]interface{}{"Palermo", "190.4424"}, []interface{}{"Catania", "56.4413"}}, nil},
+		{"geosearch", []string{"geo", "fromlonlat", "15", "37", "bybox", "400", "400", "km", "count", "1", "withhash", "withcoord"}, []interface{}{[]interface{}{"Catania", int64(3479447370796909), []string{"15.087267458438873", "37.50266842333162"}}}, nil},
+		{"geosearch", []string{"geo", "frommember", "Palermo", "bybox", "200", "200", "km"}, []string{"Palermo"}, nil},
+		{"geosearch", []string{"geo", "frommember", "Palermo", "byradius", "200", "km", "count", "1", "any"}, []string{"Palermo"}, nil},
+		{"geosearch", []string{"nosuchkey", "frommember", "Palermo", "byradius", "200", "km"}, []string{}, nil},
+		{"geosearch", []string{"geo", "frommember", "Nowhere", "byradius", "200", "km"}, nil, cmd.ErrGeoMember},
+		{"geosearch", []string{"geo", "byradius", "200", "km", "asc"}, nil, cmd.ErrGeoFrom},
+		{"geosearch", []string{"geo", "frommember", "Palermo", "fromlonlat", "0", "0", "byradius", "200", "km"}, nil, cmd.ErrGeoFrom},
+		{"geosearch", []string{"geo", "frommember", "Palermo", "asc", "withdist"}, nil, cmd.ErrGeoBy},
+		{"geosearch", []string{"geo", "frommember", "Palermo", "byradius", "-1", "km"}, nil, cmd.ErrRadiusNegative},
+		{"geosearch", []string{"geo", "frommember", "Palermo", "bybox", "1", "-1", "km"}, nil, cmd.ErrBoxNegative},
+		{"geosearch", []string{"geo", "frommember", "Palermo", "byradius", "1", "km", "any"}, nil, cmd.ErrAnyWithoutCount},
+		{"geosearch", []string{"geo", "frommember", "Palermo", "byradius", "1", "km", "count", "0"}, nil, cmd.ErrCountNotPositive},
+		{"geosearch", []string{"geo", "frommember", "Palermo", "byradius", "1", "km", "storedist"}, nil, cmd.ErrSyntax},
+		{"geosearchstore", []string{"geo2", "geo", "fromlonlat", "15", "37", "byradius", "200", "km", "storedist"}, int64(2), nil},
+		{"zrange", []string{"geo2", "0", "-1", "withscores"}, []string{"Catania", "56.4412578701582", "Palermo", "190.44242984775795"}, nil},
+		{"geosearchstore", []string{"geo2", "geo", "fromlonlat", "15", "37", "byradius", "100", "km"}, int64(1), nil},
+		{"zrange", []string{"geo2", "0", "-1", "withscores"}, []string{"Catania", "3479447370796909"}, nil},
+		{"geosearchstore", []string{"geo2", "geo", "fromlonlat", "0", "0", "byradius", "100", "km"}, int64(0), nil},
+		{"exists", []string{"geo2"}, false, nil},
+		{"geosearchstore", []string{"geo2", "geo", "fromlonlat", "15", "37", "byradius", "100", "km", "withdist"}, nil, cmd.ErrSyntax},
 	}
 
 	var got interface{}
@@ -322,6 +1013,348 @@ func TestCommand(t *testing.T) {
 	}
 }
 
+func TestXReadBlock(t *testing.T) {
+	db, _ := srv.DefaultServer.GetDB(1)
+	exec := func(name string, args ...string) (interface{}, error) {
+		cd := cmd.Commands[name]
+		args, ints, floats, err := cd.Parse(name, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cd.(cmd.DBCmd).ExecWithDB(db, args, ints, floats)
+	}
+
+	// Block until an entry is added
+	type result struct {
+		res interface{}
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		res, err := exec("xread", "block", "0", "streams", "bk", "bk2", "$", "$")
+		done <- result{res, err}
+	}()
+
+	// Give time to the reader to block
+	time.Sleep(50 * time.Millisecond)
+	if _, err := exec("xadd", "bk2", "1-1", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-done:
+		exp := []interface{}{[]interface{}{"bk2", []interface{}{[]interface{}{"1-1", []string{"a", "b"}}}}}
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if !reflect.DeepEqual(r.res, exp) {
+			t.Errorf("expected %v, got %v", exp, r.res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the blocked reader")
+	}
+}
+
+func TestListBlock(t *testing.T) {
+	db, _ := srv.DefaultServer.GetDB(4)
+	exec := func(name string, args ...string) (interface{}, error) {
+		cd := cmd.Commands[name]
+		args, ints, floats, err := cd.Parse(name, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cd.(cmd.DBCmd).ExecWithDB(db, args, ints, floats)
+	}
+
+	// Block two waiters on the same list, popping from both ends
+	type result struct {
+		res interface{}
+		err error
+	}
+	mpop, mv := make(chan result), make(chan result)
+	go func() {
+		res, err := exec("blmpop", "0", "2", "bl1", "bl2", "right", "count", "2")
+		mpop <- result{res, err}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		res, err := exec("blmove", "bl2", "bldst", "left", "right", "0")
+		mv <- result{res, err}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// A single push serves both waiters, in order
+	if res, err := exec("rpush", "bl2", "a", "b", "c"); err != nil || res != int64(3) {
+		t.Fatalf("expected 3, got %v %v", res, err)
+	}
+	for _, c := range []struct {
+		ch  chan result
+		exp interface{}
+	}{
+		{mpop, []interface{}{"bl2", []string{"c", "b"}}},
+		{mv, "a"},
+	} {
+		select {
+		case r := <-c.ch:
+			if r.err != nil {
+				t.Fatal(r.err)
+			}
+			if !reflect.DeepEqual(r.res, c.exp) {
+				t.Errorf("expected %v, got %v", c.exp, r.res)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the blocked waiter")
+		}
+	}
+
+	if res, _ := exec("exists", "bl2"); res != false {
+		t.Errorf("expected bl2 to be deleted")
+	}
+	if res, _ := exec("lrange", "bldst", "0", "-1"); !reflect.DeepEqual(res, []string{"a"}) {
+		t.Errorf("expected [a], got %v", res)
+	}
+
+	// The destination is replaced by a string while blocked, the value is
+	// pushed back on the source
+	go func() {
+		res, err := exec("blmove", "bl2", "bldst", "left", "right", "0")
+		mv <- result{res, err}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	exec("set", "bldst", "x")
+	exec("rpush", "bl2", "a", "b")
+	select {
+	case r := <-mv:
+		if r.err != cmd.ErrInvalidValType {
+			t.Errorf("expected error %v, got %v %v", cmd.ErrInvalidValType, r.res, r.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the blocked waiter")
+	}
+	if res, _ := exec("lrange", "bl2", "0", "-1"); !reflect.DeepEqual(res, []string{"a", "b"}) {
+		t.Errorf("expected [a b], got %v", res)
+	}
+	exec("del", "bl2", "bldst")
+}
+
+func TestReadyBlock(t *testing.T) {
+	db, _ := srv.DefaultServer.GetDB(8)
+	exec := func(name string, args ...string) (interface{}, error) {
+		cd := cmd.Commands[name]
+		args, ints, floats, err := cd.Parse(name, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cd.(cmd.DBCmd).ExecWithDB(db, args, ints, floats)
+	}
+
+	// Each command creates the key a client is blocked on, and serves it
+	l := types.NewList()
+	l.RPush("d")
+	dump := string(types.Dump(l))
+	type result struct {
+		res interface{}
+		err error
+	}
+	cases := []struct {
+		block []string
+		setup [][]string
+		write []string
+		exp   interface{}
+	}{
+		{[]string{"blpop", "rdst", "1"}, [][]string{{"rpush", "rsrc", "a"}}, []string{"rename", "rsrc", "rdst"}, []string{"rdst", "a"}},
+		{[]string{"blpop", "rdst", "1"}, [][]string{{"rpush", "rsrc", "b"}}, []string{"renamenx", "rsrc", "rdst"}, []string{"rdst", "b"}},
+		{[]string{"blpop", "rdst", "1"}, [][]string{{"rpush", "rsrc", "c"}}, []string{"copy", "rsrc", "rdst"}, []string{"rdst", "c"}},
+		{[]string{"blpop", "rdst", "1"}, [][]string{{"rpush", "rsrc", "2", "1"}}, []string{"sort", "rsrc", "store", "rdst"}, []string{"rdst", "1"}},
+		{[]string{"blpop", "rdst", "1"}, nil, []string{"restore", "rdst", "0", dump}, []string{"rdst", "d"}},
+		{[]string{"xread", "block", "1000", "streams", "rdst", "0"}, [][]string{{"xadd", "rsrc", "1-1", "f", "v"}}, []string{"rename", "rsrc", "rdst"},
+			[]interface{}{[]interface{}{"rdst", []interface{}{[]interface{}{"1-1", []string{"f", "v"}}}}}},
+	}
+	for i, c := range cases {
+		done := make(chan result)
+		go func() {
+			res, err := exec(c.block[0], c.block[1:]...)
+			done <- result{res, err}
+		}()
+		// Give time to the client to block
+		time.Sleep(50 * time.Millisecond)
+		for _, s := range c.setup {
+			if _, err := exec(s[0], s[1:]...); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := exec(c.write[0], c.write[1:]...); err != nil {
+			t.Fatal(err)
+		}
+		r := <-done
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if !reflect.DeepEqual(r.res, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, r.res)
+		}
+		exec("del", "rsrc", "rdst")
+	}
+
+	// MOVE and SWAPDB serve the clients blocked in the other database
+	db9, _ := srv.DefaultServer.GetDB(9)
+	for _, write := range [][]string{{"move", "rdst", "9"}, {"swapdb", "8", "9"}} {
+		done := make(chan result)
+		go func() {
+			cd := cmd.Commands["blpop"]
+			args, ints, floats, _ := cd.Parse("blpop", []string{"rdst", "1"})
+			res, err := cd.(cmd.DBCmd).ExecWithDB(db9, args, ints, floats)
+			done <- result{res, err}
+		}()
+		time.Sleep(50 * time.Millisecond)
+		if _, err := exec("rpush", "rdst", "a"); err != nil {
+			t.Fatal(err)
+		}
+		cd := cmd.Commands[write[0]]
+		args, ints, floats, err := cd.Parse(write[0], write[1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch cd := cd.(type) {
+		case cmd.DBCmd:
+			_, err = cd.ExecWithDB(db, args, ints, floats)
+		case cmd.SrvCmd:
+			_, err = cd.Exec(args, ints, floats)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := <-done
+		if exp := []string{"rdst", "a"}; r.err != nil || !reflect.DeepEqual(r.res, exp) {
+			t.Errorf("%s: expected %v, got %v %v", write[0], exp, r.res, r.err)
+		}
+		if res, _ := exec("exists", "rdst"); res != false {
+			t.Errorf("%s: expected rdst to be served from DB 9", write[0])
+		}
+	}
+}
+
+func TestRandomKey(t *testing.T) {
+	db, _ := srv.DefaultServer.GetDB(3)
+	exec := func(name string, args ...string) interface{} {
+		cd := cmd.Commands[name]
+		args, ints, floats, err := cd.Parse(name, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := cd.(cmd.DBCmd).ExecWithDB(db, args, ints, floats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	if res := exec("randomkey"); res != nil {
+		t.Errorf("expected nil, got %v", res)
+	}
+	exec("set", "a", "1")
+	exec("set", "b", "2")
+	seen := make(map[interface{}]bool)
+	for i := 0; i < 100; i++ {
+		seen[exec("randomkey")] = true
+	}
+	if len(seen) != 2 || !seen["a"] || !seen["b"] {
+		t.Errorf("expected keys a and b, got %v", seen)
+	}
+}
+
+func TestExpiredKeys(t *testing.T) {
+	db, _ := srv.DefaultServer.GetDB(7)
+	exec := func(name string, args ...string) interface{} {
+		cd := cmd.Commands[name]
+		args, ints, floats, err := cd.Parse(name, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := cd.(cmd.DBCmd).ExecWithDB(db, args, ints, floats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	// The expired keys are not deleted until accessed by a write or by the
+	// active expiration cycle, but they are never observable
+	exec("set", "a", "1")
+	exec("sadd", "b", "x")
+	exec("set", "c", "3")
+	exec("pexpireat", "a", "1")
+	exec("pexpireat", "b", "1")
+	cases := []struct {
+		cmd  string
+		args []string
+		exp  interface{}
+	}{
+		{"exists", []string{"a"}, false},
+		{"get", []string{"a"}, nil},
+		{"ttl", []string{"a"}, int64(-2)},
+		{"pttl", []string{"b"}, int64(-2)},
+		{"type", []string{"b"}, "none"},
+		{"persist", []string{"a"}, false},
+		{"expire", []string{"a", "100"}, false},
+		{"mget", []string{"a", "c"}, []interface{}{nil, "3"}},
+		{"keys", []string{"*"}, []string{"c"}},
+		{"scan", []string{"0"}, []interface{}{"0", []string{"c"}}},
+		{"randomkey", nil, "c"},
+		{"smembers", []string{"b"}, []string{}},
+		{"sunion", []string{"b"}, []string{}},
+		{"touch", []string{"a", "b", "c"}, int64(1)},
+		{"del", []string{"a", "c"}, int64(1)},
+		{"sadd", []string{"b", "y"}, int64(1)},
+		{"smembers", []string{"b"}, []string{"y"}},
+		{"ttl", []string{"b"}, int64(-1)},
+	}
+	for i, c := range cases {
+		if res := exec(c.cmd, c.args...); !reflect.DeepEqual(res, c.exp) {
+			t.Errorf("%d %s %v: expected %v, got %v", i, c.cmd, c.args, c.exp, res)
+		}
+	}
+	exec("del", "b")
+}
+
+func TestMemoryStats(t *testing.T) {
+	db, _ := srv.DefaultServer.GetDB(5)
+	exec := func(name string, args ...string) interface{} {
+		cd := cmd.Commands[name]
+		args, ints, floats, err := cd.Parse(name, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := cd.(cmd.DBCmd).ExecWithDB(db, args, ints, floats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	exec("set", "a", strings.Repeat("a", 1000))
+	exec("rpush", "b", "1", "2", "3")
+	exec("expire", "b", "100")
+	stats := make(map[string]interface{})
+	res := exec("memory", "stats").([]interface{})
+	for i := 0; i < len(res); i += 2 {
+		stats[res[i].(string)] = res[i+1]
+	}
+	for _, nm := range []string{"total.allocated", "keys.count", "dataset.bytes", "overhead.total"} {
+		if n, ok := stats[nm].(int64); !ok || n <= 0 {
+			t.Errorf("expected %s to be positive, got %v", nm, stats[nm])
+		}
+	}
+	if n := stats["dataset.bytes"].(int64); n < 1000 {
+		t.Errorf("expected dataset.bytes to be at least 1000, got %d", n)
+	}
+	exp := []interface{}{"overhead.hashtable.main", int64(2*srv.KeyOverhead + 2), "overhead.hashtable.expires", int64(srv.ExpireOverhead)}
+	if got := stats["db.5"]; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+	exec("del", "a", "b")
+}
+
 type mockConn struct {
 	ix int
 }
@@ -329,3 +1362,222 @@ type mockConn struct {
 func (mc *mockConn) Select(ix int) {
 	mc.ix = ix
 }
+
+func (mc *mockConn) DBIndex() int {
+	return mc.ix
+}
+
+func TestSwapDBConcurrent(t *testing.T) {
+	// Commands execute on their DB while SWAPDB swaps its keys, which must
+	// not race (run with -race)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if _, err := cmd.Exec(&mockConn{}, []string{"swapdb", "8", "9"}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	conn := &mockConn{ix: 8}
+	for i := 0; i < 100; i++ {
+		if _, err := cmd.Exec(conn, []string{"set", "a", "1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	cmd.Exec(conn, []string{"flushdb"})
+	cmd.Exec(&mockConn{ix: 9}, []string{"flushdb"})
+}
+
+func TestSave(t *testing.T) {
+	defer func(path string) { srv.SnapshotFile = path }(srv.SnapshotFile)
+	srv.SnapshotFile = filepath.Join(t.TempDir(), "dump.gdb")
+
+	db, _ := srv.DefaultServer.GetDB(6)
+	exec := func(name string, args ...string) (interface{}, error) {
+		cd := cmd.Commands[name]
+		args, ints, floats, err := cd.Parse(name, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch cd := cd.(type) {
+		case cmd.DBCmd:
+			return cd.ExecWithDB(db, args, ints, floats)
+		default:
+			return cd.(cmd.SrvCmd).Exec(args, ints, floats)
+		}
+	}
+
+	before := time.Now().Unix()
+	exec("set", "a", "1")
+	if res, err := exec("save"); res != cmd.OKVal || err != nil {
+		t.Fatalf("expected OK, got %v (%v)", res, err)
+	}
+	if res, _ := exec("lastsave"); res.(int64) < before {
+		t.Errorf("expected last save at or after %d, got %d", before, res)
+	}
+
+	exec("set", "a", "2")
+	if res, err := exec("bgsave"); res != cmd.BgSaveStartedVal || err != nil {
+		t.Fatalf("expected %v, got %v (%v)", cmd.BgSaveStartedVal, res, err)
+	}
+	// SAVE fails until the background save completes
+	var err error
+	for i := 0; i < 100; i++ {
+		if _, err = exec("save"); err != cmd.ErrBgSaveInProgress {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(srv.SnapshotFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := srv.NewServer(srv.DefaultServer.Databases())
+	s.Lock()
+	err = s.Load(f)
+	s.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ldb, _ := s.GetDB(6)
+	if v := ldb.Keys()["a"].Val().(types.String).Get(); v != "2" {
+		t.Errorf("expected %q, got %q", "2", v)
+	}
+
+	srv.SnapshotFile = filepath.Join(srv.SnapshotFile, "dump.gdb")
+	if _, err := exec("save"); err != cmd.ErrSaveFailed {
+		t.Errorf("expected error %v, got %v", cmd.ErrSaveFailed, err)
+	}
+	exec("del", "a")
+}
+
+func TestDirty(t *testing.T) {
+	defer func(s srv.Server) { srv.DefaultServer = s }(srv.DefaultServer)
+	srv.DefaultServer = srv.NewServer(2)
+	db, _ := srv.DefaultServer.GetDB(0)
+	exec := func(name string, args ...string) {
+		cd := cmd.Commands[name]
+		args, ints, floats, err := cd.Parse(name, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch cd := cd.(type) {
+		case cmd.DBCmd:
+			_, err = cd.ExecWithDB(db, args, ints, floats)
+		case cmd.SrvCmd:
+			_, err = cd.Exec(args, ints, floats)
+		case cmd.ConnCmd:
+			t.Fatalf("unexpected command type %T", cd)
+		}
+		if err != nil && err != cmd.ErrInvalidValType {
+			t.Fatal(err)
+		}
+	}
+
+	// The changes are counted by key, not by command
+	cases := []struct {
+		cmd   string
+		args  []string
+		dirty int64
+	}{
+		{"mset", []string{"a", "1", "b", "2", "c", "3"}, 3},
+		{"del", []string{"missing"}, 0},
+		{"setnx", []string{"a", "2"}, 0},
+		{"setnx", []string{"d", "4"}, 1},
+		{"msetnx", []string{"a", "1", "e", "5"}, 0},
+		{"incr", []string{"a"}, 1},
+		{"lpush", []string{"a", "x"}, 0},
+		{"sadd", []string{"s", "x", "y"}, 1},
+		{"sadd", []string{"s", "x"}, 0},
+		{"srem", []string{"s", "z"}, 0},
+		{"expire", []string{"missing", "10"}, 0},
+		{"persist", []string{"a"}, 0},
+		{"rename", []string{"s", "t"}, 2},
+		{"lpop", []string{"missing"}, 0},
+		{"del", []string{"a", "b", "missing"}, 2},
+		{"flushall", nil, 3},
+	}
+	for i, c := range cases {
+		before := srv.DefaultServer.Dirty()
+		exec(c.cmd, c.args...)
+		if n := srv.DefaultServer.Dirty() - before; n != c.dirty {
+			t.Errorf("%d %s %v: expected %d changes, got %d", i, c.cmd, c.args, c.dirty, n)
+		}
+	}
+}
+
+func TestDebugTime(t *testing.T) {
+	if _, err := cmd.Commands["debug"].(cmd.SrvCmd).Exec([]string{"advance", "1"}, []int64{1}, nil); err != cmd.ErrDebugTimeDisabled {
+		t.Fatalf("expected error %v, got %v", cmd.ErrDebugTimeDisabled, err)
+	}
+
+	defer func(s srv.Server) { srv.DefaultServer = s }(srv.DefaultServer)
+	srv.DefaultServer = srv.NewServerWithClock(1, srv.NewFakeClock(time.Unix(1000, 0)))
+	db, _ := srv.DefaultServer.GetDB(0)
+	exec := func(name string, args ...string) (interface{}, error) {
+		cd := cmd.Commands[name]
+		args, ints, floats, err := cd.Parse(name, args)
+		if err != nil {
+			return nil, err
+		}
+		switch cd := cd.(type) {
+		case cmd.DBCmd:
+			return cd.ExecWithDB(db, args, ints, floats)
+		case cmd.SrvCmd:
+			return cd.Exec(args, ints, floats)
+		}
+		t.Fatalf("unexpected command type %T", cd)
+		return nil, nil
+	}
+
+	exec("set", "a", "1", "px", "100")
+	exec("set", "b", "1", "exat", "1010")
+	cases := []struct {
+		cmd  string
+		args []string
+		exp  interface{}
+		err  error
+		keys int
+	}{
+		{"pttl", []string{"a"}, int64(100), nil, 2},
+		{"debug", []string{"advance", "99"}, cmd.OKVal, nil, 2},
+		{"pttl", []string{"a"}, int64(1), nil, 2},
+		// The expired keys are deleted by DEBUG, not only hidden
+		{"debug", []string{"advance", "1"}, cmd.OKVal, nil, 1},
+		{"debug", []string{"advance", "-1"}, nil, cmd.ErrClockBackwards, 1},
+		{"debug", []string{"set-time", "1000000"}, nil, cmd.ErrClockBackwards, 1},
+		{"debug", []string{"set-time", "1010000"}, cmd.OKVal, nil, 0},
+		{"time", nil, []string{"1010", "0"}, nil, 0},
+		// The stream IDs, the pending entries and the access times of the
+		// keys follow the same clock
+		{"xadd", []string{"s", "*", "f", "v"}, "1010000-0", nil, 1},
+		{"xgroup", []string{"create", "s", "g", "0"}, cmd.OKVal, nil, 1},
+		{"xreadgroup", []string{"group", "g", "c", "streams", "s", ">"}, []interface{}{[]interface{}{"s", []interface{}{[]interface{}{"1010000-0", []string{"f", "v"}}}}}, nil, 1},
+		{"debug", []string{"advance", "2500"}, cmd.OKVal, nil, 1},
+		{"object", []string{"idletime", "s"}, int64(2), nil, 1},
+		{"xpending", []string{"s", "g", "-", "+", "10"}, []interface{}{[]interface{}{"1010000-0", "c", int64(2500), int64(1)}}, nil, 1},
+		{"debug", []string{"advance", "x"}, nil, cmd.ErrNotInteger, 1},
+		{"debug", []string{"object", "1"}, nil, cmd.ErrUnknownSubcommand, 1},
+	}
+	for i, c := range cases {
+		res, err := exec(c.cmd, c.args...)
+		if err != c.err {
+			t.Errorf("%d %s %v: expected error %v, got %v", i, c.cmd, c.args, c.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(res, c.exp) {
+			t.Errorf("%d %s %v: expected %v, got %v", i, c.cmd, c.args, c.exp, res)
+		}
+		if n := len(db.Keys()); n != c.keys {
+			t.Errorf("%d %s %v: expected %d keys, got %d", i, c.cmd, c.args, c.keys, n)
+		}
+	}
+}
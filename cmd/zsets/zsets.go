@@ -0,0 +1,853 @@
+package zsets
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+func init() {
+	cmd.RegisterWrite("zadd", zadd)
+	cmd.Register("zcard", zcard)
+	cmd.Register("zcount", zcount)
+	cmd.RegisterWrite("zincrby", zincrby)
+	cmd.RegisterWrite("zinterstore", zinterstore)
+	cmd.Register("zlexcount", zlexcount)
+	cmd.Register("zrange", zrange)
+	cmd.Register("zrangebylex", zrangebylex)
+	cmd.Register("zrangebyscore", zrangebyscore)
+	cmd.Register("zrank", zrank)
+	cmd.RegisterWrite("zrem", zrem)
+	cmd.RegisterWrite("zremrangebylex", zremrangebylex)
+	cmd.RegisterWrite("zremrangebyrank", zremrangebyrank)
+	cmd.RegisterWrite("zremrangebyscore", zremrangebyscore)
+	cmd.Register("zrevrange", zrevrange)
+	cmd.Register("zrevrangebylex", zrevrangebylex)
+	cmd.Register("zrevrangebyscore", zrevrangebyscore)
+	cmd.Register("zrevrank", zrevrank)
+	cmd.Register("zscan", zscan)
+	cmd.Register("zscore", zscore)
+	cmd.RegisterWrite("zunionstore", zunionstore)
+}
+
+// zaddFlags holds the options of a ZADD command.
+type zaddFlags struct {
+	nx, xx, gt, lt, ch, incr bool
+}
+
+var zadd = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: -1,
+	},
+	zaddFn)
+
+func zaddFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// Parse the options
+	var fl zaddFlags
+	i := 1
+loop:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nx":
+			fl.nx = true
+		case "xx":
+			fl.xx = true
+		case "gt":
+			fl.gt = true
+		case "lt":
+			fl.lt = true
+		case "ch":
+			fl.ch = true
+		case "incr":
+			fl.incr = true
+		default:
+			break loop
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return nil, cmd.ErrSyntax
+	}
+	if fl.nx && fl.xx {
+		return nil, cmd.ErrXXAndNX
+	}
+	if (fl.gt && fl.lt) || (fl.nx && (fl.gt || fl.lt)) {
+		return nil, cmd.ErrGTLTAndNX
+	}
+	if fl.incr && len(pairs) > 2 {
+		return nil, cmd.ErrIncrPair
+	}
+
+	// Parse all scores before touching the sorted set
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		f, err := parseScore(pairs[2*j])
+		if err != nil {
+			return nil, err
+		}
+		scores[j] = f
+	}
+
+	// Only create the key if values may be added
+	flag := srv.NoKeyCreateSortedSet
+	if fl.xx {
+		flag = srv.NoKeyNone
+	}
+	k, unl := db.LockGetKey(args[0], flag)
+	defer unl()
+
+	if k == nil {
+		if fl.incr {
+			return nil, nil
+		}
+		return int64(0), nil
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	v, ok := k.Val().(types.SortedSet)
+	if !ok {
+		return nil, cmd.ErrInvalidValType
+	}
+
+	var added, changed int64
+	for j, score := range scores {
+		member := pairs[2*j+1]
+		cur, exists := v.ZScore(member)
+		if exists {
+			if fl.nx {
+				continue
+			}
+			if fl.incr {
+				score += cur
+				if math.IsNaN(score) {
+					return nil, cmd.ErrNaNScore
+				}
+			}
+			if (fl.gt && score <= cur) || (fl.lt && score >= cur) {
+				continue
+			}
+			if score != cur {
+				v.ZAdd(score, member)
+				changed++
+			}
+		} else {
+			if fl.xx {
+				continue
+			}
+			v.ZAdd(score, member)
+			added++
+		}
+		if fl.incr {
+			if added+changed > 0 {
+				cmd.Dirty(1)
+			}
+			return cmd.FormatFloat(score), nil
+		}
+	}
+
+	if added+changed > 0 {
+		cmd.Dirty(1)
+	}
+	if fl.incr {
+		// The increment was not applied
+		return nil, nil
+	}
+	if fl.ch {
+		return added + changed, nil
+	}
+	return added, nil
+}
+
+var zcard = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 1,
+		MaxArgs: 1,
+	},
+	srv.NoKeyDefaultVal,
+	zcardFn)
+
+func zcardFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		return v.ZCard(), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var zcount = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 3,
+	},
+	srv.NoKeyDefaultVal,
+	zcountFn)
+
+func zcountFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	r, err := parseScoreRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		return v.ZCount(r), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var zincrby = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs:      3,
+		MaxArgs:      3,
+		FloatIndices: []int{1},
+		ValidateFn: func(args []string, ints []int64, floats []float64) error {
+			if math.IsNaN(floats[0]) {
+				return cmd.ErrNotFloat
+			}
+			return nil
+		},
+	},
+	srv.NoKeyCreateSortedSet,
+	zincrbyFn)
+
+func zincrbyFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		val, ok := v.ZIncrBy(floats[0], args[2])
+		if ok {
+			cmd.Dirty(1)
+			return cmd.FormatFloat(val), nil
+		}
+		return nil, cmd.ErrNaNScore
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var zinterstore = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: -1,
+	},
+	zinterstoreFn)
+
+func zinterstoreFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return storeFn(db, args, true)
+}
+
+var zlexcount = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 3,
+	},
+	srv.NoKeyDefaultVal,
+	zlexcountFn)
+
+func zlexcountFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	r, err := parseLexRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		return v.ZLexCount(r), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+// rangeArgDef is the argument definition of the ZRANGE and ZREVRANGE commands.
+var rangeArgDef = &cmd.ArgDef{
+	MinArgs:    3,
+	MaxArgs:    4,
+	IntIndices: []int{1, 2},
+	ValidateFn: func(args []string, ints []int64, floats []float64) error {
+		if len(args) == 4 {
+			if strings.ToLower(args[3]) != "withscores" {
+				return cmd.ErrSyntax
+			}
+		}
+		return nil
+	},
+}
+
+var zrange = cmd.NewSingleKeyCmd(
+	rangeArgDef,
+	srv.NoKeyDefaultVal,
+	zrangeFn)
+
+func zrangeFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return rangeFn(k, ints[0], ints[1], false, len(args) == 4)
+}
+
+var zrangebylex = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 6,
+	},
+	srv.NoKeyDefaultVal,
+	zrangebylexFn)
+
+func zrangebylexFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return rangeByLexFn(k, args[1], args[2], false, args[3:])
+}
+
+var zrangebyscore = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 7,
+	},
+	srv.NoKeyDefaultVal,
+	zrangebyscoreFn)
+
+func zrangebyscoreFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return rangeByScoreFn(k, args[1], args[2], false, args[3:])
+}
+
+var zrank = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 2,
+	},
+	srv.NoKeyDefaultVal,
+	zrankFn)
+
+func zrankFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return rankFn(k, args[1], false)
+}
+
+var zrem = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: -1,
+	},
+	zremFn)
+
+func zremFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return remFn(db, args[0], func(v types.SortedSet) int64 {
+		return v.ZRem(args[1:]...)
+	})
+}
+
+var zremrangebylex = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 3,
+	},
+	zremrangebylexFn)
+
+func zremrangebylexFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	r, err := parseLexRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	return remFn(db, args[0], func(v types.SortedSet) int64 {
+		return v.ZRemRangeByLex(r)
+	})
+}
+
+var zremrangebyrank = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs:    3,
+		MaxArgs:    3,
+		IntIndices: []int{1, 2},
+	},
+	zremrangebyrankFn)
+
+func zremrangebyrankFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return remFn(db, args[0], func(v types.SortedSet) int64 {
+		return v.ZRemRangeByRank(ints[0], ints[1])
+	})
+}
+
+var zremrangebyscore = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 3,
+	},
+	zremrangebyscoreFn)
+
+func zremrangebyscoreFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	r, err := parseScoreRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	return remFn(db, args[0], func(v types.SortedSet) int64 {
+		return v.ZRemRangeByScore(r)
+	})
+}
+
+var zrevrange = cmd.NewSingleKeyCmd(
+	rangeArgDef,
+	srv.NoKeyDefaultVal,
+	zrevrangeFn)
+
+func zrevrangeFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return rangeFn(k, ints[0], ints[1], true, len(args) == 4)
+}
+
+var zrevrangebylex = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 6,
+	},
+	srv.NoKeyDefaultVal,
+	zrevrangebylexFn)
+
+func zrevrangebylexFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// Max comes first in the reverse command
+	return rangeByLexFn(k, args[2], args[1], true, args[3:])
+}
+
+var zrevrangebyscore = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: 7,
+	},
+	srv.NoKeyDefaultVal,
+	zrevrangebyscoreFn)
+
+func zrevrangebyscoreFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	// Max comes first in the reverse command
+	return rangeByScoreFn(k, args[2], args[1], true, args[3:])
+}
+
+var zrevrank = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 2,
+	},
+	srv.NoKeyDefaultVal,
+	zrevrankFn)
+
+func zrevrankFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return rankFn(k, args[1], true)
+}
+
+var zscan = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: -1,
+	},
+	srv.NoKeyDefaultVal,
+	zscanFn)
+
+func zscanFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	opts, err := cmd.ParseScanOpts("zscan", args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		cur, sms := v.ZScan(opts.Cursor, opts.Count, opts.Match)
+		vals := make([]string, 0, 2*len(sms))
+		for _, sm := range sms {
+			vals = append(vals, sm.Member, cmd.FormatFloat(sm.Score))
+		}
+		return []interface{}{strconv.FormatUint(cur, 10), vals}, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var zscore = cmd.NewSingleKeyCmd(
+	&cmd.ArgDef{
+		MinArgs: 2,
+		MaxArgs: 2,
+	},
+	srv.NoKeyDefaultVal,
+	zscoreFn)
+
+func zscoreFn(k srv.Key, args []string, ints []int64, floats []float64) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		score, ok := v.ZScore(args[1])
+		if ok {
+			return cmd.FormatFloat(score), nil
+		}
+		return nil, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+var zunionstore = cmd.NewDBCmd(
+	&cmd.ArgDef{
+		MinArgs: 3,
+		MaxArgs: -1,
+	},
+	zunionstoreFn)
+
+func zunionstoreFn(db srv.DB, args []string, ints []int64, floats []float64) (interface{}, error) {
+	return storeFn(db, args, false)
+}
+
+// rangeFn implements the ZRANGE and ZREVRANGE commands.
+func rangeFn(k srv.Key, start, stop int64, rev, withScores bool) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		return reply(v.ZRange(start, stop, rev), withScores), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+// rangeByLexFn implements the ZRANGEBYLEX and ZREVRANGEBYLEX commands.
+func rangeByLexFn(k srv.Key, min, max string, rev bool, opts []string) (interface{}, error) {
+	r, err := parseLexRange(min, max)
+	if err != nil {
+		return nil, err
+	}
+	offset, count, withScores, err := parseRangeOpts(opts)
+	if err != nil {
+		return nil, err
+	}
+	if withScores {
+		return nil, cmd.ErrSyntax
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		return reply(v.ZRangeByLex(r, rev, offset, count), false), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+// rangeByScoreFn implements the ZRANGEBYSCORE and ZREVRANGEBYSCORE commands.
+func rangeByScoreFn(k srv.Key, min, max string, rev bool, opts []string) (interface{}, error) {
+	r, err := parseScoreRange(min, max)
+	if err != nil {
+		return nil, err
+	}
+	offset, count, withScores, err := parseRangeOpts(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		return reply(v.ZRangeByScore(r, rev, offset, count), withScores), nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+// rankFn implements the ZRANK and ZREVRANK commands.
+func rankFn(k srv.Key, member string, rev bool) (interface{}, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		rank, ok := v.ZRank(member, rev)
+		if ok {
+			return rank, nil
+		}
+		return nil, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+// remFn implements the commands that remove members from a sorted set,
+// deleting the key if the sorted set is left empty.
+func remFn(db srv.DB, name string, fn func(types.SortedSet) int64) (interface{}, error) {
+	// Since the command may delete the key (if the sorted set is empty), must get
+	// an exclusive DB lock right away.
+	k, unl := db.XLockGetKey(name, srv.NoKeyDefaultVal)
+	defer unl()
+
+	k.Lock()
+	defer k.Unlock()
+
+	v := k.Val()
+	if v, ok := v.(types.SortedSet); ok {
+		ret := fn(v)
+		if ret > 0 {
+			if v.ZCard() == 0 {
+				db.DelKey(name)
+			}
+			cmd.Dirty(1)
+		}
+		return ret, nil
+	}
+	return nil, cmd.ErrInvalidValType
+}
+
+// storeFn implements the ZUNIONSTORE and ZINTERSTORE commands.
+func storeFn(db srv.DB, args []string, inter bool) (interface{}, error) {
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, cmd.ErrNotInteger
+	}
+	if n < 1 {
+		return nil, cmd.ErrNoStoreInput
+	}
+	if n > int64(len(args)-2) {
+		return nil, cmd.ErrSyntax
+	}
+	srcs := args[2 : 2+n]
+
+	// Parse the options
+	weights := make([]float64, n)
+	for i := range weights {
+		weights[i] = 1
+	}
+	agg := "sum"
+	for opts := args[2+n:]; len(opts) > 0; {
+		switch strings.ToLower(opts[0]) {
+		case "weights":
+			if int64(len(opts)-1) < n {
+				return nil, cmd.ErrSyntax
+			}
+			for i := range weights {
+				w, err := parseScore(opts[i+1])
+				if err != nil {
+					return nil, cmd.ErrWeightNotFloat
+				}
+				weights[i] = w
+			}
+			opts = opts[n+1:]
+		case "aggregate":
+			if len(opts) < 2 {
+				return nil, cmd.ErrSyntax
+			}
+			agg = strings.ToLower(opts[1])
+			if agg != "sum" && agg != "min" && agg != "max" {
+				return nil, cmd.ErrSyntax
+			}
+			opts = opts[2:]
+		default:
+			return nil, cmd.ErrSyntax
+		}
+	}
+
+	// In every case, the destination is replaced, so must have an exclusive lock
+	db.Lock()
+	defer db.Unlock()
+
+	// Read-lock the source keys, they are unlocked once the result is computed
+	// so that the destination can be locked even if it is also a source.
+	keys := db.Keys()
+	unl := db.LockKeys(false, srcs...)
+	inputs := make([][]types.ScoreMember, len(srcs))
+	for i, nm := range srcs {
+		k, ok := db.GetKey(nm)
+		if !ok {
+			continue
+		}
+
+		switch v := k.Val().(type) {
+		case types.SortedSet:
+			inputs[i] = v.ZRange(0, -1, false)
+		case types.Set:
+			mbrs := v.SMembers()
+			inputs[i] = make([]types.ScoreMember, len(mbrs))
+			for j, m := range mbrs {
+				inputs[i][j] = types.ScoreMember{Score: 1, Member: m}
+			}
+		default:
+			unl()
+			return nil, cmd.ErrInvalidValType
+		}
+	}
+	unl()
+
+	// Compute the result
+	res := make(map[string]float64)
+	seen := make(map[string]int)
+	for i, in := range inputs {
+		for _, sm := range in {
+			score := weights[i] * sm.Score
+			if math.IsNaN(score) {
+				score = 0
+			}
+			if cur, ok := res[sm.Member]; ok {
+				res[sm.Member] = aggregate(agg, cur, score)
+			} else {
+				res[sm.Member] = score
+			}
+			seen[sm.Member]++
+		}
+	}
+
+	zs := types.NewSortedSet()
+	for m, score := range res {
+		if inter && seen[m] < len(srcs) {
+			continue
+		}
+		zs.ZAdd(score, m)
+	}
+
+	// Replace the destination key
+	dst, exists := keys[args[0]]
+	if exists {
+		dst.Lock()
+		db.DelKey(args[0])
+		dst.Unlock()
+	}
+	if zs.ZCard() > 0 {
+		db.SetKey(args[0], zs, -1)
+	}
+	if exists || zs.ZCard() > 0 {
+		cmd.Dirty(1)
+	}
+	return zs.ZCard(), nil
+}
+
+// aggregate combines the scores cur and score according to the aggregate
+// function agg.
+func aggregate(agg string, cur, score float64) float64 {
+	switch agg {
+	case "min":
+		return math.Min(cur, score)
+	case "max":
+		return math.Max(cur, score)
+	}
+	sum := cur + score
+	if math.IsNaN(sum) {
+		// inf + -inf is defined as 0
+		return 0
+	}
+	return sum
+}
+
+// reply converts the list of members to the array returned to the client.
+func reply(sms []types.ScoreMember, withScores bool) []string {
+	n := len(sms)
+	if withScores {
+		n *= 2
+	}
+	ret := make([]string, 0, n)
+	for _, sm := range sms {
+		ret = append(ret, sm.Member)
+		if withScores {
+			ret = append(ret, cmd.FormatFloat(sm.Score))
+		}
+	}
+	return ret
+}
+
+// parseScore parses the score s as a float value, rejecting NaN.
+func parseScore(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, cmd.ErrNotFloat
+	}
+	return f, nil
+}
+
+// parseScoreRange parses the min and max score bounds. A bound prefixed
+// with "(" is exclusive.
+func parseScoreRange(min, max string) (types.ScoreRange, error) {
+	var r types.ScoreRange
+	var err error
+
+	r.Min, r.MinExclusive, err = parseScoreBound(min)
+	if err != nil {
+		return r, err
+	}
+	r.Max, r.MaxExclusive, err = parseScoreBound(max)
+	return r, err
+}
+
+// parseScoreBound parses a single score bound.
+func parseScoreBound(s string) (float64, bool, error) {
+	var ex bool
+	if strings.HasPrefix(s, "(") {
+		ex = true
+		s = s[1:]
+	}
+	f, err := parseScore(s)
+	if err != nil {
+		return 0, false, cmd.ErrMinMaxNotFloat
+	}
+	return f, ex, nil
+}
+
+// parseLexRange parses the min and max lexicographical bounds.
+func parseLexRange(min, max string) (types.LexRange, error) {
+	var r types.LexRange
+	var err error
+
+	r.Min, err = parseLexBound(min)
+	if err != nil {
+		return r, err
+	}
+	r.Max, err = parseLexBound(max)
+	return r, err
+}
+
+// parseLexBound parses a single lexicographical bound, which must be
+// "-", "+", or a value prefixed with "[" (inclusive) or "(" (exclusive).
+func parseLexBound(s string) (types.LexBound, error) {
+	switch {
+	case s == "-":
+		return types.LexBound{Inf: -1}, nil
+	case s == "+":
+		return types.LexBound{Inf: 1}, nil
+	case strings.HasPrefix(s, "["):
+		return types.LexBound{Value: s[1:]}, nil
+	case strings.HasPrefix(s, "("):
+		return types.LexBound{Value: s[1:], Exclusive: true}, nil
+	}
+	return types.LexBound{}, cmd.ErrMinMaxNotLex
+}
+
+// parseRangeOpts parses the WITHSCORES and LIMIT offset count options
+// of the range commands. If there is no LIMIT, count is -1.
+func parseRangeOpts(opts []string) (int64, int64, bool, error) {
+	var offset int64
+	var withScores bool
+
+	count := int64(-1)
+	for i := 0; i < len(opts); i++ {
+		switch strings.ToLower(opts[i]) {
+		case "withscores":
+			withScores = true
+		case "limit":
+			if i+2 >= len(opts) {
+				return 0, 0, false, cmd.ErrSyntax
+			}
+			var err1, err2 error
+			offset, err1 = strconv.ParseInt(opts[i+1], 10, 64)
+			count, err2 = strconv.ParseInt(opts[i+2], 10, 64)
+			if err1 != nil || err2 != nil {
+				return 0, 0, false, cmd.ErrNotInteger
+			}
+			i += 2
+		default:
+			return 0, 0, false, cmd.ErrSyntax
+		}
+	}
+	return offset, count, withScores, nil
+}
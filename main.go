@@ -4,16 +4,26 @@ import (
 	"flag"
 	"log"
 	"net"
+	"os"
+	"time"
 
+	"github.com/PuerkitoBio/gred/aof"
 	"github.com/PuerkitoBio/gred/cmd"
 	_ "github.com/PuerkitoBio/gred/cmd/connection"
+	_ "github.com/PuerkitoBio/gred/cmd/geo"
 	_ "github.com/PuerkitoBio/gred/cmd/hashes"
+	_ "github.com/PuerkitoBio/gred/cmd/hyperloglog"
 	_ "github.com/PuerkitoBio/gred/cmd/keys"
 	_ "github.com/PuerkitoBio/gred/cmd/lists"
 	_ "github.com/PuerkitoBio/gred/cmd/server"
 	_ "github.com/PuerkitoBio/gred/cmd/sets"
+	_ "github.com/PuerkitoBio/gred/cmd/streams"
 	_ "github.com/PuerkitoBio/gred/cmd/strings"
+	_ "github.com/PuerkitoBio/gred/cmd/zsets"
 	gnet "github.com/PuerkitoBio/gred/net"
+	"github.com/PuerkitoBio/gred/rdb"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
 	"github.com/golang/glog"
 )
 
@@ -26,16 +36,84 @@ const (
 )
 
 var (
-	addr  = flag.String("addr", ":6379", "network address to listen to")
-	iface = flag.String("net", "tcp", "network interface to use")
+	addr      = flag.String("addr", ":6379", "network address to listen to")
+	iface     = flag.String("net", "tcp", "network interface to use")
+	databases = flag.Int("databases", srv.DefaultDatabases, "number of databases")
+	save      = flag.String("save", "3600 1 300 100 60 10000", "snapshot save rules, as pairs of seconds and changes, empty to disable")
+
+	appendonly       = flag.Bool("appendonly", false, "log the write commands to the append-only file, loaded at startup instead of the snapshot")
+	appendfilename   = flag.String("appendfilename", "appendonly.aof", "path of the append-only file")
+	appendfsync      = flag.String("appendfsync", "everysec", "when to flush the append-only file to disk: always, everysec or no")
+	aofLoadTruncated = flag.Bool("aof-load-truncated", true, "truncate the append-only file if its last command is truncated, instead of failing to start")
+
+	importRDB = flag.String("import-rdb", "", "path of an RDB file saved by Redis, loaded at startup instead of the snapshot and the append-only file")
+
+	debugTime = flag.Bool("debug-time", false, "use a fake clock, moved forward only by DEBUG SET-TIME and DEBUG ADVANCE, for tests")
 )
 
+func init() {
+	flag.StringVar(&srv.SnapshotFile, "dbfilename", srv.SnapshotFile, "path of the snapshot file, loaded at startup if it exists")
+	flag.IntVar(&types.HashMaxListpackEntries, "hash-max-listpack-entries", types.HashMaxListpackEntries, "maximum number of fields of a hash in the listpack encoding")
+	flag.IntVar(&types.HashMaxListpackValue, "hash-max-listpack-value", types.HashMaxListpackValue, "maximum length of a field or value of a hash in the listpack encoding")
+	flag.IntVar(&types.SetMaxIntsetEntries, "set-max-intset-entries", types.SetMaxIntsetEntries, "maximum number of members of a set in the intset encoding")
+	flag.IntVar(&types.SetMaxListpackEntries, "set-max-listpack-entries", types.SetMaxListpackEntries, "maximum number of members of a set in the listpack encoding")
+	flag.IntVar(&types.SetMaxListpackValue, "set-max-listpack-value", types.SetMaxListpackValue, "maximum length of a member of a set in the listpack encoding")
+	flag.IntVar(&types.ListMaxListpackSize, "list-max-listpack-size", types.ListMaxListpackSize, "maximum size of a list in the listpack encoding, in values if positive, or from -1 (4KB) to -5 (64KB)")
+}
+
 func main() {
 	//defer profile.Start(profile.CPUProfile).Stop()
 
 	flag.Parse()
 	defer glog.Flush()
 
+	if *databases < 1 {
+		log.Fatalf("invalid number of databases: %d", *databases)
+	}
+	if *debugTime {
+		srv.DefaultServer = srv.NewServerWithClock(*databases, srv.NewFakeClock(time.Now()))
+	} else {
+		srv.DefaultServer = srv.NewServer(*databases)
+	}
+	rules, err := srv.ParseSaveRules(*save)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch {
+	case *importRDB != "":
+		if err := loadRDB(*importRDB); err != nil {
+			log.Fatalf("import RDB file: %s", err)
+		}
+	case *appendonly:
+		if err := aof.Load(*appendfilename, *aofLoadTruncated); err != nil {
+			log.Fatalf("load append-only file: %s", err)
+		}
+	default:
+		if err := loadSnapshot(srv.SnapshotFile); err != nil {
+			log.Fatalf("load snapshot: %s", err)
+		}
+	}
+	if *appendonly {
+		policy, err := aof.ParseFsyncPolicy(*appendfsync)
+		if err != nil {
+			log.Fatal(err)
+		}
+		f, err := aof.Open(*appendfilename, policy)
+		if err != nil {
+			log.Fatalf("open append-only file: %s", err)
+		}
+		defer f.Close()
+		aof.Default = f
+		cmd.SetPropagator(f)
+
+		// The imported keys replace those of the append-only file
+		if *importRDB != "" {
+			f.Rewrite()
+		}
+	}
+	go srv.DefaultServer.AutoSave(srv.SnapshotFile, rules, nil)
+	go srv.DefaultServer.ActiveExpire(nil)
+
 	// Print registered commands
 	if glog.V(2) {
 		for k := range cmd.Commands {
@@ -76,3 +154,43 @@ func main() {
 		}(conn)
 	}
 }
+
+// loadSnapshot loads the snapshot file at path in the databases of the
+// server, if it exists.
+func loadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	srv.DefaultServer.Lock()
+	defer srv.DefaultServer.Unlock()
+	if err := srv.DefaultServer.Load(f); err != nil {
+		return err
+	}
+	glog.V(1).Infof("loaded snapshot %s", path)
+	return nil
+}
+
+// loadRDB loads the RDB file saved by Redis at path in the databases of the
+// server.
+func loadRDB(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	srv.DefaultServer.Lock()
+	defer srv.DefaultServer.Unlock()
+	n, err := rdb.Load(f)
+	if err != nil {
+		return err
+	}
+	glog.V(1).Infof("imported %d keys from RDB file %s", n, path)
+	return nil
+}
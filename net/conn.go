@@ -3,10 +3,8 @@ package net
 import (
 	"bufio"
 	"errors"
-	"fmt"
 	"io"
 	"net"
-	"strings"
 
 	"github.com/PuerkitoBio/gred/cmd"
 	"github.com/PuerkitoBio/gred/resp"
@@ -44,6 +42,11 @@ func (c *netConn) Select(ix int) {
 	c.dbix = ix
 }
 
+// DBIndex returns the connection's DB index.
+func (c *netConn) DBIndex() int {
+	return c.dbix
+}
+
 // Handle handles a connection to the server, and processes its requests.
 func (c *netConn) Handle() error {
 	defer c.Close()
@@ -71,32 +74,7 @@ func (c *netConn) Handle() error {
 		}
 
 		// Run the command
-		var res interface{}
-		var rerr error
-		if cd, ok := cmd.Commands[strings.ToLower(ar[0])]; ok {
-			args, ints, floats, err := cd.Parse(ar[0], ar[1:])
-			if err != nil {
-				rerr = err
-			} else {
-				switch cd := cd.(type) {
-				case cmd.DBCmd:
-					// Get the connection's current database
-					db, ok := srv.DefaultServer.GetDB(c.dbix)
-					if !ok {
-						panic(fmt.Sprintf("invalid database index: %d", c.dbix))
-					}
-					res, rerr = cd.ExecWithDB(db, args, ints, floats)
-				case cmd.SrvCmd:
-					res, rerr = cd.Exec(args, ints, floats)
-				case cmd.ConnCmd:
-					res, rerr = cd.ExecWithConn(c, args, ints, floats)
-				default:
-					panic(fmt.Sprintf("unsupported command type: %T", cd))
-				}
-			}
-		} else {
-			rerr = fmt.Errorf("ERR unknown command '%s'", ar[0])
-		}
+		res, rerr := cmd.Exec(c, ar)
 		err = c.writeResponse(res, rerr)
 		if err != nil {
 			return err
@@ -0,0 +1,248 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// lzf decompresses the LZF-compressed bytes p, whose uncompressed length
+// is n.
+func lzf(p []byte, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for i := 0; i < len(p); {
+		ctrl := int(p[i])
+		i++
+
+		// A literal run of ctrl+1 bytes
+		if ctrl < 1<<5 {
+			if i+ctrl+1 > len(p) || len(out)+ctrl+1 > n {
+				return nil, ErrFormat
+			}
+			out = append(out, p[i:i+ctrl+1]...)
+			i += ctrl + 1
+			continue
+		}
+
+		// A back reference of length+2 bytes, at offset+1 bytes before the
+		// end of the output
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(p) {
+				return nil, ErrFormat
+			}
+			length += int(p[i])
+			i++
+		}
+		if i >= len(p) {
+			return nil, ErrFormat
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(p[i]) - 1
+		i++
+		if ref < 0 || len(out)+length+2 > n {
+			return nil, ErrFormat
+		}
+		// The reference may overlap the bytes being copied, so they are
+		// copied one at a time.
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != n {
+		return nil, ErrFormat
+	}
+	return out, nil
+}
+
+// ziplist returns the entries of the ziplist p, the integers being turned
+// into their decimal representation.
+func ziplist(p []byte) ([]string, error) {
+	// zlbytes, zltail and zllen, then the entries and the end byte
+	if len(p) < 11 || int(binary.LittleEndian.Uint32(p)) != len(p) || p[len(p)-1] != 0xff {
+		return nil, ErrFormat
+	}
+	var vals []string
+	for i := 10; ; {
+		if i >= len(p) {
+			return nil, ErrFormat
+		}
+		if p[i] == 0xff {
+			return vals, nil
+		}
+
+		// Skip the length of the previous entry
+		if p[i] == 0xfe {
+			i += 5
+		} else {
+			i++
+		}
+		if i >= len(p) {
+			return nil, ErrFormat
+		}
+
+		enc := p[i]
+		var val string
+		n := -1
+		switch {
+		case enc>>6 == 0:
+			val, n = zlstr(p, i+1, int(enc&0x3f))
+		case enc>>6 == 1:
+			if i+1 < len(p) {
+				if val, n = zlstr(p, i+2, int(enc&0x3f)<<8|int(p[i+1])); n >= 0 {
+					n++
+				}
+			}
+		case enc == 0x80:
+			if i+4 < len(p) {
+				if val, n = zlstr(p, i+5, int(binary.BigEndian.Uint32(p[i+1:]))); n >= 0 {
+					n += 4
+				}
+			}
+		case enc == 0xc0:
+			val, n = intLE(p, i+1, 2)
+		case enc == 0xd0:
+			val, n = intLE(p, i+1, 4)
+		case enc == 0xe0:
+			val, n = intLE(p, i+1, 8)
+		case enc == 0xf0:
+			val, n = intLE(p, i+1, 3)
+		case enc == 0xfe:
+			val, n = intLE(p, i+1, 1)
+		case enc >= 0xf1 && enc <= 0xfd:
+			// An immediate integer from 0 to 12
+			val, n = strconv.Itoa(int(enc&0x0f)-1), 0
+		default:
+			return nil, ErrFormat
+		}
+		if n < 0 {
+			return nil, ErrFormat
+		}
+		vals = append(vals, val)
+		i += 1 + n
+	}
+}
+
+// zlstr returns the string of length n at offset i of p, and n, or -1 if
+// it overflows p.
+func zlstr(p []byte, i, n int) (string, int) {
+	if n < 0 || i+n > len(p) {
+		return "", -1
+	}
+	return string(p[i : i+n]), n
+}
+
+// intLE returns the decimal representation of the little-endian signed
+// integer of size bytes at offset i of p, and size, or -1 if it overflows
+// p.
+func intLE(p []byte, i, size int) (string, int) {
+	if i+size > len(p) {
+		return "", -1
+	}
+	var u uint64
+	for j := size - 1; j >= 0; j-- {
+		u = u<<8 | uint64(p[i+j])
+	}
+	// Sign-extend from size bytes
+	shift := uint(64 - 8*size)
+	return strconv.FormatInt(int64(u<<shift)>>shift, 10), size
+}
+
+// listpack returns the entries of the listpack p, the integers being turned
+// into their decimal representation.
+func listpack(p []byte) ([]string, error) {
+	// total bytes and number of elements, then the entries and the end byte
+	if len(p) < 7 || int(binary.LittleEndian.Uint32(p)) != len(p) || p[len(p)-1] != 0xff {
+		return nil, ErrFormat
+	}
+	var vals []string
+	for i := 6; ; {
+		if i >= len(p) {
+			return nil, ErrFormat
+		}
+		enc := p[i]
+		if enc == 0xff {
+			return vals, nil
+		}
+
+		var val string
+		n := -1
+		switch {
+		case enc>>7 == 0:
+			// 7-bit unsigned integer
+			val, n = strconv.Itoa(int(enc)), 0
+		case enc>>6 == 2:
+			val, n = zlstr(p, i+1, int(enc&0x3f))
+		case enc>>5 == 6:
+			// 13-bit signed integer
+			if i+1 >= len(p) {
+				return nil, ErrFormat
+			}
+			v := int(enc&0x1f)<<8 | int(p[i+1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			val, n = strconv.Itoa(v), 1
+		case enc>>4 == 14:
+			if i+1 < len(p) {
+				if val, n = zlstr(p, i+2, int(enc&0x0f)<<8|int(p[i+1])); n >= 0 {
+					n++
+				}
+			}
+		case enc == 0xf0:
+			if i+4 < len(p) {
+				if val, n = zlstr(p, i+5, int(binary.LittleEndian.Uint32(p[i+1:]))); n >= 0 {
+					n += 4
+				}
+			}
+		case enc == 0xf1:
+			val, n = intLE(p, i+1, 2)
+		case enc == 0xf2:
+			val, n = intLE(p, i+1, 3)
+		case enc == 0xf3:
+			val, n = intLE(p, i+1, 4)
+		case enc == 0xf4:
+			val, n = intLE(p, i+1, 8)
+		default:
+			return nil, ErrFormat
+		}
+		if n < 0 {
+			return nil, ErrFormat
+		}
+		vals = append(vals, val)
+		i += 1 + n
+		i += backlenSize(1 + n)
+	}
+}
+
+// backlenSize returns the size of the back length that follows a listpack
+// entry of n bytes.
+func backlenSize(n int) int {
+	switch {
+	case n < 1<<7:
+		return 1
+	case n < 1<<14:
+		return 2
+	case n < 1<<21:
+		return 3
+	case n < 1<<28:
+		return 4
+	}
+	return 5
+}
+
+// intset returns the members of the intset p, in their decimal
+// representation.
+func intset(p []byte) ([]string, error) {
+	if len(p) < 8 {
+		return nil, ErrFormat
+	}
+	size := int(binary.LittleEndian.Uint32(p))
+	n := int(binary.LittleEndian.Uint32(p[4:]))
+	if (size != 2 && size != 4 && size != 8) || len(p) != 8+size*n {
+		return nil, ErrFormat
+	}
+	vals := make([]string, n)
+	for i := range vals {
+		vals[i], _ = intLE(p, 8+i*size, size)
+	}
+	return vals, nil
+}
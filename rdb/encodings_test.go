@@ -0,0 +1,80 @@
+package rdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLZF(t *testing.T) {
+	cases := []struct {
+		in  []byte
+		n   int
+		exp string
+		err error
+	}{
+		0: {[]byte{0x02, 'a', 'b', 'c'}, 3, "abc", nil},
+		1: {[]byte{0x02, 'a', 'b', 'c', 0xe0, 0x00, 0x02}, 12, "abcabcabcabc", nil},
+		// overlapping back reference
+		2: {[]byte{0x00, 'a', 0xe0, 0x00, 0x00}, 10, "aaaaaaaaaa", nil},
+		3: {[]byte{0x01, 'a', 'b', 0x20, 0x01}, 5, "ababa", nil},
+		4: {[]byte{0x02, 'a', 'b'}, 3, "", ErrFormat},
+		5: {[]byte{0x00, 'a', 0x20, 0x05}, 4, "", ErrFormat},
+		6: {[]byte{0x02, 'a', 'b', 'c'}, 4, "", ErrFormat},
+		7: {[]byte{0x02, 'a', 'b', 'c', 0xe0, 0x00, 0x02}, 5, "", ErrFormat},
+		8: {[]byte{0x00, 'a', 0xe0}, 10, "", ErrFormat},
+	}
+	for i, c := range cases {
+		got, err := lzf(c.in, c.n)
+		if err != c.err {
+			t.Errorf("%d: expected error %v, got %v", i, c.err, err)
+			continue
+		}
+		if string(got) != c.exp {
+			t.Errorf("%d: expected %q, got %q", i, c.exp, got)
+		}
+	}
+}
+
+func TestEncodingErrors(t *testing.T) {
+	zl := mkZiplist(zlStr("abc"), []byte{0xc0, 1, 0})
+	if vals, err := ziplist(zl); err != nil || !reflect.DeepEqual(vals, []string{"abc", "1"}) {
+		t.Fatalf("expected [abc 1], got %v %v", vals, err)
+	}
+	lp := mkListpack(lpStr("abc"), []byte{0xf3, 1, 0, 0, 0})
+	if vals, err := listpack(lp); err != nil || !reflect.DeepEqual(vals, []string{"abc", "1"}) {
+		t.Fatalf("expected [abc 1], got %v %v", vals, err)
+	}
+
+	bad := map[string][]byte{
+		"ziplist without end":        zl[:len(zl)-1],
+		"ziplist with bad size":      append(zl[:len(zl)-1:len(zl)-1], 0, 0xff),
+		"ziplist with bad string":    mkZiplist([]byte{0x05, 'a'}),
+		"ziplist with bad integer":   mkZiplist([]byte{0xe0, 1, 2}),
+		"ziplist with bad encoding":  mkZiplist([]byte{0x90}),
+		"listpack without end":       lp[:len(lp)-1],
+		"listpack with bad string":   mkListpack([]byte{0x85, 'a'}),
+		"listpack with bad integer":  mkListpack([]byte{0xf4, 1}),
+		"listpack with bad encoding": mkListpack([]byte{0xf5}),
+	}
+	for name, p := range bad {
+		var err error
+		if name[0] == 'z' {
+			_, err = ziplist(p)
+		} else {
+			_, err = listpack(p)
+		}
+		if err != ErrFormat {
+			t.Errorf("%s: expected %v, got %v", name, ErrFormat, err)
+		}
+	}
+
+	for _, p := range [][]byte{
+		{2, 0, 0},
+		append(mkIntset(3), 0),
+		mkIntset(4, 1, 2)[:14],
+	} {
+		if _, err := intset(p); err != ErrFormat {
+			t.Errorf("%v: expected %v, got %v", p, ErrFormat, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package rdb
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/PuerkitoBio/gred/srv"
+)
+
+// Load loads the keys of the RDB file read from r in the databases of the
+// server, replacing all existing keys, and returns the number of keys
+// loaded. Keys that expired since the file was saved are skipped. The keys
+// loaded count as changes to the keyspace, so that they are saved by the
+// save rules. The databases are left untouched if the file cannot be
+// loaded. The server must be exclusively locked.
+func Load(r io.Reader) (int, error) {
+	rr, err := NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+
+	// Read the whole file first, so that a bad file has no effect
+	var ents []*Entry
+	for {
+		e, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if e.DB >= srv.DefaultServer.Databases() {
+			return 0, fmt.Errorf("rdb: database %d out of range", e.DB)
+		}
+		ents = append(ents, e)
+	}
+
+	srv.DefaultServer.FlushAll()
+	now := srv.DefaultServer.Clock().Now()
+	var n int
+	for _, e := range ents {
+		ttl := time.Duration(-1)
+		if !e.ExpireAt.IsZero() {
+			if ttl = e.ExpireAt.Sub(now); ttl <= 0 {
+				continue
+			}
+		}
+
+		db, _ := srv.DefaultServer.GetDB(e.DB)
+		db.Lock()
+		k := db.SetKey(e.Key, e.Value, ttl)
+		if e.Idle >= 0 {
+			k.SetIdleTime(e.Idle)
+		}
+		if e.Freq >= 0 {
+			k.SetFreq(e.Freq)
+		}
+		db.Unlock()
+		n++
+	}
+	srv.DefaultServer.AddDirty(int64(n))
+	return n, nil
+}
@@ -0,0 +1,543 @@
+// Package rdb implements a reader of the RDB files saved by Redis, so that
+// their keys can be imported in the databases of the server.
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/PuerkitoBio/gred/types"
+)
+
+// The versions of the RDB format supported by the Reader, from Redis 2.8 to
+// Redis 7.2.
+const (
+	MinVersion = 6
+	MaxVersion = 11
+)
+
+var (
+	// ErrFormat is returned when the file is not a valid RDB file.
+	ErrFormat = errors.New("rdb: bad file format")
+
+	// ErrChecksum is returned when the checksum of the file is wrong.
+	ErrChecksum = errors.New("rdb: checksum is wrong")
+)
+
+// The opcodes of an RDB file, that precede the keys or end the file.
+const (
+	opFunction2    = 0xf5
+	opModuleAux    = 0xf7
+	opIdle         = 0xf8
+	opFreq         = 0xf9
+	opAux          = 0xfa
+	opResizeDB     = 0xfb
+	opExpireTimeMs = 0xfc
+	opExpireTime   = 0xfd
+	opSelectDB     = 0xfe
+	opEOF          = 0xff
+)
+
+// The types of the values of an RDB file.
+const (
+	typeString         = 0
+	typeList           = 1
+	typeSet            = 2
+	typeZSet           = 3
+	typeHash           = 4
+	typeZSet2          = 5
+	typeListZiplist    = 10
+	typeSetIntset      = 11
+	typeZSetZiplist    = 12
+	typeHashZiplist    = 13
+	typeListQuicklist  = 14
+	typeHashListpack   = 16
+	typeZSetListpack   = 17
+	typeListQuicklist2 = 18
+	typeSetListpack    = 20
+)
+
+// The containers of the nodes of a quicklist, from version 10.
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+// The special encodings of the strings, in the 6 low bits of a length whose
+// 2 high bits are set.
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// maxString is the maximum length of a string in a file, so that a corrupt
+// length cannot trigger a huge allocation.
+const maxString = 512 << 20
+
+// Entry is a key read from an RDB file.
+type Entry struct {
+	// DB is the index of the database of the key.
+	DB int
+
+	Key   string
+	Value types.Value
+
+	// ExpireAt is the time at which the key expires, unless it is zero.
+	ExpireAt time.Time
+
+	// Idle is the idle time of the key, or -1 if it is not saved.
+	Idle time.Duration
+
+	// Freq is the access frequency of the key, or -1 if it is not saved.
+	Freq int
+}
+
+// Reader reads the keys of an RDB file.
+type Reader struct {
+	// Version is the version of the file format.
+	Version int
+
+	// Aux holds the auxiliary fields read so far, such as "redis-ver".
+	Aux map[string]string
+
+	r   *bufio.Reader
+	crc uint64
+	db  int
+	err error
+}
+
+// NewReader returns a Reader that reads the RDB file from r. It returns an
+// error if the file does not start with a valid header.
+func NewReader(r io.Reader) (*Reader, error) {
+	rr := &Reader{r: bufio.NewReader(r), Aux: make(map[string]string)}
+	hdr := rr.read(9)
+	if rr.err != nil || string(hdr[:5]) != "REDIS" {
+		return nil, ErrFormat
+	}
+	ver, err := strconv.Atoi(string(hdr[5:]))
+	if err != nil {
+		return nil, ErrFormat
+	}
+	if ver < MinVersion || ver > MaxVersion {
+		return nil, fmt.Errorf("rdb: unsupported version %d", ver)
+	}
+	rr.Version = ver
+	return rr, nil
+}
+
+// Next returns the next key of the file. It returns io.EOF once the end of
+// the file is reached and its checksum is verified. The empty collections
+// are skipped, as does Redis.
+func (r *Reader) Next() (*Entry, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	e := &Entry{Idle: -1, Freq: -1}
+	for {
+		op := r.byte()
+		if r.err != nil {
+			return nil, r.fail()
+		}
+
+		switch op {
+		case opAux:
+			k, v := r.str(), r.str()
+			r.Aux[k] = v
+
+		case opResizeDB:
+			r.length()
+			r.length()
+
+		case opSelectDB:
+			r.db = int(r.length())
+
+		case opExpireTime:
+			b := r.read(4)
+			if r.err == nil {
+				e.ExpireAt = time.Unix(int64(binary.LittleEndian.Uint32(b)), 0)
+			}
+
+		case opExpireTimeMs:
+			b := r.read(8)
+			if r.err == nil {
+				e.ExpireAt = time.Unix(0, int64(binary.LittleEndian.Uint64(b))*int64(time.Millisecond))
+			}
+
+		case opIdle:
+			e.Idle = time.Duration(r.length()) * time.Second
+
+		case opFreq:
+			e.Freq = int(r.byte())
+
+		case opFunction2:
+			// The functions are not supported, the library is skipped.
+			r.str()
+
+		case opModuleAux:
+			r.err = errors.New("rdb: modules are not supported")
+
+		case opEOF:
+			crc := r.crc
+			b := r.read(8)
+			if r.err != nil {
+				return nil, r.fail()
+			}
+			// A zero checksum means that it was disabled when the file was saved
+			if sum := binary.LittleEndian.Uint64(b); sum != 0 && sum != crc {
+				r.err = ErrChecksum
+				return nil, r.err
+			}
+			r.err = io.EOF
+			return nil, r.err
+
+		default:
+			e.DB = r.db
+			e.Key = r.str()
+			e.Value = r.value(op)
+			if r.err != nil {
+				return nil, r.fail()
+			}
+			if e.Value != nil {
+				return e, nil
+			}
+			e = &Entry{Idle: -1, Freq: -1}
+		}
+		if r.err != nil {
+			return nil, r.fail()
+		}
+	}
+}
+
+// fail returns the error of the reader, turning an unexpected end of file
+// into ErrFormat.
+func (r *Reader) fail() error {
+	if r.err == io.EOF || r.err == io.ErrUnexpectedEOF {
+		r.err = ErrFormat
+	}
+	return r.err
+}
+
+// value reads the value of type typ. It returns nil for an empty collection.
+func (r *Reader) value(typ byte) types.Value {
+	switch typ {
+	case typeString:
+		return types.NewIncString(r.str())
+
+	case typeList:
+		vals := make([]string, 0)
+		for i, n := 0, r.length(); i < int(n) && r.err == nil; i++ {
+			vals = append(vals, r.str())
+		}
+		return r.list(vals, nil)
+
+	case typeListZiplist:
+		vals, err := ziplist([]byte(r.str()))
+		return r.list(vals, err)
+
+	case typeListQuicklist, typeListQuicklist2:
+		var vals []string
+		for i, n := 0, r.length(); i < int(n) && r.err == nil; i++ {
+			container := uint64(quicklistNodePacked)
+			if typ == typeListQuicklist2 {
+				container = r.length()
+			}
+			node := []byte(r.str())
+			if r.err != nil {
+				break
+			}
+
+			var nvals []string
+			var err error
+			switch {
+			case typ == typeListQuicklist:
+				nvals, err = ziplist(node)
+			case container == quicklistNodePlain:
+				nvals = []string{string(node)}
+			case container == quicklistNodePacked:
+				nvals, err = listpack(node)
+			default:
+				err = ErrFormat
+			}
+			if err != nil {
+				r.err = err
+				break
+			}
+			vals = append(vals, nvals...)
+		}
+		return r.list(vals, nil)
+
+	case typeSet:
+		vals := make([]string, 0)
+		for i, n := 0, r.length(); i < int(n) && r.err == nil; i++ {
+			vals = append(vals, r.str())
+		}
+		return r.set(vals, nil)
+
+	case typeSetIntset:
+		vals, err := intset([]byte(r.str()))
+		return r.set(vals, err)
+
+	case typeSetListpack:
+		vals, err := listpack([]byte(r.str()))
+		return r.set(vals, err)
+
+	case typeZSet, typeZSet2:
+		z := types.NewSortedSet()
+		for i, n := 0, r.length(); i < int(n) && r.err == nil; i++ {
+			mbr := r.str()
+			var score float64
+			if typ == typeZSet2 {
+				b := r.read(8)
+				if r.err != nil {
+					break
+				}
+				score = math.Float64frombits(binary.LittleEndian.Uint64(b))
+			} else {
+				score = r.score()
+			}
+			if r.err == nil && (math.IsNaN(score) || !z.ZAdd(score, mbr)) {
+				r.err = ErrFormat
+			}
+		}
+		if r.err != nil || z.ZCard() == 0 {
+			return nil
+		}
+		return z
+
+	case typeZSetZiplist, typeZSetListpack:
+		var vals []string
+		var err error
+		if typ == typeZSetZiplist {
+			vals, err = ziplist([]byte(r.str()))
+		} else {
+			vals, err = listpack([]byte(r.str()))
+		}
+		if r.err == nil && (err != nil || len(vals)%2 != 0) {
+			r.err = ErrFormat
+		}
+		z := types.NewSortedSet()
+		for i := 0; i+1 < len(vals) && r.err == nil; i += 2 {
+			score, err := strconv.ParseFloat(vals[i+1], 64)
+			if err != nil || math.IsNaN(score) || !z.ZAdd(score, vals[i]) {
+				r.err = ErrFormat
+			}
+		}
+		if r.err != nil || z.ZCard() == 0 {
+			return nil
+		}
+		return z
+
+	case typeHash:
+		vals := make([]string, 0)
+		for i, n := 0, r.length(); i < int(n) && r.err == nil; i++ {
+			vals = append(vals, r.str(), r.str())
+		}
+		return r.hash(vals, nil)
+
+	case typeHashZiplist:
+		vals, err := ziplist([]byte(r.str()))
+		return r.hash(vals, err)
+
+	case typeHashListpack:
+		vals, err := listpack([]byte(r.str()))
+		return r.hash(vals, err)
+	}
+
+	r.err = fmt.Errorf("rdb: unsupported value type %d", typ)
+	return nil
+}
+
+// list returns the list of the values vals, or nil if there is no value or
+// err is not nil.
+func (r *Reader) list(vals []string, err error) types.Value {
+	if r.err == nil {
+		r.err = err
+	}
+	if r.err != nil || len(vals) == 0 {
+		return nil
+	}
+	l := types.NewList()
+	l.RPush(vals...)
+	return l
+}
+
+// set returns the set of the members vals, or nil if there is no member or
+// err is not nil.
+func (r *Reader) set(vals []string, err error) types.Value {
+	if r.err == nil {
+		r.err = err
+	}
+	if r.err != nil || len(vals) == 0 {
+		return nil
+	}
+	s := types.NewSet()
+	if s.SAdd(vals...) != int64(len(vals)) {
+		r.err = ErrFormat
+		return nil
+	}
+	return s
+}
+
+// hash returns the hash of the fields and values in vals, or nil if there
+// is no field or err is not nil.
+func (r *Reader) hash(vals []string, err error) types.Value {
+	if r.err == nil {
+		r.err = err
+	}
+	if r.err == nil && len(vals)%2 != 0 {
+		r.err = ErrFormat
+	}
+	if r.err != nil || len(vals) == 0 {
+		return nil
+	}
+	h := types.NewIncHash()
+	for i := 0; i < len(vals); i += 2 {
+		if !h.HSet(vals[i], vals[i+1]) {
+			r.err = ErrFormat
+			return nil
+		}
+	}
+	return h
+}
+
+// score reads the score of a member of a sorted set in the old format, as
+// a string prefixed by its length on a byte, or a special length for NaN
+// and the infinities.
+func (r *Reader) score() float64 {
+	switch n := r.byte(); n {
+	case 253:
+		return math.NaN()
+	case 254:
+		return math.Inf(1)
+	case 255:
+		return math.Inf(-1)
+	default:
+		b := r.read(int(n))
+		if r.err != nil {
+			return 0
+		}
+		f, err := strconv.ParseFloat(string(b), 64)
+		if err != nil {
+			r.err = ErrFormat
+		}
+		return f
+	}
+}
+
+// The methods below read the bytes of the file and update its checksum.
+// Once a read fails, they return zero values and keep the error.
+
+func (r *Reader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	var b byte
+	if b, r.err = r.r.ReadByte(); r.err != nil {
+		return 0
+	}
+	r.crc = types.CRC64(r.crc, []byte{b})
+	return b
+}
+
+func (r *Reader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	p := make([]byte, n)
+	if _, r.err = io.ReadFull(r.r, p); r.err != nil {
+		return nil
+	}
+	r.crc = types.CRC64(r.crc, p)
+	return p
+}
+
+// rawLength reads a length. If the 2 high bits of its first byte are set,
+// it returns the special encoding in its 6 low bits, and true.
+func (r *Reader) rawLength() (uint64, bool) {
+	b := r.byte()
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false
+	case 1:
+		return uint64(b&0x3f)<<8 | uint64(r.byte()), false
+	case 2:
+		switch b {
+		case 0x80:
+			if p := r.read(4); p != nil {
+				return uint64(binary.BigEndian.Uint32(p)), false
+			}
+		case 0x81:
+			if p := r.read(8); p != nil {
+				return binary.BigEndian.Uint64(p), false
+			}
+		default:
+			if r.err == nil {
+				r.err = ErrFormat
+			}
+		}
+		return 0, false
+	}
+	return uint64(b & 0x3f), true
+}
+
+// length reads a length that must not have a special encoding.
+func (r *Reader) length() uint64 {
+	n, enc := r.rawLength()
+	if enc && r.err == nil {
+		r.err = ErrFormat
+	}
+	return n
+}
+
+// str reads a string, possibly encoded as an integer or compressed.
+func (r *Reader) str() string {
+	n, enc := r.rawLength()
+	if r.err != nil {
+		return ""
+	}
+	if !enc {
+		if n > maxString {
+			r.err = ErrFormat
+			return ""
+		}
+		return string(r.read(int(n)))
+	}
+
+	switch n {
+	case encInt8:
+		return strconv.Itoa(int(int8(r.byte())))
+	case encInt16:
+		if p := r.read(2); p != nil {
+			return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(p))))
+		}
+	case encInt32:
+		if p := r.read(4); p != nil {
+			return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(p))))
+		}
+	case encLZF:
+		clen, ulen := r.length(), r.length()
+		if r.err == nil && (clen > maxString || ulen > maxString) {
+			r.err = ErrFormat
+		}
+		p := r.read(int(clen))
+		if r.err != nil {
+			return ""
+		}
+		s, err := lzf(p, int(ulen))
+		if err != nil {
+			r.err = err
+		}
+		return string(s)
+	default:
+		r.err = ErrFormat
+	}
+	return ""
+}
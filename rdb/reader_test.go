@@ -0,0 +1,399 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+// builder builds the bytes of an RDB file.
+type builder struct {
+	b []byte
+}
+
+func newBuilder(ver string) *builder {
+	return &builder{b: []byte("REDIS" + ver)}
+}
+
+func (b *builder) raw(p ...byte) *builder {
+	b.b = append(b.b, p...)
+	return b
+}
+
+func (b *builder) length(n int) *builder {
+	switch {
+	case n < 1<<6:
+		return b.raw(byte(n))
+	case n < 1<<14:
+		return b.raw(0x40|byte(n>>8), byte(n))
+	}
+	b.raw(0x80)
+	b.b = binary.BigEndian.AppendUint32(b.b, uint32(n))
+	return b
+}
+
+func (b *builder) str(s string) *builder {
+	b.length(len(s))
+	return b.raw([]byte(s)...)
+}
+
+// key appends the type, the name and the string value of a key.
+func (b *builder) key(typ byte, name string, val ...string) *builder {
+	b.raw(typ).str(name)
+	for _, v := range val {
+		b.str(v)
+	}
+	return b
+}
+
+// end appends the EOF opcode and the checksum, or a zero checksum if crc
+// is false.
+func (b *builder) end(crc bool) []byte {
+	b.raw(opEOF)
+	var sum uint64
+	if crc {
+		sum = types.CRC64(0, b.b)
+	}
+	return binary.LittleEndian.AppendUint64(b.b, sum)
+}
+
+// mkZiplist returns the ziplist of the encoded entries ents.
+func mkZiplist(ents ...[]byte) []byte {
+	b := make([]byte, 10)
+	var prev, tail int
+	for _, e := range ents {
+		tail = len(b)
+		if prev < 254 {
+			b = append(b, byte(prev))
+		} else {
+			b = append(b, 0xfe)
+			b = binary.LittleEndian.AppendUint32(b, uint32(prev))
+		}
+		b = append(b, e...)
+		prev = len(b) - tail
+	}
+	b = append(b, 0xff)
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[4:], uint32(tail))
+	binary.LittleEndian.PutUint16(b[8:], uint16(len(ents)))
+	return b
+}
+
+// mkListpack returns the listpack of the encoded entries ents. The back
+// lengths are zeroed, as they are skipped by the reader.
+func mkListpack(ents ...[]byte) []byte {
+	b := make([]byte, 6)
+	for _, e := range ents {
+		b = append(b, e...)
+		b = append(b, make([]byte, backlenSize(len(e)))...)
+	}
+	b = append(b, 0xff)
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+	binary.LittleEndian.PutUint16(b[4:], uint16(len(ents)))
+	return b
+}
+
+// mkIntset returns the intset of the values vals, encoded on size bytes.
+func mkIntset(size int, vals ...int64) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, uint32(size))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(vals)))
+	for _, v := range vals {
+		for i := 0; i < size; i++ {
+			b = append(b, byte(v>>(8*i)))
+		}
+	}
+	return b
+}
+
+// zlStr and lpStr return the encoded short string entry s of a ziplist
+// and a listpack.
+func zlStr(s string) []byte { return append([]byte{byte(len(s))}, s...) }
+func lpStr(s string) []byte { return append([]byte{0x80 | byte(len(s))}, s...) }
+
+// plain returns the value v as basic Go values, to be compared.
+func plain(v types.Value) interface{} {
+	switch v := v.(type) {
+	case types.String:
+		return v.Get()
+	case types.List:
+		return v.LRange(0, -1)
+	case types.Set:
+		mbrs := v.SMembers()
+		sort.Strings(mbrs)
+		return mbrs
+	case types.Hash:
+		m := make(map[string]string)
+		vals := v.HGetAll()
+		for i := 0; i < len(vals); i += 2 {
+			m[vals[i]] = vals[i+1]
+		}
+		return m
+	case types.SortedSet:
+		m := make(map[string]float64)
+		for _, sm := range v.ZRange(0, -1, false) {
+			m[sm.Member] = sm.Score
+		}
+		return m
+	}
+	return nil
+}
+
+func TestReader(t *testing.T) {
+	future := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	long := strings.Repeat("x", 300)
+	lzfPayload := []byte{0x02, 'a', 'b', 'c', 0xe0, 0x00, 0x02}
+
+	b := newBuilder("0011")
+	b.raw(opAux).str("redis-ver").str("7.2.0")
+	b.raw(opAux).str("redis-bits").raw(0xc0, 64)
+	b.raw(opFunction2).str("#!lua name=lib\nredis.register_function('f', function() end)")
+	b.raw(opSelectDB).length(0).raw(opResizeDB).length(20).length(1)
+
+	// strings
+	b.key(typeString, "s", "hello")
+	b.raw(typeString).str("i8").raw(0xc0, 0xfb)
+	b.raw(typeString).str("i16").raw(0xc1, 0xe8, 0x03)
+	b.raw(typeString).str("i32").raw(0xc2, 0xa0, 0x86, 0x01, 0x00)
+	b.raw(typeString).str("lzf").raw(0xc3).length(len(lzfPayload)).length(12).raw(lzfPayload...)
+	b.key(typeString, "long", long)
+
+	// expiration, idle time and frequency
+	b.raw(opExpireTimeMs)
+	b.b = binary.LittleEndian.AppendUint64(b.b, uint64(future.UnixNano()/int64(time.Millisecond)))
+	b.key(typeString, "exp", "v")
+	b.raw(opExpireTime).raw(1, 0, 0, 0)
+	b.key(typeString, "old", "v")
+	b.raw(opIdle).length(100).raw(opFreq, 7)
+	b.key(typeString, "lru", "v")
+
+	// lists
+	b.raw(typeList).str("list").length(2).str("a").str("b")
+	b.raw(typeList).str("empty").length(0)
+	b.raw(typeListZiplist).str("zl").str(string(mkZiplist(
+		zlStr("a"),
+		[]byte{0xfe, 0xf6},
+		[]byte{0xc0, 0x30, 0x75},
+		[]byte{0xf0, 0xc0, 0xbd, 0xf0},
+		[]byte{0xd0, 0xa0, 0x86, 0x01, 0x00},
+		[]byte{0xe0, 0, 0, 0, 0, 0, 0, 0, 0x80},
+		[]byte{0xf1},
+		[]byte{0xfd},
+		append([]byte{0x41, 0x2c}, long...),
+		zlStr("z"),
+	)))
+	b.raw(typeListQuicklist).str("ql").length(2).
+		str(string(mkZiplist(zlStr("a"), zlStr("b")))).
+		str(string(mkZiplist([]byte{0xf4})))
+	b.raw(typeListQuicklist2).str("ql2").length(2).
+		length(quicklistNodePlain).str("plain").
+		length(quicklistNodePacked).str(string(mkListpack(lpStr("c"), []byte{0x05})))
+
+	// sets
+	b.raw(typeSet).str("set").length(2).str("a").str("b")
+	b.raw(typeSetIntset).str("is").str(string(mkIntset(2, -3, 1, 300)))
+	b.raw(typeSetIntset).str("is8").str(string(mkIntset(8, math.MinInt64, math.MaxInt64)))
+	b.raw(typeSetListpack).str("lps").str(string(mkListpack(lpStr("x"), []byte{0xdf, 0x9c})))
+
+	// sorted sets
+	b.raw(typeZSet).str("z1").length(2).str("a").raw(3).raw([]byte("1.5")...).str("b").raw(254)
+	b.raw(typeZSet2).str("z2").length(1).str("a")
+	b.b = binary.LittleEndian.AppendUint64(b.b, math.Float64bits(-2.25))
+	b.raw(typeZSetZiplist).str("zzl").str(string(mkZiplist(zlStr("a"), []byte{0xf3}, zlStr("b"), zlStr("2.5"))))
+	b.raw(typeZSetListpack).str("zlp").str(string(mkListpack(lpStr("a"), []byte{0xf1, 0x30, 0x75})))
+
+	// hashes
+	b.raw(typeHash).str("h").length(1).str("f").str("v")
+	b.raw(typeHashZiplist).str("hzl").str(string(mkZiplist(zlStr("f"), zlStr("v"), zlStr("n"), []byte{0xf2})))
+	b.raw(typeHashListpack).str("hlp").str(string(mkListpack(lpStr("f"), append([]byte{0xe0, 100}, strings.Repeat("y", 100)...))))
+
+	b.raw(opSelectDB).length(2)
+	b.key(typeString, "db2", "v")
+
+	r, err := NewReader(bytes.NewReader(b.end(true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Version != 11 {
+		t.Errorf("expected version 11, got %d", r.Version)
+	}
+
+	exp := []struct {
+		db  int
+		key string
+		val interface{}
+	}{
+		{0, "s", "hello"},
+		{0, "i8", "-5"},
+		{0, "i16", "1000"},
+		{0, "i32", "100000"},
+		{0, "lzf", "abcabcabcabc"},
+		{0, "long", long},
+		{0, "exp", "v"},
+		{0, "old", "v"},
+		{0, "lru", "v"},
+		{0, "list", []string{"a", "b"}},
+		{0, "zl", []string{"a", "-10", "30000", "-1000000", "100000", "-9223372036854775808", "0", "12", long, "z"}},
+		{0, "ql", []string{"a", "b", "3"}},
+		{0, "ql2", []string{"plain", "c", "5"}},
+		{0, "set", []string{"a", "b"}},
+		{0, "is", []string{"-3", "1", "300"}},
+		{0, "is8", []string{"-9223372036854775808", "9223372036854775807"}},
+		{0, "lps", []string{"-100", "x"}},
+		{0, "z1", map[string]float64{"a": 1.5, "b": math.Inf(1)}},
+		{0, "z2", map[string]float64{"a": -2.25}},
+		{0, "zzl", map[string]float64{"a": 2, "b": 2.5}},
+		{0, "zlp", map[string]float64{"a": 30000}},
+		{0, "h", map[string]string{"f": "v"}},
+		{0, "hzl", map[string]string{"f": "v", "n": "1"}},
+		{0, "hlp", map[string]string{"f": strings.Repeat("y", 100)}},
+		{2, "db2", "v"},
+	}
+	var ents []*Entry
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("after %d keys: %v", len(ents), err)
+		}
+		ents = append(ents, e)
+	}
+	if len(ents) != len(exp) {
+		t.Fatalf("expected %d keys, got %d", len(exp), len(ents))
+	}
+	for i, e := range ents {
+		if e.DB != exp[i].db || e.Key != exp[i].key {
+			t.Errorf("%d: expected key %q in db %d, got %q in db %d", i, exp[i].key, exp[i].db, e.Key, e.DB)
+		}
+		if got := plain(e.Value); !reflect.DeepEqual(got, exp[i].val) {
+			t.Errorf("%d: expected %v, got %v", i, exp[i].val, got)
+		}
+	}
+
+	if exp := map[string]string{"redis-ver": "7.2.0", "redis-bits": "64"}; !reflect.DeepEqual(r.Aux, exp) {
+		t.Errorf("expected aux fields %v, got %v", exp, r.Aux)
+	}
+	if e := ents[6]; !e.ExpireAt.Equal(future) {
+		t.Errorf("expected exp to expire at %v, got %v", future, e.ExpireAt)
+	}
+	if e := ents[7]; !e.ExpireAt.Equal(time.Unix(1, 0)) {
+		t.Errorf("expected old to expire at 1s, got %v", e.ExpireAt)
+	}
+	if e := ents[8]; e.Idle != 100*time.Second || e.Freq != 7 {
+		t.Errorf("expected lru idle time of 100s and frequency of 7, got %v %d", e.Idle, e.Freq)
+	}
+	if e := ents[0]; e.Idle != -1 || e.Freq != -1 || !e.ExpireAt.IsZero() {
+		t.Errorf("expected s without expiration, idle time or frequency, got %v %v %d", e.ExpireAt, e.Idle, e.Freq)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected EOF after the end, got %v", err)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	valid := func() *builder {
+		return newBuilder("0009").key(typeString, "a", "b")
+	}
+	good := valid().end(true)
+	badCRC := append([]byte(nil), good...)
+	badCRC[len(badCRC)-1]++
+
+	cases := []struct {
+		b   []byte
+		err error
+	}{
+		0:  {[]byte("REDIT0009"), ErrFormat},
+		1:  {[]byte("REDIS00x9"), ErrFormat},
+		2:  {[]byte("RED"), ErrFormat},
+		3:  {newBuilder("0005").end(true), nil},
+		4:  {newBuilder("0012").end(true), nil},
+		5:  {good, io.EOF},
+		6:  {valid().end(false), io.EOF},
+		7:  {badCRC, ErrChecksum},
+		8:  {good[:len(good)-3], ErrFormat},
+		9:  {good[:12], ErrFormat},
+		10: {newBuilder("0010").key(15, "stream").end(true), nil},
+		11: {newBuilder("0010").raw(opModuleAux).end(true), nil},
+		12: {newBuilder("0010").raw(typeSetIntset).str("a").str("bad").end(true), ErrFormat},
+		13: {newBuilder("0010").raw(typeZSet).str("a").length(1).str("m").raw(253).end(true), ErrFormat},
+		14: {newBuilder("0010").raw(typeString).str("a").raw(0xc3, 1, 5, 0).end(true), ErrFormat},
+	}
+	for i, c := range cases {
+		r, err := NewReader(bytes.NewReader(c.b))
+		if err == nil {
+			for err == nil {
+				_, err = r.Next()
+			}
+		}
+		if c.err == nil {
+			// an error specific to the case
+			if err == nil || err == io.EOF || err == ErrFormat {
+				t.Errorf("%d: expected a specific error, got %v", i, err)
+			}
+			continue
+		}
+		if err != c.err {
+			t.Errorf("%d: expected %v, got %v", i, c.err, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	defer func(s srv.Server) { srv.DefaultServer = s }(srv.DefaultServer)
+	srv.DefaultServer = srv.NewServer(4)
+
+	db, _ := srv.DefaultServer.GetDB(1)
+	db.Lock()
+	db.SetKey("gone", types.NewString("v"), -1)
+	db.Unlock()
+
+	b := newBuilder("0011").raw(opSelectDB).length(3)
+	b.raw(opExpireTime).raw(1, 0, 0, 0).key(typeString, "expired", "v")
+	b.raw(opExpireTimeMs)
+	b.b = binary.LittleEndian.AppendUint64(b.b, uint64(time.Now().Add(time.Hour).UnixNano()/int64(time.Millisecond)))
+	b.key(typeString, "a", "1")
+	b.raw(opIdle).length(50).key(typeString, "b", "2")
+	n, err := Load(bytes.NewReader(b.end(true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 keys loaded, got %d", n)
+	}
+	if d := srv.DefaultServer.Dirty(); d != 2 {
+		t.Errorf("expected 2 changes, got %d", d)
+	}
+	db, _ = srv.DefaultServer.GetDB(1)
+	if _, ok := db.Keys()["gone"]; ok {
+		t.Error("expected existing keys to be removed")
+	}
+	db, _ = srv.DefaultServer.GetDB(3)
+	if len(db.Keys()) != 2 {
+		t.Fatalf("expected 2 keys in db 3, got %d", len(db.Keys()))
+	}
+	if ttl := db.Keys()["a"].TTL(); ttl <= 59*time.Minute {
+		t.Errorf("expected a to expire in an hour, got %v", ttl)
+	}
+	if idle := db.Keys()["b"].IdleTime(); idle < 50*time.Second {
+		t.Errorf("expected b to be idle for 50s, got %v", idle)
+	}
+
+	// a database out of range leaves the keys untouched
+	b = newBuilder("0011").raw(opSelectDB).length(4).key(typeString, "c", "3")
+	if _, err := Load(bytes.NewReader(b.end(true))); err == nil {
+		t.Error("expected an error for a database out of range")
+	}
+	if len(db.Keys()) != 2 {
+		t.Errorf("expected 2 keys in db 3, got %d", len(db.Keys()))
+	}
+}
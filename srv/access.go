@@ -0,0 +1,127 @@
+package srv
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// The parameters of the logarithmic access frequency counter, that have the
+// same meaning as in Redis.
+const (
+	// lfuInitVal is the counter of a new key, so that it is not the first
+	// to be considered cold.
+	lfuInitVal = 5
+
+	// lfuLogFactor controls how fast the counter saturates: with a factor
+	// of 10, it reaches 255 after about a million accesses.
+	lfuLogFactor = 10
+
+	// lfuDecayTime is the period after which an idle key has its counter
+	// decremented by one.
+	lfuDecayTime = time.Minute
+)
+
+// Accesser is the interface that defines the methods to track the accesses
+// to a key.
+type Accesser interface {
+	// Touch records an access to the key.
+	Touch()
+
+	// IdleTime returns the time elapsed since the last access to the key.
+	IdleTime() time.Duration
+
+	// Freq returns the logarithmic access frequency counter of the key.
+	Freq() int
+
+	// SetIdleTime sets the time elapsed since the last access to the key.
+	SetIdleTime(time.Duration)
+
+	// SetFreq sets the logarithmic access frequency counter of the key.
+	SetFreq(int)
+}
+
+// accesser is the internal implementation of an Accesser for a Key. It has
+// its own lock, as the keys are usually read while only the DB is locked.
+type accesser struct {
+	mu      sync.Mutex
+	clock   Clock
+	atime   time.Time
+	freq    int
+	decayAt time.Time
+}
+
+// newAccesser creates an accesser for a new key, just accessed, whose
+// access times are based on clock c.
+func newAccesser(c Clock) *accesser {
+	now := c.Now()
+	return &accesser{clock: c, atime: now, freq: lfuInitVal, decayAt: now}
+}
+
+// Touch records an access to the key. The frequency counter is first
+// decayed, then incremented with a probability that decreases as the
+// counter grows.
+func (a *accesser) Touch() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.clock.Now()
+	a.decay(now)
+	a.atime = now
+	if a.freq == 255 {
+		return
+	}
+	base := float64(a.freq - lfuInitVal)
+	if base < 0 {
+		base = 0
+	}
+	if rand.Float64() < 1/(base*lfuLogFactor+1) {
+		a.freq++
+	}
+}
+
+// decay decrements the frequency counter by one for each lfuDecayTime
+// period elapsed since its last decrement. The lock must be held.
+func (a *accesser) decay(now time.Time) {
+	periods := int(now.Sub(a.decayAt) / lfuDecayTime)
+	if periods <= 0 {
+		return
+	}
+	a.decayAt = a.decayAt.Add(time.Duration(periods) * lfuDecayTime)
+	a.freq -= periods
+	if a.freq < 0 {
+		a.freq = 0
+	}
+}
+
+// IdleTime returns the time elapsed since the last access to the key.
+func (a *accesser) IdleTime() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.clock.Now().Sub(a.atime)
+}
+
+// Freq returns the logarithmic access frequency counter of the key, decayed
+// according to the time elapsed since its last decrement.
+func (a *accesser) Freq() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.decay(a.clock.Now())
+	return a.freq
+}
+
+// SetIdleTime sets the time elapsed since the last access to the key.
+func (a *accesser) SetIdleTime(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.atime = a.clock.Now().Add(-d)
+}
+
+// SetFreq sets the logarithmic access frequency counter of the key, which
+// must be between 0 and 255.
+func (a *accesser) SetFreq(n int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.freq = n
+	a.decayAt = a.clock.Now()
+}
@@ -0,0 +1,59 @@
+package srv
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the interface that defines the source of the current time of a
+// server, on which the expiration of the keys is based.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock that returns the system time.
+var SystemClock Clock = systemClock{}
+
+// systemClock is the implementation of SystemClock.
+type systemClock struct{}
+
+// Now returns the system time.
+func (c systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Static check to make sure *FakeClock implements the Clock interface.
+var _ Clock = (*FakeClock)(nil)
+
+// FakeClock is a Clock whose time only changes when it is set or advanced,
+// so that the expiration of the keys can be tested without waiting.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock creates a new FakeClock set to time t.
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{now: t}
+}
+
+// Now returns the time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set sets the time of the clock to t.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Advance moves the time of the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
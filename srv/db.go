@@ -2,6 +2,7 @@ package srv
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -45,10 +46,31 @@ const (
 	// NoKeyCreateSortedSet indicates that a key holding an empty sorted set should be created if the
 	// requested key does not exist.
 	NoKeyCreateSortedSet
+
+	// NoKeyCreateStream indicates that a key holding an empty stream should be created if the
+	// requested key does not exist.
+	NoKeyCreateStream
 )
 
-// WaitChan is the channel type required for the blocking operations on Lists.
-type WaitChan <-chan chan<- [2]string
+// WaitChan is the channel type required for the blocking operations. The
+// waiter receives the key name followed by the values, if any, on the
+// channel it sends on the WaitChan.
+type WaitChan <-chan chan<- []string
+
+// WaitFlag indicates the operation a waiter is blocked on.
+type WaitFlag int
+
+const (
+	// WaitLPop indicates that the waiter pops a value from the left of a List.
+	WaitLPop WaitFlag = iota
+
+	// WaitRPop indicates that the waiter pops a value from the right of a List.
+	WaitRPop
+
+	// WaitStream indicates that the waiter reads new entries from a Stream.
+	// It is only notified, it reads the entries itself.
+	WaitStream
+)
 
 // DB represents a Database, and defines the methods required to manipulate
 // its keys.
@@ -59,28 +81,37 @@ type DB interface {
 	// DB-level commands
 	Del(...string) int64
 	Exists(string) bool
-	Expire(string, int64, func()) bool
-	ExpireAt(string, int64, func()) bool
+	Expire(string, int64) bool
+	ExpireAt(string, int64) bool
 	FlushDB()
+	ID() int
 	Persist(string) bool
-	PExpire(string, int64, func()) bool
-	PExpireAt(string, int64, func()) bool
-	PSetEx(string, int64, string, func())
+	PExpire(string, int64) bool
+	PExpireAt(string, int64) bool
 	PTTL(string) int64
-	SetEx(string, int64, string, func())
+	RandomKey() (string, bool)
+	Rename(string, string)
+	Scan(uint64, int64) (uint64, []string)
+	Set(string, string, SetOpts) bool
 	TTL(string) int64
 	Type(string) string
 
 	// Keys access
+	Clock() Clock
 	Keys() map[string]Key
+	GetKey(string) (Key, bool)
 	DelKey(string)
+	DelExpired(int) int
+	MemoryStats() (int64, int64, int64)
 	LockGetKey(string, NoKeyFlag) (Key, func())
+	LockKeys(bool, ...string) func()
+	SetKey(string, types.Value, time.Duration) Key
 	XLockGetKey(string, NoKeyFlag) (Key, func())
 
-	// Blocking list waiters
-	WaitLPop(string, WaitChan)
-	WaitRPop(string, WaitChan)
-	NextWaiter(string) (WaitChan, bool)
+	// Blocking waiters
+	Wait(string, WaitChan, WaitFlag, int64)
+	NextWaiter(string, ...WaitFlag) (WaitChan, WaitFlag, int64)
+	WaitKeys() []string
 }
 
 // Static check to make sure *db implements the DB interface.
@@ -90,65 +121,106 @@ var _ DB = (*db)(nil)
 type db struct {
 	sync.RWMutex
 
-	// the database index
+	// the index at which the database was created
 	ix int
 
+	// the clock on which the expiration of the keys is based
+	clock Clock
+
 	// the keys held by the database
 	keys map[string]Key
 
-	// Block list waiters
-	waitersChans  map[string][]WaitChan
-	waitersPopPos map[string][]bool
+	// the names of the keys, indexed so that they are scanned incrementally
+	names types.Index
+
+	// the number of bytes of the names of the keys
+	nameBytes int64
+
+	// the expiration index of the keys
+	exps *expires
+
+	// Blocked waiters, per key
+	waiters map[string][]waiter
 }
 
-// NewDB creates a new DB value, with the specified index.
+// waiter is a blocked waiter, the operation it is blocked on and the
+// maximum number of values it pops.
+type waiter struct {
+	ch    WaitChan
+	flag  WaitFlag
+	count int64
+}
+
+// NewDB creates a new DB value, with the specified index, that uses the
+// system time.
 func NewDB(ix int) DB {
-	return &db{
-		ix:            ix,
-		keys:          make(map[string]Key),
-		waitersChans:  make(map[string][]WaitChan),
-		waitersPopPos: make(map[string][]bool),
-	}
+	return newDB(ix, SystemClock)
 }
 
-func (d *db) WaitLPop(key string, ch WaitChan) {
-	d.waitPop(key, ch, false)
+// newDB creates a new db value, with the specified index, that uses the
+// time of clock c.
+func newDB(ix int, c Clock) *db {
+	return &db{
+		ix:      ix,
+		clock:   c,
+		keys:    make(map[string]Key),
+		exps:    &expires{clock: c},
+		waiters: make(map[string][]waiter),
+	}
 }
 
-func (d *db) WaitRPop(key string, ch WaitChan) {
-	d.waitPop(key, ch, true)
+// Wait registers the waiter ch on the key, blocked on the operation
+// indicated by flag, that pops at most count values. The count is ignored
+// for WaitStream. The DB must be exclusively locked.
+func (d *db) Wait(key string, ch WaitChan, flag WaitFlag, count int64) {
+	d.waiters[key] = append(d.waiters[key], waiter{ch, flag, count})
 }
 
-func (d *db) NextWaiter(key string) (WaitChan, bool) {
-	slch, slbl := d.waitersChans[key], d.waitersPopPos[key]
-	if len(slch) == 0 {
-		return nil, false
+// NextWaiter removes and returns the oldest waiter on the key that is
+// blocked on one of the operations in flags, along with its operation and
+// the number of values it pops. It returns a nil channel if there is no
+// such waiter. The DB must be exclusively locked.
+func (d *db) NextWaiter(key string, flags ...WaitFlag) (WaitChan, WaitFlag, int64) {
+	ws := d.waiters[key]
+	for i, w := range ws {
+		for _, f := range flags {
+			if w.flag == f {
+				ws = append(ws[:i], ws[i+1:]...)
+				if len(ws) == 0 {
+					delete(d.waiters, key)
+				} else {
+					d.waiters[key] = ws
+				}
+				return w.ch, w.flag, w.count
+			}
+		}
 	}
-	ch, bl := slch[0], slbl[0]
-	slch, slbl = slch[1:], slbl[1:]
-	d.waitersChans[key] = slch
-	d.waitersPopPos[key] = slbl
-
-	return ch, bl
+	return nil, 0, 0
 }
 
-func (d *db) waitPop(key string, ch WaitChan, rpop bool) {
-	slch := d.waitersChans[key]
-	slch = append(slch, ch)
-	d.waitersChans[key] = slch
-	slbl := d.waitersPopPos[key]
-	slbl = append(slbl, rpop)
-	d.waitersPopPos[key] = slbl
+// WaitKeys returns the keys that have blocked waiters. The DB must be
+// locked.
+func (d *db) WaitKeys() []string {
+	keys := make([]string, 0, len(d.waiters))
+	for k := range d.waiters {
+		keys = append(keys, k)
+	}
+	return keys
 }
 
+// Del deletes the keys identified by names, and returns the number of keys
+// that existed. The keys that expired are deleted, but not counted.
 func (d *db) Del(names ...string) int64 {
 	var cnt int64
 	for _, nm := range names {
 		if k, ok := d.keys[nm]; ok {
 			k.Lock()
+			if !k.Expired() {
+				cnt++
+			}
 			k.Abort()
 			delete(d.keys, nm)
-			cnt++
+			d.removeName(nm)
 			k.Unlock()
 		}
 	}
@@ -156,66 +228,162 @@ func (d *db) Del(names ...string) int64 {
 }
 
 func (d *db) Exists(name string) bool {
-	_, ok := d.keys[name]
+	_, ok := d.GetKey(name)
 	return ok
 }
 
-func (d *db) Expire(name string, secs int64, fn func()) bool {
-	return d.expireDuration(name, time.Duration(secs)*time.Second, fn)
+func (d *db) Expire(name string, secs int64) bool {
+	return d.expireDuration(name, time.Duration(secs)*time.Second)
 }
 
-func (d *db) ExpireAt(name string, uxts int64, fn func()) bool {
-	secs := uxts - time.Now().Unix()
-	return d.expireDuration(name, time.Duration(secs)*time.Second, fn)
+func (d *db) ExpireAt(name string, uxts int64) bool {
+	secs := uxts - d.clock.Now().Unix()
+	return d.expireDuration(name, time.Duration(secs)*time.Second)
 }
 
 func (d *db) FlushDB() {
 	d.keys = make(map[string]Key)
+	d.names = types.Index{}
+	d.nameBytes = 0
+	d.exps = &expires{clock: d.clock}
 }
 
-func (d *db) PExpire(name string, ms int64, fn func()) bool {
-	return d.expireDuration(name, time.Duration(ms)*time.Millisecond, fn)
+// ID returns the index at which the DB was created, which is the index
+// used to select it, as SWAPDB only swaps the keys of the DBs. It is
+// unique among the DBs of a server and can be used to order the locks on
+// multiple DBs.
+func (d *db) ID() int {
+	return d.ix
 }
 
-func (d *db) PExpireAt(name string, uxts int64, fn func()) bool {
-	dur := (time.Duration(uxts) * time.Millisecond) - time.Duration(time.Now().UnixNano())
-	return d.expireDuration(name, dur, fn)
+func (d *db) PExpire(name string, ms int64) bool {
+	return d.expireDuration(name, time.Duration(ms)*time.Millisecond)
 }
 
-func (d *db) expireDuration(name string, dur time.Duration, fn func()) bool {
-	if k, ok := d.keys[name]; ok {
+func (d *db) PExpireAt(name string, uxts int64) bool {
+	dur := (time.Duration(uxts) * time.Millisecond) - time.Duration(d.clock.Now().UnixNano())
+	return d.expireDuration(name, dur)
+}
+
+func (d *db) expireDuration(name string, dur time.Duration) bool {
+	if k, ok := d.GetKey(name); ok {
 		k.Lock()
 		defer k.Unlock()
-		return k.Expire(dur, fn)
+		k.Expire(dur)
+		return true
 	}
 	return false
 }
 
-func (d *db) PSetEx(name string, ms int64, v string, fn func()) {
-	d.setExDuration(name, time.Duration(ms)*time.Millisecond, v, fn)
-}
+// SetOpts holds the options of DB.Set.
+type SetOpts struct {
+	// NX sets the key only if it does not exist.
+	NX bool
 
-func (d *db) SetEx(name string, secs int64, v string, fn func()) {
-	d.setExDuration(name, time.Duration(secs)*time.Second, v, fn)
+	// XX sets the key only if it exists.
+	XX bool
+
+	// KeepTTL retains the expiration of an existing key, otherwise it is
+	// removed.
+	KeepTTL bool
+
+	// ExpireAt is the time at which the key expires, unless it is zero.
+	ExpireAt time.Time
 }
 
-func (d *db) setExDuration(name string, dur time.Duration, v string, fn func()) {
-	// Get or create the key
-	k, def := d.LockGetKey(name, NoKeyCreateString)
-	defer def()
+// Set sets the key to the string value v, creating it if it does not exist,
+// if the conditions in opts are met. A key that holds a value of another
+// type is replaced. It returns true if the value was set, false if the
+// conditions were not met. The DB must be exclusively locked.
+func (d *db) Set(name, v string, opts SetOpts) bool {
+	k, ok := d.GetKey(name)
+	if (opts.NX && ok) || (opts.XX && !ok) {
+		return false
+	}
+
+	if ok {
+		old := k
+		old.Lock()
+		defer old.Unlock()
+		if kv, ok := old.Val().(types.String); ok {
+			kv.Set(v)
+			if !opts.KeepTTL {
+				old.Abort()
+			}
+		} else {
+			// The TTL of an existing key is only 0 while loading, the key
+			// then keeps expiring
+			ttl := old.TTL()
+			k = d.add(name, types.NewIncString(v))
+			if opts.KeepTTL && ttl >= 0 {
+				k.Expire(ttl)
+			}
+		}
+	} else {
+		k = d.add(name, types.NewIncString(v))
+	}
+
+	if !opts.ExpireAt.IsZero() {
+		k.Expire(opts.ExpireAt.Sub(d.clock.Now()))
+	}
+	return true
+}
 
-	// Set its value
+// Rename moves the key src to dst, replacing dst if it exists. The time to
+// live and the access statistics of src are kept, the key expiring under
+// its new name. It is assumed
+// the caller holds an exclusive lock on the DB and that src exists.
+func (d *db) Rename(src, dst string) {
+	k := d.keys[src]
 	k.Lock()
-	defer k.Unlock()
-	kv := k.Val().(types.String)
-	kv.Set(v)
+	ttl := k.TTL()
+	d.DelKey(src)
+	k.Unlock()
+
+	if dk, ok := d.keys[dst]; ok {
+		dk.Lock()
+		d.DelKey(dst)
+		dk.Unlock()
+	}
+	nk := d.SetKey(dst, k.Val(), ttl)
+	nk.SetIdleTime(k.IdleTime())
+	nk.SetFreq(k.Freq())
+}
 
-	// Expire the key
-	k.Expire(dur, fn)
+// RandomKey returns the name of a key selected uniformly at random among
+// the keys that did not expire, or false if the DB is empty. The expired
+// keys that are selected are deleted, so it is assumed the caller holds an
+// exclusive lock on the DB.
+func (d *db) RandomKey() (string, bool) {
+	for {
+		nm, ok := d.names.Random()
+		if !ok {
+			return "", false
+		}
+		if !d.keys[nm].Expired() {
+			return nm, true
+		}
+		d.DelKey(nm)
+	}
+}
+
+// Scan returns the names of the keys of one page of a cursor-based
+// iteration over the DB, starting at cursor and examining about count keys,
+// along with the cursor of the next page (0 when the iteration is done).
+// The keys that expired are skipped. It is assumed the caller holds a lock
+// on the DB.
+func (d *db) Scan(cursor uint64, count int64) (uint64, []string) {
+	var names []string
+	cur := d.names.Scan(cursor, count, func(nm string) {
+		if !d.keys[nm].Expired() {
+			names = append(names, nm)
+		}
+	})
+	return cur, names
 }
 
 func (d *db) Persist(name string) bool {
-	if k, ok := d.keys[name]; ok {
+	if k, ok := d.GetKey(name); ok {
 		k.Lock()
 		defer k.Unlock()
 		return k.Abort()
@@ -224,7 +392,7 @@ func (d *db) Persist(name string) bool {
 }
 
 func (d *db) PTTL(name string) int64 {
-	if k, ok := d.keys[name]; ok {
+	if k, ok := d.GetKey(name); ok {
 		k.RLock()
 		defer k.RUnlock()
 		ttl := k.TTL()
@@ -237,7 +405,7 @@ func (d *db) PTTL(name string) int64 {
 }
 
 func (d *db) TTL(name string) int64 {
-	if k, ok := d.keys[name]; ok {
+	if k, ok := d.GetKey(name); ok {
 		k.RLock()
 		defer k.RUnlock()
 		ttl := k.TTL()
@@ -250,7 +418,7 @@ func (d *db) TTL(name string) int64 {
 }
 
 func (d *db) Type(name string) string {
-	if k, ok := d.keys[name]; ok {
+	if k, ok := d.GetKey(name); ok {
 		k.RLock()
 		defer k.RUnlock()
 		return k.Val().Type()
@@ -258,10 +426,29 @@ func (d *db) Type(name string) string {
 	return "none"
 }
 
+// Clock returns the clock on which the expiration of the keys is based.
+func (d *db) Clock() Clock {
+	return d.clock
+}
+
+// Keys returns the keys held by the DB. It may include keys that expired
+// but were not deleted yet, which must be skipped.
 func (d *db) Keys() map[string]Key {
 	return d.keys
 }
 
+// GetKey returns the key identified by name. It returns false if the key
+// does not exist or if it expired, in which case it is not deleted, as the
+// DB may only be read-locked. It is assumed the caller holds a lock on the
+// DB.
+func (d *db) GetKey(name string) (Key, bool) {
+	k, ok := d.keys[name]
+	if !ok || k.Expired() {
+		return nil, false
+	}
+	return k, true
+}
+
 // DelKey deletes the specified key. It is assumed the caller has an exclusive lock
 // for both the DB and the key to delete.
 func (d *db) DelKey(name string) {
@@ -269,6 +456,102 @@ func (d *db) DelKey(name string) {
 	if ok {
 		k.Abort()
 		delete(d.keys, name)
+		d.removeName(name)
+	}
+}
+
+// DelExpired deletes at most max keys that expired, those that expired
+// first, or all of them if max is negative, and returns the number of keys
+// deleted. It is assumed the caller holds an exclusive lock on the DB.
+func (d *db) DelExpired(max int) int {
+	ks := d.exps.due(d.clock.Now().UnixNano(), max)
+	for _, k := range ks {
+		if d.keys[k.name] == Key(k) {
+			delete(d.keys, k.name)
+			d.removeName(k.name)
+		}
+	}
+	return len(ks)
+}
+
+// SetKey creates the key name holding the value v, replacing any existing
+// key, and returns it. If ttl is not negative, the key expires after this
+// duration. It is assumed the caller holds an exclusive lock on the DB, and
+// on the key being replaced, if any.
+func (d *db) SetKey(name string, v types.Value, ttl time.Duration) Key {
+	k := d.add(name, v)
+	if ttl >= 0 {
+		k.Expire(ttl)
+	}
+	return k
+}
+
+// add creates the key name holding the value v, replacing any existing
+// key, expired or not, and returns it. It is assumed the caller holds an
+// exclusive lock on the DB, and on the key being replaced, if any.
+func (d *db) add(name string, v types.Value) Key {
+	if old, ok := d.keys[name]; ok {
+		old.Abort()
+	}
+	k := newKey(name, v, d.exps)
+	d.keys[name] = k
+	if d.names.Add(name) {
+		d.nameBytes += int64(len(name))
+	}
+	return k
+}
+
+// removeName removes the name of a deleted key from the index.
+func (d *db) removeName(name string) {
+	if d.names.Remove(name) {
+		d.nameBytes -= int64(len(name))
+	}
+}
+
+// MemoryStats returns the number of keys of the DB, including the keys
+// that expired but were not deleted yet, and the estimated number of bytes
+// used by the keys themselves and by the expiration index. The keys are
+// counted as they are added and deleted, so they are not scanned. The DB
+// must be locked.
+func (d *db) MemoryStats() (keys, overhead, expires int64) {
+	keys = int64(len(d.keys))
+	d.exps.Lock()
+	expires = int64(len(d.exps.h)) * ExpireOverhead
+	d.exps.Unlock()
+	return keys, keys*KeyOverhead + d.nameBytes, expires
+}
+
+// LockKeys locks the existing keys identified by names, each distinct key
+// being locked once and recorded as accessed, in sorted order so that commands that lock multiple keys
+// cannot deadlock each other. The keys are locked exclusively if excl is true,
+// read-locked otherwise. It returns the function to call to unlock the keys.
+// It is assumed the caller holds a lock on the DB.
+func (d *db) LockKeys(excl bool, names ...string) func() {
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
+
+	unlocks := make([]func(), 0, len(sorted))
+	for i, nm := range sorted {
+		if i > 0 && nm == sorted[i-1] {
+			continue
+		}
+		if k, ok := d.GetKey(nm); ok {
+			k.Touch()
+			if excl {
+				k.Lock()
+				unlocks = append(unlocks, k.Unlock)
+			} else {
+				k.RLock()
+				unlocks = append(unlocks, k.RUnlock)
+			}
+		}
+	}
+
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
 	}
 }
 
@@ -280,6 +563,9 @@ func (d *db) LockGetKey(name string, flag NoKeyFlag) (Key, func()) {
 	return d.lockGetKey(false, name, flag)
 }
 
+// lockGetKey locks the DB, exclusively if excl is true, and returns the key
+// identified by name, recorded as accessed, or handles its absence as
+// requested by flag. It returns the function to call to unlock the DB.
 func (d *db) lockGetKey(excl bool, name string, flag NoKeyFlag) (Key, func()) {
 	var ret func()
 
@@ -290,9 +576,15 @@ func (d *db) lockGetKey(excl bool, name string, flag NoKeyFlag) (Key, func()) {
 		d.RLock()
 		ret = d.RUnlock
 	}
-	if k, ok := d.keys[name]; ok {
+	if k, ok := d.GetKey(name); ok {
+		k.Touch()
 		return k, ret
 	}
+	if excl {
+		// Delete the key if it expired, now that the DB is exclusively
+		// locked
+		d.DelKey(name)
+	}
 
 	// Key does not exist, what to do?
 	switch flag {
@@ -309,27 +601,32 @@ func (d *db) lockGetKey(excl bool, name string, flag NoKeyFlag) (Key, func()) {
 		ret = d.Unlock
 
 		// Check if key now exists (added during the lock upgrade)
-		if k, ok := d.keys[name]; ok {
+		if k, ok := d.GetKey(name); ok {
+			k.Touch()
 			return k, ret
 		}
 	}
 
-	// Still no chance, create as requested
-	var k Key
+	// Still no chance, create as requested, replacing the key if it
+	// expired
+	var v types.Value
 	switch flag {
 	case NoKeyCreateString:
-		k = NewKey(name, types.NewIncString(""))
+		v = types.NewIncString("")
 	case NoKeyCreateStringInt:
-		k = NewKey(name, types.NewIncString("0"))
+		v = types.NewIncString("0")
 	case NoKeyCreateHash:
-		k = NewKey(name, types.NewIncHash())
+		v = types.NewIncHash()
 	case NoKeyCreateList:
-		k = NewKey(name, types.NewList())
+		v = types.NewList()
 	case NoKeyCreateSet:
-		k = NewKey(name, types.NewSet())
+		v = types.NewSet()
+	case NoKeyCreateSortedSet:
+		v = types.NewSortedSet()
+	case NoKeyCreateStream:
+		v = types.NewStream()
 	default:
 		panic(fmt.Sprintf("db.Key NoKeyFlag not implemented: %d", flag))
 	}
-	d.keys[name] = k
-	return k, ret
+	return d.add(name, v), ret
 }
@@ -10,37 +10,69 @@ var dv defVal
 
 var empty = []string{}
 
+var emptyScores = []types.ScoreMember{}
+
+var emptyEntries = []types.StreamEntry{}
+
 var (
-	_ Key          = (*defKey)(nil)
-	_ types.String = (*defVal)(nil)
-	_ types.Hash   = (*defVal)(nil)
-	_ types.List   = (*defVal)(nil)
-	_ types.Set    = (*defVal)(nil)
+	_ Key             = (*defKey)(nil)
+	_ types.String    = (*defVal)(nil)
+	_ types.Hash      = (*defVal)(nil)
+	_ types.List      = (*defVal)(nil)
+	_ types.Set       = (*defVal)(nil)
+	_ types.SortedSet = (*defVal)(nil)
+	_ types.Stream    = (*defVal)(nil)
 )
 
 type defKey string
 
-func (d defKey) Lock()                                 {}
-func (d defKey) Unlock()                               {}
-func (d defKey) RLock()                                {}
-func (d defKey) RUnlock()                              {}
-func (d defKey) Expire(_ time.Duration, _ func()) bool { return true }
-func (d defKey) TTL() time.Duration                    { return 0 }
-func (d defKey) Abort() bool                           { return true }
-func (d defKey) Val() types.Value                      { return dv }
+func (d defKey) Lock()                       {}
+func (d defKey) Unlock()                     {}
+func (d defKey) RLock()                      {}
+func (d defKey) RUnlock()                    {}
+func (d defKey) Expire(_ time.Duration)      {}
+func (d defKey) TTL() time.Duration          { return 0 }
+func (d defKey) Abort() bool                 { return true }
+func (d defKey) Expired() bool               { return false }
+func (d defKey) Val() types.Value            { return dv }
+func (d defKey) Touch()                      {}
+func (d defKey) IdleTime() time.Duration     { return 0 }
+func (d defKey) Freq() int                   { return 0 }
+func (d defKey) SetIdleTime(_ time.Duration) {}
+func (d defKey) SetFreq(_ int)               {}
+func (d defKey) MemoryUsage(_ int) int64     { return 0 }
 
 func (d defKey) Name() string { return string(d) }
 
 type defVal struct{}
 
-func (d defVal) Type() string { panic("Type called on defKey value") }
+func (d defVal) Type() string       { panic("Type called on defKey value") }
+func (d defVal) Clone() types.Value { return d }
+
+func (d defVal) MemoryUsage(_ int) int64 { return 0 }
 
 // String implementation
-func (d defVal) Append(_ string) int64            { return 0 }
+func (d defVal) Append(_ string) int64              { return 0 }
+func (d defVal) BitCount(_ types.BitRange) int64    { return 0 }
+func (d defVal) BitFieldGet(_ types.BitField) int64 { return 0 }
+func (d defVal) BitFieldIncrBy(_ types.BitField, _ int64, _ types.BitFieldOverflow) (int64, bool) {
+	return 0, false
+}
+func (d defVal) BitFieldSet(_ types.BitField, _ int64, _ types.BitFieldOverflow) (int64, bool) {
+	return 0, false
+}
+func (d defVal) BitPos(bit int, _ types.BitRange, _ bool) int64 {
+	if bit == 1 {
+		return -1
+	}
+	return 0
+}
 func (d defVal) Get() string                      { return "" }
+func (d defVal) GetBit(_ int64) int               { return 0 }
 func (d defVal) GetRange(_, _ int64) string       { return "" }
 func (d defVal) GetSet(_ string) string           { return "" }
 func (d defVal) Set(_ string)                     {}
+func (d defVal) SetBit(_ int64, _ int) int        { return 0 }
 func (d defVal) SetRange(_ int64, _ string) int64 { return 0 }
 func (d defVal) StrLen() int64                    { return 0 }
 
@@ -53,30 +85,77 @@ func (d defVal) HKeys() []string                      { return empty }
 func (d defVal) HLen() int64                          { return 0 }
 func (d defVal) HMGet(fields ...string) []interface{} { return make([]interface{}, len(fields)) }
 func (d defVal) HMSet(_ ...string)                    {}
-func (d defVal) HSet(_, _ string) bool                { return false }
-func (d defVal) HSetNx(_, _ string) bool              { return false }
-func (d defVal) HVals() []string                      { return empty }
+func (d defVal) HScan(_ uint64, _ int64, _ string) (uint64, []string) {
+	return 0, empty
+}
+func (d defVal) HSet(_, _ string) bool   { return false }
+func (d defVal) HSetNx(_, _ string) bool { return false }
+func (d defVal) HVals() []string         { return empty }
 
 // Lists implementation
-func (d defVal) LIndex(_ int64) (string, bool)   { return "", false }
-func (d defVal) LInsertBefore(_, _ string) int64 { return 0 }
-func (d defVal) LInsertAfter(_, _ string) int64  { return 0 }
-func (d defVal) LLen() int64                     { return 0 }
-func (d defVal) LPop() (string, bool)            { return "", false }
-func (d defVal) LPush(_ ...string) int64         { return 0 }
-func (d defVal) LRange(_, _ int64) []string      { return empty }
-func (d defVal) LRem(_ int64, _ string) int64    { return 0 }
-func (d defVal) LSet(_ int64, _ string) bool     { return false }
-func (d defVal) LTrim(_, _ int64)                {}
-func (d defVal) RPop() (string, bool)            { return "", false }
-func (d defVal) RPush(_ ...string) int64         { return 0 }
+func (d defVal) LIndex(_ int64) (string, bool)        { return "", false }
+func (d defVal) LInsertBefore(_, _ string) int64      { return 0 }
+func (d defVal) LInsertAfter(_, _ string) int64       { return 0 }
+func (d defVal) LLen() int64                          { return 0 }
+func (d defVal) LPop() (string, bool)                 { return "", false }
+func (d defVal) LPos(_ string, _, _, _ int64) []int64 { return nil }
+func (d defVal) LPush(_ ...string) int64              { return 0 }
+func (d defVal) LRange(_, _ int64) []string           { return empty }
+func (d defVal) LRem(_ int64, _ string) int64         { return 0 }
+func (d defVal) LSet(_ int64, _ string) bool          { return false }
+func (d defVal) LTrim(_, _ int64)                     {}
+func (d defVal) RPop() (string, bool)                 { return "", false }
+func (d defVal) RPush(_ ...string) int64              { return 0 }
 
 // Sets implementation
-func (d defVal) SAdd(_ ...string) int64         { return 0 }
-func (d defVal) SCard() int64                   { return 0 }
-func (d defVal) SDiff(_ ...types.Set) []string  { return empty }
-func (d defVal) SInter(_ ...types.Set) []string { return empty }
-func (d defVal) SIsMember(_ string) bool        { return false }
-func (d defVal) SMembers() []string             { return empty }
-func (d defVal) SRem(_ ...string) int64         { return 0 }
+func (d defVal) SAdd(_ ...string) int64                   { return 0 }
+func (d defVal) SCard() int64                             { return 0 }
+func (d defVal) SDiff(_ ...types.Set) []string            { return empty }
+func (d defVal) SInter(_ ...types.Set) []string           { return empty }
+func (d defVal) SInterCard(_ int64, _ ...types.Set) int64 { return 0 }
+func (d defVal) SIsMember(_ string) bool                  { return false }
+func (d defVal) SMembers() []string                       { return empty }
+func (d defVal) SPop(_ int64) []string                    { return empty }
+func (d defVal) SRandMember(_ int64) []string             { return empty }
+func (d defVal) SRem(_ ...string) int64                   { return 0 }
+func (d defVal) SScan(_ uint64, _ int64, _ string) (uint64, []string) {
+	return 0, empty
+}
 func (d defVal) SUnion(_ ...types.Set) []string { return empty }
+
+// Sorted sets implementation
+func (d defVal) ZAdd(_ float64, _ string) bool                 { return false }
+func (d defVal) ZCard() int64                                  { return 0 }
+func (d defVal) ZCount(_ types.ScoreRange) int64               { return 0 }
+func (d defVal) ZIncrBy(_ float64, _ string) (float64, bool)   { return 0, false }
+func (d defVal) ZLexCount(_ types.LexRange) int64              { return 0 }
+func (d defVal) ZRange(_, _ int64, _ bool) []types.ScoreMember { return emptyScores }
+func (d defVal) ZRangeByLex(_ types.LexRange, _ bool, _, _ int64) []types.ScoreMember {
+	return emptyScores
+}
+func (d defVal) ZRangeByScore(_ types.ScoreRange, _ bool, _, _ int64) []types.ScoreMember {
+	return emptyScores
+}
+func (d defVal) ZRank(_ string, _ bool) (int64, bool)      { return 0, false }
+func (d defVal) ZRem(_ ...string) int64                    { return 0 }
+func (d defVal) ZRemRangeByLex(_ types.LexRange) int64     { return 0 }
+func (d defVal) ZRemRangeByRank(_, _ int64) int64          { return 0 }
+func (d defVal) ZRemRangeByScore(_ types.ScoreRange) int64 { return 0 }
+func (d defVal) ZScan(_ uint64, _ int64, _ string) (uint64, []types.ScoreMember) {
+	return 0, emptyScores
+}
+func (d defVal) ZScore(_ string) (float64, bool) { return 0, false }
+
+// Streams implementation
+func (d defVal) LastID() types.StreamID                                          { return types.StreamID{} }
+func (d defVal) XAdd(_ types.StreamID, _ []string) bool                          { return false }
+func (d defVal) XDel(_ ...types.StreamID) int64                                  { return 0 }
+func (d defVal) XLen() int64                                                     { return 0 }
+func (d defVal) XRange(_, _ types.StreamID, _ int64, _ bool) []types.StreamEntry { return emptyEntries }
+func (d defVal) XTrimMaxLen(_ int64, _ bool, _ int64) int64                      { return 0 }
+func (d defVal) XTrimMinID(_ types.StreamID, _ bool, _ int64) int64              { return 0 }
+func (d defVal) CreateGroup(_ string, _ types.StreamID) bool                     { return false }
+func (d defVal) DestroyGroup(_ string) bool                                      { return false }
+func (d defVal) Group(_ string) (types.StreamGroup, bool)                        { return nil, false }
+func (d defVal) Groups() []types.StreamGroup                                     { return nil }
+func (d defVal) Info() types.StreamInfo                                          { return types.StreamInfo{} }
@@ -1,60 +1,279 @@
 package srv
 
-import "time"
+import (
+	"container/heap"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The parameters of the active expiration cycle, that deletes the keys that
+// expired but are not accessed anymore.
+const (
+	// activeExpireBatch is the number of expired keys deleted at once from
+	// a DB, while it is exclusively locked.
+	activeExpireBatch = 20
+
+	// activeExpireBudget is the share of the interval between two cycles
+	// that a cycle may spend deleting keys, in percent.
+	activeExpireBudget = 25
+)
+
+// activeExpireTick is the interval at which the active expiration cycle
+// runs.
+var activeExpireTick = 100 * time.Millisecond
+
+// loading is non-zero while the databases are loaded from an append-only
+// file. It is accessed atomically.
+var loading int32
+
+// SetLoading sets whether the databases are being loaded from an
+// append-only file. While they are, the keys never expire, as in Redis, so
+// that the commands replayed find the keys that existed when they were
+// executed. The keys that expired must be deleted with DelExpired once the
+// file is loaded.
+func SetLoading(b bool) {
+	var n int32
+	if b {
+		n = 1
+	}
+	atomic.StoreInt32(&loading, n)
+}
+
+// Loading returns true while the databases are loaded from an append-only
+// file.
+func Loading() bool {
+	return atomic.LoadInt32(&loading) != 0
+}
 
 // Expirer is the interface that defines the methods to manage expiration.
 type Expirer interface {
-	Expire(time.Duration, func()) bool
+	// Expire sets the key to expire after dur.
+	Expire(dur time.Duration)
+
+	// TTL returns the time-to-live of the key, 0 if it expired, or -1 if
+	// it does not expire.
 	TTL() time.Duration
+
+	// Abort removes the expiration of the key. It returns true if the key
+	// was set to expire, false otherwise.
 	Abort() bool
+
+	// Expired returns true if the key expired.
+	Expired() bool
 }
 
-// expirer is the internal implementation of an Expirer for a Key.
+// expirer holds the expiration of a Key.
 type expirer struct {
-	tmr   *time.Timer
-	expAt time.Time
-}
-
-// Expire sets the key to expire at time t, at which point function fn will
-// be executed in its own goroutine. It returns true if expiration was
-// successfully set, false otherwise.
-func (e *expirer) Expire(dur time.Duration, fn func()) bool {
-	if e.tmr != nil {
-		set := e.tmr.Reset(dur)
-		if set {
-			e.expAt = time.Now().Add(dur)
-		}
-		return set
+	// the time at which the key expires, in nanoseconds since the epoch,
+	// or 0 if it does not expire. It is accessed atomically, as it is read
+	// by the lookups of the key without the lock of the key.
+	at int64
+
+	// the index of the key in the expiration index, -1 if it is not in it
+	ix int
+
+	// the expiration index of the DB holding the key, nil if the key was
+	// not created by a DB, in which case it is only expired on access
+	exps *expires
+}
+
+// now returns the current time, in nanoseconds since the epoch, according
+// to the clock of the DB holding the key, if any.
+func (k *key) now() int64 {
+	if k.exps == nil {
+		return SystemClock.Now().UnixNano()
 	}
+	return k.exps.clock.Now().UnixNano()
+}
 
-	e.tmr = time.AfterFunc(dur, fn)
-	e.expAt = time.Now().Add(dur)
-	return true
+// Expire sets the key to expire after dur.
+func (k *key) Expire(dur time.Duration) {
+	now := k.now()
+	at := now + int64(dur)
+	switch {
+	case dur > 0 && at < now:
+		// The time is out of range, the key never expires in practice
+		at = math.MaxInt64
+	case at <= 0:
+		at = 1
+	}
+	if k.exps == nil {
+		atomic.StoreInt64(&k.at, at)
+		return
+	}
+	k.exps.set(k, at)
 }
 
-// Abort aborts execution of an expiration timer. It returns true if a timer
-// was successfully aborted, false otherwise.
-func (e *expirer) Abort() bool {
-	if e.tmr != nil {
-		ok := e.tmr.Stop()
-		if ok {
-			e.tmr = nil
-			e.expAt = time.Time{}
-		}
-		return ok
+// Abort removes the expiration of the key. It returns true if the key was
+// set to expire, false otherwise.
+func (k *key) Abort() bool {
+	if atomic.LoadInt64(&k.at) == 0 {
+		return false
 	}
-	return false
+	if k.exps == nil {
+		atomic.StoreInt64(&k.at, 0)
+		return true
+	}
+	k.exps.remove(k)
+	return true
 }
 
-// TTL returns the time-to-live of the key before an expiration is triggered.
-// It returns -1 if there is no expiration associated with the key.
-func (e *expirer) TTL() time.Duration {
-	if e.tmr == nil {
+// TTL returns the time-to-live of the key before it expires, 0 if it
+// expired, or -1 if there is no expiration associated with the key.
+func (k *key) TTL() time.Duration {
+	at := atomic.LoadInt64(&k.at)
+	if at == 0 {
 		return -1
 	}
-	dur := e.expAt.Sub(time.Now())
-	if dur > 0 {
+	if dur := time.Duration(at - k.now()); dur > 0 {
 		return dur
 	}
 	return 0
 }
+
+// Expired returns true if the key expired. An expired key is never returned
+// by the lookups of the DB, even if it was not deleted yet. No key expires
+// while the databases are loading.
+func (k *key) Expired() bool {
+	at := atomic.LoadInt64(&k.at)
+	return at != 0 && at <= k.now() && !Loading()
+}
+
+// expires is the expiration index of a DB, that holds its keys that
+// expire, so that the expired keys are found without scanning all keys. It
+// has its own lock, as the expiration of a key may be changed while the DB
+// is only read-locked.
+type expires struct {
+	sync.Mutex
+	h     expHeap
+	clock Clock
+}
+
+// set sets the key k to expire at time at, in nanoseconds since the epoch.
+func (e *expires) set(k *key, at int64) {
+	e.Lock()
+	defer e.Unlock()
+	atomic.StoreInt64(&k.at, at)
+	if k.ix >= 0 {
+		heap.Fix(&e.h, k.ix)
+	} else {
+		heap.Push(&e.h, k)
+	}
+}
+
+// remove removes the expiration of the key k.
+func (e *expires) remove(k *key) {
+	e.Lock()
+	defer e.Unlock()
+	atomic.StoreInt64(&k.at, 0)
+	if k.ix >= 0 {
+		heap.Remove(&e.h, k.ix)
+	}
+}
+
+// due removes and returns at most max keys that expired at time now, in
+// nanoseconds since the epoch, those that expired first, or all of them if
+// max is negative.
+func (e *expires) due(now int64, max int) []*key {
+	e.Lock()
+	defer e.Unlock()
+	var ks []*key
+	for (max < 0 || len(ks) < max) && len(e.h) > 0 && e.h[0].at <= now {
+		ks = append(ks, heap.Pop(&e.h).(*key))
+	}
+	return ks
+}
+
+// expHeap is a min-heap of keys, ordered by expiration time. It implements
+// heap.Interface.
+type expHeap []*key
+
+func (h expHeap) Len() int           { return len(h) }
+func (h expHeap) Less(i, j int) bool { return h[i].at < h[j].at }
+
+func (h expHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].ix = i
+	h[j].ix = j
+}
+
+func (h *expHeap) Push(x interface{}) {
+	k := x.(*key)
+	k.ix = len(*h)
+	*h = append(*h, k)
+}
+
+func (h *expHeap) Pop() interface{} {
+	old := *h
+	n := len(old) - 1
+	k := old[n]
+	old[n] = nil
+	k.ix = -1
+	*h = old[:n]
+	return k
+}
+
+// ActiveExpire deletes the keys that expired from the databases, at a
+// regular interval until stop is closed, so that the expired keys that are
+// not accessed anymore do not use memory. Each cycle deletes the keys that
+// expired first, by batches, as long as batches are full and the cycle
+// remains within its share of the interval; the next cycle resumes at the
+// database where the previous one stopped.
+func (s *server) ActiveExpire(stop <-chan struct{}) {
+	t := time.NewTicker(activeExpireTick)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			s.expireCycle(activeExpireTick * activeExpireBudget / 100)
+		}
+	}
+}
+
+// DelExpired deletes all the keys that expired from the databases, and
+// returns the number of keys deleted.
+func (s *server) DelExpired() int {
+	s.RLock()
+	dbs := make([]DB, len(s.dbs))
+	copy(dbs, s.dbs)
+	s.RUnlock()
+
+	var n int
+	for _, db := range dbs {
+		db.Lock()
+		n += db.DelExpired(-1)
+		db.Unlock()
+	}
+	return n
+}
+
+// expireCycle deletes the keys that expired from the databases, spending
+// at most about budget doing so.
+func (s *server) expireCycle(budget time.Duration) {
+	start := time.Now()
+	s.RLock()
+	dbs := make([]DB, len(s.dbs))
+	copy(dbs, s.dbs)
+	s.RUnlock()
+
+	for i := range dbs {
+		ix := (s.expireNext + i) % len(dbs)
+		db := dbs[ix]
+		for {
+			db.Lock()
+			n := db.DelExpired(activeExpireBatch)
+			db.Unlock()
+			if n < activeExpireBatch {
+				break
+			}
+			if time.Since(start) >= budget {
+				s.expireNext = ix
+				return
+			}
+		}
+	}
+}
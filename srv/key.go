@@ -2,6 +2,7 @@ package srv
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/PuerkitoBio/gred/types"
 )
@@ -22,28 +23,70 @@ type Key interface {
 	// Expirer behaviour
 	Expirer
 
+	// Accesser behaviour
+	Accesser
+
 	// Val returns the underlying value
 	Val() types.Value
 
 	// Name returns the name of the key
 	Name() string
+
+	// MemoryUsage returns the estimated number of bytes used by the key
+	// and its value, the size of the elements of an aggregate value being
+	// extrapolated from that of samples elements, or computed from all of
+	// them if samples is 0.
+	MemoryUsage(samples int) int64
 }
 
+// The estimated number of bytes used by a key besides its name and its
+// value.
+const (
+	// KeyOverhead is the size of the key itself, including its expirer and
+	// the snapshots that share its value (120 bytes), its accesser (80
+	// bytes), its entry in the map of the DB (41 bytes) and in the index of
+	// the names of the DB (32 bytes).
+	KeyOverhead = 120 + 80 + 41 + 32
+
+	// ExpireOverhead is the size of the entry of a key that expires in the
+	// expiration index of the DB.
+	ExpireOverhead = 8
+)
+
 // key implements the Key interface.
 type key struct {
 	sync.RWMutex
-	*expirer
+	expirer
+	*accesser
 
 	v    types.Value
 	name string
+
+	// the snapshots that share the value, that were not written yet
+	smu   sync.Mutex
+	snaps []*snapKey
 }
 
-// NewKey creates a new Key with the specified name and value.
+// NewKey creates a new Key with the specified name and value. The key is
+// not in the expiration index of a DB, so if it is set to expire, it is
+// only deleted when accessed: the keys held by a DB should be created by
+// the DB.
 func NewKey(name string, v types.Value) Key {
+	return newKey(name, v, nil)
+}
+
+// newKey creates a new key with the specified name and value, that uses
+// the expiration index exps and its clock.
+func newKey(name string, v types.Value, exps *expires) *key {
+	c := SystemClock
+	if exps != nil {
+		c = exps.clock
+	}
 	return &key{
-		expirer: &expirer{},
-		v:       v,
-		name:    name,
+		expirer:  expirer{ix: -1, exps: exps},
+		accesser: newAccesser(c),
+		v:        v,
+		name:     name,
 	}
 }
 
@@ -52,3 +95,55 @@ func (k *key) Name() string { return k.name }
 
 // Val returns the value of the key.
 func (k *key) Val() types.Value { return k.v }
+
+// Lock locks the key for writing. As the value is about to be modified,
+// the snapshots that share it get a copy of it first.
+func (k *key) Lock() {
+	k.RWMutex.Lock()
+
+	k.smu.Lock()
+	defer k.smu.Unlock()
+	if len(k.snaps) > 0 {
+		c := k.v.Clone()
+		for _, sk := range k.snaps {
+			sk.v = c
+		}
+		k.snaps = nil
+	}
+}
+
+// share shares the value of the key with the snapshot key sk, until it is
+// written or the key is modified.
+func (k *key) share(sk *snapKey) {
+	k.smu.Lock()
+	defer k.smu.Unlock()
+	k.snaps = append(k.snaps, sk)
+}
+
+// unshare stops sharing the value of the key with the snapshot key sk, and
+// returns the value of the key when the snapshot was taken. The key must be
+// locked while the value is used, if it is still shared.
+func (k *key) unshare(sk *snapKey) types.Value {
+	k.smu.Lock()
+	defer k.smu.Unlock()
+	if sk.v != nil {
+		return sk.v
+	}
+	for i, s := range k.snaps {
+		if s == sk {
+			k.snaps = append(k.snaps[:i], k.snaps[i+1:]...)
+			break
+		}
+	}
+	return k.v
+}
+
+// MemoryUsage returns the estimated number of bytes used by the key and
+// its value.
+func (k *key) MemoryUsage(samples int) int64 {
+	size := KeyOverhead + int64(len(k.name)) + k.v.MemoryUsage(samples)
+	if atomic.LoadInt64(&k.at) != 0 {
+		size += ExpireOverhead
+	}
+	return size
+}
@@ -0,0 +1,187 @@
+package srv
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// SnapshotFile is the path of the snapshot file written by the SAVE and
+// BGSAVE commands and by the save rules.
+var SnapshotFile = "dump.gdb"
+
+// ErrBgSaveInProgress is returned by Save and BgSave when a background
+// save is already in progress.
+var ErrBgSaveInProgress = errors.New("srv: background save already in progress")
+
+// bgSaveRetryDelay is the delay after a failed background save before
+// another one is triggered by the save rules.
+const bgSaveRetryDelay = 5 * time.Second
+
+// autoSaveTick is the interval at which the save rules are checked.
+var autoSaveTick = time.Second
+
+// SaveRule is a rule that triggers a background save when at least Changes
+// changes were made to the keyspace and more than Interval elapsed since
+// the last successful save.
+type SaveRule struct {
+	Interval time.Duration
+	Changes  int64
+}
+
+// ParseSaveRules parses save rules in the format of the save directive of
+// Redis, a space-separated list of seconds and changes pairs, such as
+// "3600 1 300 100". It returns no rule for an empty string.
+func ParseSaveRules(s string) ([]SaveRule, error) {
+	flds := strings.Fields(s)
+	if len(flds)%2 != 0 {
+		return nil, errors.New("srv: save rules must be pairs of seconds and changes")
+	}
+	rules := make([]SaveRule, 0, len(flds)/2)
+	for i := 0; i < len(flds); i += 2 {
+		secs, err1 := strconv.ParseInt(flds[i], 10, 64)
+		chgs, err2 := strconv.ParseInt(flds[i+1], 10, 64)
+		if err1 != nil || err2 != nil || secs < 0 || chgs < 0 {
+			return nil, errors.New("srv: invalid save rule: " + flds[i] + " " + flds[i+1])
+		}
+		rules = append(rules, SaveRule{time.Duration(secs) * time.Second, chgs})
+	}
+	return rules, nil
+}
+
+// persistence holds the state of the snapshots of a server.
+type persistence struct {
+	// the number of changes since the last successful save, atomically
+	// updated by the commands
+	dirty int64
+
+	mu        sync.Mutex
+	lastSave  time.Time
+	bgSaving  bool
+	bgSaveTry time.Time
+	bgSaveErr error
+}
+
+// AddDirty adds n to the number of changes made to the keyspace since the
+// last successful save.
+func (s *server) AddDirty(n int64) {
+	atomic.AddInt64(&s.dirty, n)
+}
+
+// Dirty returns the number of changes made to the keyspace since the last
+// successful save.
+func (s *server) Dirty() int64 {
+	return atomic.LoadInt64(&s.dirty)
+}
+
+// LastSave returns the time of the last successful save, or the time the
+// server was created if it was never saved.
+func (s *server) LastSave() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSave
+}
+
+// Save saves a snapshot of the server to the file at path, and returns
+// once it is written. It returns ErrBgSaveInProgress if a background save
+// is in progress. The server must be exclusively locked.
+func (s *server) Save(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bgSaving {
+		return ErrBgSaveInProgress
+	}
+
+	sn := s.Snapshot()
+	if err := sn.WriteFile(path); err != nil {
+		return err
+	}
+	s.saved(sn)
+	return nil
+}
+
+// BgSave takes a snapshot of the server and saves it to the file at path
+// in a new goroutine, so that the databases are only locked while the
+// snapshot is taken. It returns ErrBgSaveInProgress if a background save
+// is already in progress. The server must be exclusively locked.
+func (s *server) BgSave(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bgSaving {
+		return ErrBgSaveInProgress
+	}
+
+	sn := s.Snapshot()
+	s.bgSaving = true
+	s.bgSaveTry = time.Now()
+	go func() {
+		err := sn.WriteFile(path)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.bgSaving = false
+		s.bgSaveErr = err
+		if err != nil {
+			glog.Errorf("background save: %s", err)
+			return
+		}
+		s.saved(sn)
+	}()
+	return nil
+}
+
+// saved records the successful save of the snapshot sn. The changes made
+// since the snapshot was taken remain to be saved. The persistence lock
+// must be held.
+func (s *server) saved(sn *Snapshot) {
+	atomic.AddInt64(&s.dirty, -sn.dirty)
+	s.lastSave = time.Now()
+}
+
+// AutoSave starts a background save of the server to the file at path each
+// time one of the rules is met, until stop is closed. After a failed
+// background save, the next one is delayed by a few seconds.
+func (s *server) AutoSave(path string, rules []SaveRule, stop <-chan struct{}) {
+	if len(rules) == 0 {
+		return
+	}
+
+	t := time.NewTicker(autoSaveTick)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			if s.saveDue(rules, now) {
+				s.Lock()
+				if err := s.BgSave(path); err != nil && err != ErrBgSaveInProgress {
+					glog.Errorf("background save: %s", err)
+				}
+				s.Unlock()
+			}
+		}
+	}
+}
+
+// saveDue returns true if one of the rules is met at time now, and a
+// background save may be started.
+func (s *server) saveDue(rules []SaveRule, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bgSaving || (s.bgSaveErr != nil && now.Sub(s.bgSaveTry) <= bgSaveRetryDelay) {
+		return false
+	}
+	dirty := s.Dirty()
+	for _, r := range rules {
+		if dirty >= r.Changes && now.Sub(s.lastSave) > r.Interval {
+			return true
+		}
+	}
+	return false
+}
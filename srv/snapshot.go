@@ -0,0 +1,372 @@
+package srv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/PuerkitoBio/gred/types"
+)
+
+// SnapshotVersion is the version of the snapshot file format written by
+// Save and BgSave. Load accepts files of this version or of a previous one.
+const SnapshotVersion = 1
+
+// snapshotMagic starts every snapshot file.
+const snapshotMagic = "GRED"
+
+// The opcodes of a snapshot file. After the magic string and the version,
+// a file is a sequence of opcodes, each followed by its operands:
+//   - opSelectDB, the uvarint index of the database of the following keys
+//   - opExpireAt, the unix time in milliseconds at which the following key
+//     expires, as a little-endian int64
+//   - opKey, the uvarint length and the bytes of the name of the key, then
+//     those of its value serialized by types.Dump
+//   - opEOF, that ends the file, and is followed by the CRC64 of all the
+//     preceding bytes, as a little-endian uint64.
+const (
+	opKey      byte = 0x00
+	opExpireAt byte = 0xfc
+	opSelectDB byte = 0xfe
+	opEOF      byte = 0xff
+)
+
+// maxSnapshotString is the maximum length of a key name or a serialized
+// value in a snapshot file, so that a corrupt length cannot trigger a huge
+// allocation.
+const maxSnapshotString = 512 << 20
+
+var (
+	// ErrSnapshotFormat is returned by Load when the file is not a valid
+	// snapshot.
+	ErrSnapshotFormat = errors.New("srv: bad snapshot file format")
+
+	// ErrSnapshotChecksum is returned by Load when the checksum of the file
+	// is wrong.
+	ErrSnapshotChecksum = errors.New("srv: snapshot checksum is wrong")
+)
+
+// Snapshot is a point-in-time copy of the keys of the databases of a
+// server, that can be written once without holding any lock. The values
+// are not copied when the snapshot is taken, they are shared with the keys
+// until the snapshot is written, a value being copied only if its key is
+// locked to be modified before it is written.
+type Snapshot struct {
+	dbs   []snapDB
+	dirty int64
+}
+
+// snapDB holds the keys of a database in a snapshot.
+type snapDB struct {
+	ix   int
+	keys []*snapKey
+}
+
+// snapKey holds a key in a snapshot, and expAt is zero if the key does not
+// expire. The value of the key k is shared with the snapshot until it is
+// written, unless k gets modified first, in which case v holds a copy of
+// the value it had when the snapshot was taken.
+type snapKey struct {
+	name  string
+	k     *key
+	v     types.Value
+	expAt time.Time
+}
+
+// dump returns the serialized value of the key when the snapshot was
+// taken, and stops sharing the value of the key with the snapshot.
+func (sk *snapKey) dump() []byte {
+	sk.k.RLock()
+	defer sk.k.RUnlock()
+	return types.Dump(sk.k.unshare(sk))
+}
+
+// Snapshot takes a snapshot of the keys of all databases. All databases
+// are exclusively locked while their keys are recorded, so the snapshot is
+// consistent across databases, but the values are not copied, and the
+// databases are not locked while it is written. The server must be
+// exclusively locked.
+func (s *server) Snapshot() *Snapshot {
+	locked := make([]DB, len(s.dbs))
+	copy(locked, s.dbs)
+	// lock in the same order as the commands that lock multiple databases
+	sort.Slice(locked, func(i, j int) bool { return locked[i].ID() < locked[j].ID() })
+	for _, db := range locked {
+		db.Lock()
+	}
+	defer func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			locked[i].Unlock()
+		}
+	}()
+
+	now := s.clock.Now()
+	sn := &Snapshot{dirty: s.Dirty()}
+	for ix, db := range s.dbs {
+		if len(db.Keys()) == 0 {
+			continue
+		}
+		sdb := snapDB{ix: ix, keys: make([]*snapKey, 0, len(db.Keys()))}
+		for name, k := range db.Keys() {
+			sk := &snapKey{name: name, k: k.(*key)}
+			if ttl := k.TTL(); ttl == 0 {
+				// expired, but not deleted yet
+				continue
+			} else if ttl > 0 {
+				sk.expAt = now.Add(ttl)
+			}
+			sk.k.share(sk)
+			sdb.keys = append(sdb.keys, sk)
+		}
+		sn.dbs = append(sn.dbs, sdb)
+	}
+	return sn
+}
+
+// WriteFile writes the snapshot to the file at path. It is first written to
+// a temporary file in the same directory, that is renamed once complete, so
+// that the file at path is always a complete snapshot.
+func (sn *Snapshot) WriteFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "temp-*.gdb")
+	if err != nil {
+		sn.Discard()
+		return err
+	}
+	tmp := f.Name()
+
+	w := bufio.NewWriter(f)
+	err = sn.Write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Discard releases the keys of a snapshot that is not written, so that
+// their values are no longer copied before being modified.
+func (sn *Snapshot) Discard() {
+	for _, db := range sn.dbs {
+		for _, k := range db.keys {
+			k.k.unshare(k)
+		}
+	}
+}
+
+// Write writes the snapshot to w. A snapshot can only be written once, its
+// keys being released as they are written, or once the write fails.
+func (sn *Snapshot) Write(w io.Writer) error {
+	e := &snapEncoder{w: w}
+	e.write([]byte(snapshotMagic))
+	e.write([]byte{byte(SnapshotVersion), byte(SnapshotVersion >> 8)})
+	for _, db := range sn.dbs {
+		e.write([]byte{opSelectDB})
+		e.uvarint(uint64(db.ix))
+		for _, k := range db.keys {
+			if e.err != nil {
+				k.k.unshare(k)
+				continue
+			}
+			if !k.expAt.IsZero() {
+				var b [9]byte
+				b[0] = opExpireAt
+				binary.LittleEndian.PutUint64(b[1:], uint64(k.expAt.UnixNano()/int64(time.Millisecond)))
+				e.write(b[:])
+			}
+			e.write([]byte{opKey})
+			e.str([]byte(k.name))
+			e.str(k.dump())
+		}
+	}
+	e.write([]byte{opEOF})
+
+	var crc [8]byte
+	binary.LittleEndian.PutUint64(crc[:], e.crc)
+	e.write(crc[:])
+	return e.err
+}
+
+// snapEncoder writes the bytes of a snapshot and computes their checksum.
+// Once a write fails, it ignores the subsequent writes and keeps the error.
+type snapEncoder struct {
+	w   io.Writer
+	crc uint64
+	err error
+}
+
+func (e *snapEncoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	e.crc = types.CRC64(e.crc, p)
+	_, e.err = e.w.Write(p)
+}
+
+func (e *snapEncoder) uvarint(n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.write(b[:binary.PutUvarint(b[:], n)])
+}
+
+func (e *snapEncoder) str(p []byte) {
+	e.uvarint(uint64(len(p)))
+	e.write(p)
+}
+
+// Load loads the keys of the snapshot read from r in the databases of the
+// server, replacing all existing keys. Keys that expired since the
+// snapshot was written are skipped, unless the databases are loading from
+// an append-only file. The databases are left untouched if
+// the snapshot cannot be loaded. If r is a *bufio.Reader, it is left
+// positioned after the end of the snapshot. The server must be
+// exclusively locked.
+func (s *server) Load(r io.Reader) error {
+	d := &snapDecoder{r: bufio.NewReader(r)}
+	hdr := d.read(len(snapshotMagic) + 2)
+	if d.err != nil || string(hdr[:len(snapshotMagic)]) != snapshotMagic {
+		return ErrSnapshotFormat
+	}
+	if ver := binary.LittleEndian.Uint16(hdr[len(snapshotMagic):]); ver < 1 || ver > SnapshotVersion {
+		return fmt.Errorf("srv: unsupported snapshot version %d", ver)
+	}
+
+	dbs := newDBs(len(s.dbs), s.clock)
+	var db DB
+	var expAt time.Time
+	now := s.clock.Now()
+	for {
+		op := d.byte()
+		if d.err != nil {
+			return ErrSnapshotFormat
+		}
+
+		switch op {
+		case opSelectDB:
+			ix := d.uvarint()
+			if d.err != nil {
+				return ErrSnapshotFormat
+			}
+			if ix >= uint64(len(dbs)) {
+				return fmt.Errorf("srv: snapshot database %d out of range", ix)
+			}
+			db = dbs[ix]
+
+		case opExpireAt:
+			b := d.read(8)
+			if d.err != nil {
+				return ErrSnapshotFormat
+			}
+			ms := int64(binary.LittleEndian.Uint64(b))
+			expAt = time.Unix(0, ms*int64(time.Millisecond))
+
+		case opKey:
+			name, p := d.str(), d.str()
+			if d.err != nil || db == nil {
+				return ErrSnapshotFormat
+			}
+			v, err := types.Restore(p)
+			if err != nil {
+				return fmt.Errorf("srv: snapshot key %q: %v", name, err)
+			}
+			ttl := time.Duration(-1)
+			if !expAt.IsZero() {
+				ttl = expAt.Sub(now)
+				expAt = time.Time{}
+				if ttl <= 0 {
+					if !Loading() {
+						continue
+					}
+					ttl = 0
+				}
+			}
+			db.SetKey(string(name), v, ttl)
+
+		case opEOF:
+			crc := d.crc
+			b := d.read(8)
+			if d.err != nil {
+				return ErrSnapshotFormat
+			}
+			if binary.LittleEndian.Uint64(b) != crc {
+				return ErrSnapshotChecksum
+			}
+			copy(s.dbs, dbs)
+			return nil
+
+		default:
+			return ErrSnapshotFormat
+		}
+	}
+}
+
+// snapDecoder reads the bytes of a snapshot and computes their checksum.
+// Once a read fails, it returns zero values and keeps the error.
+type snapDecoder struct {
+	r   *bufio.Reader
+	crc uint64
+	err error
+}
+
+// ReadByte implements io.ByteReader, so that the decoder can be used to
+// read uvarints.
+func (d *snapDecoder) ReadByte() (byte, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	var b byte
+	b, d.err = d.r.ReadByte()
+	if d.err != nil {
+		return 0, d.err
+	}
+	d.crc = types.CRC64(d.crc, []byte{b})
+	return b, nil
+}
+
+func (d *snapDecoder) byte() byte {
+	b, _ := d.ReadByte()
+	return b
+}
+
+func (d *snapDecoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	p := make([]byte, n)
+	if _, d.err = io.ReadFull(d.r, p); d.err != nil {
+		return nil
+	}
+	d.crc = types.CRC64(d.crc, p)
+	return p
+}
+
+func (d *snapDecoder) uvarint() uint64 {
+	n, err := binary.ReadUvarint(d)
+	if d.err == nil {
+		d.err = err
+	}
+	return n
+}
+
+func (d *snapDecoder) str() []byte {
+	n := d.uvarint()
+	if d.err == nil && n > maxSnapshotString {
+		d.err = ErrSnapshotFormat
+	}
+	return d.read(int(n))
+}
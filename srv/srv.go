@@ -3,6 +3,7 @@
 package srv
 
 import (
+	"io"
 	"sync"
 	"time"
 )
@@ -11,12 +12,30 @@ import (
 type Server interface {
 	RWLocker
 
+	Databases() int
 	FlushAll()
 	GetDB(int) (DB, bool)
+	SwapDB(int, int) bool
 	Time() (int64, int64)
+
+	// Expiration
+	ActiveExpire(<-chan struct{})
+	Clock() Clock
+	DelExpired() int
+
+	// Persistence
+	AddDirty(int64)
+	AutoSave(string, []SaveRule, <-chan struct{})
+	BgSave(string) error
+	Dirty() int64
+	LastSave() time.Time
+	Load(io.Reader) error
+	Save(string) error
+	Snapshot() *Snapshot
 }
 
-const maxDBs = 16 // TODO : Should be read from configuration
+// DefaultDatabases is the default number of databases of a server.
+const DefaultDatabases = 16
 
 // Static check to make sure *server implements the Server interface.
 var _ Server = (*server)(nil)
@@ -27,36 +46,91 @@ var DefaultServer Server
 // server is the internal implementation of a Server.
 type server struct {
 	sync.RWMutex
-	dbs []DB
+	dbs   []DB
+	clock Clock
+
+	// the index of the DB at which the next active expiration cycle
+	// starts, only accessed by the cycles
+	expireNext int
+
+	persistence
 }
 
 func init() {
-	// TODO : Read configuration
-	DefaultServer = &server{
-		dbs: make([]DB, maxDBs),
+	DefaultServer = NewServer(DefaultDatabases)
+}
+
+// NewServer creates a new Server holding n databases, that uses the
+// system time.
+func NewServer(n int) Server {
+	return NewServerWithClock(n, SystemClock)
+}
+
+// NewServerWithClock creates a new Server holding n databases, that uses
+// the time of clock c for the expiration of the keys and the TIME command.
+func NewServerWithClock(n int, c Clock) Server {
+	return &server{
+		dbs:         newDBs(n, c),
+		clock:       c,
+		persistence: persistence{lastSave: time.Now()},
 	}
 }
 
-// FlushAll clears the keys from all databases.
+// Clock returns the clock of the server.
+func (s *server) Clock() Clock {
+	return s.clock
+}
+
+// Databases returns the number of databases of the server.
+func (s *server) Databases() int {
+	return len(s.dbs)
+}
+
+// newDBs creates n empty databases that use the time of clock c.
+func newDBs(n int, c Clock) []DB {
+	dbs := make([]DB, n)
+	for i := range dbs {
+		dbs[i] = newDB(i, c)
+	}
+	return dbs
+}
+
+// FlushAll clears the keys from all databases. The server must be
+// exclusively locked.
 func (s *server) FlushAll() {
-	s.dbs = make([]DB, maxDBs)
+	s.dbs = newDBs(len(s.dbs), s.clock)
 }
 
-// GetDB returns the database identified by its index.
+// GetDB returns the database identified by its index. The databases are
+// created with the server, and only replaced while the server is
+// exclusively locked, so the caller must hold a lock on the server.
 func (s *server) GetDB(ix int) (DB, bool) {
-	if ix < 0 || ix >= maxDBs {
+	if ix < 0 || ix >= len(s.dbs) {
 		return nil, false
 	}
+	return s.dbs[ix], true
+}
 
-	db := s.dbs[ix]
-	if db == nil {
-		db = NewDB(ix)
-		s.dbs[ix] = db
+// SwapDB swaps the keys of the databases at indices ix1 and ix2, so that
+// selecting one index returns the keys previously at the other index. The
+// clients blocked on keys stay blocked on the database at the same index.
+// It returns false if an index is invalid. The server and both databases
+// must be exclusively locked.
+func (s *server) SwapDB(ix1, ix2 int) bool {
+	db1, ok1 := s.GetDB(ix1)
+	db2, ok2 := s.GetDB(ix2)
+	if !ok1 || !ok2 {
+		return false
 	}
-	return db, true
+	d1, d2 := db1.(*db), db2.(*db)
+	d1.keys, d2.keys = d2.keys, d1.keys
+	d1.names, d2.names = d2.names, d1.names
+	d1.nameBytes, d2.nameBytes = d2.nameBytes, d1.nameBytes
+	d1.exps, d2.exps = d2.exps, d1.exps
+	return true
 }
 
 func (s *server) Time() (int64, int64) {
-	t := time.Now()
+	t := s.clock.Now()
 	return t.Unix(), int64(time.Duration(t.Nanosecond()) / time.Microsecond)
 }
@@ -1,13 +1,19 @@
 package srv
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/PuerkitoBio/gred/types"
 )
 
 func TestSrvGetDB(t *testing.T) {
-	s := &server{dbs: make([]DB, maxDBs)}
+	s := NewServer(DefaultDatabases)
 	// Getting DB 0 should return a non-nil DB
 	d0, _ := s.GetDB(0)
 	if d0 == nil {
@@ -31,3 +37,641 @@ func TestSrvGetDB(t *testing.T) {
 		t.Fatalf("DB 1 has key 'a'")
 	}
 }
+
+func TestDBRename(t *testing.T) {
+	d := NewDB(0)
+	d.Lock()
+	d.Keys()["a"] = NewKey("a", types.NewString("1"))
+	d.SetKey("b", types.NewString("2"), time.Hour)
+	d.SetKey("c", types.NewString("3"), -1)
+
+	// The TTL moves along with the key
+	d.Rename("b", "c")
+	if d.Exists("b") {
+		t.Errorf("expected key b to be renamed")
+	}
+	k := d.Keys()["c"]
+	if v := k.Val().(types.String).Get(); v != "2" || k.Name() != "c" {
+		t.Errorf("expected key c with value 2, got %s %s", k.Name(), v)
+	}
+	if ttl := d.TTL("c"); ttl <= 0 {
+		t.Errorf("expected a TTL, got %d", ttl)
+	}
+
+	// The key expires under its new name
+	d.SetKey("e", types.NewString("4"), time.Millisecond)
+	d.Rename("e", "f")
+	d.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	d.Lock()
+	defer d.Unlock()
+	if d.Exists("f") || d.Exists("e") {
+		t.Errorf("expected key f to be expired")
+	}
+	if !d.Exists("a") || d.TTL("a") != -1 {
+		t.Errorf("expected key a without TTL")
+	}
+}
+
+func TestDBScan(t *testing.T) {
+	d := NewDB(0)
+	d.Lock()
+	defer d.Unlock()
+	for i := 0; i < 1000; i++ {
+		d.SetKey(strconv.Itoa(i), types.NewString("1"), -1)
+	}
+	d.SetKey("expired", types.NewString("1"), -1).Expire(-time.Second)
+	d.Del("0", "1")
+
+	seen := make(map[string]bool)
+	cur, calls := uint64(0), 0
+	for {
+		var page []string
+		cur, page = d.Scan(cur, 10)
+		if len(page) > 30 {
+			t.Fatalf("expected about 10 keys per page, got %d", len(page))
+		}
+		for _, nm := range page {
+			seen[nm] = true
+		}
+		calls++
+		if cur == 0 {
+			break
+		}
+	}
+	if len(seen) != 998 || seen["0"] || seen["expired"] {
+		t.Errorf("expected the 998 keys that exist, got %d", len(seen))
+	}
+	if calls < 30 {
+		t.Errorf("expected at least 30 calls, got %d", calls)
+	}
+}
+
+func TestDBRandomKey(t *testing.T) {
+	d := NewDB(0)
+	d.Lock()
+	defer d.Unlock()
+	if _, ok := d.RandomKey(); ok {
+		t.Fatal("expected no key in an empty DB")
+	}
+	for i := 0; i < 10; i++ {
+		d.SetKey(strconv.Itoa(i), types.NewString("1"), -1)
+	}
+	for i := 0; i < 1000; i++ {
+		d.SetKey("expired"+strconv.Itoa(i), types.NewString("1"), -1).Expire(-time.Second)
+	}
+
+	// The expired keys that are selected are deleted, all the others are
+	// eventually selected
+	seen := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		nm, ok := d.RandomKey()
+		if !ok || d.(*db).keys[nm].Expired() {
+			t.Fatalf("expected a key that did not expire, got %q", nm)
+		}
+		seen[nm]++
+	}
+	if len(seen) != 10 {
+		t.Errorf("expected the 10 keys to be selected, got %v", seen)
+	}
+	if n := len(d.Keys()); n >= 1010 {
+		t.Errorf("expected expired keys to be deleted, got %d keys", n)
+	}
+}
+
+func TestDBExpire(t *testing.T) {
+	d := NewDB(0)
+	d.Lock()
+	defer d.Unlock()
+	exps := d.(*db).exps
+	d.SetKey("a", types.NewString("1"), time.Millisecond)
+	d.SetKey("b", types.NewString("2"), time.Hour)
+	d.SetKey("c", types.NewString("3"), -1)
+	time.Sleep(5 * time.Millisecond)
+
+	// An expired key is not observable, even before it is deleted
+	if d.Exists("a") || d.TTL("a") != -2 || d.PTTL("a") != -2 || d.Type("a") != "none" {
+		t.Errorf("expected key a to be expired")
+	}
+	if d.Persist("a") || d.Expire("a", 10) {
+		t.Errorf("expected no expiration change on an expired key")
+	}
+	if _, ok := d.Keys()["a"]; !ok {
+		t.Errorf("expected key a not to be deleted yet")
+	}
+	if n := d.Del("a"); n != 0 {
+		t.Errorf("expected no deleted key, got %d", n)
+	}
+	if _, ok := d.Keys()["a"]; ok || len(exps.h) != 1 {
+		t.Errorf("expected key a to be deleted, with 1 key left to expire, got %d", len(exps.h))
+	}
+
+	// PERSIST removes the key from the expiration index
+	if !d.Persist("b") || d.TTL("b") != -1 || len(exps.h) != 0 {
+		t.Errorf("expected key b without TTL, with no key left to expire, got %d", len(exps.h))
+	}
+	if d.Persist("b") || d.Persist("c") {
+		t.Errorf("expected no expiration to remove")
+	}
+
+	// The keys that expired first are deleted first
+	for i, nm := range []string{"e", "f", "g", "h"} {
+		d.SetKey(nm, types.NewString("4"), -1).Expire(time.Duration(i-3) * time.Second)
+	}
+	d.Expire("c", 3600)
+	if n := d.DelExpired(2); n != 2 {
+		t.Errorf("expected 2 deleted keys, got %d", n)
+	}
+	for _, nm := range []string{"e", "f"} {
+		if _, ok := d.Keys()[nm]; ok {
+			t.Errorf("expected key %s to be deleted", nm)
+		}
+	}
+	if n := d.DelExpired(10); n != 2 || len(d.Keys()) != 2 || len(exps.h) != 1 {
+		t.Errorf("expected 2 deleted keys and 2 keys left, got %d %d", n, len(d.Keys()))
+	}
+
+	// A key that replaces an expired one does not expire
+	d.SetKey("i", types.NewString("5"), 0)
+	d.Unlock()
+	k, unl := d.XLockGetKey("i", NoKeyCreateString)
+	if k.TTL() != -1 || k.Val().(types.String).Get() != "" {
+		t.Errorf("expected a new key i without TTL")
+	}
+	unl()
+	d.Lock()
+	if len(exps.h) != 1 {
+		t.Errorf("expected 1 key left to expire, got %d", len(exps.h))
+	}
+
+	// A key that expires far in the future does not expire
+	d.Expire("i", 99999999999)
+	if d.TTL("i") <= 0 {
+		t.Errorf("expected a TTL, got %d", d.TTL("i"))
+	}
+}
+
+func TestActiveExpire(t *testing.T) {
+	s := NewServer(2).(*server)
+	for ix, n := range []int{50, 3} {
+		d, _ := s.GetDB(ix)
+		d.Lock()
+		for i := 0; i < n; i++ {
+			d.SetKey(strconv.Itoa(i), types.NewString("1"), 0)
+		}
+		d.SetKey("live", types.NewString("1"), time.Hour)
+		d.Unlock()
+	}
+	d0, _ := s.GetDB(0)
+	d1, _ := s.GetDB(1)
+
+	// Without budget, the cycle stops after a full batch
+	s.expireCycle(0)
+	if n := len(d0.Keys()); n != 50-activeExpireBatch+1 || s.expireNext != 0 {
+		t.Errorf("expected %d keys left in DB 0, got %d", 50-activeExpireBatch+1, n)
+	}
+	if n := len(d1.Keys()); n != 4 {
+		t.Errorf("expected 4 keys left in DB 1, got %d", n)
+	}
+
+	defer func(d time.Duration) { activeExpireTick = d }(activeExpireTick)
+	activeExpireTick = time.Millisecond
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.ActiveExpire(stop)
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		d0.RLock()
+		n0 := len(d0.Keys())
+		d0.RUnlock()
+		d1.RLock()
+		n1 := len(d1.Keys())
+		d1.RUnlock()
+		if n0 == 1 && n1 == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+	if !d0.Exists("live") || !d1.Exists("live") || len(d0.Keys()) != 1 || len(d1.Keys()) != 1 {
+		t.Errorf("expected only the live keys left, got %d %d", len(d0.Keys()), len(d1.Keys()))
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	c := NewFakeClock(time.Unix(1000, 0))
+	s := NewServerWithClock(1, c)
+	d, _ := s.GetDB(0)
+	d.Lock()
+	d.SetKey("a", types.NewString("1"), 10*time.Second)
+	d.SetKey("b", types.NewString("1"), 20*time.Second)
+	d.SetKey("c", types.NewString("1"), -1)
+	d.Unlock()
+
+	if ttl := d.PTTL("a"); ttl != 10000 {
+		t.Errorf("expected TTL 10000, got %d", ttl)
+	}
+	c.Advance(9 * time.Second)
+	if ttl := d.PTTL("a"); ttl != 1000 {
+		t.Errorf("expected TTL 1000, got %d", ttl)
+	}
+	c.Advance(time.Second)
+	if d.Exists("a") || !d.Exists("b") || len(d.Keys()) != 3 {
+		t.Errorf("expected a to be expired but not deleted, got %d keys", len(d.Keys()))
+	}
+	c.Set(time.Unix(1020, 0))
+	if n := s.DelExpired(); n != 2 || len(d.Keys()) != 1 {
+		t.Errorf("expected 2 keys deleted and 1 left, got %d and %d", n, len(d.Keys()))
+	}
+	if sec, usec := s.Time(); sec != 1020 || usec != 0 {
+		t.Errorf("expected time 1020 0, got %d %d", sec, usec)
+	}
+}
+
+func TestKeyAccess(t *testing.T) {
+	d := NewDB(0)
+	d.Lock()
+	k := d.SetKey("a", types.NewString("1"), -1)
+	d.Unlock()
+	if f := k.Freq(); f != lfuInitVal {
+		t.Errorf("expected frequency %d, got %d", lfuInitVal, f)
+	}
+
+	// A lookup records an access
+	k.SetIdleTime(time.Hour)
+	_, unl := d.LockGetKey("a", NoKeyNone)
+	unl()
+	if f, idle := k.Freq(), k.IdleTime(); f != lfuInitVal+1 || idle >= time.Minute {
+		t.Errorf("expected frequency %d and no idle time, got %d %s", lfuInitVal+1, f, idle)
+	}
+
+	// The counter saturates, and grows slower as it grows
+	for i := 0; i < 1000; i++ {
+		k.Touch()
+	}
+	if f := k.Freq(); f <= lfuInitVal+1 || f >= 100 {
+		t.Errorf("expected a logarithmic frequency, got %d", f)
+	}
+	k.SetFreq(255)
+	k.Touch()
+	if f := k.Freq(); f != 255 {
+		t.Errorf("expected frequency 255, got %d", f)
+	}
+
+	// The counter decays over time
+	k.(*key).decayAt = time.Now().Add(-3*lfuDecayTime - time.Second)
+	if f := k.Freq(); f != 252 {
+		t.Errorf("expected frequency 252, got %d", f)
+	}
+	k.SetFreq(1)
+	k.(*key).decayAt = time.Now().Add(-3 * lfuDecayTime)
+	if f := k.Freq(); f != 0 {
+		t.Errorf("expected frequency 0, got %d", f)
+	}
+}
+
+func TestKeyMemoryUsage(t *testing.T) {
+	d := NewDB(0)
+	d.Lock()
+	defer d.Unlock()
+	k := d.SetKey("abc", types.NewString("1234"), -1)
+	if n := k.MemoryUsage(0); n != KeyOverhead+3+16+4 {
+		t.Errorf("expected %d, got %d", KeyOverhead+3+16+4, n)
+	}
+	k = d.SetKey("abc", types.NewString("1234"), time.Hour)
+	if n := k.MemoryUsage(0); n != KeyOverhead+3+16+4+ExpireOverhead {
+		t.Errorf("expected %d, got %d", KeyOverhead+3+16+4+ExpireOverhead, n)
+	}
+}
+
+func TestDBMemoryStats(t *testing.T) {
+	d := NewDB(0)
+	d.Lock()
+	defer d.Unlock()
+	d.SetKey("abc", types.NewString("1"), -1)
+	d.SetKey("abc", types.NewString("2"), time.Hour)
+	d.SetKey("de", types.NewString("3"), time.Hour)
+	if keys, overhead, expires := d.MemoryStats(); keys != 2 || overhead != 2*KeyOverhead+5 || expires != 2*ExpireOverhead {
+		t.Errorf("expected 2 %d %d, got %d %d %d", 2*KeyOverhead+5, 2*ExpireOverhead, keys, overhead, expires)
+	}
+	k, _ := d.GetKey("abc")
+	k.Lock()
+	d.DelKey("abc")
+	k.Unlock()
+	if keys, overhead, expires := d.MemoryStats(); keys != 1 || overhead != KeyOverhead+2 || expires != ExpireOverhead {
+		t.Errorf("expected 1 %d %d, got %d %d %d", KeyOverhead+2, ExpireOverhead, keys, overhead, expires)
+	}
+	d.FlushDB()
+	if keys, overhead, expires := d.MemoryStats(); keys != 0 || overhead != 0 || expires != 0 {
+		t.Errorf("expected 0 0 0, got %d %d %d", keys, overhead, expires)
+	}
+}
+
+func TestSrvSwapDB(t *testing.T) {
+	s := NewServer(3)
+	d0, _ := s.GetDB(0)
+	d0.Lock()
+	d0.SetKey("a", types.NewString("1"), time.Millisecond)
+	d0.Unlock()
+
+	if !s.SwapDB(0, 2) {
+		t.Fatalf("expected DBs to be swapped")
+	}
+	if s.SwapDB(0, 3) {
+		t.Fatalf("expected invalid index to fail")
+	}
+	d2, _ := s.GetDB(2)
+	if d, _ := s.GetDB(0); d != d0 || d.Exists("a") || d.ID() != 0 || d2.ID() != 2 {
+		t.Errorf("expected DB 0 to hold the empty keys of DB 2")
+	}
+	if !d2.Exists("a") {
+		t.Fatalf("expected key a to be in DB 2")
+	}
+
+	// The key expires from the DB it was moved to
+	time.Sleep(10 * time.Millisecond)
+	d2.RLock()
+	defer d2.RUnlock()
+	if d2.Exists("a") {
+		t.Errorf("expected key a to be expired")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.gdb")
+
+	s := NewServer(4)
+	d0, _ := s.GetDB(0)
+	d2, _ := s.GetDB(2)
+	l := types.NewList()
+	l.RPush("a", "b", "c")
+	h := types.NewIncHash()
+	h.HSet("f", "v")
+	d0.Lock()
+	d0.SetKey("s", types.NewIncString("1"), -1)
+	d0.SetKey("l", l, time.Hour)
+	d0.SetKey("gone", types.NewIncString("x"), time.Millisecond)
+	d0.Unlock()
+	d2.Lock()
+	d2.SetKey("h", h, -1)
+	d2.Unlock()
+	s.AddDirty(3)
+	time.Sleep(5 * time.Millisecond)
+
+	s.Lock()
+	if err := s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	s.Unlock()
+	if n := s.Dirty(); n != 0 {
+		t.Errorf("expected no change after the save, got %d", n)
+	}
+
+	// The snapshot does not change with the keys
+	d0.Lock()
+	d0.Del("s")
+	d0.Unlock()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ls := NewServer(4)
+	ls.Lock()
+	err = ls.Load(f)
+	ls.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l0, _ := ls.GetDB(0)
+	l2, _ := ls.GetDB(2)
+	if n := len(l0.Keys()); n != 2 {
+		t.Errorf("expected 2 keys in DB 0, got %d", n)
+	}
+	if v := l0.Keys()["s"].Val().(types.String).Get(); v != "1" {
+		t.Errorf("expected %q, got %q", "1", v)
+	}
+	if v := l0.Keys()["l"].Val().(types.List).LRange(0, -1); len(v) != 3 || v[2] != "c" {
+		t.Errorf("expected [a b c], got %v", v)
+	}
+	if ttl := l0.TTL("l"); ttl < 3599 || ttl > 3600 {
+		t.Errorf("expected a TTL of 3600, got %d", ttl)
+	}
+	if ttl := l0.TTL("s"); ttl != -1 {
+		t.Errorf("expected no TTL, got %d", ttl)
+	}
+	if v, _ := l2.Keys()["h"].Val().(types.Hash).HGet("f"); v != "v" {
+		t.Errorf("expected %q, got %q", "v", v)
+	}
+
+	// A corrupt snapshot is rejected and leaves the keys untouched
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-10]++
+	ls.Lock()
+	err = ls.Load(bytes.NewReader(b))
+	ls.Unlock()
+	if err == nil {
+		t.Errorf("expected an error loading a corrupt snapshot")
+	}
+	if n := len(l0.Keys()); n != 2 {
+		t.Errorf("expected 2 keys in DB 0, got %d", n)
+	}
+	ls.Lock()
+	err = ls.Load(bytes.NewReader(b[:len(b)/2]))
+	ls.Unlock()
+	if err != ErrSnapshotFormat {
+		t.Errorf("expected error %v, got %v", ErrSnapshotFormat, err)
+	}
+
+	// The snapshot must fit in the databases of the server
+	f.Seek(0, 0)
+	ss := NewServer(2)
+	ss.Lock()
+	err = ss.Load(f)
+	ss.Unlock()
+	if err == nil {
+		t.Errorf("expected an error loading DB 2 in a server with 2 DBs")
+	}
+}
+
+func TestSnapshotCopyOnWrite(t *testing.T) {
+	s := NewServer(1)
+	d, _ := s.GetDB(0)
+	d.Lock()
+	l := types.NewList()
+	l.RPush("a")
+	d.SetKey("l", l, -1)
+	d.SetKey("s", types.NewString("1"), -1)
+	d.Unlock()
+
+	s.Lock()
+	sn := s.Snapshot()
+	s.Unlock()
+
+	// The values are shared until a key is modified
+	keys := make(map[string]*snapKey)
+	for _, sk := range sn.dbs[0].keys {
+		keys[sk.name] = sk
+	}
+	if keys["l"].v != nil || keys["s"].v != nil {
+		t.Fatal("expected the values to be shared with the keys")
+	}
+	k, _ := d.GetKey("l")
+	k.Lock()
+	l.RPush("b")
+	k.Unlock()
+	if keys["l"].v == nil || keys["s"].v != nil {
+		t.Fatal("expected only the value of l to be copied")
+	}
+
+	var buf bytes.Buffer
+	if err := sn.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, sk := range keys {
+		if len(sk.k.snaps) != 0 {
+			t.Errorf("expected key %s to be released", sk.name)
+		}
+	}
+	ls := NewServer(1)
+	ls.Lock()
+	err := ls.Load(&buf)
+	ls.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld, _ := ls.GetDB(0)
+	if v := ld.Keys()["l"].Val().(types.List).LRange(0, -1); !reflect.DeepEqual(v, []string{"a"}) {
+		t.Errorf("expected [a], got %v", v)
+	}
+}
+
+func TestBgSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.gdb")
+
+	s := NewServer(1).(*server)
+	d, _ := s.GetDB(0)
+	d.Lock()
+	d.SetKey("a", types.NewIncString("1"), -1)
+	d.Unlock()
+	s.AddDirty(1)
+	before := s.LastSave()
+
+	s.Lock()
+	err := s.BgSave(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// changes made during the save remain to be saved
+	s.AddDirty(1)
+	err2 := s.Save(path)
+	s.Unlock()
+	if err2 != ErrBgSaveInProgress && err2 != nil {
+		t.Errorf("expected error %v, got %v", ErrBgSaveInProgress, err2)
+	}
+	waitBgSave(t, s)
+
+	if n := s.Dirty(); n != 1 {
+		t.Errorf("expected 1 change after the save, got %d", n)
+	}
+	if !s.LastSave().After(before) {
+		t.Errorf("expected the last save time to be updated")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the snapshot file to exist: %s", err)
+	}
+
+	// a failed save is reported, and does not update the last save time
+	before = s.LastSave()
+	s.Lock()
+	err = s.BgSave(filepath.Join(path, "nodir", "dump.gdb"))
+	s.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitBgSave(t, s)
+	if s.bgSaveErr == nil {
+		t.Errorf("expected a background save error")
+	}
+	if !s.LastSave().Equal(before) {
+		t.Errorf("expected the last save time to be unchanged")
+	}
+	if s.saveDue([]SaveRule{{0, 0}}, time.Now()) {
+		t.Errorf("expected the next save to be delayed after a failure")
+	}
+}
+
+// waitBgSave waits for the background save of s to complete.
+func waitBgSave(t *testing.T, s *server) {
+	for i := 0; i < 100; i++ {
+		s.mu.Lock()
+		done := !s.bgSaving
+		s.mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("background save did not complete")
+}
+
+func TestAutoSave(t *testing.T) {
+	defer func(d time.Duration) { autoSaveTick = d }(autoSaveTick)
+	autoSaveTick = time.Millisecond
+	path := filepath.Join(t.TempDir(), "dump.gdb")
+
+	s := NewServer(1).(*server)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.AutoSave(path, []SaveRule{{time.Hour, 1}, {0, 2}}, stop)
+		close(done)
+	}()
+
+	s.AddDirty(1)
+	time.Sleep(20 * time.Millisecond)
+	if _, err := os.Stat(path); err == nil {
+		t.Errorf("expected no save after a single change")
+	}
+	s.AddDirty(1)
+	for i := 0; i < 100 && s.Dirty() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+	waitBgSave(t, s)
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the snapshot file to exist: %s", err)
+	}
+}
+
+func TestParseSaveRules(t *testing.T) {
+	cases := []struct {
+		in  string
+		exp []SaveRule
+		err bool
+	}{
+		0: {"", []SaveRule{}, false},
+		1: {"3600 1 300 100", []SaveRule{{time.Hour, 1}, {5 * time.Minute, 100}}, false},
+		2: {"3600", nil, true},
+		3: {"a 1", nil, true},
+		4: {"60 -1", nil, true},
+	}
+	for i, c := range cases {
+		got, err := ParseSaveRules(c.in)
+		if (err != nil) != c.err {
+			t.Errorf("%d: expected error %t, got %v", i, c.err, err)
+		}
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Command rdbimport imports the keys of an RDB file saved by Redis in a gred
+snapshot file, so that they are loaded when the gred server starts. It can
+also dump the keys of the RDB file as JSON, for inspection.
+
+# Usage
+
+An example command-line usage is:
+
+	rdbimport -o dump.gdb dump.rdb
+
+rdbimport supports the following flags:
+
+	-o         : output file, defaults to dump.gdb, or to stdout with -json.
+	-json      : dump the keys as JSON instead of importing them.
+	-databases : number of databases of the snapshot, defaults to 16.
+
+The RDB files of versions 6 to 11 are supported, that is those saved by
+Redis 2.8 to 7.2. The streams, modules and functions are not supported.
+
+# JSON output
+
+The JSON output has the following format:
+
+	{
+	    "version": 11,
+	    "aux": {"redis-ver": "7.2.0"},
+	    "keys": [
+	        {"db": 0, "key": "k", "type": "string", "value": "v"},
+	        {"db": 0, "key": "l", "type": "list", "expireat": 1700000000000, "value": ["a", "b"]}
+	    ]
+	}
+
+The expireat field is the unix time in milliseconds at which the key expires,
+if it does. The value of a hash is an object, that of a set is a sorted array
+of members, and that of a sorted set an array of member and score objects.
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/PuerkitoBio/gred/cmd"
+	"github.com/PuerkitoBio/gred/rdb"
+	"github.com/PuerkitoBio/gred/srv"
+	"github.com/PuerkitoBio/gred/types"
+)
+
+var (
+	output    = flag.String("o", "", "output file (defaults to dump.gdb, or to stdout with -json)")
+	asJSON    = flag.Bool("json", false, "dump the keys as JSON instead of importing them")
+	databases = flag.Int("databases", srv.DefaultDatabases, "number of databases of the snapshot")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: rdbimport [-json] [-o FILE] [-databases N] RDBFILE")
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	if *asJSON {
+		out := os.Stdout
+		if *output != "" {
+			out, err = os.Create(*output)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer out.Close()
+		}
+		if err := dumpJSON(f, out); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	path := *output
+	if path == "" {
+		path = srv.SnapshotFile
+	}
+	if *databases < 1 {
+		log.Fatalf("invalid number of databases: %d", *databases)
+	}
+	srv.DefaultServer = srv.NewServer(*databases)
+	srv.DefaultServer.Lock()
+	defer srv.DefaultServer.Unlock()
+	n, err := rdb.Load(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := srv.DefaultServer.Save(path); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("imported %d keys in %s\n", n, path)
+}
+
+// jsonFile is the JSON representation of an RDB file.
+type jsonFile struct {
+	Version int               `json:"version"`
+	Aux     map[string]string `json:"aux"`
+	Keys    []jsonKey         `json:"keys"`
+}
+
+// jsonKey is the JSON representation of a key of an RDB file.
+type jsonKey struct {
+	DB       int         `json:"db"`
+	Key      string      `json:"key"`
+	Type     string      `json:"type"`
+	ExpireAt int64       `json:"expireat,omitempty"`
+	Value    interface{} `json:"value"`
+}
+
+// jsonMember is the JSON representation of a member of a sorted set. The
+// score is a string, as the infinities have no JSON representation.
+type jsonMember struct {
+	Member string `json:"member"`
+	Score  string `json:"score"`
+}
+
+// dumpJSON writes the keys of the RDB file read from r to w, as JSON.
+func dumpJSON(r io.Reader, w io.Writer) error {
+	rr, err := rdb.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	jf := jsonFile{Version: rr.Version, Keys: []jsonKey{}}
+	for {
+		e, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		jk := jsonKey{DB: e.DB, Key: e.Key, Type: e.Value.Type(), Value: jsonValue(e.Value)}
+		if !e.ExpireAt.IsZero() {
+			jk.ExpireAt = e.ExpireAt.UnixNano() / int64(time.Millisecond)
+		}
+		jf.Keys = append(jf.Keys, jk)
+	}
+	jf.Aux = rr.Aux
+
+	b, err := json.MarshalIndent(jf, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// jsonValue returns the JSON representation of the value v.
+func jsonValue(v types.Value) interface{} {
+	switch v := v.(type) {
+	case types.String:
+		return v.Get()
+	case types.List:
+		return v.LRange(0, -1)
+	case types.Set:
+		mbrs := v.SMembers()
+		sort.Strings(mbrs)
+		return mbrs
+	case types.Hash:
+		vals := v.HGetAll()
+		m := make(map[string]string, len(vals)/2)
+		for i := 0; i+1 < len(vals); i += 2 {
+			m[vals[i]] = vals[i+1]
+		}
+		return m
+	case types.SortedSet:
+		sms := v.ZRange(0, -1, false)
+		mbrs := make([]jsonMember, len(sms))
+		for i, sm := range sms {
+			mbrs[i] = jsonMember{sm.Member, cmd.FormatFloat(sm.Score)}
+		}
+		return mbrs
+	}
+	return nil
+}
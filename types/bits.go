@@ -0,0 +1,273 @@
+package types
+
+import (
+	"math"
+	"math/bits"
+)
+
+// BitRange is a range of a String used by the bit operations. Both bounds
+// are inclusive, and negative bounds are relative to the end of the string.
+// The bounds are byte offsets, unless Bit is true, in which case they are
+// bit offsets.
+type BitRange struct {
+	Start, End int64
+	Bit        bool
+}
+
+// BitField is an integer stored at an arbitrary bit offset of a String,
+// using Bits bits (1 to 64 if Signed, 1 to 63 otherwise), most significant
+// bit first.
+type BitField struct {
+	Offset int64
+	Bits   uint
+	Signed bool
+}
+
+// BitFieldOverflow defines the behaviour of the bit field write operations
+// when the resulting value does not fit in the bit field.
+type BitFieldOverflow int
+
+// List of overflow behaviours.
+const (
+	// OverflowWrap wraps around the value, modulo the range of the field.
+	OverflowWrap BitFieldOverflow = iota
+	// OverflowSat saturates the value to the minimum or maximum value of the field.
+	OverflowSat
+	// OverflowFail fails the operation, leaving the field unmodified.
+	OverflowFail
+)
+
+// GetBit returns the value of the bit at offset ofs, which is 0 if the
+// offset is beyond the end of the string. Bit 0 is the most significant
+// bit of the first byte.
+func (s *stringval) GetBit(ofs int64) int {
+	i := ofs >> 3
+	if i >= int64(len(*s)) {
+		return 0
+	}
+	return int((*s)[i]>>(7-uint(ofs&7))) & 1
+}
+
+// SetBit sets the bit at offset ofs to v, which must be 0 or 1, growing
+// the string with 0 bytes if required. It returns the previous value of
+// the bit.
+func (s *stringval) SetBit(ofs int64, v int) int {
+	b := s.grow((ofs >> 3) + 1)
+	old := setBit(b, ofs, v)
+	*s = stringval(b)
+	return old
+}
+
+// BitCount returns the number of bits set to 1 in the range r.
+func (s *stringval) BitCount(r BitRange) int64 {
+	if r.Start < 0 && r.End < 0 && r.Start > r.End {
+		return 0
+	}
+	start, end, ok := s.bitRange(r)
+	if !ok {
+		return 0
+	}
+
+	var cnt int64
+	for i := start; i <= end; {
+		if i&7 == 0 && i+7 <= end {
+			cnt += int64(bits.OnesCount8((*s)[i>>3]))
+			i += 8
+			continue
+		}
+		cnt += int64(s.GetBit(i))
+		i++
+	}
+	return cnt
+}
+
+// BitPos returns the offset of the first bit set to bit in the range r,
+// or -1 if there is none. If bit is 0 and the end of the range was not
+// explicitly specified (as indicated by endSet), the string is considered
+// padded with 0 bits on the right, so the offset of the first bit after the
+// range is returned if it contains only bits set to 1.
+func (s *stringval) BitPos(bit int, r BitRange, endSet bool) int64 {
+	start, end, ok := s.bitRange(r)
+	if !ok {
+		return -1
+	}
+
+	// Skip the whole bytes that cannot match
+	var skip byte
+	if bit == 0 {
+		skip = 0xff
+	}
+	for i := start; i <= end; {
+		if i&7 == 0 && i+7 <= end && (*s)[i>>3] == skip {
+			i += 8
+			continue
+		}
+		if s.GetBit(i) == bit {
+			return i
+		}
+		i++
+	}
+	if bit == 1 || endSet {
+		return -1
+	}
+	return end + 1
+}
+
+// bitRange returns the bit offsets of the range r, with the negative bounds
+// converted and clamped to the length of the string. It returns false if
+// the range is empty.
+func (s *stringval) bitRange(r BitRange) (int64, int64, bool) {
+	ln := int64(len(*s))
+	if r.Bit {
+		ln <<= 3
+	}
+	start, end := r.Start, r.End
+	if start < 0 {
+		start += ln
+	}
+	if end < 0 {
+		end += ln
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= ln {
+		end = ln - 1
+	}
+	if start > end {
+		return 0, 0, false
+	}
+	if !r.Bit {
+		start, end = start<<3, end<<3|7
+	}
+	return start, end, true
+}
+
+// BitFieldGet returns the value of the bit field f.
+func (s *stringval) BitFieldGet(f BitField) int64 {
+	var v uint64
+	for i := int64(0); i < int64(f.Bits); i++ {
+		v = v<<1 | uint64(s.GetBit(f.Offset+i))
+	}
+	if f.Signed && f.Bits < 64 && v&(1<<(f.Bits-1)) != 0 {
+		v |= ^uint64(0) << f.Bits
+	}
+	return int64(v)
+}
+
+// BitFieldSet sets the bit field f to v, growing the string with 0 bytes
+// if required. If v does not fit in the field, the overflow behaviour ow
+// is applied. It returns the previous value of the field, or false if the
+// field was not set because of an overflow with OverflowFail.
+func (s *stringval) BitFieldSet(f BitField, v int64, ow BitFieldOverflow) (int64, bool) {
+	s.growBits(f)
+	old := s.BitFieldGet(f)
+	nv, ok := f.overflow(v, 0, ow)
+	if !ok {
+		return 0, false
+	}
+	s.setBits(f, nv)
+	return old, true
+}
+
+// BitFieldIncrBy increments the bit field f by incr, growing the string
+// with 0 bytes if required. If the resulting value does not fit in the
+// field, the overflow behaviour ow is applied. It returns the new value
+// of the field, or false if the field was not set because of an overflow
+// with OverflowFail.
+func (s *stringval) BitFieldIncrBy(f BitField, incr int64, ow BitFieldOverflow) (int64, bool) {
+	s.growBits(f)
+	nv, ok := f.overflow(s.BitFieldGet(f), incr, ow)
+	if !ok {
+		return 0, false
+	}
+	s.setBits(f, nv)
+	return nv, true
+}
+
+// growBits grows the string so that it can hold the bit field f.
+func (s *stringval) growBits(f BitField) {
+	if n := (f.Offset+int64(f.Bits)-1)>>3 + 1; n > int64(len(*s)) {
+		*s = stringval(s.grow(n))
+	}
+}
+
+// setBits stores v in the bit field f. The string must be large enough to
+// hold the field.
+func (s *stringval) setBits(f BitField, v int64) {
+	b := []byte(*s)
+	for i := int64(0); i < int64(f.Bits); i++ {
+		setBit(b, f.Offset+i, int(uint64(v)>>(f.Bits-1-uint(i)))&1)
+	}
+	*s = stringval(b)
+}
+
+// grow returns a copy of the string as a slice of bytes of at least n bytes,
+// padded with 0 bytes.
+func (s *stringval) grow(n int64) []byte {
+	if n < int64(len(*s)) {
+		n = int64(len(*s))
+	}
+	b := make([]byte, n)
+	copy(b, *s)
+	return b
+}
+
+// setBit sets the bit at offset ofs of b to v, and returns its previous value.
+func setBit(b []byte, ofs int64, v int) int {
+	i, sh := ofs>>3, 7-uint(ofs&7)
+	old := int(b[i]>>sh) & 1
+	b[i] &^= 1 << sh
+	b[i] |= byte(v&1) << sh
+	return old
+}
+
+// overflow returns the value of the field after incrementing v by incr,
+// applying the overflow behaviour ow if the result does not fit in the
+// field. It returns false if the result overflows and ow is OverflowFail.
+func (f BitField) overflow(v, incr int64, ow BitFieldOverflow) (int64, bool) {
+	var over, under bool
+	var max, min int64
+	if f.Signed {
+		max = math.MaxInt64
+		if f.Bits < 64 {
+			max = 1<<(f.Bits-1) - 1
+		}
+		min = -max - 1
+		over = v > max || (incr > 0 && v > max-incr)
+		under = v < min || (incr < 0 && v < min-incr)
+	} else {
+		umax := uint64(1)<<f.Bits - 1
+		uv := uint64(v)
+		over = uv > umax || (incr > 0 && uint64(incr) > umax-uv)
+		under = !over && incr < 0 && uint64(-incr) > uv
+		max = int64(umax)
+	}
+
+	switch {
+	case !over && !under:
+		return v + incr, true
+	case ow == OverflowFail:
+		return 0, false
+	case ow == OverflowSat && over:
+		return max, true
+	case ow == OverflowSat:
+		return min, true
+	}
+
+	// Wrap around, keeping only the bits of the field, sign-extended if
+	// the field is signed.
+	c := uint64(v) + uint64(incr)
+	if f.Bits < 64 {
+		mask := ^uint64(0) << f.Bits
+		if f.Signed && c&(1<<(f.Bits-1)) != 0 {
+			c |= mask
+		} else {
+			c &^= mask
+		}
+	}
+	return int64(c), true
+}
@@ -0,0 +1,208 @@
+package types
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStringGetBit(t *testing.T) {
+	cases := []struct {
+		s   string
+		ofs int64
+		exp int
+	}{
+		0: {"", 0, 0},
+		1: {"\x80", 0, 1},
+		2: {"\x80", 1, 0},
+		3: {"\x01", 7, 1},
+		4: {"\x01", 8, 0},
+		5: {"\x00\x40", 9, 1},
+		6: {"a", 100, 0},
+	}
+	for i, c := range cases {
+		s := NewString(c.s)
+		got := s.GetBit(c.ofs)
+		if got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+	}
+}
+
+func TestStringSetBit(t *testing.T) {
+	cases := []struct {
+		s    string
+		ofs  int64
+		v    int
+		exp  int
+		news string
+	}{
+		0: {"", 0, 1, 0, "\x80"},
+		1: {"", 7, 1, 0, "\x01"},
+		2: {"", 9, 0, 0, "\x00\x00"},
+		3: {"\xff", 0, 0, 1, "\x7f"},
+		4: {"\xff", 7, 1, 1, "\xff"},
+		5: {"a", 23, 1, 0, "a\x00\x01"},
+	}
+	for i, c := range cases {
+		s := NewString(c.s)
+		got := s.SetBit(c.ofs, c.v)
+		if got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+		if s.Get() != c.news {
+			t.Errorf("%d: expected %q, got %q", i, c.news, s.Get())
+		}
+	}
+}
+
+func TestStringBitCount(t *testing.T) {
+	cases := []struct {
+		s   string
+		r   BitRange
+		exp int64
+	}{
+		0:  {"", BitRange{0, -1, false}, 0},
+		1:  {"foobar", BitRange{0, -1, false}, 26},
+		2:  {"foobar", BitRange{0, 0, false}, 4},
+		3:  {"foobar", BitRange{1, 1, false}, 6},
+		4:  {"foobar", BitRange{1, -2, false}, 18},
+		5:  {"foobar", BitRange{5, 30, true}, 17},
+		6:  {"foobar", BitRange{-100, 100, false}, 26},
+		7:  {"foobar", BitRange{3, 1, false}, 0},
+		8:  {"foobar", BitRange{-1, -5, false}, 0},
+		9:  {"foobar", BitRange{-100, -200, false}, 0},
+		10: {"\xff\xff", BitRange{-1, -1, true}, 1},
+	}
+	for i, c := range cases {
+		s := NewString(c.s)
+		got := s.BitCount(c.r)
+		if got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+	}
+}
+
+func TestStringBitPos(t *testing.T) {
+	cases := []struct {
+		s      string
+		bit    int
+		r      BitRange
+		endSet bool
+		exp    int64
+	}{
+		0:  {"\xff\xf0\x00", 0, BitRange{0, -1, false}, false, 12},
+		1:  {"\x00\xff\xf0", 1, BitRange{0, -1, false}, false, 8},
+		2:  {"\x00\xff\xf0", 1, BitRange{2, -1, false}, false, 16},
+		3:  {"\x00\xff\xf0", 1, BitRange{7, 15, true}, true, 8},
+		4:  {"\x00\x00\x00", 1, BitRange{0, -1, false}, false, -1},
+		5:  {"\xff\xff\xff", 0, BitRange{0, -1, false}, false, 24},
+		6:  {"\xff\xff\xff", 0, BitRange{0, -1, false}, true, -1},
+		7:  {"\xff\xff\xff", 0, BitRange{1, -1, false}, false, 24},
+		8:  {"\xff\xff\xff", 0, BitRange{0, 1, false}, true, -1},
+		9:  {"", 0, BitRange{0, -1, false}, false, -1},
+		10: {"\x00\xff", 1, BitRange{2, 1, false}, true, -1},
+		11: {"\xfe", 0, BitRange{0, 6, true}, true, -1},
+		12: {"\xfe", 0, BitRange{0, 7, true}, true, 7},
+	}
+	for i, c := range cases {
+		s := NewString(c.s)
+		got := s.BitPos(c.bit, c.r, c.endSet)
+		if got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+	}
+}
+
+func TestStringBitFieldGet(t *testing.T) {
+	cases := []struct {
+		s   string
+		f   BitField
+		exp int64
+	}{
+		0: {"", BitField{0, 8, false}, 0},
+		1: {"\xff", BitField{0, 8, false}, 255},
+		2: {"\xff", BitField{0, 8, true}, -1},
+		3: {"\x0f\xf0", BitField{4, 8, false}, 255},
+		4: {"\x0f\xf0", BitField{4, 4, true}, -1},
+		5: {"\x0f\xf0", BitField{6, 4, false}, 15},
+		6: {"\x7f\xff\xff\xff\xff\xff\xff\xff", BitField{0, 64, true}, math.MaxInt64},
+		7: {"\x80\x00\x00\x00\x00\x00\x00\x00", BitField{0, 64, true}, math.MinInt64},
+		8: {"\x80", BitField{0, 1, true}, -1},
+	}
+	for i, c := range cases {
+		s := NewString(c.s)
+		got := s.BitFieldGet(c.f)
+		if got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+	}
+}
+
+func TestStringBitFieldSet(t *testing.T) {
+	cases := []struct {
+		s    string
+		f    BitField
+		v    int64
+		ow   BitFieldOverflow
+		exp  int64
+		ok   bool
+		news string
+	}{
+		0: {"", BitField{0, 8, false}, 255, OverflowWrap, 0, true, "\xff"},
+		1: {"", BitField{4, 8, false}, 255, OverflowWrap, 0, true, "\x0f\xf0"},
+		2: {"\xff", BitField{0, 8, false}, 1, OverflowWrap, 255, true, "\x01"},
+		3: {"", BitField{0, 8, false}, 256, OverflowWrap, 0, true, "\x00"},
+		4: {"", BitField{0, 8, false}, 256, OverflowSat, 0, true, "\xff"},
+		5: {"", BitField{0, 8, false}, 256, OverflowFail, 0, false, "\x00"},
+		6: {"", BitField{0, 8, false}, -1, OverflowSat, 0, true, "\xff"},
+		7: {"", BitField{0, 8, true}, -1, OverflowWrap, 0, true, "\xff"},
+		8: {"", BitField{0, 8, true}, 128, OverflowWrap, 0, true, "\x80"},
+		9: {"", BitField{0, 8, true}, -200, OverflowSat, 0, true, "\x80"},
+	}
+	for i, c := range cases {
+		s := NewString(c.s)
+		got, ok := s.BitFieldSet(c.f, c.v, c.ow)
+		if got != c.exp || ok != c.ok {
+			t.Errorf("%d: expected %d %t, got %d %t", i, c.exp, c.ok, got, ok)
+		}
+		if s.Get() != c.news {
+			t.Errorf("%d: expected %q, got %q", i, c.news, s.Get())
+		}
+	}
+}
+
+func TestStringBitFieldIncrBy(t *testing.T) {
+	cases := []struct {
+		s    string
+		f    BitField
+		incr int64
+		ow   BitFieldOverflow
+		exp  int64
+		ok   bool
+	}{
+		0:  {"", BitField{0, 8, false}, 10, OverflowWrap, 10, true},
+		1:  {"\xff", BitField{0, 8, false}, 1, OverflowWrap, 0, true},
+		2:  {"\xff", BitField{0, 8, false}, 1, OverflowSat, 255, true},
+		3:  {"\xff", BitField{0, 8, false}, 1, OverflowFail, 0, false},
+		4:  {"\x01", BitField{0, 8, false}, -2, OverflowWrap, 255, true},
+		5:  {"\x01", BitField{0, 8, false}, -2, OverflowSat, 0, true},
+		6:  {"\x7f", BitField{0, 8, true}, 1, OverflowWrap, -128, true},
+		7:  {"\x7f", BitField{0, 8, true}, 1, OverflowSat, 127, true},
+		8:  {"\x80", BitField{0, 8, true}, -1, OverflowSat, -128, true},
+		9:  {"\x80", BitField{0, 8, true}, -1, OverflowWrap, 127, true},
+		10: {"\x7f\xff\xff\xff\xff\xff\xff\xff", BitField{0, 64, true}, 1, OverflowWrap, math.MinInt64, true},
+		11: {"\x7f\xff\xff\xff\xff\xff\xff\xff", BitField{0, 64, true}, 1, OverflowSat, math.MaxInt64, true},
+		12: {"", BitField{0, 63, false}, -1, OverflowSat, 0, true},
+		13: {"", BitField{0, 63, false}, math.MinInt64, OverflowFail, 0, false},
+	}
+	for i, c := range cases {
+		s := NewString(c.s)
+		got, ok := s.BitFieldIncrBy(c.f, c.incr, c.ow)
+		if got != c.exp || ok != c.ok {
+			t.Errorf("%d: expected %d %t, got %d %t", i, c.exp, c.ok, got, ok)
+		}
+		if ok && s.BitFieldGet(c.f) != got {
+			t.Errorf("%d: expected field to hold %d, got %d", i, got, s.BitFieldGet(c.f))
+		}
+	}
+}
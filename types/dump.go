@@ -0,0 +1,390 @@
+package types
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"math"
+	"sort"
+)
+
+// DumpVersion is the version of the serialization format written by Dump.
+// Restore accepts payloads of this version or of a previous one.
+const DumpVersion = 1
+
+var (
+	// ErrDumpPayload is returned by Restore when the payload has a bad
+	// checksum or an unknown version.
+	ErrDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+
+	// ErrDumpFormat is returned by Restore when the payload has a valid
+	// checksum but its value cannot be decoded.
+	ErrDumpFormat = errors.New("bad DUMP payload data format")
+)
+
+// The type tags of the serialized values.
+const (
+	dumpString byte = iota
+	dumpList
+	dumpSet
+	dumpSortedSet
+	dumpHash
+	dumpStream
+)
+
+// dumpFooterSize is the size of the version and checksum that end a
+// payload.
+const dumpFooterSize = 2 + 8
+
+// crcTable is the table of the CRC-64 with the Jones polynomial, in its
+// reflected form.
+var crcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// CRC64 updates the checksum crc with the bytes of p, and returns the new
+// checksum. It is the CRC-64 used by Redis to protect DUMP payloads and
+// RDB files (Jones polynomial, reflected, no final xor), so a new checksum
+// starts with a crc of 0.
+func CRC64(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crcTable[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc
+}
+
+// Dump serializes the value. The payload starts with a tag that identifies
+// the type of the value, followed by the encoded value, the DumpVersion as
+// a 16-bit integer and the CRC64 of all the preceding bytes, both in
+// little-endian order. Strings and lengths are prefixed by their length,
+// and all integers are encoded as varints.
+func Dump(v Value) []byte {
+	var e encoder
+	switch v := v.(type) {
+	case String:
+		e.byte(dumpString)
+		e.str(v.Get())
+	case List:
+		e.byte(dumpList)
+		e.strs(v.LRange(0, -1))
+	case Set:
+		e.byte(dumpSet)
+		e.strs(v.SMembers())
+	case SortedSet:
+		e.byte(dumpSortedSet)
+		sms := v.ZRange(0, -1, false)
+		e.uint(uint64(len(sms)))
+		for _, sm := range sms {
+			e.str(sm.Member)
+			e.float(sm.Score)
+		}
+	case Hash:
+		e.byte(dumpHash)
+		e.strs(v.HGetAll())
+	case *stream:
+		e.byte(dumpStream)
+		e.stream(v)
+	default:
+		panic("types: Dump not implemented for type " + v.Type())
+	}
+
+	e.b = append(e.b, 0, 0)
+	binary.LittleEndian.PutUint16(e.b[len(e.b)-2:], DumpVersion)
+	crc := CRC64(0, e.b)
+	e.b = append(e.b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(e.b[len(e.b)-8:], crc)
+	return e.b
+}
+
+// Restore deserializes a value serialized by Dump. It returns ErrDumpPayload
+// if the checksum is wrong or if the version is unknown, and ErrDumpFormat
+// if the value cannot be decoded.
+func Restore(p []byte) (Value, error) {
+	if len(p) < 1+dumpFooterSize {
+		return nil, ErrDumpPayload
+	}
+	n := len(p) - dumpFooterSize
+	ver := binary.LittleEndian.Uint16(p[n:])
+	crc := binary.LittleEndian.Uint64(p[n+2:])
+	if ver < 1 || ver > DumpVersion || crc != CRC64(0, p[:n+2]) {
+		return nil, ErrDumpPayload
+	}
+
+	d := decoder{b: p[:n]}
+	var v Value
+	switch d.byte() {
+	case dumpString:
+		v = NewIncString(d.str())
+	case dumpList:
+		l := NewList()
+		if vals := d.strs(); len(vals) > 0 {
+			l.RPush(vals...)
+		}
+		v = l
+	case dumpSet:
+		s := NewSet()
+		for _, mbr := range d.strs() {
+			if s.SAdd(mbr) == 0 {
+				d.fail()
+			}
+		}
+		v = s
+	case dumpSortedSet:
+		z := NewSortedSet()
+		for i, n := 0, d.len(); i < n; i++ {
+			mbr, score := d.str(), d.float()
+			if math.IsNaN(score) || !z.ZAdd(score, mbr) {
+				d.fail()
+			}
+		}
+		v = z
+	case dumpHash:
+		h := NewIncHash()
+		vals := d.strs()
+		if len(vals)%2 != 0 {
+			d.fail()
+		}
+		for i := 0; i+1 < len(vals); i += 2 {
+			if !h.HSet(vals[i], vals[i+1]) {
+				d.fail()
+			}
+		}
+		v = h
+	case dumpStream:
+		v = d.stream()
+	default:
+		d.fail()
+	}
+
+	if d.err == nil && len(d.b) > 0 {
+		d.fail()
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return v, nil
+}
+
+// encoder appends the encoded values to its buffer.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) byte(b byte) {
+	e.b = append(e.b, b)
+}
+
+func (e *encoder) uint(u uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], u)
+	e.b = append(e.b, buf[:n]...)
+}
+
+func (e *encoder) int(i int64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], i)
+	e.b = append(e.b, buf[:n]...)
+}
+
+func (e *encoder) float(f float64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+	e.b = append(e.b, buf[:]...)
+}
+
+func (e *encoder) str(s string) {
+	e.uint(uint64(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) strs(vals []string) {
+	e.uint(uint64(len(vals)))
+	for _, s := range vals {
+		e.str(s)
+	}
+}
+
+func (e *encoder) id(id StreamID) {
+	e.uint(id.Ms)
+	e.uint(id.Seq)
+}
+
+// stream encodes the entries of the stream, its metadata and its consumer
+// groups, sorted by name.
+func (e *encoder) stream(s *stream) {
+	e.uint(uint64(s.length))
+	for _, n := range s.nodes {
+		for _, ent := range n.entries {
+			e.id(ent.ID)
+			e.strs(ent.Fields)
+		}
+	}
+	e.id(s.lastID)
+	e.id(s.maxDeletedID)
+	e.int(s.entriesAdded)
+
+	groups := s.Groups()
+	e.uint(uint64(len(groups)))
+	for _, sg := range groups {
+		g := sg.(*streamGroup)
+		e.str(g.name)
+		e.id(g.lastID)
+		e.uint(uint64(len(g.pelIDs)))
+		for _, id := range g.pelIDs {
+			pe := g.pel[id]
+			e.id(pe.ID)
+			e.str(pe.Consumer)
+			e.int(pe.DeliveryTime)
+			e.int(pe.DeliveryCount)
+		}
+
+		names := make([]string, 0, len(g.consumers))
+		for nm := range g.consumers {
+			names = append(names, nm)
+		}
+		sort.Strings(names)
+		e.uint(uint64(len(names)))
+		for _, nm := range names {
+			e.str(nm)
+			e.int(g.consumers[nm].SeenTime)
+		}
+	}
+}
+
+// decoder decodes the values from its buffer. Once an error occurs, all
+// calls return zero values and err is set to ErrDumpFormat.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) fail() {
+	d.err = ErrDumpFormat
+	d.b = nil
+}
+
+func (d *decoder) byte() byte {
+	if len(d.b) < 1 {
+		d.fail()
+		return 0
+	}
+	b := d.b[0]
+	d.b = d.b[1:]
+	return b
+}
+
+func (d *decoder) uint() uint64 {
+	u, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return u
+}
+
+func (d *decoder) int() int64 {
+	i, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return i
+}
+
+func (d *decoder) float() float64 {
+	if len(d.b) < 8 {
+		d.fail()
+		return 0
+	}
+	f := math.Float64frombits(binary.LittleEndian.Uint64(d.b))
+	d.b = d.b[8:]
+	return f
+}
+
+// len decodes a number of elements. Each element takes at least one byte,
+// so a number greater than the remaining bytes is invalid, which prevents
+// huge allocations for corrupted payloads.
+func (d *decoder) len() int {
+	u := d.uint()
+	if u > uint64(len(d.b)) {
+		d.fail()
+		return 0
+	}
+	return int(u)
+}
+
+func (d *decoder) str() string {
+	n := d.len()
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+func (d *decoder) strs() []string {
+	vals := make([]string, d.len())
+	for i := range vals {
+		vals[i] = d.str()
+	}
+	return vals
+}
+
+func (d *decoder) id() StreamID {
+	return StreamID{d.uint(), d.uint()}
+}
+
+// stream decodes a stream encoded by encoder.stream.
+func (d *decoder) stream() *stream {
+	s := &stream{}
+	for i, n := 0, d.len(); i < n && d.err == nil; i++ {
+		id, fields := d.id(), d.strs()
+		if !s.lastID.Less(id) || len(fields) == 0 || len(fields)%2 != 0 {
+			d.fail()
+			break
+		}
+		s.XAdd(id, fields)
+	}
+	lastID := d.id()
+	if lastID.Less(s.lastID) {
+		d.fail()
+	}
+	s.lastID = lastID
+	s.maxDeletedID = d.id()
+	s.entriesAdded = d.int()
+
+	for i, n := 0, d.len(); i < n && d.err == nil; i++ {
+		name := d.str()
+		if _, ok := s.groups[name]; ok {
+			d.fail()
+			break
+		}
+		g := newStreamGroup(name, s, d.id())
+		pes := make([]*PendingEntry, d.len())
+		for j := range pes {
+			pes[j] = &PendingEntry{
+				ID:            d.id(),
+				Consumer:      d.str(),
+				DeliveryTime:  d.int(),
+				DeliveryCount: d.int(),
+			}
+		}
+		for j, n := 0, d.len(); j < n; j++ {
+			nm := d.str()
+			g.consumers[nm] = &StreamConsumer{Name: nm, SeenTime: d.int()}
+		}
+		for _, pe := range pes {
+			c, ok := g.consumers[pe.Consumer]
+			if _, dup := g.pel[pe.ID]; !ok || dup {
+				d.fail()
+				break
+			}
+			g.pending(pe.ID)
+			g.pel[pe.ID] = pe
+			c.Pending++
+		}
+		if s.groups == nil {
+			s.groups = make(map[string]*streamGroup)
+		}
+		s.groups[name] = g
+	}
+	return s
+}
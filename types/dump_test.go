@@ -0,0 +1,147 @@
+package types
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCRC64(t *testing.T) {
+	// Check value of the CRC-64 used by Redis
+	if got := CRC64(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("expected %x, got %x", uint64(0xe9c6d914c4b8d9ca), got)
+	}
+	if got := CRC64(CRC64(0, []byte("1234")), []byte("56789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("expected %x for incremental update, got %x", uint64(0xe9c6d914c4b8d9ca), got)
+	}
+}
+
+func TestDumpRestore(t *testing.T) {
+	l := NewList()
+	l.RPush("c", "a", "b", "a")
+	s := NewSet()
+	s.SAdd("z", "y", "x")
+	z := NewSortedSet()
+	z.ZAdd(2, "b")
+	z.ZAdd(-1.5, "a")
+	z.ZAdd(2, "c")
+	st := streamFromIDs(3)
+	st.XAdd(StreamID{4, 1}, []string{"f1", "v1", "f2", "v2"})
+	st.XDel(StreamID{2, 0})
+	st.CreateGroup("g2", StreamID{})
+	st.CreateGroup("g1", StreamID{3, 0})
+	g, _ := st.Group("g2")
+	g.ReadNew("c1", 2, false, 100)
+	g.ReadNew("c2", -1, false, 200)
+	g.CreateConsumer("c3", 300)
+	g.Ack(StreamID{3, 0})
+
+	cases := []Value{
+		NewString(""),
+		NewIncString("abc"),
+		NewIncString(string([]byte{0, 1, 2, 255})),
+		NewList(),
+		l,
+		s,
+		z,
+		NewStream(),
+		st,
+	}
+	for i, v := range cases {
+		p := Dump(v)
+		got, err := Restore(p)
+		if err != nil {
+			t.Errorf("%d: expected no error, got %v", i, err)
+			continue
+		}
+		if got.Type() != v.Type() {
+			t.Errorf("%d: expected type %s, got %s", i, v.Type(), got.Type())
+		}
+		if p2 := Dump(got); !bytes.Equal(p, p2) {
+			t.Errorf("%d: expected %x, got %x", i, p, p2)
+		}
+	}
+
+	// Restored strings and hashes support the increment commands
+	if _, ok := cases[1].(IncString); !ok {
+		t.Errorf("expected restored string to be an IncString")
+	}
+	rs, _ := Restore(Dump(st))
+	rg, _ := rs.(Stream).Group("g2")
+	if n, lo, hi, cs := rg.PendingSummary(); n != 2 || lo != (StreamID{1, 0}) || hi != (StreamID{4, 1}) || len(cs) != 2 {
+		t.Errorf("expected 2 pending from 1-0 to 4-1 by 2 consumers, got %d %v %v %v", n, lo, hi, cs)
+	}
+	if info := rs.(Stream).Info(); info != st.Info() {
+		t.Errorf("expected %+v, got %+v", st.Info(), info)
+	}
+}
+
+func TestDumpRestoreHash(t *testing.T) {
+	h := NewHash()
+	h.HMSet("a", "1", "b", "", "c", "3")
+	v, err := Restore(Dump(h))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ih, ok := v.(IncHash)
+	if !ok {
+		t.Fatalf("expected an IncHash, got %T", v)
+	}
+	if n, ok := ih.HIncrBy("c", 1); !ok || n != 4 {
+		t.Errorf("expected 4, got %d %t", n, ok)
+	}
+	got := ih.HKeys()
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("expected [a b c], got %v", got)
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	valid := Dump(NewIncString("abc"))
+
+	// withFooter returns the body followed by a valid footer of the version
+	withFooter := func(body []byte, ver uint16) []byte {
+		p := append([]byte{}, body...)
+		p = append(p, 0, 0)
+		binary.LittleEndian.PutUint16(p[len(p)-2:], ver)
+		p = append(p, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(p[len(p)-8:], CRC64(0, p[:len(p)-8]))
+		return p
+	}
+
+	badCRC := append([]byte{}, valid...)
+	badCRC[len(badCRC)-1] ^= 1
+	badVal := append([]byte{}, valid...)
+	badVal[1] = 'x'
+
+	cases := []struct {
+		p   []byte
+		err error
+	}{
+		0:  {nil, ErrDumpPayload},
+		1:  {valid[:len(valid)-1], ErrDumpPayload},
+		2:  {badCRC, ErrDumpPayload},
+		3:  {badVal, ErrDumpPayload},
+		4:  {withFooter([]byte{dumpString, 3, 'a', 'b', 'c'}, DumpVersion+1), ErrDumpPayload},
+		5:  {withFooter([]byte{dumpString, 3, 'a', 'b', 'c'}, 0), ErrDumpPayload},
+		6:  {withFooter([]byte{dumpString, 3, 'a', 'b', 'c'}, DumpVersion), nil},
+		7:  {withFooter([]byte{dumpString, 4, 'a', 'b', 'c'}, DumpVersion), ErrDumpFormat},
+		8:  {withFooter([]byte{dumpString, 2, 'a', 'b', 'c'}, DumpVersion), ErrDumpFormat},
+		9:  {withFooter([]byte{42, 0}, DumpVersion), ErrDumpFormat},
+		10: {withFooter([]byte{dumpSet, 2, 1, 'a', 1, 'a'}, DumpVersion), ErrDumpFormat},
+		11: {withFooter([]byte{dumpHash, 1, 1, 'a'}, DumpVersion), ErrDumpFormat},
+		12: {withFooter([]byte{dumpList, 0xff, 0xff, 0xff, 0xff, 0x0f}, DumpVersion), ErrDumpFormat},
+	}
+	for i, c := range cases {
+		v, err := Restore(c.p)
+		if err != c.err {
+			t.Errorf("%d: expected error %v, got %v", i, c.err, err)
+		}
+		if (err == nil) != (v != nil) {
+			t.Errorf("%d: expected a value only without error, got %v", i, v)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package types
+
+// The thresholds of the compact encodings. Small hashes, sets and lists
+// are stored in a listpack (or an intset for sets of integers), and are
+// converted to their regular encoding once they grow past those sizes. The
+// conversion is never reverted. They are meant to be set once, before any
+// value is created, and have the same meaning as the Redis configuration
+// parameters of the same name.
+var (
+	// HashMaxListpackEntries is the maximum number of fields of a hash
+	// stored in a listpack.
+	HashMaxListpackEntries = 128
+
+	// HashMaxListpackValue is the maximum length of the fields and values
+	// of a hash stored in a listpack.
+	HashMaxListpackValue = 64
+
+	// SetMaxIntsetEntries is the maximum number of members of a set
+	// stored in an intset.
+	SetMaxIntsetEntries = 512
+
+	// SetMaxListpackEntries is the maximum number of members of a set
+	// stored in a listpack.
+	SetMaxListpackEntries = 128
+
+	// SetMaxListpackValue is the maximum length of the members of a set
+	// stored in a listpack.
+	SetMaxListpackValue = 64
+
+	// ListMaxListpackSize is the maximum size of a list stored in a
+	// listpack. A positive value is a number of values, a negative value
+	// from -1 to -5 is a number of bytes: 4KB for -1, 8KB for -2, 16KB for
+	// -3, 32KB for -4 and 64KB for -5.
+	ListMaxListpackSize = -2
+)
+
+// embstrMaxLen is the maximum length of a string reported with the embstr
+// encoding.
+const embstrMaxLen = 44
+
+// Encoding returns the name of the internal encoding of the value, as
+// reported by the OBJECT ENCODING command.
+func Encoding(v Value) string {
+	switch v := v.(type) {
+	case *incString:
+		if v.enc == encInt {
+			return "int"
+		}
+		return Encoding(v.String)
+	case *stringval:
+		if _, ok := parseCanonicalInt(string(*v)); ok {
+			return "int"
+		}
+		if len(*v) <= embstrMaxLen {
+			return "embstr"
+		}
+		return "raw"
+	case *incHash:
+		return Encoding(v.Hash)
+	case *packedHash:
+		return "listpack"
+	case *hash:
+		return "hashtable"
+	case *list:
+		if v.lp != nil {
+			return "listpack"
+		}
+		return "quicklist"
+	case *set:
+		switch {
+		case v.is != nil:
+			return "intset"
+		case v.lp != nil:
+			return "listpack"
+		}
+		return "hashtable"
+	case *sortedSet:
+		return "skiplist"
+	case *stream:
+		return "stream"
+	}
+	return "unknown"
+}
+
+// listpackFits returns true if the listpack is small enough to encode a
+// list, according to ListMaxListpackSize.
+func listpackFits(lp *listpack) bool {
+	if ListMaxListpackSize >= 0 {
+		return lp.len() <= ListMaxListpackSize
+	}
+	n := -ListMaxListpackSize
+	if n > 5 {
+		n = 5
+	}
+	return lp.size() <= 4096<<uint(n-1)
+}
@@ -0,0 +1,239 @@
+package types
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// withThresholds sets the thresholds of the compact encodings, and returns
+// a function that restores their previous values.
+func withThresholds(hashEntries, setIntEntries, setEntries, listSize int) func() {
+	old := []int{HashMaxListpackEntries, SetMaxIntsetEntries, SetMaxListpackEntries, ListMaxListpackSize}
+	HashMaxListpackEntries, SetMaxIntsetEntries, SetMaxListpackEntries, ListMaxListpackSize = hashEntries, setIntEntries, setEntries, listSize
+	return func() {
+		HashMaxListpackEntries, SetMaxIntsetEntries, SetMaxListpackEntries, ListMaxListpackSize = old[0], old[1], old[2], old[3]
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	cases := []struct {
+		v   Value
+		exp string
+	}{
+		0:  {NewString("123"), "int"},
+		1:  {NewIncString("-9223372036854775808"), "int"},
+		2:  {NewIncString("9223372036854775808"), "embstr"},
+		3:  {NewIncString("0123"), "embstr"},
+		4:  {NewIncString(""), "embstr"},
+		5:  {NewIncString(strings.Repeat("a", 44)), "embstr"},
+		6:  {NewIncString(strings.Repeat("a", 45)), "raw"},
+		7:  {NewIncHash(), "listpack"},
+		8:  {NewHash(), "hashtable"},
+		9:  {NewList(), "listpack"},
+		10: {&list{}, "quicklist"},
+		11: {NewSet(), "intset"},
+		12: {NewSortedSet(), "skiplist"},
+		13: {NewStream(), "stream"},
+	}
+	for i, c := range cases {
+		if got := Encoding(c.v); got != c.exp {
+			t.Errorf("%d: expected %q, got %q", i, c.exp, got)
+		}
+	}
+}
+
+func TestHashEncoding(t *testing.T) {
+	defer withThresholds(3, 512, 128, -2)()
+
+	h := NewIncHash()
+	h.HMSet("a", "1", "b", "2")
+	h.HSet("c", "3")
+	h.HSet("c", "4")
+	h.HIncrBy("a", 1)
+	if got := Encoding(h); got != "listpack" {
+		t.Fatalf("expected listpack, got %s", got)
+	}
+	exp := []string{"a", "2", "b", "2", "c", "4"}
+	if got := h.HGetAll(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v in insertion order, got %v", exp, got)
+	}
+	if cur, got := h.HScan(0, 1, "[ac]"); cur != 0 || !reflect.DeepEqual(got, []string{"a", "2", "c", "4"}) {
+		t.Errorf("expected a single page, got %d %v", cur, got)
+	}
+	if got := h.HMGet("c", "x"); !reflect.DeepEqual(got, []interface{}{"4", nil}) {
+		t.Errorf("expected [4 <nil>], got %v", got)
+	}
+	if h.HDel("a", "x") != 1 || h.HSetNx("b", "x") || !h.HSetNx("a", "1") {
+		t.Errorf("unexpected HDel or HSetNx result")
+	}
+	if got := h.HVals(); !reflect.DeepEqual(got, []string{"2", "4", "1"}) {
+		t.Errorf("expected [2 4 1], got %v", got)
+	}
+
+	// Too many fields
+	c := h.Clone().(IncHash)
+	c.HSetNx("d", "5")
+	if got := Encoding(c); got != "hashtable" {
+		t.Errorf("expected hashtable, got %s", got)
+	}
+	// A value too long
+	c = h.Clone().(IncHash)
+	c.HSet("a", strings.Repeat("x", HashMaxListpackValue+1))
+	if got := Encoding(c); got != "hashtable" {
+		t.Errorf("expected hashtable, got %s", got)
+	}
+	if got := c.HKeys(); !sameMembers(got, []string{"a", "b", "c"}) {
+		t.Errorf("expected [a b c], got %v", got)
+	}
+	if got := Encoding(h); got != "listpack" {
+		t.Errorf("expected original to be a listpack, got %s", got)
+	}
+}
+
+func TestSetEncoding(t *testing.T) {
+	defer withThresholds(128, 4, 3, -2)()
+
+	s := NewSet()
+	s.SAdd("3", "1", "-2")
+	if got := Encoding(s); got != "intset" {
+		t.Fatalf("expected intset, got %s", got)
+	}
+	if got := s.SMembers(); !reflect.DeepEqual(got, []string{"-2", "1", "3"}) {
+		t.Errorf("expected sorted members, got %v", got)
+	}
+	if !s.SIsMember("1") || s.SIsMember("01") || s.SIsMember("a") {
+		t.Errorf("unexpected SIsMember result")
+	}
+	if s.SRem("01", "a", "1") != 1 {
+		t.Errorf("expected 1 member removed")
+	}
+
+	// Intset to listpack
+	c := s.Clone().(Set)
+	c.SAdd("a")
+	if got := Encoding(c); got != "listpack" {
+		t.Errorf("expected listpack, got %s", got)
+	}
+	if cur, got := c.SScan(0, 1, ""); cur != 0 || !sameMembers(got, []string{"-2", "3", "a"}) {
+		t.Errorf("expected a single page, got %d %v", cur, got)
+	}
+	// Listpack to hashtable
+	c.SAdd("b")
+	if got := Encoding(c); got != "hashtable" {
+		t.Errorf("expected hashtable, got %s", got)
+	}
+	if got := c.SMembers(); !sameMembers(got, []string{"-2", "3", "a", "b"}) {
+		t.Errorf("expected [-2 3 a b], got %v", got)
+	}
+	// Intset to hashtable, too many integers for a listpack
+	c = s.Clone().(Set)
+	c.SAdd("4", "5", "6")
+	if got := Encoding(c); got != "hashtable" {
+		t.Errorf("expected hashtable, got %s", got)
+	}
+	// Intset to hashtable, a member too long for a listpack
+	c = s.Clone().(Set)
+	c.SAdd(strings.Repeat("x", SetMaxListpackValue+1))
+	if got := Encoding(c); got != "hashtable" {
+		t.Errorf("expected hashtable, got %s", got)
+	}
+	if got := Encoding(s); got != "intset" {
+		t.Errorf("expected original to be an intset, got %s", got)
+	}
+}
+
+func TestSetModel(t *testing.T) {
+	defer withThresholds(128, 20, 10, -2)()
+
+	// Apply random operations to sets and to a map, so that the sets go
+	// through all encodings.
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		s := NewSet()
+		exp := make(map[string]bool)
+		for j := 0; j < 50; j++ {
+			val := strconv.Itoa(rnd.Intn(40) - 10)
+			if rnd.Intn(40) == 0 {
+				val = "v" + val
+			}
+			switch rnd.Intn(4) {
+			case 0, 1:
+				if got := s.SAdd(val); got != 1 && !exp[val] {
+					t.Fatalf("%d-%d: expected %s to be added", i, j, val)
+				}
+				exp[val] = true
+			case 2:
+				if got := s.SRem(val); (got == 1) != exp[val] {
+					t.Fatalf("%d-%d: expected %s removed to be %t", i, j, val, exp[val])
+				}
+				delete(exp, val)
+			case 3:
+				for _, v := range s.SPop(1) {
+					if !exp[v] {
+						t.Fatalf("%d-%d: popped %s not in the set", i, j, v)
+					}
+					delete(exp, v)
+				}
+			}
+			mbrs := make([]string, 0, len(exp))
+			for m := range exp {
+				mbrs = append(mbrs, m)
+			}
+			if got := s.SMembers(); !sameMembers(got, mbrs) {
+				t.Fatalf("%d-%d: expected %v, got %v (%s)", i, j, mbrs, got, Encoding(s))
+			}
+			for _, m := range s.SRandMember(-3) {
+				if !exp[m] {
+					t.Fatalf("%d-%d: random member %s not in the set", i, j, m)
+				}
+			}
+		}
+	}
+}
+
+func TestListEncoding(t *testing.T) {
+	defer withThresholds(128, 512, 128, 3)()
+
+	l := NewList()
+	l.RPush("a", "b")
+	l.LPush("c")
+	if got := Encoding(l); got != "listpack" {
+		t.Fatalf("expected listpack, got %s", got)
+	}
+	if v, ok := l.LIndex(-1); !ok || v != "b" {
+		t.Errorf("expected b, got %q %t", v, ok)
+	}
+	if got := l.LPos("a", 1, 0, 0); !reflect.DeepEqual(got, []int64{1}) {
+		t.Errorf("expected [1], got %v", got)
+	}
+	c := l.Clone().(List)
+	if n := c.LInsertAfter("c", "d"); n != 4 || Encoding(c) != "quicklist" {
+		t.Errorf("expected a quicklist of 4 values, got %d %s", n, Encoding(c))
+	}
+	if got := c.LRange(0, -1); !reflect.DeepEqual(got, []string{"c", "d", "a", "b"}) {
+		t.Errorf("expected [c d a b], got %v", got)
+	}
+	l.LTrim(1, -1)
+	l.RPush("e")
+	if got := Encoding(l); got != "listpack" {
+		t.Errorf("expected listpack, got %s", got)
+	}
+
+	// A size in bytes
+	ListMaxListpackSize = -1
+	l = NewList()
+	l.RPush(strings.Repeat("x", 4000))
+	if got := Encoding(l); got != "listpack" {
+		t.Errorf("expected listpack, got %s", got)
+	}
+	l.LSet(0, strings.Repeat("x", 4100))
+	if got := Encoding(l); got != "quicklist" {
+		t.Errorf("expected quicklist, got %s", got)
+	}
+	if v, ok := l.RPop(); !ok || len(v) != 4100 {
+		t.Errorf("expected a value of 4100 bytes, got %d %t", len(v), ok)
+	}
+}
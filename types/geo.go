@@ -0,0 +1,344 @@
+package types
+
+import "math"
+
+// Geo values are stored in sorted sets, the score of a member being the
+// 52-bit geohash of its position, so that the members close to each other
+// have close scores. This is the same encoding as in Redis.
+const (
+	// GeoLonMin and GeoLonMax are the bounds of a valid longitude.
+	GeoLonMin = -180.0
+	GeoLonMax = 180.0
+
+	// GeoLatMin and GeoLatMax are the bounds of a valid latitude, limited
+	// as in the EPSG:900913 / EPSG:3785 / OSGEO:41001 projections.
+	GeoLatMin = -85.05112878
+	GeoLatMax = 85.05112878
+
+	// geoStepMax is the number of bits used for each coordinate.
+	geoStepMax = 26
+
+	// earthRadius is the radius of the Earth in meters used to compute
+	// distances, as in Redis.
+	earthRadius = 6372797.560856
+
+	// mercatorMax is half the length of the equator in the Mercator
+	// projection, in meters.
+	mercatorMax = 20037726.37
+
+	// geoAlphabet is the alphabet of the standard geohash string.
+	geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+// GeoPoint is a position on Earth, in degrees.
+type GeoPoint struct {
+	Lon, Lat float64
+}
+
+// geoHash is a geohash of step bits per coordinate, the latitude bits
+// being interleaved at the even positions and the longitude bits at the
+// odd positions.
+type geoHash struct {
+	bits uint64
+	step uint
+}
+
+// geoArea is the area covered by a geoHash.
+type geoArea struct {
+	lonMin, lonMax, latMin, latMax float64
+}
+
+// GeoEncode returns the 52-bit geohash of the point, used as score in a
+// sorted set. It returns false if the point is out of the valid bounds.
+func GeoEncode(p GeoPoint) (uint64, bool) {
+	if p.Lon < GeoLonMin || p.Lon > GeoLonMax || p.Lat < GeoLatMin || p.Lat > GeoLatMax {
+		return 0, false
+	}
+	h := geoEncode(p, GeoLatMin, GeoLatMax, geoStepMax)
+	return h.bits, true
+}
+
+// GeoDecode returns the point at the center of the area covered by the
+// 52-bit geohash.
+func GeoDecode(bits uint64) GeoPoint {
+	a := geoDecode(geoHash{bits, geoStepMax}, GeoLatMin, GeoLatMax)
+	p := GeoPoint{(a.lonMin + a.lonMax) / 2, (a.latMin + a.latMax) / 2}
+	p.Lon = math.Max(GeoLonMin, math.Min(GeoLonMax, p.Lon))
+	p.Lat = math.Max(GeoLatMin, math.Min(GeoLatMax, p.Lat))
+	return p
+}
+
+// GeoHashString returns the standard 11 characters geohash string of the
+// point, computed with the standard latitude bounds of -90 to 90.
+func GeoHashString(p GeoPoint) string {
+	h := geoEncode(p, -90, 90, geoStepMax)
+	buf := make([]byte, 11)
+	for i := range buf {
+		// 52 bits give only 10 full characters, the last one is 0
+		var idx uint64
+		if i < 10 {
+			idx = (h.bits >> (52 - uint(i+1)*5)) & 0x1f
+		}
+		buf[i] = geoAlphabet[idx]
+	}
+	return string(buf)
+}
+
+// GeoDistance returns the distance in meters between the two points,
+// using the haversine formula.
+func GeoDistance(a, b GeoPoint) float64 {
+	lat1, lat2 := degToRad(a.Lat), degToRad(b.Lat)
+	u := math.Sin((lat2 - lat1) / 2)
+	v := math.Sin((degToRad(b.Lon) - degToRad(a.Lon)) / 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1)*math.Cos(lat2)*v*v))
+}
+
+// GeoShape is the shape of a geo search, either a circle of the specified
+// radius, or a box of the specified width and height if Box is true. The
+// dimensions are in meters.
+type GeoShape struct {
+	Center        GeoPoint
+	Box           bool
+	Radius        float64
+	Width, Height float64
+}
+
+// Contains returns the distance in meters of the point from the center of
+// the shape, and false as second value if the point is outside the shape.
+func (s GeoShape) Contains(p GeoPoint) (float64, bool) {
+	if !s.Box {
+		d := GeoDistance(s.Center, p)
+		return d, d <= s.Radius
+	}
+	// The latitude distance is cheaper to compute, check it first
+	if earthRadius*math.Abs(degToRad(p.Lat)-degToRad(s.Center.Lat)) > s.Height/2 {
+		return 0, false
+	}
+	if GeoDistance(GeoPoint{s.Center.Lon, p.Lat}, p) > s.Width/2 {
+		return 0, false
+	}
+	return GeoDistance(s.Center, p), true
+}
+
+// ScoreRanges returns the ranges of scores of the geohash cells that
+// cover the shape: the cell of the center and its neighbours, with a cell
+// size based on the size of the shape. The members in those ranges must
+// still be checked with Contains.
+func (s GeoShape) ScoreRanges() []ScoreRange {
+	lonMin, latMin, lonMax, latMax := s.bounds()
+	radius := s.Radius
+	if s.Box {
+		radius = math.Sqrt((s.Width/2)*(s.Width/2) + (s.Height/2)*(s.Height/2))
+	}
+	step := geoEstimateSteps(radius, s.Center.Lat)
+
+	h := geoEncode(s.Center, GeoLatMin, GeoLatMax, step)
+	cells := h.neighbours()
+	area := geoDecode(h, GeoLatMin, GeoLatMax)
+
+	// If the neighbours do not cover the shape, use larger cells
+	if step > 1 {
+		n, so := geoDecode(cells[1], GeoLatMin, GeoLatMax), geoDecode(cells[2], GeoLatMin, GeoLatMax)
+		e, w := geoDecode(cells[3], GeoLatMin, GeoLatMax), geoDecode(cells[4], GeoLatMin, GeoLatMax)
+		if n.latMax < latMax || so.latMin > latMin || e.lonMax < lonMax || w.lonMin > lonMin {
+			step--
+			h = geoEncode(s.Center, GeoLatMin, GeoLatMax, step)
+			cells = h.neighbours()
+			area = geoDecode(h, GeoLatMin, GeoLatMax)
+		}
+	}
+
+	// Exclude the neighbours that are outside the shape, in the order
+	// center, N, S, E, W, NE, NW, SE, SW.
+	skip := make([]bool, len(cells))
+	if step >= 2 {
+		if area.latMin < latMin {
+			skip[2], skip[7], skip[8] = true, true, true
+		}
+		if area.latMax > latMax {
+			skip[1], skip[5], skip[6] = true, true, true
+		}
+		if area.lonMin < lonMin {
+			skip[4], skip[6], skip[8] = true, true, true
+		}
+		if area.lonMax > lonMax {
+			skip[3], skip[5], skip[7] = true, true, true
+		}
+	}
+
+	var ret []ScoreRange
+	seen := make(map[uint64]bool, len(cells))
+	for i, c := range cells {
+		if skip[i] || seen[c.bits] {
+			continue
+		}
+		seen[c.bits] = true
+		shift := 2 * (geoStepMax - c.step)
+		ret = append(ret, ScoreRange{
+			Min:          float64(c.bits << shift),
+			Max:          float64((c.bits + 1) << shift),
+			MaxExclusive: true,
+		})
+	}
+	return ret
+}
+
+// bounds returns the bounding box of the shape, as the minimum longitude
+// and latitude and the maximum longitude and latitude.
+func (s GeoShape) bounds() (float64, float64, float64, float64) {
+	width, height := s.Radius, s.Radius
+	if s.Box {
+		width, height = s.Width/2, s.Height/2
+	}
+	lon, lat := s.Center.Lon, s.Center.Lat
+	latDelta := radToDeg(height / earthRadius)
+	lonDeltaTop := radToDeg(width / earthRadius / math.Cos(degToRad(lat+latDelta)))
+	lonDeltaBottom := radToDeg(width / earthRadius / math.Cos(degToRad(lat-latDelta)))
+	lonDelta := lonDeltaTop
+	if lat < 0 {
+		lonDelta = lonDeltaBottom
+	}
+	return lon - lonDelta, lat - latDelta, lon + lonDelta, lat + latDelta
+}
+
+// geoEstimateSteps returns the number of bits per coordinate of the
+// geohash cells to use to cover a search of the specified radius in
+// meters, at the specified latitude.
+func geoEstimateSteps(radius, lat float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	// Make sure the radius is included in most cases
+	step -= 2
+
+	// Cells are narrower towards the poles
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > geoStepMax {
+		step = geoStepMax
+	}
+	return uint(step)
+}
+
+// geoEncode returns the geohash of step bits per coordinate of the point,
+// using the specified latitude bounds.
+func geoEncode(p GeoPoint, latMin, latMax float64, step uint) geoHash {
+	latOfs := (p.Lat - latMin) / (latMax - latMin)
+	lonOfs := (p.Lon - GeoLonMin) / (GeoLonMax - GeoLonMin)
+	latOfs *= float64(uint64(1) << step)
+	lonOfs *= float64(uint64(1) << step)
+	return geoHash{interleave(uint32(latOfs), uint32(lonOfs)), step}
+}
+
+// geoDecode returns the area covered by the geohash, using the specified
+// latitude bounds.
+func geoDecode(h geoHash, latMin, latMax float64) geoArea {
+	lat, lon := deinterleave(h.bits)
+	scale := float64(uint64(1) << h.step)
+	latScale, lonScale := latMax-latMin, GeoLonMax-GeoLonMin
+	return geoArea{
+		latMin: latMin + (float64(lat)/scale)*latScale,
+		latMax: latMin + (float64(lat+1)/scale)*latScale,
+		lonMin: GeoLonMin + (float64(lon)/scale)*lonScale,
+		lonMax: GeoLonMin + (float64(lon+1)/scale)*lonScale,
+	}
+}
+
+// neighbours returns the geohash and its 8 neighbours, in the order
+// center, N, S, E, W, NE, NW, SE, SW.
+func (h geoHash) neighbours() []geoHash {
+	return []geoHash{
+		h,
+		h.move(0, 1),
+		h.move(0, -1),
+		h.move(1, 0),
+		h.move(-1, 0),
+		h.move(1, 1),
+		h.move(-1, 1),
+		h.move(1, -1),
+		h.move(-1, -1),
+	}
+}
+
+// move returns the geohash of the adjacent cell in the longitude direction
+// dx and the latitude direction dy, wrapping around the bounds.
+func (h geoHash) move(dx, dy int) geoHash {
+	const even, odd = 0x5555555555555555, 0xaaaaaaaaaaaaaaaa
+
+	shift := 64 - 2*h.step
+	x, y := h.bits&odd, h.bits&even
+	if dx != 0 {
+		zz := uint64(even) >> shift
+		if dx > 0 {
+			x += zz + 1
+		} else {
+			x = (x | zz) - (zz + 1)
+		}
+		x &= uint64(odd) >> shift
+	}
+	if dy != 0 {
+		zz := uint64(odd) >> shift
+		if dy > 0 {
+			y += zz + 1
+		} else {
+			y = (y | zz) - (zz + 1)
+		}
+		y &= uint64(even) >> shift
+	}
+	return geoHash{x | y, h.step}
+}
+
+// interleave returns the bits of x at the even positions and the bits of
+// y at the odd positions.
+func interleave(x, y uint32) uint64 {
+	return spread(x) | spread(y)<<1
+}
+
+// deinterleave returns the bits at the even positions and the bits at the
+// odd positions.
+func deinterleave(v uint64) (uint32, uint32) {
+	return squash(v), squash(v >> 1)
+}
+
+// spread spreads the bits of v to the even positions of the result.
+func spread(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000ffff0000ffff
+	x = (x | x<<8) & 0x00ff00ff00ff00ff
+	x = (x | x<<4) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// squash gathers the bits at the even positions of v, the reverse of
+// spread.
+func squash(v uint64) uint32 {
+	x := v & 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x>>4) & 0x00ff00ff00ff00ff
+	x = (x | x>>8) & 0x0000ffff0000ffff
+	x = (x | x>>16) & 0x00000000ffffffff
+	return uint32(x)
+}
+
+func degToRad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func radToDeg(r float64) float64 {
+	return r * 180 / math.Pi
+}
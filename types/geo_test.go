@@ -0,0 +1,135 @@
+package types
+
+import (
+	"math"
+	"testing"
+)
+
+var (
+	palermo = GeoPoint{13.361389, 38.115556}
+	catania = GeoPoint{15.087269, 37.502669}
+)
+
+func TestGeoEncodeDecode(t *testing.T) {
+	cases := []struct {
+		p    GeoPoint
+		hash uint64
+		ok   bool
+	}{
+		0: {palermo, 3479099956230698, true},
+		1: {catania, 3479447370796909, true},
+		2: {GeoPoint{0, 0}, 3377699720527872, true},
+		3: {GeoPoint{181, 0}, 0, false},
+		4: {GeoPoint{0, 86}, 0, false},
+		5: {GeoPoint{-180.1, 0}, 0, false},
+	}
+	for i, c := range cases {
+		got, ok := GeoEncode(c.p)
+		if ok != c.ok {
+			t.Errorf("%d: expected %t, got %t", i, c.ok, ok)
+			continue
+		}
+		if got != c.hash {
+			t.Errorf("%d: expected %d, got %d", i, c.hash, got)
+		}
+		if !ok {
+			continue
+		}
+		p := GeoDecode(got)
+		if math.Abs(p.Lon-c.p.Lon) > 1e-5 || math.Abs(p.Lat-c.p.Lat) > 1e-5 {
+			t.Errorf("%d: expected %v, got %v", i, c.p, p)
+		}
+	}
+}
+
+func TestGeoHashString(t *testing.T) {
+	cases := []struct {
+		p   GeoPoint
+		exp string
+	}{
+		0: {palermo, "sqc8b49rny0"},
+		1: {catania, "sqdtr74hyu0"},
+	}
+	for i, c := range cases {
+		h, _ := GeoEncode(c.p)
+		got := GeoHashString(GeoDecode(h))
+		if got != c.exp {
+			t.Errorf("%d: expected %s, got %s", i, c.exp, got)
+		}
+	}
+}
+
+func TestGeoDistance(t *testing.T) {
+	hp, _ := GeoEncode(palermo)
+	hc, _ := GeoEncode(catania)
+	got := GeoDistance(GeoDecode(hp), GeoDecode(hc))
+	if math.Abs(got-166274.1516) > 1e-3 {
+		t.Errorf("expected %f, got %f", 166274.1516, got)
+	}
+	if got := GeoDistance(palermo, palermo); got != 0 {
+		t.Errorf("expected 0, got %f", got)
+	}
+}
+
+func TestGeoShape(t *testing.T) {
+	center := GeoPoint{15, 37}
+	cases := []struct {
+		s       GeoShape
+		p       GeoPoint
+		dist    float64
+		contain bool
+	}{
+		0: {GeoShape{Center: center, Radius: 200000}, catania, 56441.3, true},
+		1: {GeoShape{Center: center, Radius: 200000}, palermo, 190442.4, true},
+		2: {GeoShape{Center: center, Radius: 100000}, palermo, 190442.4, false},
+		3: {GeoShape{Center: center, Box: true, Width: 400000, Height: 400000}, palermo, 190442.4, true},
+		4: {GeoShape{Center: center, Box: true, Width: 200000, Height: 400000}, palermo, 0, false},
+		5: {GeoShape{Center: center, Box: true, Width: 400000, Height: 100000}, palermo, 0, false},
+	}
+	for i, c := range cases {
+		h, _ := GeoEncode(c.p)
+		p := GeoDecode(h)
+		dist, ok := c.s.Contains(p)
+		if ok != c.contain {
+			t.Errorf("%d: expected %t, got %t", i, c.contain, ok)
+		}
+		if ok && math.Abs(dist-c.dist) > 0.1 {
+			t.Errorf("%d: expected distance %f, got %f", i, c.dist, dist)
+		}
+
+		// If contained, the point must be in one of the score ranges
+		if !ok {
+			continue
+		}
+		var found bool
+		for _, r := range c.s.ScoreRanges() {
+			if float64(h) >= r.Min && float64(h) < r.Max {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%d: expected point to be in the score ranges", i)
+		}
+	}
+}
+
+func TestGeoHashMove(t *testing.T) {
+	h := geoEncode(GeoPoint{0, 0}, GeoLatMin, GeoLatMax, 4)
+	lat, lon := deinterleave(h.bits)
+	cases := []struct {
+		dx, dy   int
+		lat, lon uint32
+	}{
+		0: {1, 0, lat, lon + 1},
+		1: {-1, 0, lat, lon - 1},
+		2: {0, 1, lat + 1, lon},
+		3: {0, -1, lat - 1, lon},
+		4: {1, -1, lat - 1, lon + 1},
+	}
+	for i, c := range cases {
+		gotLat, gotLon := deinterleave(h.move(c.dx, c.dy).bits)
+		if gotLat != c.lat || gotLon != c.lon {
+			t.Errorf("%d: expected %d,%d, got %d,%d", i, c.lat, c.lon, gotLat, gotLon)
+		}
+	}
+}
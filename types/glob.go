@@ -0,0 +1,108 @@
+package types
+
+// MatchGlob returns true if the string s matches the glob-style pattern,
+// using the same rules as Redis:
+//
+//   - '*' matches any sequence of bytes, including the empty one
+//   - '?' matches any single byte
+//   - '[abc]' matches one of the bytes in the brackets
+//   - '[a-z]' matches one byte in the range (inclusive)
+//   - '[^ab]' matches one byte that is not in the brackets
+//   - '\x' matches the byte x literally
+//
+// The matching is done byte-wise and is case-sensitive.
+func MatchGlob(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// Consecutive stars are the same as a single one
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if MatchGlob(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var ok bool
+			ok, pattern = matchClass(pattern[1:], s[0])
+			if !ok {
+				return false
+			}
+			s = s[1:]
+
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchClass matches the byte b against the character class that starts
+// at the beginning of pattern, just after the opening bracket. It returns
+// true if b matches, and the rest of the pattern after the closing bracket.
+// An unterminated class ends with the pattern.
+func matchClass(pattern string, b byte) (bool, string) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+
+	var match bool
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			if pattern[1] == b {
+				match = true
+			}
+			pattern = pattern[2:]
+
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			start, end := pattern[0], pattern[2]
+			if start > end {
+				start, end = end, start
+			}
+			if b >= start && b <= end {
+				match = true
+			}
+			pattern = pattern[3:]
+
+		default:
+			if pattern[0] == b {
+				match = true
+			}
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		// Skip the closing bracket
+		pattern = pattern[1:]
+	}
+	return match != not, pattern
+}
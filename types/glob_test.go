@@ -0,0 +1,50 @@
+package types
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		s       string
+		exp     bool
+	}{
+		0:  {"", "", true},
+		1:  {"", "a", false},
+		2:  {"*", "", true},
+		3:  {"*", "abc", true},
+		4:  {"a*", "abc", true},
+		5:  {"a*", "bac", false},
+		6:  {"*c", "abc", true},
+		7:  {"a**c", "abbbc", true},
+		8:  {"*b*", "abc", true},
+		9:  {"*b*d", "abc", false},
+		10: {"h?llo", "hello", true},
+		11: {"h?llo", "hllo", false},
+		12: {"h[ae]llo", "hallo", true},
+		13: {"h[ae]llo", "hillo", false},
+		14: {"h[^e]llo", "hallo", true},
+		15: {"h[^e]llo", "hello", false},
+		16: {"h[a-b]llo", "hbllo", true},
+		17: {"h[b-a]llo", "hbllo", true},
+		18: {"h[a-b]llo", "hcllo", false},
+		19: {`h\*llo`, "h*llo", true},
+		20: {`h\*llo`, "hello", false},
+		21: {`h[\]]llo`, "h]llo", true},
+		22: {`h[\-]llo`, "h-llo", true},
+		23: {"h[a-", "ha", true},
+		24: {"h[a", "hb", false},
+		25: {"[", "a", false},
+		26: {`a\`, `a\`, true},
+		27: {"*?", "", false},
+		28: {"user:*:name", "user:1234:name", true},
+		29: {"user:*:name", "user:1234:email", false},
+		30: {"a[a-]", "a-", true},
+		31: {"A*", "abc", false},
+	}
+	for i, c := range cases {
+		got := MatchGlob(c.pattern, c.s)
+		if got != c.exp {
+			t.Errorf("%d: expected %t, got %t", i, c.exp, got)
+		}
+	}
+}
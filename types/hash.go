@@ -12,94 +12,126 @@ type Hash interface {
 	HLen() int64
 	HMGet(...string) []interface{}
 	HMSet(...string)
+	HScan(uint64, int64, string) (uint64, []string)
 	HSet(string, string) bool
 	HSetNx(string, string) bool
 	HVals() []string
 }
 
-// Static check to make sure hash implements Hash.
-var _ Hash = hash(nil)
+// Static check to make sure *hash implements Hash.
+var _ Hash = (*hash)(nil)
 
-// hash is the internal implementation of the Hash interface.
-type hash map[string]string
+// hash is the hashtable encoding of a Hash. The values are held in a map
+// by field, and the fields in an Index, so that they are scanned
+// incrementally and returned in a stable order.
+type hash struct {
+	vals   map[string]string
+	fields Index
+}
 
 // NewHash creates a new Hash value.
 func NewHash() Hash {
-	return make(hash)
+	return newHash(0)
+}
+
+// newHash creates a new hashtable-encoded hash, with room for size fields.
+func newHash(size int) *hash {
+	return &hash{
+		vals: make(map[string]string, size),
+	}
 }
 
 // Type returns the type of the value, which is "hash".
-func (h hash) Type() string {
+func (h *hash) Type() string {
 	return "hash"
 }
 
+// Clone returns a copy of the hash.
+func (h *hash) Clone() Value {
+	c := &hash{
+		vals:   make(map[string]string, len(h.vals)),
+		fields: h.fields.Clone(),
+	}
+	for k, v := range h.vals {
+		c.vals[k] = v
+	}
+	return c
+}
+
+// MemoryUsage returns the estimated number of bytes used by the hash,
+// extrapolated from the size of samples fields and values.
+func (h *hash) MemoryUsage(samples int) int64 {
+	n := int64(len(h.vals))
+	sampled := make([]string, 0, 2*sampleCount(n, samples))
+	for _, f := range h.fields.Names()[:sampleCount(n, samples)] {
+		sampled = append(sampled, f, h.vals[f])
+	}
+	// The fields of the index share their data with the keys of the map
+	size := wordSize + h.fields.memoryUsage()
+	return size + n*mapEntrySize(2*stringHeaderSize) + sampledSize(2*n, sampled)
+}
+
 // HDel deletes the specified fields from the hash, and returns the
 // number of fields removed.
-func (h hash) HDel(fields ...string) int64 {
+func (h *hash) HDel(fields ...string) int64 {
 	var cnt int64
 	for _, f := range fields {
-		if _, ok := h[f]; ok {
+		if _, ok := h.vals[f]; ok {
 			cnt++
-			delete(h, f)
+			delete(h.vals, f)
+			h.fields.Remove(f)
 		}
 	}
 	return cnt
 }
 
 // HExists returns true if the specified field exists in the hash.
-func (h hash) HExists(field string) bool {
-	_, ok := h[field]
+func (h *hash) HExists(field string) bool {
+	_, ok := h.vals[field]
 	return ok
 }
 
 // HGet returns the value of the specified field. The second return value
 // indicates if the field exists in the hash.
-func (h hash) HGet(field string) (string, bool) {
-	v, ok := h[field]
+func (h *hash) HGet(field string) (string, bool) {
+	v, ok := h.vals[field]
 	return v, ok
 }
 
 // HGetAll returns the list of all key-value pairs in the hash.
-func (h hash) HGetAll() []string {
-	if len(h) == 0 {
+func (h *hash) HGetAll() []string {
+	if len(h.vals) == 0 {
 		return empty
 	}
-	vals := make([]string, 2*len(h))
-	i := 0
-	for k, v := range h {
-		vals[i] = k
-		vals[i+1] = v
-		i += 2
+	vals := make([]string, 0, 2*len(h.vals))
+	for _, f := range h.fields.Names() {
+		vals = append(vals, f, h.vals[f])
 	}
 	return vals
 }
 
 // HKeys returns the list of keys in the hash.
-func (h hash) HKeys() []string {
-	if len(h) == 0 {
+func (h *hash) HKeys() []string {
+	if len(h.vals) == 0 {
 		return empty
 	}
-	keys := make([]string, len(h))
-	i := 0
-	for k := range h {
-		keys[i] = k
-		i++
-	}
+	keys := make([]string, len(h.vals))
+	copy(keys, h.fields.Names())
 	return keys
 }
 
 // HLen returns the number of fields in the hash.
-func (h hash) HLen() int64 {
-	return int64(len(h))
+func (h *hash) HLen() int64 {
+	return int64(len(h.vals))
 }
 
 // HMGet returns the list of values for all requested fields, in the
 // order of the requested fields. It returns a nil value at the position
 // of non-existing fields.
-func (h hash) HMGet(fields ...string) []interface{} {
+func (h *hash) HMGet(fields ...string) []interface{} {
 	ret := make([]interface{}, len(fields))
 	for i, f := range fields {
-		if v, ok := h[f]; ok {
+		if v, ok := h.vals[f]; ok {
 			ret[i] = v
 		}
 	}
@@ -107,41 +139,57 @@ func (h hash) HMGet(fields ...string) []interface{} {
 }
 
 // HMSet sets the values for all key-value tuples as received as argument.
-func (h hash) HMSet(tuples ...string) {
+func (h *hash) HMSet(tuples ...string) {
 	for i := 0; i < len(tuples); {
-		h[tuples[i]] = tuples[i+1]
+		h.HSet(tuples[i], tuples[i+1])
 		i += 2
 	}
 }
 
+// HScan returns the field-value pairs of one page of a cursor-based
+// iteration over the hash, starting at cursor and examining about count
+// fields, along with the cursor of the next page (0 when the iteration is
+// done). If match is not empty, only the fields that match this glob-style
+// pattern are returned.
+func (h *hash) HScan(cursor uint64, count int64, match string) (uint64, []string) {
+	vals := []string{}
+	cur := h.fields.Scan(cursor, count, func(f string) {
+		if match == "" || MatchGlob(match, f) {
+			vals = append(vals, f, h.vals[f])
+		}
+	})
+	return cur, vals
+}
+
 // HSet sets the value of field to val, and returns true if the field had to be
 // created.
-func (h hash) HSet(field, val string) bool {
-	_, ok := h[field]
-	h[field] = val
+func (h *hash) HSet(field, val string) bool {
+	_, ok := h.vals[field]
+	h.vals[field] = val
+	if !ok {
+		h.fields.Add(field)
+	}
 	return !ok
 }
 
 // HSetNx sets the value of field to val only if the field does not already exists
 // in the hash. It returns true if it did create and set the field.
-func (h hash) HSetNx(field, val string) bool {
-	if _, ok := h[field]; !ok {
-		h[field] = val
+func (h *hash) HSetNx(field, val string) bool {
+	if _, ok := h.vals[field]; !ok {
+		h.HSet(field, val)
 		return true
 	}
 	return false
 }
 
 // HVals returns the list of values in the hash.
-func (h hash) HVals() []string {
-	if len(h) == 0 {
+func (h *hash) HVals() []string {
+	if len(h.vals) == 0 {
 		return empty
 	}
-	vals := make([]string, len(h))
-	i := 0
-	for _, v := range h {
-		vals[i] = v
-		i++
+	vals := make([]string, 0, len(h.vals))
+	for _, f := range h.fields.Names() {
+		vals = append(vals, h.vals[f])
 	}
 	return vals
 }
@@ -2,23 +2,24 @@ package types
 
 import (
 	"reflect"
+	"strconv"
 	"testing"
 )
 
-var hcase = hash{
-	"a": "v1",
-	"b": "v2",
-	"c": "v3",
-}
+var hcase = newTestHash("a", "v1", "b", "v2", "c", "v3")
 
-var hempty = hash{}
+var hempty = newTestHash()
 
-func cloneHash(h hash) hash {
-	h2 := hash{}
-	for k, v := range h {
-		h2[k] = v
-	}
-	return h2
+// newTestHash creates a hashtable-encoded hash holding the field-value
+// pairs in tuples.
+func newTestHash(tuples ...string) *hash {
+	h := newHash(len(tuples) / 2)
+	h.HMSet(tuples...)
+	return h
+}
+
+func cloneHash(h *hash) *hash {
+	return h.Clone().(*hash)
 }
 
 func TestHashType(t *testing.T) {
@@ -27,6 +28,18 @@ func TestHashType(t *testing.T) {
 	}
 }
 
+func TestHashClone(t *testing.T) {
+	c := hcase.Clone().(Hash)
+	c.HSet("a", "x")
+	c.HDel("b")
+	if got := hcase.HGetAll(); !reflect.DeepEqual(got, []string{"a", "v1", "b", "v2", "c", "v3"}) {
+		t.Errorf("expected original hash to be unchanged, got %v", got)
+	}
+	if got := c.HGetAll(); !reflect.DeepEqual(got, []string{"a", "x", "c", "v3"}) {
+		t.Errorf("expected %v, got %v", []string{"a", "x", "c", "v3"}, got)
+	}
+}
+
 func TestHashHDel(t *testing.T) {
 	// copy the hcase
 	hdelCase := cloneHash(hcase)
@@ -182,6 +195,47 @@ func TestHashHMSet(t *testing.T) {
 	}
 }
 
+func TestHashHScan(t *testing.T) {
+	cases := []struct {
+		h     Hash
+		match string
+		exp   map[string]string
+	}{
+		0: {hcase, "", map[string]string{"a": "v1", "b": "v2", "c": "v3"}},
+		1: {hcase, "[ab]", map[string]string{"a": "v1", "b": "v2"}},
+		2: {hcase, "z*", map[string]string{}},
+		3: {hempty, "", map[string]string{}},
+	}
+	for i, c := range cases {
+		// Iterate one field at a time
+		got := make(map[string]string)
+		cur := uint64(0)
+		for {
+			var vals []string
+			cur, vals = c.h.HScan(cur, 1, c.match)
+			for j := 0; j < len(vals); j += 2 {
+				got[vals[j]] = vals[j+1]
+			}
+			if cur == 0 {
+				break
+			}
+		}
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
+		}
+	}
+
+	// A page of a large hash only visits about count fields
+	h := newHash(1000)
+	for i := 0; i < 1000; i++ {
+		h.HSet(strconv.Itoa(i), "v")
+	}
+	cur, vals := h.HScan(0, 10, "")
+	if cur == 0 || len(vals) < 20 || len(vals) > 60 {
+		t.Errorf("expected about 10 fields, got %d with cursor %d", len(vals)/2, cur)
+	}
+}
+
 func TestHashHSet(t *testing.T) {
 	hset := cloneHash(hcase)
 	hempty := cloneHash(hempty)
@@ -0,0 +1,417 @@
+package types
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLog defines the methods required to implement a HyperLogLog.
+// A HyperLogLog is not a Value on its own: like in Redis, it is stored
+// in a String, using the same binary representation as Redis (a "HYLL"
+// header followed by the sparse or dense encoding of the registers).
+type HyperLogLog interface {
+	PFAdd(...string) bool
+	PFCount() int64
+	PFMerge(...HyperLogLog)
+	String() string
+
+	// registers decodes the registers in regs.
+	registers(*[hllRegisters]uint8)
+	sparse() bool
+}
+
+var (
+	// ErrNotHLL is returned by ParseHyperLogLog when the string value is
+	// not a HyperLogLog.
+	ErrNotHLL = errors.New("not a valid HyperLogLog string value")
+
+	// ErrCorruptHLL is returned by ParseHyperLogLog when the string value
+	// has a HyperLogLog header but its registers cannot be decoded.
+	ErrCorruptHLL = errors.New("corrupted HyperLogLog value")
+)
+
+const (
+	hllP         = 14                 // bits of the hash used to select the register
+	hllQ         = 64 - hllP          // bits of the hash used to count the run of zeroes
+	hllRegisters = 1 << hllP          // number of registers
+	hllPMask     = hllRegisters - 1   // mask to extract the register index
+	hllBits      = 6                  // bits per register in the dense encoding
+	hllRegMax    = (1 << hllBits) - 1 // maximum value of a register
+	hllHdrSize   = 16                 // size of the header
+	hllDenseSize = hllHdrSize + (hllRegisters*hllBits+7)/8
+
+	hllDense  = 0 // dense encoding
+	hllSparse = 1 // sparse encoding
+
+	hllSparseMaxBytes    = 3000  // sparse values larger than this are promoted to dense
+	hllSparseValMax      = 32    // maximum register value in the sparse encoding
+	hllSparseValMaxLen   = 4     // maximum run length of a VAL opcode
+	hllSparseZeroMaxLen  = 64    // maximum run length of a ZERO opcode
+	hllSparseXZeroMaxLen = 16384 // maximum run length of a XZERO opcode
+
+	hllAlphaInf = 0.721347520444481703680
+	hllSeed     = 0xadc83b19
+	hllMagic    = "HYLL"
+)
+
+// Static type check to validate that *hyperLogLog implements HyperLogLog.
+var _ HyperLogLog = (*hyperLogLog)(nil)
+
+// hyperLogLog is the internal implementation of a HyperLogLog. It holds
+// the Redis binary representation of the HyperLogLog: the 16 bytes header
+// (magic, encoding, 3 unused bytes, and the cached cardinality stored as a
+// little-endian 64-bit integer whose most significant bit invalidates the
+// cache), followed by the registers.
+type hyperLogLog struct {
+	buf []byte
+}
+
+// NewHyperLogLog creates a new empty HyperLogLog, using the sparse encoding.
+func NewHyperLogLog() HyperLogLog {
+	buf := make([]byte, hllHdrSize, hllHdrSize+2)
+	copy(buf, hllMagic)
+	buf[4] = hllSparse
+	buf = append(buf, 0x40|byte((hllSparseXZeroMaxLen-1)>>8), byte((hllSparseXZeroMaxLen-1)&0xff))
+	return &hyperLogLog{buf}
+}
+
+// ParseHyperLogLog returns the HyperLogLog represented by the string v.
+// It returns ErrNotHLL if v is not a HyperLogLog, and ErrCorruptHLL if
+// its sparse representation is invalid.
+func ParseHyperLogLog(v string) (HyperLogLog, error) {
+	if len(v) < hllHdrSize || v[:4] != hllMagic || v[4] > hllSparse {
+		return nil, ErrNotHLL
+	}
+	if v[4] == hllDense && len(v) != hllDenseSize {
+		return nil, ErrNotHLL
+	}
+	h := &hyperLogLog{[]byte(v)}
+	if h.sparse() && !hllSparseDecode(h.buf[hllHdrSize:], nil) {
+		return nil, ErrCorruptHLL
+	}
+	return h, nil
+}
+
+// String returns the binary representation of the HyperLogLog.
+func (h *hyperLogLog) String() string {
+	return string(h.buf)
+}
+
+// PFAdd adds the values to the HyperLogLog. It returns true if at least
+// one register was altered, meaning that the approximated cardinality
+// may have changed.
+func (h *hyperLogLog) PFAdd(vals ...string) bool {
+	var changed bool
+
+	if !h.sparse() {
+		for _, v := range vals {
+			ix, cnt := hllPatLen(v)
+			if cnt > h.denseGet(ix) {
+				h.denseSet(ix, cnt)
+				changed = true
+			}
+		}
+	} else {
+		var regs [hllRegisters]uint8
+		h.registers(&regs)
+		for _, v := range vals {
+			ix, cnt := hllPatLen(v)
+			if cnt > regs[ix] {
+				regs[ix] = cnt
+				changed = true
+			}
+		}
+		if changed {
+			h.encode(&regs, true)
+		}
+	}
+	if changed {
+		h.invalidate()
+	}
+	return changed
+}
+
+// PFCount returns the approximated cardinality of the HyperLogLog. The
+// cardinality is cached in the header until the HyperLogLog is modified.
+func (h *hyperLogLog) PFCount() int64 {
+	if h.buf[15]&0x80 == 0 {
+		return int64(binary.LittleEndian.Uint64(h.buf[8:hllHdrSize]))
+	}
+
+	var regs [hllRegisters]uint8
+	h.registers(&regs)
+	card := hllCount(&regs)
+	binary.LittleEndian.PutUint64(h.buf[8:hllHdrSize], card)
+	return int64(card)
+}
+
+// PFMerge merges the other HyperLogLogs in this one, so that each register
+// holds the maximum value found in all HyperLogLogs. The result uses the
+// sparse encoding only if all HyperLogLogs do and the registers still fit
+// in this encoding.
+func (h *hyperLogLog) PFMerge(others ...HyperLogLog) {
+	var regs, oregs [hllRegisters]uint8
+
+	sparse := h.sparse()
+	h.registers(&regs)
+	for _, o := range others {
+		sparse = sparse && o.sparse()
+		o.registers(&oregs)
+		for i, v := range oregs {
+			if v > regs[i] {
+				regs[i] = v
+			}
+		}
+	}
+	h.encode(&regs, sparse)
+	h.invalidate()
+}
+
+// sparse returns true if the HyperLogLog uses the sparse encoding.
+func (h *hyperLogLog) sparse() bool {
+	return h.buf[4] == hllSparse
+}
+
+// invalidate invalidates the cached cardinality.
+func (h *hyperLogLog) invalidate() {
+	h.buf[15] |= 0x80
+}
+
+// registers decodes the registers in regs.
+func (h *hyperLogLog) registers(regs *[hllRegisters]uint8) {
+	if h.sparse() {
+		*regs = [hllRegisters]uint8{}
+		hllSparseDecode(h.buf[hllHdrSize:], regs)
+		return
+	}
+	for i := range regs {
+		regs[i] = h.denseGet(i)
+	}
+}
+
+// encode replaces the registers with the values in regs, using the sparse
+// encoding if sparse is true and the registers fit in this encoding, and
+// the dense encoding otherwise. The header is preserved.
+func (h *hyperLogLog) encode(regs *[hllRegisters]uint8, sparse bool) {
+	var hdr [hllHdrSize]byte
+	copy(hdr[:], h.buf)
+
+	if sparse {
+		if p, ok := hllSparseEncode(regs); ok {
+			h.buf = append(append(h.buf[:0], hdr[:]...), p...)
+			h.buf[4] = hllSparse
+			return
+		}
+	}
+	if len(h.buf) != hllDenseSize {
+		h.buf = make([]byte, hllDenseSize)
+		copy(h.buf, hdr[:])
+	}
+	h.buf[4] = hllDense
+	for i, v := range regs {
+		h.denseSet(i, v)
+	}
+}
+
+// denseGet returns the value of the register at index ix, in the dense
+// encoding. Registers are stored as 6 bits integers, from the least
+// significant to the most significant bit of each byte.
+func (h *hyperLogLog) denseGet(ix int) uint8 {
+	p := h.buf[hllHdrSize:]
+	b := ix * hllBits / 8
+	fb := uint(ix * hllBits & 7)
+	v := uint(p[b]) >> fb
+	if b+1 < len(p) {
+		v |= uint(p[b+1]) << (8 - fb)
+	}
+	return uint8(v & hllRegMax)
+}
+
+// denseSet sets the value of the register at index ix to v, in the dense
+// encoding.
+func (h *hyperLogLog) denseSet(ix int, v uint8) {
+	p := h.buf[hllHdrSize:]
+	b := ix * hllBits / 8
+	fb := uint(ix * hllBits & 7)
+	p[b] &^= hllRegMax << fb
+	p[b] |= v << fb
+	if b+1 < len(p) {
+		p[b+1] &^= hllRegMax >> (8 - fb)
+		p[b+1] |= v >> (8 - fb)
+	}
+}
+
+// hllSparseEncode returns the sparse encoding of the registers. It returns
+// false if a register value cannot be represented in this encoding, or if
+// the encoded value would be larger than hllSparseMaxBytes.
+//
+// The sparse encoding is a sequence of opcodes:
+// ZERO (00xxxxxx): xxxxxx+1 registers set to 0;
+// XZERO (01xxxxxx yyyyyyyy): xxxxxxyyyyyyyy+1 registers set to 0;
+// VAL (1vvvvvxx): xx+1 registers set to vvvvv+1.
+func hllSparseEncode(regs *[hllRegisters]uint8) ([]byte, bool) {
+	var p []byte
+
+	for i := 0; i < hllRegisters; {
+		v := regs[i]
+		j := i + 1
+		for j < hllRegisters && regs[j] == v {
+			j++
+		}
+		run := j - i
+		i = j
+
+		switch {
+		case v > hllSparseValMax:
+			return nil, false
+		case v == 0 && run > hllSparseZeroMaxLen:
+			p = append(p, 0x40|byte((run-1)>>8), byte((run-1)&0xff))
+		case v == 0:
+			p = append(p, byte(run-1))
+		default:
+			for ; run > 0; run -= hllSparseValMaxLen {
+				n := run
+				if n > hllSparseValMaxLen {
+					n = hllSparseValMaxLen
+				}
+				p = append(p, 0x80|(v-1)<<2|byte(n-1))
+			}
+		}
+		if hllHdrSize+len(p) > hllSparseMaxBytes {
+			return nil, false
+		}
+	}
+	return p, true
+}
+
+// hllSparseDecode decodes the sparse encoding p into regs, which must be
+// zeroed. If regs is nil, it only validates the encoding. It returns false
+// if the encoding is invalid.
+func hllSparseDecode(p []byte, regs *[hllRegisters]uint8) bool {
+	var ix int
+
+	for i := 0; i < len(p); {
+		var run int
+		var v uint8
+
+		switch op := p[i]; op & 0xc0 {
+		case 0x00:
+			run = int(op&0x3f) + 1
+			i++
+		case 0x40:
+			if i+1 >= len(p) {
+				return false
+			}
+			run = (int(op&0x3f)<<8 | int(p[i+1])) + 1
+			i += 2
+		default:
+			v = (op>>2)&0x1f + 1
+			run = int(op&0x03) + 1
+			i++
+		}
+		if ix+run > hllRegisters {
+			return false
+		}
+		if regs != nil && v > 0 {
+			for j := ix; j < ix+run; j++ {
+				regs[j] = v
+			}
+		}
+		ix += run
+	}
+	return ix == hllRegisters
+}
+
+// hllPatLen returns the index of the register selected by the hash of v,
+// and the length of the pattern 000..1 found in the rest of the hash.
+func hllPatLen(v string) (int, uint8) {
+	hash := murmurHash64A(v, hllSeed)
+	ix := int(hash & hllPMask)
+	hash >>= hllP
+	hash |= 1 << hllQ
+	return ix, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+// hllCount returns the approximated cardinality of the registers, using the
+// improved estimator described by Otmar Ertl in "New cardinality estimation
+// algorithms for HyperLogLog sketches", as Redis does.
+func hllCount(regs *[hllRegisters]uint8) uint64 {
+	var histo [hllQ + 2]int
+	for _, v := range regs {
+		histo[v]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		zp := z
+		z += x * y
+		y += y
+		if zp == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zp := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zp == z {
+			return z / 3
+		}
+	}
+}
+
+// murmurHash64A is the 64-bit MurmurHash2 by Austin Appleby, as used by
+// Redis to hash the HyperLogLog values. It reads the data as little-endian
+// so that the result is the same on all platforms.
+func murmurHash64A(data string, seed uint64) uint64 {
+	const (
+		m = 0xc6a4a7935bd1e995
+		r = 47
+	)
+
+	h := seed ^ (uint64(len(data)) * m)
+	for ; len(data) >= 8; data = data[8:] {
+		k := uint64(data[0]) | uint64(data[1])<<8 | uint64(data[2])<<16 | uint64(data[3])<<24 |
+			uint64(data[4])<<32 | uint64(data[5])<<40 | uint64(data[6])<<48 | uint64(data[7])<<56
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
@@ -0,0 +1,178 @@
+package types
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLogNew(t *testing.T) {
+	h := NewHyperLogLog()
+	s := h.String()
+	if len(s) != hllHdrSize+2 {
+		t.Errorf("expected length %d, got %d", hllHdrSize+2, len(s))
+	}
+	if s[:4] != "HYLL" {
+		t.Errorf("expected magic %q, got %q", "HYLL", s[:4])
+	}
+	if !h.sparse() {
+		t.Errorf("expected sparse encoding")
+	}
+	if got := h.PFCount(); got != 0 {
+		t.Errorf("expected count %d, got %d", 0, got)
+	}
+}
+
+func TestHyperLogLogParse(t *testing.T) {
+	dense := NewHyperLogLog().(*hyperLogLog)
+	var regs [hllRegisters]uint8
+	dense.encode(&regs, false)
+
+	cases := []struct {
+		v   string
+		err error
+	}{
+		0: {NewHyperLogLog().String(), nil},
+		1: {dense.String(), nil},
+		2: {"", ErrNotHLL},
+		3: {"HYLL", ErrNotHLL},
+		4: {"abcd" + NewHyperLogLog().String()[4:], ErrNotHLL},
+		5: {"HYLL\x02" + NewHyperLogLog().String()[5:], ErrNotHLL},
+		6: {dense.String()[:hllDenseSize-1], ErrNotHLL},
+		7: {NewHyperLogLog().String() + "\x00", ErrCorruptHLL},
+		8: {NewHyperLogLog().String()[:hllHdrSize+1], ErrCorruptHLL},
+		9: {NewHyperLogLog().String()[:hllHdrSize], ErrCorruptHLL},
+	}
+	for i, c := range cases {
+		_, err := ParseHyperLogLog(c.v)
+		if err != c.err {
+			t.Errorf("%d: expected error %v, got %v", i, c.err, err)
+		}
+	}
+}
+
+func TestHyperLogLogPFAdd(t *testing.T) {
+	h := NewHyperLogLog()
+	if !h.PFAdd("a", "b", "c") {
+		t.Errorf("expected registers to be altered")
+	}
+	if h.PFAdd("a", "b") {
+		t.Errorf("expected registers to be unchanged")
+	}
+	if got := h.PFCount(); got != 3 {
+		t.Errorf("expected count %d, got %d", 3, got)
+	}
+
+	// Round-trip through the string representation
+	h2, err := ParseHyperLogLog(h.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := h2.PFCount(); got != 3 {
+		t.Errorf("expected count %d, got %d", 3, got)
+	}
+}
+
+func TestHyperLogLogPromote(t *testing.T) {
+	h := NewHyperLogLog()
+	for i := 0; i < 10000 && h.sparse(); i++ {
+		h.PFAdd(strconv.Itoa(i))
+	}
+	if h.sparse() {
+		t.Fatalf("expected dense encoding")
+	}
+	if got := len(h.String()); got != hllDenseSize {
+		t.Errorf("expected length %d, got %d", hllDenseSize, got)
+	}
+	if _, err := ParseHyperLogLog(h.String()); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestHyperLogLogRegisters(t *testing.T) {
+	var regs, got [hllRegisters]uint8
+	for i := range regs {
+		regs[i] = uint8(i % (hllRegMax + 1))
+	}
+	h := NewHyperLogLog().(*hyperLogLog)
+	h.encode(&regs, true)
+	if h.sparse() {
+		t.Errorf("expected dense encoding")
+	}
+	h.registers(&got)
+	if got != regs {
+		t.Errorf("dense registers do not match")
+	}
+
+	for i := range regs {
+		regs[i] = 0
+		if i%100 == 0 {
+			regs[i] = uint8(i%hllSparseValMax + 1)
+		}
+	}
+	h = NewHyperLogLog().(*hyperLogLog)
+	h.encode(&regs, true)
+	if !h.sparse() {
+		t.Errorf("expected sparse encoding")
+	}
+	h.registers(&got)
+	if got != regs {
+		t.Errorf("sparse registers do not match")
+	}
+}
+
+func TestHyperLogLogPFCount(t *testing.T) {
+	cases := []int{1, 10, 100, 1000, 10000, 100000}
+	for i, c := range cases {
+		h := NewHyperLogLog()
+		for j := 0; j < c; j++ {
+			h.PFAdd(strconv.Itoa(j))
+		}
+		got := h.PFCount()
+		if diff := math.Abs(float64(got)-float64(c)) / float64(c); diff > 0.03 {
+			t.Errorf("%d: expected %d, got %d (error %.2f%%)", i, c, got, diff*100)
+		}
+	}
+}
+
+func TestHyperLogLogPFMerge(t *testing.T) {
+	h1, h2, h3 := NewHyperLogLog(), NewHyperLogLog(), NewHyperLogLog()
+	for i := 0; i < 5000; i++ {
+		h1.PFAdd("a" + strconv.Itoa(i))
+		h2.PFAdd("b" + strconv.Itoa(i))
+	}
+	h3.PFAdd("a0", "b0")
+	s2 := h2.String()
+
+	h1.PFMerge(h2, h3)
+	got := h1.PFCount()
+	if diff := math.Abs(float64(got)-10000) / 10000; diff > 0.03 {
+		t.Errorf("expected %d, got %d (error %.2f%%)", 10000, got, diff*100)
+	}
+	if h2.String() != s2 {
+		t.Errorf("merged HyperLogLog was modified")
+	}
+
+	// Merging sparse HyperLogLogs keeps the sparse encoding
+	h4 := NewHyperLogLog()
+	h4.PFMerge(h3)
+	if !h4.sparse() {
+		t.Errorf("expected sparse encoding")
+	}
+	if got := h4.PFCount(); got != 2 {
+		t.Errorf("expected %d, got %d", 2, got)
+	}
+}
+
+func TestMurmurHash64A(t *testing.T) {
+	// The hash must be stable, check that all tail lengths are handled
+	// and produce distinct values.
+	seen := make(map[uint64]bool)
+	for i := 0; i <= 16; i++ {
+		h := murmurHash64A("abcdefghijklmnop"[:i], hllSeed)
+		if seen[h] {
+			t.Errorf("%d: duplicate hash %x", i, h)
+		}
+		seen[h] = true
+	}
+}
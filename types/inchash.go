@@ -13,7 +13,10 @@ type IncHash interface {
 // Static type check to validate that *incHash implements IncHash.
 var _ IncHash = (*incHash)(nil)
 
-// incHash implements a IncHash.
+// incHash implements a IncHash. The embedded Hash starts with the
+// listpack encoding, and is converted to the map encoding once it holds
+// more than HashMaxListpackEntries fields or a field or value longer than
+// HashMaxListpackValue.
 type incHash struct {
 	Hash
 	// TODO : May hold the parsed integer value eventually
@@ -22,10 +25,62 @@ type incHash struct {
 // NewIncHash creates a new IncHash.
 func NewIncHash() IncHash {
 	return &incHash{
-		NewHash(),
+		newPackedHash(),
 	}
 }
 
+// convert converts the hash to the map encoding if it is listpack-encoded
+// and the tuples to set do not fit a listpack.
+func (ih *incHash) convert(tuples ...string) {
+	ph, ok := ih.Hash.(*packedHash)
+	if !ok {
+		return
+	}
+	n := ph.HLen()
+	fits := true
+	for i := 0; i < len(tuples); i += 2 {
+		if !ph.HExists(tuples[i]) {
+			n++
+		}
+		fits = fits && len(tuples[i]) <= HashMaxListpackValue && len(tuples[i+1]) <= HashMaxListpackValue
+	}
+	if !fits || n > int64(HashMaxListpackEntries) {
+		ih.Hash = ph.unpack()
+	}
+}
+
+// HMSet sets the values for all key-value tuples as received as argument.
+func (ih *incHash) HMSet(tuples ...string) {
+	ih.convert(tuples...)
+	ih.Hash.HMSet(tuples...)
+}
+
+// HSet sets the value of field to val, and returns true if the field had to be
+// created.
+func (ih *incHash) HSet(field, val string) bool {
+	ih.convert(field, val)
+	return ih.Hash.HSet(field, val)
+}
+
+// HSetNx sets the value of field to val only if the field does not already exists
+// in the hash. It returns true if it did create and set the field.
+func (ih *incHash) HSetNx(field, val string) bool {
+	ih.convert(field, val)
+	return ih.Hash.HSetNx(field, val)
+}
+
+// Clone returns a copy of the incrementable hash.
+func (ih *incHash) Clone() Value {
+	return &incHash{
+		ih.Hash.Clone().(Hash),
+	}
+}
+
+// MemoryUsage returns the estimated number of bytes used by the hash.
+func (ih *incHash) MemoryUsage(samples int) int64 {
+	return ifaceSize + ih.Hash.MemoryUsage(samples)
+}
+
 // HIncrBy increments the value of field by inc. It creates the field
 // and sets it at 0 before incrementing if field does not exist in the hash.
 // It returns false as second return value if it could not perform the
@@ -22,6 +22,20 @@ func cloneIncHash(ih IncHash) IncHash {
 	return newh
 }
 
+func TestIncHashClone(t *testing.T) {
+	c, ok := inchash.Clone().(IncHash)
+	if !ok {
+		t.Fatalf("expected clone to be an IncHash")
+	}
+	c.HIncrBy("a", 1)
+	if v, _ := inchash.HGet("a"); v != "1" {
+		t.Errorf("expected %q, got %q", "1", v)
+	}
+	if v, _ := c.HGet("a"); v != "2" {
+		t.Errorf("expected %q, got %q", "2", v)
+	}
+}
+
 func TestIncHashHIncrBy(t *testing.T) {
 	ih := cloneIncHash(inchash)
 	cases := []struct {
@@ -1,75 +1,282 @@
 package types
 
-import "strconv"
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrNotInteger is returned when incrementing a string that does not
+	// hold the canonical representation of a 64-bit integer.
+	ErrNotInteger = errors.New("value is not an integer or out of range")
+
+	// ErrNotFloat is returned when incrementing by a float a string that
+	// does not hold a valid float.
+	ErrNotFloat = errors.New("value is not a valid float")
+
+	// ErrIncrOverflow is returned when an integer increment or decrement
+	// would overflow a 64-bit integer.
+	ErrIncrOverflow = errors.New("increment or decrement would overflow")
+
+	// ErrIncrNaNOrInf is returned when a float increment would produce NaN
+	// or an infinity.
+	ErrIncrNaNOrInf = errors.New("increment would produce NaN or Infinity")
+)
 
 // IncString defines the methods required to implement an incrementable
 // string. IncString is a String with additional methods to increment
 // or decrement a numeric value.
 type IncString interface {
 	String
-	Decr() (int64, bool)
-	DecrBy(int64) (int64, bool)
-	Incr() (int64, bool)
-	IncrBy(int64) (int64, bool)
-	IncrByFloat(float64) (string, bool)
+	Decr() (int64, error)
+	DecrBy(int64) (int64, error)
+	Incr() (int64, error)
+	IncrBy(int64) (int64, error)
+	IncrByFloat(string) (string, error)
 }
 
 // Static type check to validate that *incString implements IncString.
 var _ IncString = (*incString)(nil)
 
-// incString implements an IncString.
+// numEnc is the numeric encoding of an incString.
+type numEnc int
+
+const (
+	encRaw numEnc = iota // only the string is valid
+	encInt               // n holds the value, the string may be stale
+)
+
+const (
+	// longDoublePrec is the precision of the mantissa of an x87 extended
+	// precision long double, with which Redis increments floats.
+	longDoublePrec = 64
+
+	// longDoubleMaxExp is the exponent of the largest long double, with
+	// the mantissa in [0.5, 1) as used by big.Float.
+	longDoubleMaxExp = 16384
+)
+
+// incString implements an IncString. Once incremented, it holds the
+// numeric value so that subsequent increments don't have to parse the
+// string again. The string form of an integer is only materialized when
+// the string is read or modified. As reads may happen concurrently under
+// the read lock of the key, the materialization has its own lock.
 type incString struct {
 	String
-	// TODO : May hold the parsed integer value eventually
+	enc numEnc
+	n   int64
+
+	mu    sync.Mutex
+	stale bool
 }
 
 // NewIncString creates a new IncString with the provided initial value.
 func NewIncString(initval string) IncString {
 	return &incString{
-		NewString(initval),
+		String: NewString(initval),
+	}
+}
+
+// Clone returns a copy of the incrementable string.
+func (is *incString) Clone() Value {
+	is.materialize()
+	return &incString{
+		String: is.String.Clone().(String),
+		enc:    is.enc,
+		n:      is.n,
+	}
+}
+
+// MemoryUsage returns the estimated number of bytes used by the string.
+func (is *incString) MemoryUsage(samples int) int64 {
+	is.materialize()
+	return ifaceSize + 2*wordSize + is.String.MemoryUsage(samples)
+}
+
+// materialize updates the string form of the value if it is stale.
+func (is *incString) materialize() {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	if is.stale {
+		is.String.Set(strconv.FormatInt(is.n, 10))
+		is.stale = false
 	}
 }
 
-// Decr decrements the value by 1. It returns false as second return value
-// if the current string value is not numeric.
-func (is *incString) Decr() (int64, bool) {
+// raw materializes the string form of the value and drops its numeric
+// encoding, before the string gets modified.
+func (is *incString) raw() {
+	is.materialize()
+	is.enc = encRaw
+}
+
+// Decr decrements the value by 1. It returns an error if the current
+// string value is not an integer or if the operation would overflow.
+func (is *incString) Decr() (int64, error) {
 	return is.IncrBy(-1)
 }
 
-// DecrBy decrements the value by dec. It returns false as second return value
-// if the current string value is not numeric.
-func (is *incString) DecrBy(dec int64) (int64, bool) {
-	return is.IncrBy(-1 * dec)
+// DecrBy decrements the value by dec. It returns an error if the current
+// string value is not an integer or if the operation would overflow.
+func (is *incString) DecrBy(dec int64) (int64, error) {
+	if dec == math.MinInt64 {
+		return 0, ErrIncrOverflow
+	}
+	return is.IncrBy(-dec)
 }
 
-// Incr increments the value by 1. It returns false as second return value
-// if the current string value is not numeric.
-func (is *incString) Incr() (int64, bool) {
+// Incr increments the value by 1. It returns an error if the current
+// string value is not an integer or if the operation would overflow.
+func (is *incString) Incr() (int64, error) {
 	return is.IncrBy(1)
 }
 
-// IncrBy increments the value by inc. It returns false as second return value
-// if the current string value is not numeric.
-func (is *incString) IncrBy(inc int64) (int64, bool) {
-	v, err := strconv.ParseInt(is.Get(), 10, 64)
-	if err != nil {
-		return 0, false
+// IncrBy increments the value by inc. It returns an error if the current
+// string value is not an integer or if the operation would overflow. Like
+// Redis, it only accepts the canonical representation of an integer, so
+// that values such as "+1" or "01" are rejected.
+func (is *incString) IncrBy(inc int64) (int64, error) {
+	v := is.n
+	if is.enc != encInt {
+		var ok bool
+		if v, ok = parseCanonicalInt(is.String.Get()); !ok {
+			return 0, ErrNotInteger
+		}
 	}
-	v += inc
-	is.Set(strconv.FormatInt(v, 10))
-	return v, true
+	if (inc < 0 && v < math.MinInt64-inc) || (inc > 0 && v > math.MaxInt64-inc) {
+		return 0, ErrIncrOverflow
+	}
+	is.enc, is.n, is.stale = encInt, v+inc, true
+	return is.n, nil
 }
 
-// IncrByFloat increments the value by inc. It returns false as second return value
-// if the current string value is not numeric.
-func (is *incString) IncrByFloat(inc float64) (string, bool) {
-	v, err := strconv.ParseFloat(is.Get(), 64)
-	if err != nil {
-		return "", false
+// IncrByFloat increments the value by inc, and returns the resulting
+// value. Like Redis, the value and the increment are parsed and added as
+// long doubles, and the result is formatted with 17 decimals, without the
+// trailing zeros and without an exponent. It returns an error if the
+// current string value or inc is not a valid float or if the result would
+// be NaN or an infinity.
+func (is *incString) IncrByFloat(inc string) (string, error) {
+	var v *big.Float
+	if is.enc == encInt {
+		v = new(big.Float).SetPrec(longDoublePrec).SetInt64(is.n)
+	} else {
+		var ok bool
+		if v, ok = parseLongDouble(is.String.Get()); !ok {
+			return "", ErrNotFloat
+		}
+	}
+	d, ok := parseLongDouble(inc)
+	if !ok {
+		return "", ErrNotFloat
+	}
+	if v.IsInf() || d.IsInf() {
+		return "", ErrIncrNaNOrInf
+	}
+	v.Add(v, d)
+	if v.MantExp(nil) > longDoubleMaxExp {
+		return "", ErrIncrNaNOrInf
 	}
-	v += inc
-	// TODO: Limit to 17 digits precision, like Redis?
-	ret := strconv.FormatFloat(v, 'f', -1, 64)
-	is.Set(ret)
-	return ret, true
+
+	ret := v.Text('f', 17)
+	ret = strings.TrimRight(strings.TrimRight(ret, "0"), ".")
+	is.String.Set(ret)
+	is.enc, is.stale = encRaw, false
+	return ret, nil
+}
+
+// parseLongDouble parses s as a decimal float with the precision of a long
+// double. It returns false if s is not a valid float.
+func parseLongDouble(s string) (*big.Float, bool) {
+	f, _, err := big.ParseFloat(s, 10, longDoublePrec, big.ToNearestEven)
+	return f, err == nil
+}
+
+// Append appends the value to the string and returns the new length.
+func (is *incString) Append(v string) int64 {
+	is.raw()
+	return is.String.Append(v)
+}
+
+// BitCount returns the number of bits set in the string.
+func (is *incString) BitCount(r BitRange) int64 {
+	is.materialize()
+	return is.String.BitCount(r)
+}
+
+// BitFieldGet returns the value of the bit field.
+func (is *incString) BitFieldGet(f BitField) int64 {
+	is.materialize()
+	return is.String.BitFieldGet(f)
+}
+
+// BitFieldIncrBy increments the bit field by inc.
+func (is *incString) BitFieldIncrBy(f BitField, inc int64, ovf BitFieldOverflow) (int64, bool) {
+	is.raw()
+	return is.String.BitFieldIncrBy(f, inc, ovf)
+}
+
+// BitFieldSet sets the bit field to v.
+func (is *incString) BitFieldSet(f BitField, v int64, ovf BitFieldOverflow) (int64, bool) {
+	is.raw()
+	return is.String.BitFieldSet(f, v, ovf)
+}
+
+// BitPos returns the position of the first bit set to bit.
+func (is *incString) BitPos(bit int, r BitRange, end bool) int64 {
+	is.materialize()
+	return is.String.BitPos(bit, r, end)
+}
+
+// Get returns the value of the string.
+func (is *incString) Get() string {
+	is.materialize()
+	return is.String.Get()
+}
+
+// GetBit returns the value of the bit at offset.
+func (is *incString) GetBit(offset int64) int {
+	is.materialize()
+	return is.String.GetBit(offset)
+}
+
+// GetRange returns the substring between start and end.
+func (is *incString) GetRange(start, end int64) string {
+	is.materialize()
+	return is.String.GetRange(start, end)
+}
+
+// GetSet sets the value of the string and returns its old value.
+func (is *incString) GetSet(v string) string {
+	is.raw()
+	return is.String.GetSet(v)
+}
+
+// Set sets the value of the string.
+func (is *incString) Set(v string) {
+	is.enc, is.stale = encRaw, false
+	is.String.Set(v)
+}
+
+// SetBit sets the bit at offset to v and returns its old value.
+func (is *incString) SetBit(offset int64, v int) int {
+	is.raw()
+	return is.String.SetBit(offset, v)
+}
+
+// SetRange overwrites the string starting at offset with v, and returns
+// the new length.
+func (is *incString) SetRange(offset int64, v string) int64 {
+	is.raw()
+	return is.String.SetRange(offset, v)
+}
+
+// StrLen returns the length of the string.
+func (is *incString) StrLen() int64 {
+	is.materialize()
+	return is.String.StrLen()
 }
@@ -1,52 +1,87 @@
 package types
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
 
 func TestIncStringIncr(t *testing.T) {
 	cases := []struct {
 		s   string
 		exp int64
-		ok  bool
+		err error
 	}{
-		0: {"", 0, false},
-		1: {"0", 1, true},
-		2: {"5", 6, true},
-		3: {"-6.34", 0, false},
-		4: {"-6", -5, true},
-		5: {"abc", 0, false},
+		0: {"", 0, ErrNotInteger},
+		1: {"0", 1, nil},
+		2: {"5", 6, nil},
+		3: {"-6.34", 0, ErrNotInteger},
+		4: {"-6", -5, nil},
+		5: {"abc", 0, ErrNotInteger},
 	}
 	for i, c := range cases {
 		is := NewIncString(c.s)
-		got, ok := is.Incr()
+		got, err := is.Incr()
 		if c.exp != got {
 			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
 		}
-		if c.ok != ok {
-			t.Errorf("%d: expected %t, got %t", i, c.ok, ok)
+		if c.err != err {
+			t.Errorf("%d: expected error %v, got %v", i, c.err, err)
 		}
 	}
 }
 
+func TestIncStringClone(t *testing.T) {
+	s := NewIncString("1")
+	c, ok := s.Clone().(IncString)
+	if !ok {
+		t.Fatalf("expected clone to be an IncString")
+	}
+	c.Incr()
+	if got := s.Get(); got != "1" {
+		t.Errorf("expected %q, got %q", "1", got)
+	}
+	if got := c.Get(); got != "2" {
+		t.Errorf("expected %q, got %q", "2", got)
+	}
+
+	// cloning an incremented value materializes its string form
+	c.Incr()
+	cc := c.Clone().(IncString)
+	cc.Incr()
+	if got := c.Get(); got != "3" {
+		t.Errorf("expected %q, got %q", "3", got)
+	}
+	if got := cc.Get(); got != "4" {
+		t.Errorf("expected %q, got %q", "4", got)
+	}
+}
+
 func TestIncStringIncrBy(t *testing.T) {
 	cases := []struct {
 		s   string
 		inc int64
 		exp int64
-		ok  bool
+		err error
 	}{
-		0: {"", 1, 0, false},
-		1: {"3", 45, 48, true},
-		2: {"3", -45, -42, true},
-		3: {"abc", -45, 0, false},
+		0: {"", 1, 0, ErrNotInteger},
+		1: {"3", 45, 48, nil},
+		2: {"3", -45, -42, nil},
+		3: {"abc", -45, 0, ErrNotInteger},
+		4: {"+3", 1, 0, ErrNotInteger},
+		5: {"03", 1, 0, ErrNotInteger},
+		6: {" 3", 1, 0, ErrNotInteger},
+		7: {"9223372036854775806", 1, math.MaxInt64, nil},
+		8: {"9223372036854775807", 1, 0, ErrIncrOverflow},
+		9: {"-9223372036854775807", -2, 0, ErrIncrOverflow},
 	}
 	for i, c := range cases {
 		is := NewIncString(c.s)
-		got, ok := is.IncrBy(c.inc)
+		got, err := is.IncrBy(c.inc)
 		if c.exp != got {
 			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
 		}
-		if c.ok != ok {
-			t.Errorf("%d: expected %t, got %t", i, c.ok, ok)
+		if c.err != err {
+			t.Errorf("%d: expected error %v, got %v", i, c.err, err)
 		}
 	}
 }
@@ -54,24 +89,33 @@ func TestIncStringIncrBy(t *testing.T) {
 func TestIncStringIncrByFloat(t *testing.T) {
 	cases := []struct {
 		s   string
-		inc float64
+		inc string
 		exp string
-		ok  bool
+		err error
 	}{
-		0: {"", 1.0, "", false},
-		1: {"a", 1.3, "", false},
-		2: {"3", 1.3, "4.3", true},
-		3: {"3.4", 1.3, "4.7", true},
-		4: {"-3.45", -2.3, "-5.75", true},
+		0:  {"", "1.0", "", ErrNotFloat},
+		1:  {"a", "1.3", "", ErrNotFloat},
+		2:  {"3", "1.3", "4.3", nil},
+		3:  {"3.4", "1.3", "4.7", nil},
+		4:  {"-3.45", "-2.3", "-5.75", nil},
+		5:  {"10.5", "0.1", "10.6", nil},
+		6:  {"5.0e3", "2.0e2", "5200", nil},
+		7:  {"1", "inf", "", ErrIncrNaNOrInf},
+		8:  {"nan", "1", "", ErrNotFloat},
+		9:  {"1.1e4932", "1.1e4932", "", ErrIncrNaNOrInf},
+		10: {"0.1", "0.2", "0.3", nil},
+		11: {"9223372036854775807", "0", "9223372036854775807", nil},
+		12: {"9223372036854775807", "1", "9223372036854775808", nil},
+		13: {"1", "a", "", ErrNotFloat},
 	}
 	for i, c := range cases {
 		is := NewIncString(c.s)
-		got, ok := is.IncrByFloat(c.inc)
+		got, err := is.IncrByFloat(c.inc)
 		if c.exp != got {
 			t.Errorf("%d: expected %s, got %s", i, c.exp, got)
 		}
-		if c.ok != ok {
-			t.Errorf("%d: expected %t, got %t", i, c.ok, ok)
+		if c.err != err {
+			t.Errorf("%d: expected error %v, got %v", i, c.err, err)
 		}
 	}
 }
@@ -80,23 +124,23 @@ func TestIncStringDecr(t *testing.T) {
 	cases := []struct {
 		s   string
 		exp int64
-		ok  bool
+		err error
 	}{
-		0: {"", 0, false},
-		1: {"0", -1, true},
-		2: {"5", 4, true},
-		3: {"-6.34", 0, false},
-		4: {"-6", -7, true},
-		5: {"abc", 0, false},
+		0: {"", 0, ErrNotInteger},
+		1: {"0", -1, nil},
+		2: {"5", 4, nil},
+		3: {"-6.34", 0, ErrNotInteger},
+		4: {"-6", -7, nil},
+		5: {"abc", 0, ErrNotInteger},
 	}
 	for i, c := range cases {
 		is := NewIncString(c.s)
-		got, ok := is.Decr()
+		got, err := is.Decr()
 		if c.exp != got {
 			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
 		}
-		if c.ok != ok {
-			t.Errorf("%d: expected %t, got %t", i, c.ok, ok)
+		if c.err != err {
+			t.Errorf("%d: expected error %v, got %v", i, c.err, err)
 		}
 	}
 }
@@ -106,21 +150,80 @@ func TestIncStringDecrBy(t *testing.T) {
 		s   string
 		dec int64
 		exp int64
-		ok  bool
+		err error
 	}{
-		0: {"", 1, 0, false},
-		1: {"3", 45, -42, true},
-		2: {"3", -45, 48, true},
-		3: {"abc", 45, 0, false},
+		0: {"", 1, 0, ErrNotInteger},
+		1: {"3", 45, -42, nil},
+		2: {"3", -45, 48, nil},
+		3: {"abc", 45, 0, ErrNotInteger},
+		4: {"-9223372036854775807", 1, math.MinInt64, nil},
+		5: {"-9223372036854775808", 1, 0, ErrIncrOverflow},
+		6: {"0", math.MinInt64, 0, ErrIncrOverflow},
 	}
 	for i, c := range cases {
 		is := NewIncString(c.s)
-		got, ok := is.DecrBy(c.dec)
+		got, err := is.DecrBy(c.dec)
 		if c.exp != got {
 			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
 		}
-		if c.ok != ok {
-			t.Errorf("%d: expected %t, got %t", i, c.ok, ok)
+		if c.err != err {
+			t.Errorf("%d: expected error %v, got %v", i, c.err, err)
 		}
 	}
 }
+
+func TestIncStringLazy(t *testing.T) {
+	is := NewIncString("10").(*incString)
+	for i := 0; i < 3; i++ {
+		if _, err := is.Incr(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !is.stale {
+		t.Fatalf("expected the string form to be stale")
+	}
+	if got := Encoding(is); got != "int" {
+		t.Errorf("expected encoding %q, got %q", "int", got)
+	}
+	if got := is.GetRange(0, -1); got != "13" {
+		t.Errorf("expected %q, got %q", "13", got)
+	}
+	if is.stale || is.enc != encInt {
+		t.Errorf("expected a materialized int, got stale=%t enc=%d", is.stale, is.enc)
+	}
+
+	// the string form is materialized before appending
+	is.Incr()
+	if n := is.Append("0"); n != 3 {
+		t.Errorf("expected length %d, got %d", 3, n)
+	}
+	if got := is.Get(); got != "140" {
+		t.Errorf("expected %q, got %q", "140", got)
+	}
+	if n, err := is.Incr(); n != 141 || err != nil {
+		t.Errorf("expected %d, got %d (%v)", 141, n, err)
+	}
+
+	// a float increment keeps the string in sync
+	if got, err := is.IncrByFloat("0.5"); got != "141.5" || err != nil {
+		t.Errorf("expected %q, got %q (%v)", "141.5", got, err)
+	}
+	if _, err := is.Incr(); err != ErrNotInteger {
+		t.Errorf("expected error %v, got %v", ErrNotInteger, err)
+	}
+	if got, err := is.IncrByFloat("-0.5"); got != "141" || err != nil {
+		t.Errorf("expected %q, got %q (%v)", "141", got, err)
+	}
+	if n, err := is.Incr(); n != 142 || err != nil {
+		t.Errorf("expected %d, got %d (%v)", 142, n, err)
+	}
+
+	// setting the string drops the integer
+	is.Set("abc")
+	if got := is.Get(); got != "abc" {
+		t.Errorf("expected %q, got %q", "abc", got)
+	}
+	if _, err := is.Incr(); err != ErrNotInteger {
+		t.Errorf("expected error %v, got %v", ErrNotInteger, err)
+	}
+}
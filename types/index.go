@@ -0,0 +1,191 @@
+package types
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand"
+)
+
+// minIndexBuckets is the number of buckets of the hash table of a
+// non-empty Index, below which it does not shrink.
+const minIndexBuckets = 4
+
+// indexSeed is the seed of the hash function of the Index values, so that
+// the distribution of the names in the buckets cannot be predicted.
+var indexSeed = maphash.MakeSeed()
+
+// Index is a set of names stored in a hash table, so that a cursor-based
+// iteration only visits a few buckets per call, and that a random name is
+// selected in O(1). The names are held in a slice, and each bucket is a
+// chain of indices in this slice, so that removing a name moves the last
+// one in its slot. The zero value is an empty Index ready to use.
+//
+// The cursor of Scan is the index of the next bucket to visit, which is
+// incremented from its most significant bit, as Redis does for its SCAN
+// commands. The table only ever doubles or halves, so a name that is
+// present for the whole iteration is returned at least once even if the
+// table is resized between calls, some names being returned more than
+// once if it shrinks.
+type Index struct {
+	names   []string
+	next    []int
+	buckets []int
+}
+
+// indexHash returns the hash of the name that selects its bucket.
+func indexHash(name string) uint64 {
+	var h maphash.Hash
+	h.SetSeed(indexSeed)
+	h.WriteString(name)
+	return h.Sum64()
+}
+
+// Len returns the number of names in the index.
+func (x *Index) Len() int {
+	return len(x.names)
+}
+
+// Has returns true if the name is in the index.
+func (x *Index) Has(name string) bool {
+	if len(x.names) == 0 {
+		return false
+	}
+	_, ok := x.find(name)
+	return ok
+}
+
+// Add adds the name to the index, and returns true if it was not already
+// in it.
+func (x *Index) Add(name string) bool {
+	if len(x.buckets) == 0 {
+		x.resize(minIndexBuckets)
+	}
+	p, ok := x.find(name)
+	if ok {
+		return false
+	}
+	*p = len(x.names)
+	x.names = append(x.names, name)
+	x.next = append(x.next, -1)
+	if len(x.names) > len(x.buckets) {
+		x.resize(2 * len(x.buckets))
+	}
+	return true
+}
+
+// Remove removes the name from the index, and returns true if it was in
+// it.
+func (x *Index) Remove(name string) bool {
+	if len(x.names) == 0 {
+		return false
+	}
+	p, ok := x.find(name)
+	if !ok {
+		return false
+	}
+	i := *p
+	*p = x.next[i]
+
+	last := len(x.names) - 1
+	if i != last {
+		q, _ := x.find(x.names[last])
+		*q = i
+		x.names[i], x.next[i] = x.names[last], x.next[last]
+	}
+	if last == 0 {
+		*x = Index{}
+		return true
+	}
+	x.names[last] = ""
+	x.names, x.next = x.names[:last], x.next[:last]
+	if len(x.buckets) > minIndexBuckets && len(x.names) < len(x.buckets)/8 {
+		x.resize(len(x.buckets) / 2)
+	}
+	return true
+}
+
+// Names returns the names of the index, in the order in which they are
+// stored. The returned slice must not be modified.
+func (x *Index) Names() []string {
+	return x.names
+}
+
+// Clone returns a copy of the index.
+func (x *Index) Clone() Index {
+	return Index{
+		names:   append([]string(nil), x.names...),
+		next:    append([]int(nil), x.next...),
+		buckets: append([]int(nil), x.buckets...),
+	}
+}
+
+// memoryUsage returns the estimated number of bytes used by the index,
+// besides the data of the names.
+func (x *Index) memoryUsage() int64 {
+	return 3*sliceHeaderSize + int64(cap(x.names))*stringHeaderSize + int64(cap(x.next)+len(x.buckets))*wordSize
+}
+
+// Random returns a name of the index selected uniformly at random, or
+// false if the index is empty.
+func (x *Index) Random() (string, bool) {
+	if len(x.names) == 0 {
+		return "", false
+	}
+	return x.names[rand.Intn(len(x.names))], true
+}
+
+// Scan calls fn for the names of the buckets visited from cursor, until
+// count names were visited or the iteration is done, and returns the
+// cursor of the next call, which is 0 when the iteration is done. At most
+// 10*count empty buckets are visited, so that a call stays short when the
+// table is sparse. The function fn must not modify the index.
+func (x *Index) Scan(cursor uint64, count int64, fn func(string)) uint64 {
+	if len(x.names) == 0 {
+		return 0
+	}
+	mask := uint64(len(x.buckets) - 1)
+	var n, empty int64
+	for {
+		i := x.buckets[cursor&mask]
+		if i < 0 {
+			empty++
+		}
+		for ; i >= 0; i = x.next[i] {
+			fn(x.names[i])
+			n++
+		}
+
+		// Set the bits that are not part of the bucket index, so that the
+		// increment of the reversed cursor carries over them.
+		cursor |= ^mask
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+		if cursor == 0 || n >= count || empty >= 10*count {
+			return cursor
+		}
+	}
+}
+
+// find returns a pointer to the entry of the table that holds the index
+// of the name, which is either the head of its bucket or the next entry of
+// the previous name of the bucket, and true if the name is in the index.
+// If it is not, the entry is the end of the bucket, where it can be added.
+func (x *Index) find(name string) (*int, bool) {
+	p := &x.buckets[indexHash(name)&uint64(len(x.buckets)-1)]
+	for *p >= 0 && x.names[*p] != name {
+		p = &x.next[*p]
+	}
+	return p, *p >= 0
+}
+
+// resize rebuilds the table with n buckets, n being a power of 2.
+func (x *Index) resize(n int) {
+	x.buckets = make([]int, n)
+	for i := range x.buckets {
+		x.buckets[i] = -1
+	}
+	for i, nm := range x.names {
+		b := indexHash(nm) & uint64(n-1)
+		x.next[i] = x.buckets[b]
+		x.buckets[b] = i
+	}
+}
@@ -0,0 +1,92 @@
+package types
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	var x Index
+	if _, ok := x.Random(); ok || x.Has("a") || x.Remove("a") {
+		t.Fatal("expected an empty index")
+	}
+	for i := 0; i < 1000; i++ {
+		if !x.Add(fmt.Sprintf("key:%d", i)) {
+			t.Fatalf("expected key:%d to be added", i)
+		}
+	}
+	if x.Add("key:10") || x.Len() != 1000 {
+		t.Fatalf("expected key:10 to already exist and 1000 names, got %d", x.Len())
+	}
+	for i := 0; i < 1000; i += 2 {
+		if !x.Remove(fmt.Sprintf("key:%d", i)) {
+			t.Fatalf("expected key:%d to be removed", i)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if x.Has(fmt.Sprintf("key:%d", i)) != (i%2 == 1) {
+			t.Errorf("key:%d: expected Has to be %t", i, i%2 == 1)
+		}
+	}
+	if nm, ok := x.Random(); !ok || !x.Has(nm) {
+		t.Errorf("expected a random name of the index, got %q", nm)
+	}
+
+	// The table shrinks as the names are removed
+	for i := 1; i < 990; i += 2 {
+		x.Remove(fmt.Sprintf("key:%d", i))
+	}
+	if x.Len() != 5 || len(x.buckets) > 64 {
+		t.Errorf("expected 5 names in at most 64 buckets, got %d in %d", x.Len(), len(x.buckets))
+	}
+	for i := 991; i < 1000; i += 2 {
+		x.Remove(fmt.Sprintf("key:%d", i))
+	}
+	if x.Len() != 0 || x.buckets != nil {
+		t.Errorf("expected an empty index, got %d names in %d buckets", x.Len(), len(x.buckets))
+	}
+}
+
+func TestIndexScan(t *testing.T) {
+	var x Index
+	for i := 0; i < 10000; i++ {
+		x.Add(fmt.Sprintf("key:%d", i))
+	}
+
+	// A call only visits about count names
+	var n int
+	cur := x.Scan(0, 10, func(string) { n++ })
+	if cur == 0 || n < 10 || n > 30 {
+		t.Errorf("expected about 10 names visited, got %d with cursor %d", n, cur)
+	}
+
+	// Iterate while the table grows and shrinks, the names present for the
+	// whole iteration are returned at least once
+	seen := make(map[string]int)
+	cur, calls := uint64(0), 0
+	for {
+		cur = x.Scan(cur, 100, func(nm string) { seen[nm]++ })
+		calls++
+		if cur == 0 {
+			break
+		}
+		switch calls {
+		case 10:
+			for i := 10000; i < 30000; i++ {
+				x.Add(fmt.Sprintf("key:%d", i))
+			}
+		case 50:
+			for i := 5000; i < 30000; i++ {
+				x.Remove(fmt.Sprintf("key:%d", i))
+			}
+		}
+	}
+	for i := 0; i < 5000; i++ {
+		if nm := fmt.Sprintf("key:%d", i); seen[nm] == 0 {
+			t.Errorf("expected %s to be returned", nm)
+		}
+	}
+	if calls < 50 {
+		t.Errorf("expected at least 50 calls, got %d", calls)
+	}
+}
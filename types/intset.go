@@ -0,0 +1,153 @@
+package types
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// intset is a sorted set of integers, packed in a single byte slice where
+// each integer uses the same width, 2, 4 or 8 bytes, that is the smallest
+// that can store all its integers. It is the encoding of small sets whose
+// members are all integers.
+type intset struct {
+	width int
+	b     []byte
+}
+
+// parseCanonicalInt returns the integer value of s, if s is the canonical
+// decimal representation of an integer, as required to store it in an intset
+// or to report the int encoding of a string.
+func parseCanonicalInt(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != s {
+		return 0, false
+	}
+	return n, true
+}
+
+// intWidth returns the number of bytes needed to store n.
+func intWidth(n int64) int {
+	switch {
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		return 2
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// len returns the number of integers in the intset.
+func (is *intset) len() int {
+	if is.width == 0 {
+		return 0
+	}
+	return len(is.b) / is.width
+}
+
+// memoryUsage returns the estimated number of bytes used by the intset.
+func (is *intset) memoryUsage() int64 {
+	return wordSize + sliceHeaderSize + int64(cap(is.b))
+}
+
+// clone returns a copy of the intset.
+func (is *intset) clone() *intset {
+	b := make([]byte, len(is.b))
+	copy(b, is.b)
+	return &intset{is.width, b}
+}
+
+// at returns the integer at index i.
+func (is *intset) at(i int) int64 {
+	p := is.b[i*is.width:]
+	switch is.width {
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(p)))
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(p)))
+	default:
+		return int64(binary.LittleEndian.Uint64(p))
+	}
+}
+
+// put stores n at index i, using the width of the intset.
+func (is *intset) put(i int, n int64) {
+	p := is.b[i*is.width:]
+	switch is.width {
+	case 2:
+		binary.LittleEndian.PutUint16(p, uint16(n))
+	case 4:
+		binary.LittleEndian.PutUint32(p, uint32(n))
+	default:
+		binary.LittleEndian.PutUint64(p, uint64(n))
+	}
+}
+
+// search returns the index of n in the intset, or the index where it
+// would be inserted, and whether it is in the intset.
+func (is *intset) search(n int64) (int, bool) {
+	l := is.len()
+	i := sort.Search(l, func(i int) bool {
+		return is.at(i) >= n
+	})
+	return i, i < l && is.at(i) == n
+}
+
+// has returns true if n is in the intset.
+func (is *intset) has(n int64) bool {
+	_, ok := is.search(n)
+	return ok
+}
+
+// add adds n to the intset, and returns true if it was not already there.
+// The width of the intset is upgraded if n does not fit.
+func (is *intset) add(n int64) bool {
+	if w := intWidth(n); w > is.width {
+		is.upgrade(w)
+	}
+	i, ok := is.search(n)
+	if ok {
+		return false
+	}
+	l := is.len()
+	is.b = append(is.b, make([]byte, is.width)...)
+	copy(is.b[(i+1)*is.width:], is.b[i*is.width:l*is.width])
+	is.put(i, n)
+	return true
+}
+
+// upgrade converts the intset to the larger width w.
+func (is *intset) upgrade(w int) {
+	old := *is
+	is.width = w
+	is.b = make([]byte, old.len()*w)
+	for i := 0; i < old.len(); i++ {
+		is.put(i, old.at(i))
+	}
+}
+
+// remove removes n from the intset, and returns true if it was there. The
+// width is never downgraded.
+func (is *intset) remove(n int64) bool {
+	i, ok := is.search(n)
+	if !ok {
+		return false
+	}
+	is.b = append(is.b[:i*is.width], is.b[(i+1)*is.width:]...)
+	return true
+}
+
+// strings returns the integers of the intset as strings, in increasing
+// order.
+func (is *intset) strings() []string {
+	vals := make([]string, is.len())
+	for i := range vals {
+		vals[i] = strconv.FormatInt(is.at(i), 10)
+	}
+	return vals
+}
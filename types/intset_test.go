@@ -0,0 +1,103 @@
+package types
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseCanonicalInt(t *testing.T) {
+	cases := []struct {
+		s   string
+		exp int64
+		ok  bool
+	}{
+		0:  {"", 0, false},
+		1:  {"0", 0, true},
+		2:  {"-0", 0, false},
+		3:  {"+1", 0, false},
+		4:  {"01", 0, false},
+		5:  {"-12", -12, true},
+		6:  {" 1", 0, false},
+		7:  {"1.0", 0, false},
+		8:  {"9223372036854775807", math.MaxInt64, true},
+		9:  {"-9223372036854775808", math.MinInt64, true},
+		10: {"9223372036854775808", 0, false},
+	}
+	for i, c := range cases {
+		got, ok := parseCanonicalInt(c.s)
+		if got != c.exp || ok != c.ok {
+			t.Errorf("%d: expected %d %t, got %d %t", i, c.exp, c.ok, got, ok)
+		}
+	}
+}
+
+func TestIntsetUpgrade(t *testing.T) {
+	is := &intset{}
+	is.add(3)
+	is.add(-1)
+	if is.width != 2 {
+		t.Errorf("expected width 2, got %d", is.width)
+	}
+	is.add(math.MaxInt16 + 1)
+	if is.width != 4 {
+		t.Errorf("expected width 4, got %d", is.width)
+	}
+	is.add(math.MinInt64)
+	if is.width != 8 {
+		t.Errorf("expected width 8, got %d", is.width)
+	}
+	c := is.clone()
+	is.remove(3)
+	exp := []string{"-9223372036854775808", "-1", "32768"}
+	if got := is.strings(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+	if c.len() != 4 {
+		t.Errorf("expected clone to be unchanged, got %v", c.strings())
+	}
+}
+
+func TestIntsetModel(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	is := &intset{}
+	exp := make(map[int64]bool)
+	for i := 0; i < 5000; i++ {
+		n := rnd.Int63n(200) - 100
+		if i > 2000 {
+			n <<= uint(rnd.Intn(56))
+		}
+		if rnd.Intn(3) == 0 {
+			if got := is.remove(n); got != exp[n] {
+				t.Fatalf("%d: expected remove %d to be %t, got %t", i, n, exp[n], got)
+			}
+			delete(exp, n)
+		} else {
+			if got := is.add(n); got == exp[n] {
+				t.Fatalf("%d: expected add %d to be %t, got %t", i, n, !exp[n], got)
+			}
+			exp[n] = true
+		}
+	}
+	vals := make([]int, 0, len(exp))
+	for n := range exp {
+		vals = append(vals, int(n))
+	}
+	sort.Ints(vals)
+	if is.len() != len(vals) {
+		t.Fatalf("expected %d integers, got %d", len(vals), is.len())
+	}
+	for i, n := range vals {
+		if got := is.at(i); got != int64(n) {
+			t.Fatalf("%d: expected %d, got %d", i, n, got)
+		}
+		if !is.has(int64(n)) {
+			t.Fatalf("%d: expected %d to be in the intset", i, n)
+		}
+	}
+	if is.has(math.MaxInt64) {
+		t.Errorf("expected %d not to be in the intset", int64(math.MaxInt64))
+	}
+}
@@ -9,6 +9,7 @@ type List interface {
 	LInsertAfter(string, string) int64
 	LLen() int64
 	LPop() (string, bool)
+	LPos(string, int64, int64, int64) []int64
 	LPush(...string) int64
 	LRange(int64, int64) []string
 	LRem(int64, string) int64
@@ -18,38 +19,96 @@ type List interface {
 	RPush(...string) int64
 }
 
-// initListCap is the initial capacity of the list.
-const initListCap int = 10
+const (
+	// listNodeSize is the maximum number of values held by a node of a
+	// list.
+	listNodeSize = 128
+
+	// listNodeMinSize is the initial size of the array of a node, that
+	// doubles as needed up to listNodeSize.
+	listNodeMinSize = 8
+)
 
 // Static type check to validate that *list implements List.
 var _ List = (*list)(nil)
 
-// list is the internal type that implements List.
-type list []string
+// list is the internal type that implements List. Like the quicklist of
+// Redis, it is a doubly-linked list of nodes that each hold up to
+// listNodeSize values in an array, so that pushing and popping at both
+// ends is O(1), and that looking up an index skips whole nodes.
+//
+// Adjacent nodes are merged when a removal in the middle of the list
+// leaves them with few enough values to fit in a single node, so each
+// node holds, on average, at least half of listNodeSize values.
+//
+// A small list is stored in a listpack instead, and converted to nodes
+// once it grows past ListMaxListpackSize. The length is maintained in both
+// encodings.
+type list struct {
+	lp         *listpack
+	head, tail *listNode
+	length     int64
+}
+
+// listNode is a node of a list. The values are held in vals[start:end],
+// so that there is room to push values on both sides. The slots outside
+// this range are always zeroed, so that popped values can be collected.
+type listNode struct {
+	prev, next *listNode
+	start, end int
+	vals       []string
+}
 
 // NewList creates a new List.
 func NewList() List {
-	l := make(list, 0, initListCap)
-	return &l
+	return &list{lp: &listpack{}}
 }
 
-// TODO : Must set zero value to elements of a slice before slicing
-// them out (i.e. before `sl = sl[1:]`). Will leak memory otherwise.
-
 // Type returns the type of this value, which is "list".
-func (l list) Type() string {
+func (l *list) Type() string {
 	return "list"
 }
 
+// Clone returns a copy of the list.
+func (l *list) Clone() Value {
+	if l.lp != nil {
+		return &list{lp: l.lp.clone(), length: l.length}
+	}
+	c := &list{}
+	for n := l.head; n != nil; n = n.next {
+		c.RPush(n.values()...)
+	}
+	return c
+}
+
+// MemoryUsage returns the estimated number of bytes used by the list,
+// extrapolated from the size of its first samples values.
+func (l *list) MemoryUsage(samples int) int64 {
+	size := int64(4 * wordSize)
+	if l.lp != nil {
+		return size + l.lp.memoryUsage()
+	}
+	var nodes, slots int64
+	for n := l.head; n != nil; n = n.next {
+		nodes++
+		slots += int64(len(n.vals))
+	}
+	sampled := l.LRange(0, sampleCount(l.length, samples)-1)
+	return size + nodes*(4*wordSize+sliceHeaderSize) + slots*stringHeaderSize + sampledSize(l.length, sampled)
+}
+
 // LIndex returns the value at index ix. It returns false as second
 // return value if index is out of bounds.
 func (l *list) LIndex(ix int64) (string, bool) {
-	ln := int64(len(*l))
 	if ix < 0 {
-		ix += ln
+		ix += l.length
 	}
-	if ix >= 0 && ix < ln {
-		return (*l)[ix], true
+	if ix >= 0 && ix < l.length {
+		if l.lp != nil {
+			return l.lp.get(int(ix)), true
+		}
+		n, i := l.find(ix)
+		return n.vals[i], true
 	}
 	return "", false
 }
@@ -57,63 +116,135 @@ func (l *list) LIndex(ix int64) (string, bool) {
 // LInsertBefore inserts val in the list before the pivot value. It returns
 // the new length of the list, or -1 if the pivot value was not found.
 func (l *list) LInsertBefore(pivot, val string) int64 {
-	for i := 0; i < len(*l); i++ {
-		if (*l)[i] == pivot {
-			// Append a dummy value so that there's enough room in the slice
-			*l = append(*l, "")
-			// Copy all elements starting at pivot, one element to the right
-			copy((*l)[i+1:], (*l)[i:])
-			// Insert the new element at i
-			(*l)[i] = val
-			return int64(len(*l))
-		}
+	if l.lp != nil {
+		var ret int64
+		l.unpacked(func(q *list) { ret = q.LInsertBefore(pivot, val) })
+		return ret
 	}
-	return -1
+	ix := l.indexOf(pivot)
+	if ix < 0 {
+		return -1
+	}
+	l.insert(ix, val)
+	return l.length
 }
 
 // LInsertAfter inserts val in the list after the pivot value. It returns
 // the new length of the list, or -1 if the pivot value was not found.
 func (l *list) LInsertAfter(pivot, val string) int64 {
-	for i := 0; i < len(*l); i++ {
-		if (*l)[i] == pivot {
-			// Append a dummy value so that there's enough room in the slice
-			*l = append(*l, "")
-			// Copy all elements starting at pivot, one element to the right
-			copy((*l)[i+2:], (*l)[i+1:])
-			// Insert the new element at i+1
-			(*l)[i+1] = val
-			return int64(len(*l))
-		}
+	if l.lp != nil {
+		var ret int64
+		l.unpacked(func(q *list) { ret = q.LInsertAfter(pivot, val) })
+		return ret
 	}
-	return -1
+	ix := l.indexOf(pivot)
+	if ix < 0 {
+		return -1
+	}
+	l.insert(ix+1, val)
+	return l.length
 }
 
 // LLen returns the length of the list.
 func (l *list) LLen() int64 {
-	return int64(len(*l))
+	return l.length
 }
 
 // LPop pops a value from the head of the list and returns it. It returns false
 // as second value if it could not return a value.
 func (l *list) LPop() (string, bool) {
-	if len(*l) == 0 {
+	if l.lp != nil {
+		return l.packedPop(0)
+	}
+	n := l.head
+	if n == nil {
 		return "", false
 	}
-	val := (*l)[0]
-	(*l)[0] = ""
-	*l = (*l)[1:]
+	val := n.vals[n.start]
+	n.vals[n.start] = ""
+	n.start++
+	l.length--
+	if n.len() == 0 {
+		l.unlink(n)
+	}
 	return val, true
 }
 
+// LPos returns the indices of the occurrences of val in the list. The
+// search starts at the head of the list and skips the first rank-1
+// occurrences if rank is positive, or starts at the tail and skips the
+// first -rank-1 occurrences if rank is negative. It returns at most cnt
+// indices, and compares at most maxLen elements, 0 meaning no limit for
+// both. The rank must not be 0.
+func (l *list) LPos(val string, rank, cnt, maxLen int64) []int64 {
+	if l.lp != nil {
+		return l.quicklist().LPos(val, rank, cnt, maxLen)
+	}
+	if maxLen <= 0 || maxLen > l.length {
+		maxLen = l.length
+	}
+
+	var ixs []int64
+	// match records the occurrence at index ix, and returns true when
+	// the search is done.
+	match := func(ix int64) bool {
+		if rank > 1 || rank < -1 {
+			if rank > 0 {
+				rank--
+			} else {
+				rank++
+			}
+			return false
+		}
+		ixs = append(ixs, ix)
+		return cnt > 0 && int64(len(ixs)) == cnt
+	}
+
+	var ix int64
+	if rank > 0 {
+		for n := l.head; n != nil && ix < maxLen; n = n.next {
+			for i := n.start; i < n.end && ix < maxLen; i, ix = i+1, ix+1 {
+				if n.vals[i] == val && match(ix) {
+					return ixs
+				}
+			}
+		}
+		return ixs
+	}
+	for n := l.tail; n != nil && ix < maxLen; n = n.prev {
+		for i := n.end - 1; i >= n.start && ix < maxLen; i, ix = i-1, ix+1 {
+			if n.vals[i] == val && match(l.length-1-ix) {
+				return ixs
+			}
+		}
+	}
+	return ixs
+}
+
 // LPush pushes the provided values on the head of the list. It returns the new
 // length of the list.
 func (l *list) LPush(vals ...string) int64 {
-	// Reverse vals, then append
-	for i, j := 0, len(vals)-1; i < j; i, j = i+1, j-1 {
-		vals[i], vals[j] = vals[j], vals[i]
+	if l.lp != nil {
+		rev := make([]string, len(vals))
+		for i, val := range vals {
+			rev[len(vals)-1-i] = val
+		}
+		l.lp.insert(0, rev...)
+		l.length += int64(len(vals))
+		l.fit()
+		return l.length
+	}
+	for _, val := range vals {
+		n := l.head
+		if n == nil || n.start == 0 && !n.grow(true) {
+			n = newListNode(listNodeMinSize, true)
+			l.link(nil, n)
+		}
+		n.start--
+		n.vals[n.start] = val
+		l.length++
 	}
-	*l = append(vals, *l...)
-	return int64(len(*l))
+	return l.length
 }
 
 // LRange returns the values in the list between start and stop.
@@ -122,7 +253,24 @@ func (l *list) LRange(start, stop int64) []string {
 	if stop-start < 0 {
 		return empty
 	}
-	return (*l)[start : stop+1]
+	if l.lp != nil {
+		return l.lp.strings()[start : stop+1]
+	}
+
+	ret := make([]string, 0, stop-start+1)
+	n, i := l.find(start)
+	for remain := int(stop - start + 1); remain > 0; n = n.next {
+		end := n.end
+		if end-i > remain {
+			end = i + remain
+		}
+		ret = append(ret, n.vals[i:end]...)
+		remain -= end - i
+		if n.next != nil {
+			i = n.next.start
+		}
+	}
+	return ret
 }
 
 // LRem removes up to cnt occurrences of val from the list. If cnt is
@@ -131,87 +279,194 @@ func (l *list) LRange(start, stop int64) []string {
 // that were removed.
 func (l *list) LRem(cnt int64, val string) int64 {
 	var n int64
-	ln := len(*l)
+	if l.lp != nil {
+		l.unpacked(func(q *list) { n = q.LRem(cnt, val) })
+		return n
+	}
 	if cnt >= 0 {
-		for i := 0; i < ln; i++ {
-			if (*l)[i] == val {
-				l.del(i)
-				i--
-				ln--
-				n++
-				if cnt > 0 && n >= cnt {
-					break
+		for nd := l.head; nd != nil && (cnt == 0 || n < cnt); nd = nd.next {
+			for i := 0; i < nd.len() && (cnt == 0 || n < cnt); {
+				if nd.vals[nd.start+i] == val {
+					nd.remove(i)
+					n++
+				} else {
+					i++
 				}
 			}
 		}
 	} else {
 		cnt *= -1
-		for i := ln - 1; i >= 0; i-- {
-			if (*l)[i] == val {
-				l.del(i)
-				i--
-				n++
-				if n >= cnt {
-					break
+		for nd := l.tail; nd != nil && n < cnt; nd = nd.prev {
+			for i := nd.len() - 1; i >= 0 && n < cnt; i-- {
+				if nd.vals[nd.start+i] == val {
+					nd.remove(i)
+					n++
 				}
 			}
 		}
 	}
+	if n > 0 {
+		l.length -= n
+		l.compact()
+	}
 	return n
 }
 
-func (l *list) del(ix int) {
-	copy((*l)[ix:], (*l)[ix+1:])
-	(*l)[len(*l)-1] = ""
-	*l = (*l)[:len(*l)-1]
-}
-
 // LSet sets the value at index ix to val. It returns false if the index
 // is out of bounds.
 func (l *list) LSet(ix int64, val string) bool {
-	ln := int64(len(*l))
 	if ix < 0 {
-		ix += ln
+		ix += l.length
 	}
-	if ix >= 0 && ix < ln {
-		(*l)[ix] = val
+	if ix >= 0 && ix < l.length {
+		if l.lp != nil {
+			l.lp.set(int(ix), val)
+			l.fit()
+			return true
+		}
+		n, i := l.find(ix)
+		n.vals[i] = val
 		return true
 	}
 	return false
 }
 
-// LTrim returns the number of elements remaining in the list, so that
-// the containing key can know if it should be deleted.
+// LTrim trims the list so that it only holds the values between start and
+// stop.
 func (l *list) LTrim(start, stop int64) {
+	if l.lp != nil {
+		l.unpacked(func(q *list) { q.LTrim(start, stop) })
+		return
+	}
 	start, stop = l.normalizeStartStop(start, stop)
 	if stop-start < 0 {
-		*l = (*l)[:0]
+		l.head, l.tail, l.length = nil, nil, 0
 		return
 	}
-	*l = (*l)[start : stop+1]
+
+	// Drop the values before start, skipping whole nodes
+	for drop := start; drop > 0; {
+		n := l.head
+		if ln := int64(n.len()); ln <= drop {
+			l.unlink(n)
+			l.length -= ln
+			drop -= ln
+			continue
+		}
+		for i := 0; i < int(drop); i++ {
+			n.vals[n.start+i] = ""
+		}
+		n.start += int(drop)
+		l.length -= drop
+		drop = 0
+	}
+
+	// Then the values after stop, that is now at index stop-start
+	for drop := l.length - (stop - start + 1); drop > 0; {
+		n := l.tail
+		if ln := int64(n.len()); ln <= drop {
+			l.unlink(n)
+			l.length -= ln
+			drop -= ln
+			continue
+		}
+		for i := 1; i <= int(drop); i++ {
+			n.vals[n.end-i] = ""
+		}
+		n.end -= int(drop)
+		l.length -= drop
+		drop = 0
+	}
 }
 
 // RPop pops a value from the tail of the list and returns it. It returns false
 // as second value if it could not return a value.
 func (l *list) RPop() (string, bool) {
-	if len(*l) == 0 {
+	if l.lp != nil {
+		return l.packedPop(int(l.length - 1))
+	}
+	n := l.tail
+	if n == nil {
 		return "", false
 	}
-	val := (*l)[len(*l)-1]
-	(*l)[len(*l)-1] = ""
-	*l = (*l)[:len(*l)-1]
+	n.end--
+	val := n.vals[n.end]
+	n.vals[n.end] = ""
+	l.length--
+	if n.len() == 0 {
+		l.unlink(n)
+	}
 	return val, true
 }
 
 // RPush pushes the provided values on the tail of the list. It returns the new
 // length of the list.
 func (l *list) RPush(vals ...string) int64 {
-	*l = append(*l, vals...)
-	return int64(len(*l))
+	if l.lp != nil {
+		l.lp.insert(l.lp.len(), vals...)
+		l.length += int64(len(vals))
+		l.fit()
+		return l.length
+	}
+	for _, val := range vals {
+		n := l.tail
+		if n == nil || n.end == len(n.vals) && !n.grow(false) {
+			n = newListNode(listNodeMinSize, false)
+			l.link(l.tail, n)
+		}
+		n.vals[n.end] = val
+		n.end++
+		l.length++
+	}
+	return l.length
+}
+
+// packedPop removes and returns the value at index ix of a
+// listpack-encoded list.
+func (l *list) packedPop(ix int) (string, bool) {
+	if l.length == 0 {
+		return "", false
+	}
+	val := l.lp.get(ix)
+	l.lp.remove(ix, 1)
+	l.length--
+	return val, true
+}
+
+// fit converts a listpack-encoded list to nodes if it grew past
+// ListMaxListpackSize.
+func (l *list) fit() {
+	if listpackFits(l.lp) {
+		return
+	}
+	vals := l.lp.strings()
+	*l = list{}
+	l.RPush(vals...)
+}
+
+// quicklist returns a copy of a listpack-encoded list, stored in nodes.
+func (l *list) quicklist() *list {
+	q := &list{}
+	q.RPush(l.lp.strings()...)
+	return q
+}
+
+// unpacked applies fn to a copy of a listpack-encoded list stored in
+// nodes, and stores the result back in a listpack if it still fits in it.
+// It is used for the operations that are too involved to be implemented
+// on the listpack itself, which is small enough for the copy to be cheap.
+func (l *list) unpacked(fn func(*list)) {
+	q := l.quicklist()
+	fn(q)
+	if lp := newListpack(q.LRange(0, -1)...); listpackFits(lp) {
+		*l = list{lp: lp, length: q.length}
+		return
+	}
+	*l = *q
 }
 
 func (l *list) normalizeStartStop(start, stop int64) (int64, int64) {
-	ln := int64(len(*l))
+	ln := l.length
 	if start < 0 {
 		start += ln
 	}
@@ -226,3 +481,218 @@ func (l *list) normalizeStartStop(start, stop int64) (int64, int64) {
 	}
 	return start, stop
 }
+
+// find returns the node that holds the value at index ix, and the index
+// of the value in the array of the node. The index must be valid. The
+// search starts at the end of the list closest to the index.
+func (l *list) find(ix int64) (*listNode, int) {
+	if ix < l.length/2 {
+		for n := l.head; ; n = n.next {
+			if ln := int64(n.len()); ix >= ln {
+				ix -= ln
+				continue
+			}
+			return n, n.start + int(ix)
+		}
+	}
+	// Index starting from the tail
+	ix = l.length - 1 - ix
+	for n := l.tail; ; n = n.prev {
+		if ln := int64(n.len()); ix >= ln {
+			ix -= ln
+			continue
+		}
+		return n, n.end - 1 - int(ix)
+	}
+}
+
+// indexOf returns the index of the first occurrence of val, or -1 if val
+// is not in the list.
+func (l *list) indexOf(val string) int64 {
+	var ix int64
+	for n := l.head; n != nil; n = n.next {
+		for i := n.start; i < n.end; i, ix = i+1, ix+1 {
+			if n.vals[i] == val {
+				return ix
+			}
+		}
+	}
+	return -1
+}
+
+// insert inserts val at index ix, which must be between 0 and the length
+// of the list.
+func (l *list) insert(ix int64, val string) {
+	switch ix {
+	case 0:
+		l.LPush(val)
+		return
+	case l.length:
+		l.RPush(val)
+		return
+	}
+
+	n, i := l.find(ix)
+	i -= n.start
+	if n.len() == listNodeSize {
+		// Split the full node in two, and insert in the half that holds
+		// the index.
+		m := l.split(n)
+		if i > n.len() {
+			i -= n.len()
+			n = m
+		}
+	}
+	n.insert(i, val)
+	l.length++
+}
+
+// split moves the second half of the values of the node n to a new node
+// inserted after n, and returns the new node.
+func (l *list) split(n *listNode) *listNode {
+	half := n.start + n.len()/2
+	m := newListNode(listNodeSize, false)
+	m.end = copy(m.vals, n.vals[half:n.end])
+	for i := half; i < n.end; i++ {
+		n.vals[i] = ""
+	}
+	n.end = half
+	l.link(n, m)
+	return m
+}
+
+// compact removes the empty nodes, and merges adjacent nodes when their
+// values fit in a single node.
+func (l *list) compact() {
+	for n := l.head; n != nil; {
+		if n.len() == 0 {
+			next := n.next
+			l.unlink(n)
+			n = next
+			continue
+		}
+		if m := n.next; m != nil && n.len()+m.len() <= listNodeSize {
+			n.append(m.values())
+			l.unlink(m)
+			continue
+		}
+		n = n.next
+	}
+}
+
+// link inserts the node n after the node prev, or at the head of the list
+// if prev is nil.
+func (l *list) link(prev, n *listNode) {
+	n.prev = prev
+	if prev == nil {
+		n.next = l.head
+		l.head = n
+	} else {
+		n.next = prev.next
+		prev.next = n
+	}
+	if n.next == nil {
+		l.tail = n
+	} else {
+		n.next.prev = n
+	}
+}
+
+// unlink removes the node n from the list.
+func (l *list) unlink(n *listNode) {
+	if n.prev == nil {
+		l.head = n.next
+	} else {
+		n.prev.next = n.next
+	}
+	if n.next == nil {
+		l.tail = n.prev
+	} else {
+		n.next.prev = n.prev
+	}
+	n.prev, n.next = nil, nil
+}
+
+// newListNode creates an empty node with an array of the specified size.
+// The values of the node start at the end of the array if atEnd is true,
+// so that there is room to push values at the front, and at the start
+// of the array otherwise.
+func newListNode(size int, atEnd bool) *listNode {
+	n := &listNode{vals: make([]string, size)}
+	if atEnd {
+		n.start, n.end = size, size
+	}
+	return n
+}
+
+// len returns the number of values held by the node.
+func (n *listNode) len() int {
+	return n.end - n.start
+}
+
+// values returns the values held by the node.
+func (n *listNode) values() []string {
+	return n.vals[n.start:n.end]
+}
+
+// grow doubles the size of the array of the node, adding the room at
+// the front if front is true, at the back otherwise. It returns false if
+// the array is already at its maximum size.
+func (n *listNode) grow(front bool) bool {
+	size := 2 * len(n.vals)
+	if size > listNodeSize {
+		size = listNodeSize
+	}
+	if size <= len(n.vals) {
+		return false
+	}
+	n.resize(size, front)
+	return true
+}
+
+// resize moves the values of the node to a new array of the specified
+// size, at the end of the array if atEnd is true, at the start otherwise.
+func (n *listNode) resize(size int, atEnd bool) {
+	vals := make([]string, size)
+	start := 0
+	if atEnd {
+		start = size - n.len()
+	}
+	n.end = start + copy(vals[start:], n.values())
+	n.start, n.vals = start, vals
+}
+
+// insert inserts val at the index i of the values of the node, which must
+// not be full.
+func (n *listNode) insert(i int, val string) {
+	if n.end == len(n.vals) {
+		size := len(n.vals)
+		if n.start == 0 {
+			size *= 2
+			if size > listNodeSize {
+				size = listNodeSize
+			}
+		}
+		n.resize(size, false)
+	}
+	at := n.start + i
+	copy(n.vals[at+1:n.end+1], n.vals[at:n.end])
+	n.vals[at] = val
+	n.end++
+}
+
+// remove removes the value at the index i of the values of the node.
+func (n *listNode) remove(i int) {
+	at := n.start + i
+	copy(n.vals[at:], n.vals[at+1:n.end])
+	n.end--
+	n.vals[n.end] = ""
+}
+
+// append appends the values to the node, that must have room for them.
+func (n *listNode) append(vals []string) {
+	if len(n.vals)-n.end < len(vals) {
+		n.resize(listNodeSize, false)
+	}
+	n.end += copy(n.vals[n.end:], vals)
+}
@@ -1,10 +1,65 @@
 package types
 
 import (
+	"math/rand"
 	"reflect"
+	"strconv"
 	"testing"
 )
 
+// listFrom creates a list holding the values.
+func listFrom(vals []string) *list {
+	l := NewList().(*list)
+	l.RPush(vals...)
+	return l
+}
+
+// equalValues returns true if both lists of values are equal, a nil list
+// being equal to an empty one.
+func equalValues(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// checkList checks the invariants of the nodes of the list, or of its
+// listpack.
+func checkList(t *testing.T, l *list) {
+	if l.lp != nil {
+		if l.head != nil || l.tail != nil {
+			t.Fatalf("expected no node in a listpack-encoded list")
+		}
+		if int64(l.lp.len()) != l.length || !listpackFits(l.lp) {
+			t.Fatalf("expected a listpack of length %d, got %d (%d bytes)", l.length, l.lp.len(), l.lp.size())
+		}
+		return
+	}
+	var cnt int64
+	var prev *listNode
+	for n := l.head; n != nil; n = n.next {
+		if n.prev != prev {
+			t.Fatalf("node %p: expected prev %p, got %p", n, prev, n.prev)
+		}
+		if n.len() == 0 || n.len() > listNodeSize || len(n.vals) > listNodeSize {
+			t.Fatalf("node %p: invalid length %d, size %d", n, n.len(), len(n.vals))
+		}
+		for i, v := range n.vals {
+			if (i < n.start || i >= n.end) && v != "" {
+				t.Fatalf("node %p: slot %d is not zeroed", n, i)
+			}
+		}
+		cnt += int64(n.len())
+		prev = n
+	}
+	if l.tail != prev {
+		t.Fatalf("expected tail %p, got %p", prev, l.tail)
+	}
+	if cnt != l.length {
+		t.Fatalf("expected length %d, got %d", cnt, l.length)
+	}
+}
+
 func TestListLIndex(t *testing.T) {
 	cases := []struct {
 		l   []string
@@ -24,7 +79,7 @@ func TestListLIndex(t *testing.T) {
 		9: {[]string{"a", "b", "c"}, -4, "", false},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := listFrom(c.l)
 		got, ok := l.LIndex(c.ix)
 		if got != c.exp {
 			t.Errorf("%d: expected %q, got %q", i, c.exp, got)
@@ -46,7 +101,7 @@ func TestListLLen(t *testing.T) {
 		3: {[]string{"a", "b", "c"}, 3},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := listFrom(c.l)
 		got := l.LLen()
 		if got != c.exp {
 			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
@@ -68,13 +123,13 @@ func TestListLPush(t *testing.T) {
 		5: {[]string{}, []string{"c", "b", "a"}, []string{"a", "b", "c"}},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := listFrom(c.l)
 		got := l.LPush(c.vals...)
 		if got != int64(len(c.exp)) {
 			t.Errorf("%d: expected length of %d, got %d", i, len(c.exp), got)
 		}
-		if !reflect.DeepEqual([]string(l), c.exp) {
-			t.Errorf("%d: expected %v, got %v", i, c.exp, l)
+		if !equalValues(l.LRange(0, -1), c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, l.LRange(0, -1))
 		}
 	}
 }
@@ -95,14 +150,14 @@ func TestListLInsertBefore(t *testing.T) {
 		7: {[]string{"e", "d", "a", "c", "b", "a"}, "a", "z", 7, 2},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := listFrom(c.l)
 		got := l.LInsertBefore(c.piv, c.val)
 		if got != c.exp {
 			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
 		}
 		if c.at >= 0 {
-			if l[c.at] != c.val {
-				t.Errorf("%d: value %q should be at index %d, got %q", i, c.val, c.at, l[c.at])
+			if got, _ := l.LIndex(c.at); got != c.val {
+				t.Errorf("%d: value %q should be at index %d, got %q", i, c.val, c.at, got)
 			}
 		}
 	}
@@ -123,14 +178,14 @@ func TestListLInsertAfter(t *testing.T) {
 		6: {[]string{"a", "b", "c"}, "c", "z", 4, 3},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := listFrom(c.l)
 		got := l.LInsertAfter(c.piv, c.val)
 		if got != c.exp {
 			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
 		}
 		if c.at >= 0 {
-			if l[c.at] != c.val {
-				t.Errorf("%d: value %q should be at index %d, got %q", i, c.val, c.at, l[c.at])
+			if got, _ := l.LIndex(c.at); got != c.val {
+				t.Errorf("%d: value %q should be at index %d, got %q", i, c.val, c.at, got)
 			}
 		}
 	}
@@ -148,7 +203,7 @@ func TestListLPop(t *testing.T) {
 		3: {[]string{"a", "b", "c"}, "a", true},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := listFrom(c.l)
 		orilen := len(c.l)
 		got, ok := l.LPop()
 		if got != c.exp {
@@ -165,6 +220,37 @@ func TestListLPop(t *testing.T) {
 	}
 }
 
+func TestListLPos(t *testing.T) {
+	abc := []string{"a", "b", "c", "a", "b", "a"}
+	cases := []struct {
+		l                 []string
+		val               string
+		rank, cnt, maxLen int64
+		exp               []int64
+	}{
+		0:  {nil, "a", 1, 0, 0, nil},
+		1:  {abc, "z", 1, 0, 0, nil},
+		2:  {abc, "a", 1, 1, 0, []int64{0}},
+		3:  {abc, "a", 1, 0, 0, []int64{0, 3, 5}},
+		4:  {abc, "a", 2, 0, 0, []int64{3, 5}},
+		5:  {abc, "a", 4, 0, 0, nil},
+		6:  {abc, "a", -1, 0, 0, []int64{5, 3, 0}},
+		7:  {abc, "a", -2, 1, 0, []int64{3}},
+		8:  {abc, "b", 1, 2, 0, []int64{1, 4}},
+		9:  {abc, "a", 1, 0, 4, []int64{0, 3}},
+		10: {abc, "a", -1, 0, 2, []int64{5}},
+		11: {abc, "c", 1, 0, 2, nil},
+		12: {abc, "a", 1, 0, 100, []int64{0, 3, 5}},
+	}
+	for i, c := range cases {
+		l := listFrom(c.l)
+		got := l.LPos(c.val, c.rank, c.cnt, c.maxLen)
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
+		}
+	}
+}
+
 func TestListLRange(t *testing.T) {
 	cases := []struct {
 		l           []string
@@ -183,7 +269,7 @@ func TestListLRange(t *testing.T) {
 		9: {[]string{"a", "b", "c"}, 17, -18, []string{}},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := listFrom(c.l)
 		got := l.LRange(c.start, c.stop)
 		if !reflect.DeepEqual(got, c.exp) {
 			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
@@ -211,13 +297,13 @@ func TestListLRem(t *testing.T) {
 		10: {[]string{"a", "z", "c", "z"}, "a", -4, 1, []string{"z", "c", "z"}},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := listFrom(c.l)
 		got := l.LRem(c.cnt, c.val)
 		if got != c.n {
 			t.Errorf("%d: expected %d elements removed, got %d", i, c.n, got)
 		}
-		if !reflect.DeepEqual([]string(l), c.exp) {
-			t.Errorf("%d: expected %v, got %v", i, c.exp, l)
+		if !equalValues(l.LRange(0, -1), c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, l.LRange(0, -1))
 		}
 	}
 }
@@ -243,13 +329,13 @@ func TestListLSet(t *testing.T) {
 		10: {[]string{"a", "b", "c"}, "z", -4, []string{"a", "b", "c"}, false},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := listFrom(c.l)
 		got := l.LSet(c.ix, c.val)
 		if got != c.res {
 			t.Errorf("%d: expected %v, got %v", i, c.res, got)
 		}
-		if !reflect.DeepEqual([]string(l), c.exp) {
-			t.Errorf("%d: expected %v, got %v", i, c.exp, l)
+		if !equalValues(l.LRange(0, -1), c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, l.LRange(0, -1))
 		}
 	}
 }
@@ -275,10 +361,10 @@ func TestListLTrim(t *testing.T) {
 		12: {[]string{"a", "b", "c"}, -15, -13, []string{}},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := listFrom(c.l)
 		l.LTrim(c.start, c.stop)
-		if !reflect.DeepEqual([]string(l), c.exp) {
-			t.Errorf("%d: expected %v, got %v", i, c.exp, l)
+		if !equalValues(l.LRange(0, -1), c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, l.LRange(0, -1))
 		}
 	}
 }
@@ -297,13 +383,13 @@ func TestListRPush(t *testing.T) {
 		5: {[]string{}, []string{"c", "b", "a"}, []string{"c", "b", "a"}},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := listFrom(c.l)
 		got := l.RPush(c.vals...)
 		if got != int64(len(c.exp)) {
 			t.Errorf("%d: expected length of %d, got %d", i, len(c.exp), got)
 		}
-		if !reflect.DeepEqual([]string(l), c.exp) {
-			t.Errorf("%d: expected %v, got %v", i, c.exp, l)
+		if !equalValues(l.LRange(0, -1), c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, l.LRange(0, -1))
 		}
 	}
 }
@@ -320,7 +406,7 @@ func TestListRPop(t *testing.T) {
 		3: {[]string{"a", "b", "c"}, "c", true},
 	}
 	for i, c := range cases {
-		l := list(c.l)
+		l := listFrom(c.l)
 		orilen := len(c.l)
 		got, ok := l.RPop()
 		if got != c.exp {
@@ -344,3 +430,317 @@ func TestListType(t *testing.T) {
 		t.Errorf("expected %q, got %q", "list", tp)
 	}
 }
+
+func TestListClone(t *testing.T) {
+	l := NewList()
+	l.RPush("a", "b")
+	c := l.Clone().(List)
+	c.LSet(0, "x")
+	c.RPush("c")
+	if got := l.LRange(0, -1); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("expected %v, got %v", []string{"a", "b"}, got)
+	}
+	if got := c.LRange(0, -1); !reflect.DeepEqual(got, []string{"x", "b", "c"}) {
+		t.Errorf("expected %v, got %v", []string{"x", "b", "c"}, got)
+	}
+}
+
+func TestListModel(t *testing.T) {
+	// Apply random operations to a list and to a slice, so that the
+	// values cross the boundaries of the nodes. With a small listpack
+	// size, the list is also converted from a listpack.
+	testListModel(t, &list{})
+	defer withThresholds(HashMaxListpackEntries, SetMaxIntsetEntries, SetMaxListpackEntries, 64)()
+	testListModel(t, NewList().(*list))
+}
+
+func testListModel(t *testing.T, l *list) {
+	rnd := rand.New(rand.NewSource(1))
+	var exp []string
+	for i := 0; i < 20000; i++ {
+		val := strconv.Itoa(rnd.Intn(50))
+		ix := int64(rnd.Intn(len(exp)+1)) - int64(len(exp)/2)
+		switch op := rnd.Intn(10); op {
+		case 0, 1:
+			l.LPush(val)
+			exp = append([]string{val}, exp...)
+		case 2, 3:
+			l.RPush(val, val)
+			exp = append(exp, val, val)
+		case 4:
+			l.LPop()
+			if len(exp) > 0 {
+				exp = exp[1:]
+			}
+		case 5:
+			l.RPop()
+			if len(exp) > 0 {
+				exp = exp[:len(exp)-1]
+			}
+		case 6:
+			if l.LInsertAfter(val, "x"+val) >= 0 {
+				for j, v := range exp {
+					if v == val {
+						exp = append(exp[:j+1], append([]string{"x" + val}, exp[j+1:]...)...)
+						break
+					}
+				}
+			}
+		case 7:
+			cnt := int64(rnd.Intn(5) - 2)
+			n := l.LRem(cnt, val)
+			sl := sliceList(exp)
+			if m := sl.lrem(cnt, val); m != n {
+				t.Fatalf("%d: expected %d removed, got %d", i, m, n)
+			}
+			exp = sl
+		case 8:
+			l.LSet(ix, val)
+			if ix < 0 {
+				ix += int64(len(exp))
+			}
+			if ix >= 0 && ix < int64(len(exp)) {
+				exp[ix] = val
+			}
+		case 9:
+			if rnd.Intn(20) == 0 {
+				start, stop := ix, ix+int64(rnd.Intn(len(exp)+1))
+				l.LTrim(start, stop)
+				exp = sliceList(exp).lrange(start, stop)
+			}
+		}
+		checkList(t, l)
+		if !equalValues(l.LRange(0, -1), exp) {
+			t.Fatalf("%d: expected %v, got %v", i, exp, l.LRange(0, -1))
+		}
+		if got, want := l.LRange(ix, ix+10), sliceList(exp).lrange(ix, ix+10); !equalValues(got, want) {
+			t.Fatalf("%d: expected range %v, got %v", i, want, got)
+		}
+	}
+}
+
+// sliceList is the slice-backed implementation of a list that was used
+// before the current one, kept to compare their performance.
+type sliceList []string
+
+func (l *sliceList) lpush(vals ...string) {
+	for i, j := 0, len(vals)-1; i < j; i, j = i+1, j-1 {
+		vals[i], vals[j] = vals[j], vals[i]
+	}
+	*l = append(vals, *l...)
+}
+
+func (l *sliceList) rpush(vals ...string) {
+	*l = append(*l, vals...)
+}
+
+func (l *sliceList) lpop() (string, bool) {
+	if len(*l) == 0 {
+		return "", false
+	}
+	val := (*l)[0]
+	(*l)[0] = ""
+	*l = (*l)[1:]
+	return val, true
+}
+
+func (l *sliceList) rpop() (string, bool) {
+	if len(*l) == 0 {
+		return "", false
+	}
+	val := (*l)[len(*l)-1]
+	(*l)[len(*l)-1] = ""
+	*l = (*l)[:len(*l)-1]
+	return val, true
+}
+
+func (l sliceList) lindex(ix int64) (string, bool) {
+	if ix < 0 {
+		ix += int64(len(l))
+	}
+	if ix >= 0 && ix < int64(len(l)) {
+		return l[ix], true
+	}
+	return "", false
+}
+
+func (l sliceList) lrange(start, stop int64) []string {
+	ln := int64(len(l))
+	if start < 0 {
+		start += ln
+	}
+	if stop < 0 {
+		stop += ln
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= ln {
+		stop = ln - 1
+	}
+	if stop-start < 0 {
+		return []string{}
+	}
+	ret := make([]string, stop-start+1)
+	copy(ret, l[start:stop+1])
+	return ret
+}
+
+func (l *sliceList) lrem(cnt int64, val string) int64 {
+	var n int64
+	del := func(ix int) {
+		copy((*l)[ix:], (*l)[ix+1:])
+		(*l)[len(*l)-1] = ""
+		*l = (*l)[:len(*l)-1]
+	}
+	if cnt >= 0 {
+		for i := 0; i < len(*l) && (cnt == 0 || n < cnt); {
+			if (*l)[i] == val {
+				del(i)
+				n++
+			} else {
+				i++
+			}
+		}
+		return n
+	}
+	for i := len(*l) - 1; i >= 0 && n < -cnt; i-- {
+		if (*l)[i] == val {
+			del(i)
+			n++
+		}
+	}
+	return n
+}
+
+const benchListLen = 10000
+
+func BenchmarkListLPush(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		l := NewList()
+		for j := 0; j < benchListLen; j++ {
+			l.LPush("a")
+		}
+	}
+}
+
+func BenchmarkSliceListLPush(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		var l sliceList
+		for j := 0; j < benchListLen; j++ {
+			l.lpush("a")
+		}
+	}
+}
+
+func BenchmarkListRPush(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		l := NewList()
+		for j := 0; j < benchListLen; j++ {
+			l.RPush("a")
+		}
+	}
+}
+
+func BenchmarkSliceListRPush(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		var l sliceList
+		for j := 0; j < benchListLen; j++ {
+			l.rpush("a")
+		}
+	}
+}
+
+func BenchmarkListQueue(b *testing.B) {
+	l := NewList()
+	for j := 0; j < benchListLen; j++ {
+		l.RPush("a")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.RPush("a")
+		l.LPop()
+	}
+}
+
+func BenchmarkSliceListQueue(b *testing.B) {
+	var l sliceList
+	for j := 0; j < benchListLen; j++ {
+		l.rpush("a")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.rpush("a")
+		l.lpop()
+	}
+}
+
+func BenchmarkListStack(b *testing.B) {
+	l := NewList()
+	for j := 0; j < benchListLen; j++ {
+		l.LPush("a")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.LPush("a")
+		l.LPop()
+	}
+}
+
+func BenchmarkSliceListStack(b *testing.B) {
+	var l sliceList
+	for j := 0; j < benchListLen; j++ {
+		l.lpush("a")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.lpush("a")
+		l.lpop()
+	}
+}
+
+func BenchmarkListLIndex(b *testing.B) {
+	l := NewList()
+	for j := 0; j < benchListLen; j++ {
+		l.RPush("a")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.LIndex(int64(i % benchListLen))
+	}
+}
+
+func BenchmarkSliceListLIndex(b *testing.B) {
+	var l sliceList
+	for j := 0; j < benchListLen; j++ {
+		l.rpush("a")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.lindex(int64(i % benchListLen))
+	}
+}
+
+func BenchmarkListLRange(b *testing.B) {
+	l := NewList()
+	for j := 0; j < benchListLen; j++ {
+		l.RPush("a")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := int64(i % (benchListLen - 100))
+		l.LRange(start, start+99)
+	}
+}
+
+func BenchmarkSliceListLRange(b *testing.B) {
+	var l sliceList
+	for j := 0; j < benchListLen; j++ {
+		l.rpush("a")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := int64(i % (benchListLen - 100))
+		l.lrange(start, start+99)
+	}
+}
@@ -0,0 +1,127 @@
+package types
+
+import "encoding/binary"
+
+// listpack is a compact sequence of strings, packed in a single byte slice
+// where each string is prefixed by its length encoded as a uvarint. It is
+// the encoding of small hashes, sets and lists: it costs a few bytes per
+// string instead of the headers and pointers of a map or of a slice, but
+// most operations are O(n), so it is only used up to configured sizes.
+type listpack struct {
+	b []byte
+	n int
+}
+
+// newListpack creates a listpack holding the values.
+func newListpack(vals ...string) *listpack {
+	lp := &listpack{}
+	lp.insert(0, vals...)
+	return lp
+}
+
+// len returns the number of strings in the listpack.
+func (lp *listpack) len() int {
+	return lp.n
+}
+
+// size returns the number of bytes used by the listpack.
+func (lp *listpack) size() int {
+	return len(lp.b)
+}
+
+// memoryUsage returns the estimated number of bytes used by the listpack.
+func (lp *listpack) memoryUsage() int64 {
+	return sliceHeaderSize + wordSize + int64(cap(lp.b))
+}
+
+// clone returns a copy of the listpack.
+func (lp *listpack) clone() *listpack {
+	b := make([]byte, len(lp.b))
+	copy(b, lp.b)
+	return &listpack{b, lp.n}
+}
+
+// entry returns the bounds of the string of the entry that starts at the
+// offset off, and the offset of the next entry.
+func (lp *listpack) entry(off int) (int, int, int) {
+	ln, n := binary.Uvarint(lp.b[off:])
+	start := off + n
+	end := start + int(ln)
+	return start, end, end
+}
+
+// offset returns the offset of the entry at index i, or the size of the
+// listpack if i is its length.
+func (lp *listpack) offset(i int) int {
+	off := 0
+	for ; i > 0; i-- {
+		_, _, off = lp.entry(off)
+	}
+	return off
+}
+
+// get returns the string at index i.
+func (lp *listpack) get(i int) string {
+	start, end, _ := lp.entry(lp.offset(i))
+	return string(lp.b[start:end])
+}
+
+// index returns the index of the first occurrence of s at an index that is
+// a multiple of step, starting at 0, or -1 if there is no such occurrence.
+// A step of 2 searches the fields of a hash stored as field-value pairs.
+func (lp *listpack) index(s string, step int) int {
+	for i, off := 0, 0; i < lp.n; i++ {
+		start, end, next := lp.entry(off)
+		if i%step == 0 && string(lp.b[start:end]) == s {
+			return i
+		}
+		off = next
+	}
+	return -1
+}
+
+// strings returns all the strings of the listpack.
+func (lp *listpack) strings() []string {
+	vals := make([]string, lp.n)
+	for i, off := 0, 0; i < lp.n; i++ {
+		start, end, next := lp.entry(off)
+		vals[i] = string(lp.b[start:end])
+		off = next
+	}
+	return vals
+}
+
+// insert inserts the values at index i, which must be between 0 and the
+// length of the listpack.
+func (lp *listpack) insert(i int, vals ...string) {
+	var enc []byte
+	var buf [binary.MaxVarintLen64]byte
+	for _, v := range vals {
+		n := binary.PutUvarint(buf[:], uint64(len(v)))
+		enc = append(enc, buf[:n]...)
+		enc = append(enc, v...)
+	}
+
+	off := lp.offset(i)
+	lp.b = append(lp.b, enc...)
+	copy(lp.b[off+len(enc):], lp.b[off:len(lp.b)-len(enc)])
+	copy(lp.b[off:], enc)
+	lp.n += len(vals)
+}
+
+// remove removes cnt strings starting at index i.
+func (lp *listpack) remove(i, cnt int) {
+	start := lp.offset(i)
+	end := start
+	for j := 0; j < cnt; j++ {
+		_, _, end = lp.entry(end)
+	}
+	lp.b = append(lp.b[:start], lp.b[end:]...)
+	lp.n -= cnt
+}
+
+// set replaces the string at index i with s.
+func (lp *listpack) set(i int, s string) {
+	lp.remove(i, 1)
+	lp.insert(i, s)
+}
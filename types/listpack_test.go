@@ -0,0 +1,74 @@
+package types
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestListpack(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	lp := newListpack("a", "", long)
+	lp.insert(1, "b", "c")
+	lp.insert(5, "d")
+	lp.insert(0, "e")
+	exp := []string{"e", "a", "b", "c", "", long, "d"}
+	if got := lp.strings(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if lp.len() != 7 || lp.size() != 5*2+1+302 {
+		t.Errorf("expected 7 strings in 313 bytes, got %d in %d", lp.len(), lp.size())
+	}
+	for i, v := range exp {
+		if got := lp.get(i); got != v {
+			t.Errorf("%d: expected %q, got %q", i, v, got)
+		}
+	}
+	if ix := lp.index(long, 1); ix != 5 {
+		t.Errorf("expected index 5, got %d", ix)
+	}
+	if ix := lp.index("c", 2); ix != -1 {
+		t.Errorf("expected index -1 at even indices, got %d", ix)
+	}
+
+	c := lp.clone()
+	lp.set(5, "f")
+	lp.remove(0, 2)
+	lp.remove(lp.len()-1, 1)
+	exp = []string{"b", "c", "", "f"}
+	if got := lp.strings(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+	if got := c.get(5); got != long {
+		t.Errorf("expected clone to be unchanged, got %q", got)
+	}
+}
+
+func TestListpackModel(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	lp := &listpack{}
+	var exp []string
+	for i := 0; i < 2000; i++ {
+		val := strings.Repeat("v", rnd.Intn(200))
+		ix := rnd.Intn(len(exp) + 1)
+		switch rnd.Intn(3) {
+		case 0:
+			lp.insert(ix, val)
+			exp = append(exp[:ix], append([]string{val}, exp[ix:]...)...)
+		case 1:
+			if ix < len(exp) {
+				lp.remove(ix, 1)
+				exp = append(exp[:ix], exp[ix+1:]...)
+			}
+		case 2:
+			if ix < len(exp) {
+				lp.set(ix, val)
+				exp[ix] = val
+			}
+		}
+		if got := lp.strings(); !equalValues(got, exp) {
+			t.Fatalf("%d: expected %v, got %v", i, exp, got)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package types
+
+// The sizes, in bytes, used to estimate the memory used by the values on
+// a 64-bit platform. The estimates account for the data and for the Go
+// headers and pointers that hold it, but not for the overhead of the memory
+// allocator.
+const (
+	wordSize         = 8
+	stringHeaderSize = 2 * wordSize
+	sliceHeaderSize  = 3 * wordSize
+	ifaceSize        = 2 * wordSize
+)
+
+// mapEntrySize returns the estimated size of an entry of a map, whose key
+// and value use kvSize bytes. A bucket holds 8 entries, a byte of hash per
+// entry and a pointer to an overflow bucket, and buckets are on average
+// 6.5/8 full.
+func mapEntrySize(kvSize int64) int64 {
+	return (8*kvSize + 16) * 2 / 13
+}
+
+// sampleCount returns the number of elements to sample out of n when
+// estimating the memory used by an aggregate value, which is samples, or n
+// if samples is 0 or greater than n.
+func sampleCount(n int64, samples int) int64 {
+	if samples <= 0 || int64(samples) > n {
+		return n
+	}
+	return int64(samples)
+}
+
+// sampledSize returns the estimated number of bytes of the data of n
+// strings, extrapolated from the sampled strings.
+func sampledSize(n int64, sampled []string) int64 {
+	if len(sampled) == 0 {
+		return 0
+	}
+	var size int64
+	for _, s := range sampled {
+		size += int64(len(s))
+	}
+	return size * n / int64(len(sampled))
+}
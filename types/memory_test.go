@@ -0,0 +1,63 @@
+package types
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMemoryUsage(t *testing.T) {
+	defer withThresholds(0, 0, 0, 0)()
+
+	small := strings.Repeat("a", 10)
+	large := strings.Repeat("a", 100)
+	fill := func(v Value, val string) Value {
+		for i := 0; i < 1000; i++ {
+			m := strconv.Itoa(i) + val
+			switch v := v.(type) {
+			case List:
+				v.RPush(m)
+			case Set:
+				v.SAdd(m)
+			case Hash:
+				v.HSet(m, val)
+			case SortedSet:
+				v.ZAdd(float64(i), m)
+			case Stream:
+				v.XAdd(StreamID{uint64(i + 1), 0}, []string{"f", m})
+			}
+		}
+		return v
+	}
+	news := []func() Value{
+		func() Value { return NewList() },
+		func() Value { return NewSet() },
+		func() Value { return NewIncHash() },
+		func() Value { return NewSortedSet() },
+		func() Value { return NewStream() },
+	}
+	for i, fn := range news {
+		empty := fn().MemoryUsage(0)
+		vs, vl := fill(fn(), small), fill(fn(), large)
+		s, l := vs.MemoryUsage(0), vl.MemoryUsage(0)
+		if s <= empty+1000*10 || l < s+1000*90 {
+			t.Errorf("%d: expected empty < small < large, got %d %d %d", i, empty, s, l)
+		}
+		// Sampling the first elements extrapolates the same values
+		if s5 := vs.MemoryUsage(5); s5 < s-s/10 || s5 > s+s/10 {
+			t.Errorf("%d: expected %d with samples, got %d", i, s, s5)
+		}
+	}
+
+	if n := NewIncString("abc").MemoryUsage(0); n != ifaceSize+2*wordSize+stringHeaderSize+3 {
+		t.Errorf("expected %d, got %d", ifaceSize+stringHeaderSize+3, n)
+	}
+
+	// Compact encodings are smaller
+	withThresholds(128, 512, 128, -2)
+	h := NewIncHash()
+	h.HMSet("a", "1", "b", "2")
+	if n := h.MemoryUsage(0); n >= newTestHash("a", "1", "b", "2").MemoryUsage(0)+ifaceSize {
+		t.Errorf("expected a listpack to be smaller than a map, got %d", n)
+	}
+}
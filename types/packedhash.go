@@ -0,0 +1,158 @@
+package types
+
+// Static check to make sure *packedHash implements Hash.
+var _ Hash = (*packedHash)(nil)
+
+// packedHash is the listpack encoding of a Hash. The fields and values
+// are stored as consecutive entries, so that the hash keeps the insertion
+// order of its fields.
+type packedHash struct {
+	lp listpack
+}
+
+// newPackedHash creates an empty listpack-encoded Hash.
+func newPackedHash() *packedHash {
+	return &packedHash{}
+}
+
+// Type returns the type of the value, which is "hash".
+func (h *packedHash) Type() string {
+	return "hash"
+}
+
+// Clone returns a copy of the hash.
+func (h *packedHash) Clone() Value {
+	return &packedHash{*h.lp.clone()}
+}
+
+// MemoryUsage returns the estimated number of bytes used by the hash.
+func (h *packedHash) MemoryUsage(samples int) int64 {
+	return h.lp.memoryUsage()
+}
+
+// unpack returns the map encoding of the hash.
+func (h *packedHash) unpack() *hash {
+	m := newHash(h.lp.len() / 2)
+	m.HMSet(h.lp.strings()...)
+	return m
+}
+
+// HDel deletes the specified fields from the hash, and returns the
+// number of fields removed.
+func (h *packedHash) HDel(fields ...string) int64 {
+	var cnt int64
+	for _, f := range fields {
+		if i := h.lp.index(f, 2); i >= 0 {
+			h.lp.remove(i, 2)
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// HExists returns true if the specified field exists in the hash.
+func (h *packedHash) HExists(field string) bool {
+	return h.lp.index(field, 2) >= 0
+}
+
+// HGet returns the value of the specified field. The second return value
+// indicates if the field exists in the hash.
+func (h *packedHash) HGet(field string) (string, bool) {
+	if i := h.lp.index(field, 2); i >= 0 {
+		return h.lp.get(i + 1), true
+	}
+	return "", false
+}
+
+// HGetAll returns the list of all key-value pairs in the hash.
+func (h *packedHash) HGetAll() []string {
+	if h.lp.len() == 0 {
+		return empty
+	}
+	return h.lp.strings()
+}
+
+// HKeys returns the list of keys in the hash.
+func (h *packedHash) HKeys() []string {
+	return h.every(0)
+}
+
+// HLen returns the number of fields in the hash.
+func (h *packedHash) HLen() int64 {
+	return int64(h.lp.len() / 2)
+}
+
+// HMGet returns the list of values for all requested fields, in the
+// order of the requested fields. It returns a nil value at the position
+// of non-existing fields.
+func (h *packedHash) HMGet(fields ...string) []interface{} {
+	ret := make([]interface{}, len(fields))
+	for i, f := range fields {
+		if v, ok := h.HGet(f); ok {
+			ret[i] = v
+		}
+	}
+	return ret
+}
+
+// HMSet sets the values for all key-value tuples as received as argument.
+func (h *packedHash) HMSet(tuples ...string) {
+	for i := 0; i < len(tuples); i += 2 {
+		h.HSet(tuples[i], tuples[i+1])
+	}
+}
+
+// HScan returns the field-value pairs that match the glob-style pattern
+// match, or all of them if match is empty. Like Redis does for its compact
+// encodings, the whole hash is returned in a single page, regardless of
+// the cursor and count, so the returned cursor is always 0.
+func (h *packedHash) HScan(cursor uint64, count int64, match string) (uint64, []string) {
+	all := h.lp.strings()
+	vals := make([]string, 0, len(all))
+	for i := 0; i < len(all); i += 2 {
+		if match == "" || MatchGlob(match, all[i]) {
+			vals = append(vals, all[i], all[i+1])
+		}
+	}
+	return 0, vals
+}
+
+// HSet sets the value of field to val, and returns true if the field had to be
+// created.
+func (h *packedHash) HSet(field, val string) bool {
+	if i := h.lp.index(field, 2); i >= 0 {
+		h.lp.set(i+1, val)
+		return false
+	}
+	h.lp.insert(h.lp.len(), field, val)
+	return true
+}
+
+// HSetNx sets the value of field to val only if the field does not already exists
+// in the hash. It returns true if it did create and set the field.
+func (h *packedHash) HSetNx(field, val string) bool {
+	if h.HExists(field) {
+		return false
+	}
+	h.lp.insert(h.lp.len(), field, val)
+	return true
+}
+
+// HVals returns the list of values in the hash.
+func (h *packedHash) HVals() []string {
+	return h.every(1)
+}
+
+// every returns every other entry of the listpack, starting at index
+// start.
+func (h *packedHash) every(start int) []string {
+	if h.lp.len() == 0 {
+		return empty
+	}
+	all := h.lp.strings()
+	vals := make([]string, 0, len(all)/2)
+	for i := start; i < len(all); i += 2 {
+		vals = append(vals, all[i])
+	}
+	return vals
+}
@@ -1,5 +1,10 @@
 package types
 
+import (
+	"math/rand"
+	"strconv"
+)
+
 // Set defines the methods required to implement a Set.
 type Set interface {
 	Value
@@ -8,36 +13,162 @@ type Set interface {
 	SCard() int64
 	SDiff(...Set) []string
 	SInter(...Set) []string
+	SInterCard(int64, ...Set) int64
 	SIsMember(string) bool
 	SMembers() []string
+	SPop(int64) []string
+	SRandMember(int64) []string
 	SRem(...string) int64
+	SScan(uint64, int64, string) (uint64, []string)
 	SUnion(...Set) []string
 }
 
 // Static type check to validate that *set implements Set.
 var _ Set = (*set)(nil)
 
-// set is the internal implementation of a Set.
-type set map[string]struct{}
+// set is the internal implementation of a Set. A small set is stored in
+// an intset if all its members are integers, or in a listpack otherwise,
+// and is converted to the hashtable encoding once it grows past
+// SetMaxIntsetEntries or SetMaxListpackEntries members, or holds a member
+// longer than SetMaxListpackValue. In the hashtable encoding, the members
+// are stored in an Index, so that a random member is selected in O(1) and
+// that the members are scanned incrementally.
+type set struct {
+	is   *intset
+	lp   *listpack
+	mbrs Index
+}
 
 // NewSet creates a new Set.
 func NewSet() Set {
-	return make(set)
+	return &set{
+		is: &intset{},
+	}
+}
+
+// newHashtableSet creates a new Set with the hashtable encoding.
+func newHashtableSet() *set {
+	return &set{}
 }
 
 // Type returns the type of the value, which is "set".
-func (s set) Type() string {
+func (s *set) Type() string {
 	return "set"
 }
 
+// Clone returns a copy of the set.
+func (s *set) Clone() Value {
+	switch {
+	case s.is != nil:
+		return &set{is: s.is.clone()}
+	case s.lp != nil:
+		return &set{lp: s.lp.clone()}
+	}
+	return &set{mbrs: s.mbrs.Clone()}
+}
+
+// MemoryUsage returns the estimated number of bytes used by the set,
+// extrapolated from the size of samples members.
+func (s *set) MemoryUsage(samples int) int64 {
+	size := int64(3*wordSize + sliceHeaderSize)
+	switch {
+	case s.is != nil:
+		return size + s.is.memoryUsage()
+	case s.lp != nil:
+		return size + s.lp.memoryUsage()
+	}
+	n := int64(s.mbrs.Len())
+	size += s.mbrs.memoryUsage()
+	return size + sampledSize(n, s.mbrs.Names()[:sampleCount(n, samples)])
+}
+
+// len returns the number of members of the set.
+func (s *set) len() int {
+	switch {
+	case s.is != nil:
+		return s.is.len()
+	case s.lp != nil:
+		return s.lp.len()
+	}
+	return s.mbrs.Len()
+}
+
+// member returns the member at index i, in the order of the encoding.
+func (s *set) member(i int) string {
+	switch {
+	case s.is != nil:
+		return strconv.FormatInt(s.is.at(i), 10)
+	case s.lp != nil:
+		return s.lp.get(i)
+	}
+	return s.mbrs.Names()[i]
+}
+
+// members returns the members of the set. The returned slice must not be
+// modified.
+func (s *set) members() []string {
+	switch {
+	case s.is != nil:
+		return s.is.strings()
+	case s.lp != nil:
+		return s.lp.strings()
+	}
+	return s.mbrs.Names()
+}
+
+// add adds the value v to the set, converting its encoding if v does not
+// fit in it. It returns true if the value was added.
+func (s *set) add(v string) bool {
+	if s.is != nil {
+		if n, ok := parseCanonicalInt(v); ok {
+			if s.is.has(n) {
+				return false
+			}
+			if s.is.len() < SetMaxIntsetEntries {
+				return s.is.add(n)
+			}
+		}
+		s.convert(v)
+	}
+	if s.lp != nil {
+		if s.lp.index(v, 1) >= 0 {
+			return false
+		}
+		if s.lp.len() < SetMaxListpackEntries && len(v) <= SetMaxListpackValue {
+			s.lp.insert(s.lp.len(), v)
+			return true
+		}
+		s.convert(v)
+	}
+	return s.mbrs.Add(v)
+}
+
+// convert converts a set with a compact encoding to the listpack encoding
+// if its members and v fit in it, to the hashtable encoding otherwise.
+func (s *set) convert(v string) {
+	vals := s.members()
+	fits := s.is != nil && len(vals) < SetMaxListpackEntries && len(v) <= SetMaxListpackValue
+	for _, m := range vals {
+		fits = fits && len(m) <= SetMaxListpackValue
+	}
+	s.is, s.lp = nil, nil
+	if fits {
+		s.lp = newListpack(vals...)
+		return
+	}
+	*s = *newHashtableSet()
+	for _, m := range vals {
+		s.add(m)
+	}
+}
+
 // SAdd adds the values to the set. It returns the number of values
 // that were actually added.
-func (s set) SAdd(vals ...string) int64 {
+func (s *set) SAdd(vals ...string) int64 {
 	var cnt int64
 
 	for _, v := range vals {
-		if _, ok := s[v]; !ok {
-			s[v] = struct{}{}
+		if s.add(v) {
 			cnt++
 		}
 	}
@@ -45,17 +176,17 @@ func (s set) SAdd(vals ...string) int64 {
 }
 
 // SCard returns the number of elements in the set.
-func (s set) SCard() int64 {
-	return int64(len(s))
+func (s *set) SCard() int64 {
+	return int64(s.len())
 }
 
 // SDiff returns the elements found in the set that are not
 // found in the other sets specified by vals.
-func (s set) SDiff(vals ...Set) []string {
+func (s *set) SDiff(vals ...Set) []string {
 	var ok bool
 
 	ret := []string{}
-	for k := range s {
+	for _, k := range s.members() {
 		ok = true
 		for _, other := range vals {
 			if ex := other.SIsMember(k); ex {
@@ -71,11 +202,11 @@ func (s set) SDiff(vals ...Set) []string {
 }
 
 // SInter returns the intersection of the sets.
-func (s set) SInter(vals ...Set) []string {
+func (s *set) SInter(vals ...Set) []string {
 	var ok bool
 
 	ret := []string{}
-	for k := range s {
+	for _, k := range s.members() {
 		ok = true
 		for _, other := range vals {
 			if ex := other.SIsMember(k); !ex {
@@ -90,47 +221,170 @@ func (s set) SInter(vals ...Set) []string {
 	return ret
 }
 
+// SInterCard returns the cardinality of the intersection of the sets. If
+// limit is greater than 0, it stops counting once limit is reached.
+func (s *set) SInterCard(limit int64, vals ...Set) int64 {
+	var cnt int64
+
+outer:
+	for _, k := range s.members() {
+		for _, other := range vals {
+			if ex := other.SIsMember(k); !ex {
+				continue outer
+			}
+		}
+		cnt++
+		if cnt == limit {
+			break
+		}
+	}
+	return cnt
+}
+
 // SIsMember returns true if the value val is in the set.
-func (s set) SIsMember(val string) bool {
-	_, ok := s[val]
-	return ok
+func (s *set) SIsMember(val string) bool {
+	switch {
+	case s.is != nil:
+		n, ok := parseCanonicalInt(val)
+		return ok && s.is.has(n)
+	case s.lp != nil:
+		return s.lp.index(val, 1) >= 0
+	}
+	return s.mbrs.Has(val)
 }
 
 // SMembers returns the list of all members of the set.
-func (s set) SMembers() []string {
-	ret := make([]string, len(s))
-	i := 0
-	for k := range s {
-		ret[i] = k
-		i++
+func (s *set) SMembers() []string {
+	mbrs := s.members()
+	ret := make([]string, len(mbrs))
+	copy(ret, mbrs)
+	return ret
+}
+
+// SPop removes and returns up to cnt random members of the set.
+func (s *set) SPop(cnt int64) []string {
+	if cnt > int64(s.len()) {
+		cnt = int64(s.len())
+	}
+	ret := make([]string, cnt)
+	for i := range ret {
+		v := s.member(rand.Intn(s.len()))
+		s.del(v)
+		ret[i] = v
+	}
+	return ret
+}
+
+// SRandMember returns random members of the set. If cnt is positive, it
+// returns up to cnt distinct members. If it is negative, it returns exactly
+// -cnt members, possibly with repetitions.
+func (s *set) SRandMember(cnt int64) []string {
+	ln := int64(s.len())
+	if ln == 0 || cnt == 0 {
+		return empty
+	}
+
+	// Negative count, repetitions allowed
+	if cnt < 0 {
+		ret := make([]string, -cnt)
+		for i := range ret {
+			ret[i] = s.member(int(rand.Int63n(ln)))
+		}
+		return ret
+	}
+
+	// Count covers the whole set
+	if cnt >= ln {
+		return s.SMembers()
+	}
+
+	// If a large portion of the set is requested, shuffle a copy of the
+	// members, otherwise pick random members until enough distinct ones
+	// are found.
+	if cnt*3 > ln {
+		ret := s.SMembers()
+		for i := int64(0); i < cnt; i++ {
+			j := i + rand.Int63n(ln-i)
+			ret[i], ret[j] = ret[j], ret[i]
+		}
+		return ret[:cnt]
+	}
+	ret := make([]string, 0, cnt)
+	picked := make(map[int64]struct{}, cnt)
+	for int64(len(ret)) < cnt {
+		i := rand.Int63n(ln)
+		if _, ok := picked[i]; !ok {
+			picked[i] = struct{}{}
+			ret = append(ret, s.member(int(i)))
+		}
 	}
 	return ret
 }
 
 // SRem removes the values vals from the set. It returns the number
 // of elements that were actually removed.
-func (s set) SRem(vals ...string) int64 {
+func (s *set) SRem(vals ...string) int64 {
 	var cnt int64
 	for _, v := range vals {
-		if _, ok := s[v]; ok {
-			delete(s, v)
+		if s.del(v) {
 			cnt++
 		}
 	}
 	return cnt
 }
 
-// SUnion returns the union of all sets.
-func (s set) SUnion(sets ...Set) []string {
-	ret := make(set, len(s))
-	for k := range s {
-		ret[k] = struct{}{}
+// del removes the value v from the set. It returns true if the value was
+// removed.
+func (s *set) del(v string) bool {
+	switch {
+	case s.is != nil:
+		n, ok := parseCanonicalInt(v)
+		return ok && s.is.remove(n)
+	case s.lp != nil:
+		i := s.lp.index(v, 1)
+		if i < 0 {
+			return false
+		}
+		s.lp.remove(i, 1)
+		return true
 	}
-	for _, otherSet := range sets {
-		m := otherSet.SMembers()
-		for _, k := range m {
-			ret[k] = struct{}{}
+	return s.mbrs.Remove(v)
+}
+
+// SScan returns the members of one page of a cursor-based iteration over
+// the set, starting at cursor and examining about count members, along with
+// the cursor of the next page (0 when the iteration is done). If match is
+// not empty, only the members that match this glob-style pattern are
+// returned. Like Redis does for its compact encodings, an intset or
+// listpack-encoded set is returned in a single page, regardless of the
+// cursor and count.
+func (s *set) SScan(cursor uint64, count int64, match string) (uint64, []string) {
+	var cur uint64
+	mbrs := []string{}
+	add := func(m string) {
+		if match == "" || MatchGlob(match, m) {
+			mbrs = append(mbrs, m)
+		}
+	}
+	if s.is != nil || s.lp != nil {
+		for _, m := range s.members() {
+			add(m)
 		}
+	} else {
+		cur = s.mbrs.Scan(cursor, count, add)
+	}
+	return cur, mbrs
+}
+
+// SUnion returns the union of all sets.
+func (s *set) SUnion(sets ...Set) []string {
+	ret := newHashtableSet()
+	ret.SAdd(s.members()...)
+	for _, otherSet := range sets {
+		ret.SAdd(otherSet.SMembers()...)
+	}
+	if ret.len() == 0 {
+		return empty
 	}
-	return ret.SMembers()
+	return ret.mbrs.Names()
 }
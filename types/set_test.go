@@ -2,6 +2,8 @@ package types
 
 import (
 	"reflect"
+	"sort"
+	"strconv"
 	"testing"
 )
 
@@ -24,6 +26,16 @@ func setFromStrings(s []string) Set {
 	return newset
 }
 
+// sameMembers returns true if both lists hold the same values, regardless
+// of order, as the order of the members of a set is unspecified.
+func sameMembers(got, exp []string) bool {
+	got = append([]string{}, got...)
+	exp = append([]string{}, exp...)
+	sort.Strings(got)
+	sort.Strings(exp)
+	return reflect.DeepEqual(got, exp)
+}
+
 func TestSetType(t *testing.T) {
 	tp := setcase.Type()
 	if tp != "set" {
@@ -31,6 +43,18 @@ func TestSetType(t *testing.T) {
 	}
 }
 
+func TestSetClone(t *testing.T) {
+	c := setcase.Clone().(Set)
+	c.SRem("a")
+	c.SAdd("d")
+	if got := setcase.SMembers(); !sameMembers(got, []string{"a", "b", "c"}) {
+		t.Errorf("expected %v, got %v", []string{"a", "b", "c"}, got)
+	}
+	if got := c.SMembers(); !sameMembers(got, []string{"b", "c", "d"}) {
+		t.Errorf("expected %v, got %v", []string{"b", "c", "d"}, got)
+	}
+}
+
 func TestSetSAdd(t *testing.T) {
 	empty := NewSet()
 	set := cloneSet(setcase)
@@ -103,7 +127,7 @@ func TestSetSDiff(t *testing.T) {
 			sets[j] = setFromStrings(vals)
 		}
 		got := c.s.SDiff(sets...)
-		if !reflect.DeepEqual(got, c.exp) {
+		if !sameMembers(got, c.exp) {
 			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
 	}
@@ -143,7 +167,7 @@ func TestSetSInter(t *testing.T) {
 			sets[j] = setFromStrings(vals)
 		}
 		got := c.s.SInter(sets...)
-		if !reflect.DeepEqual(got, c.exp) {
+		if !sameMembers(got, c.exp) {
 			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
 	}
@@ -179,10 +203,49 @@ func TestSetSMembers(t *testing.T) {
 	}
 	for i, c := range cases {
 		got := c.s.SMembers()
-		if !reflect.DeepEqual(got, c.exp) {
+		if !sameMembers(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
+		}
+	}
+}
+
+func TestSetSScan(t *testing.T) {
+	cases := []struct {
+		s     Set
+		count int64
+		match string
+		exp   []string
+	}{
+		0: {setcase, 10, "", []string{"a", "b", "c"}},
+		1: {setcase, 1, "", []string{"a", "b", "c"}},
+		2: {setcase, 2, "[^b]", []string{"a", "c"}},
+		3: {setempty, 10, "", []string{}},
+	}
+	for i, c := range cases {
+		var got []string
+		cur := uint64(0)
+		for {
+			var mbrs []string
+			cur, mbrs = c.s.SScan(cur, c.count, c.match)
+			got = append(got, mbrs...)
+			if cur == 0 {
+				break
+			}
+		}
+		if !sameMembers(got, c.exp) {
 			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
 	}
+
+	// A page of a large set only visits about count members
+	s := NewSet()
+	for i := 0; i < 1000; i++ {
+		s.SAdd("m" + strconv.Itoa(i))
+	}
+	cur, mbrs := s.SScan(0, 10, "")
+	if cur == 0 || len(mbrs) < 10 || len(mbrs) > 30 {
+		t.Errorf("expected about 10 members, got %d with cursor %d", len(mbrs), cur)
+	}
 }
 
 func TestSetSRem(t *testing.T) {
@@ -205,7 +268,7 @@ func TestSetSRem(t *testing.T) {
 			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
 		}
 		vals := c.s.SMembers()
-		if !reflect.DeepEqual(vals, c.res) {
+		if !sameMembers(vals, c.res) {
 			t.Errorf("%d: expected %v, got %v", i, c.res, vals)
 		}
 	}
@@ -245,8 +308,118 @@ func TestSetSUnion(t *testing.T) {
 			sets[j] = setFromStrings(vals)
 		}
 		got := c.s.SUnion(sets...)
-		if !reflect.DeepEqual(got, c.exp) {
+		if !sameMembers(got, c.exp) {
 			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
 		}
 	}
 }
+
+func TestSetSInterCard(t *testing.T) {
+	cases := []struct {
+		s      Set
+		limit  int64
+		inters [][]string
+		exp    int64
+	}{
+		0: {setempty, 0, [][]string{}, 0},
+		1: {setcase, 0, [][]string{}, 3},
+		2: {setcase, 2, [][]string{}, 2},
+		3: {setcase, 0, [][]string{
+			{"a", "b", "c"},
+			{"c", "b"},
+		}, 2},
+		4: {setcase, 1, [][]string{
+			{"a", "b", "c"},
+			{"c", "b"},
+		}, 1},
+		5: {setcase, 0, [][]string{
+			{"e"},
+		}, 0},
+	}
+	for i, c := range cases {
+		sets := make([]Set, len(c.inters))
+		for j, vals := range c.inters {
+			sets[j] = setFromStrings(vals)
+		}
+		got := c.s.SInterCard(c.limit, sets...)
+		if got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+	}
+}
+
+func TestSetSPop(t *testing.T) {
+	set := cloneSet(setcase)
+	cases := []struct {
+		cnt  int64
+		exp  int
+		card int64
+	}{
+		0: {0, 0, 3},
+		1: {1, 1, 2},
+		2: {5, 2, 0},
+		3: {1, 0, 0},
+	}
+	seen := make(map[string]bool)
+	for i, c := range cases {
+		got := set.SPop(c.cnt)
+		if len(got) != c.exp {
+			t.Errorf("%d: expected %d values, got %d", i, c.exp, len(got))
+		}
+		for _, v := range got {
+			if seen[v] || !setcase.SIsMember(v) {
+				t.Errorf("%d: unexpected value %q", i, v)
+			}
+			seen[v] = true
+			if set.SIsMember(v) {
+				t.Errorf("%d: expected %q to be removed", i, v)
+			}
+		}
+		if card := set.SCard(); card != c.card {
+			t.Errorf("%d: expected cardinality to be %d, got %d", i, c.card, card)
+		}
+	}
+}
+
+func TestSetSRandMember(t *testing.T) {
+	large := NewSet()
+	for i := 0; i < 100; i++ {
+		large.SAdd(string(rune('A' + i)))
+	}
+	cases := []struct {
+		s        Set
+		cnt      int64
+		exp      int
+		distinct bool
+	}{
+		0: {setempty, 3, 0, true},
+		1: {setempty, -3, 0, true},
+		2: {setcase, 0, 0, true},
+		3: {setcase, 2, 2, true},
+		4: {setcase, 5, 3, true},
+		5: {setcase, -5, 5, false},
+		6: {large, 10, 10, true},
+		7: {large, 90, 90, true},
+		8: {large, -200, 200, false},
+	}
+	for i, c := range cases {
+		card := c.s.SCard()
+		got := c.s.SRandMember(c.cnt)
+		if len(got) != c.exp {
+			t.Errorf("%d: expected %d values, got %d", i, c.exp, len(got))
+		}
+		seen := make(map[string]bool)
+		for _, v := range got {
+			if !c.s.SIsMember(v) {
+				t.Errorf("%d: unexpected value %q", i, v)
+			}
+			if c.distinct && seen[v] {
+				t.Errorf("%d: duplicate value %q", i, v)
+			}
+			seen[v] = true
+		}
+		if newCard := c.s.SCard(); newCard != card {
+			t.Errorf("%d: expected cardinality to be %d, got %d", i, card, newCard)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package types
+
+import "math/rand"
+
+const (
+	// skiplistMaxLevel is the maximum number of levels of a skiplist node,
+	// enough for 2^64 elements.
+	skiplistMaxLevel = 32
+
+	// skiplistP is the probability that a node has an additional level.
+	skiplistP = 0.25
+)
+
+// skiplistLevel is a level of a skiplist node, pointing to the next node
+// at this level and holding the number of nodes it skips over.
+type skiplistLevel struct {
+	forward *skiplistNode
+	span    int64
+}
+
+// skiplistNode is an element of the skiplist.
+type skiplistNode struct {
+	member   string
+	score    float64
+	backward *skiplistNode
+	level    []skiplistLevel
+}
+
+// skiplist is an ordered list of score-member pairs, sorted by score and
+// then by member. It supports O(log n) insertion, deletion and lookup
+// by score or by rank, in the same way as the Redis zskiplist.
+type skiplist struct {
+	header, tail *skiplistNode
+	length       int64
+	level        int
+}
+
+// newSkiplist creates a new, empty skiplist.
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: &skiplistNode{level: make([]skiplistLevel, skiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+// randomLevel returns a random level for a new node, following a power law
+// so that higher levels are less likely.
+func randomLevel() int {
+	lvl := 1
+	for lvl < skiplistMaxLevel && rand.Float64() < skiplistP {
+		lvl++
+	}
+	return lvl
+}
+
+// less returns true if the node n sorts before the score-member pair.
+func (n *skiplistNode) less(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// insert adds a new node with the specified score and member. It is assumed
+// that the member is not already in the skiplist.
+func (sl *skiplist) insert(score float64, member string) *skiplistNode {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int64
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	lvl := randomLevel()
+	if lvl > sl.level {
+		for i := sl.level; i < lvl; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].level[i].span = sl.length
+		}
+		sl.level = lvl
+	}
+
+	x = &skiplistNode{
+		member: member,
+		score:  score,
+		level:  make([]skiplistLevel, lvl),
+	}
+	for i := 0; i < lvl; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+
+		// Update the span covered by update[i], as x is inserted here
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+
+	// Increment span for untouched levels
+	for i := lvl; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+	return x
+}
+
+// deleteNode unlinks the node x, given the update vector of the nodes
+// pointing to it at each level.
+func (sl *skiplist) deleteNode(x *skiplistNode, update []*skiplistNode) {
+	for i := 0; i < sl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.header.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+}
+
+// delete removes the node with the specified score and member. It returns
+// true if the node was found and removed.
+func (sl *skiplist) delete(score float64, member string) bool {
+	var update [skiplistMaxLevel]*skiplistNode
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x != nil && x.score == score && x.member == member {
+		sl.deleteNode(x, update[:])
+		return true
+	}
+	return false
+}
+
+// updateScore changes the score of the existing node identified by
+// curScore and member to newScore. It returns the updated node.
+func (sl *skiplist) updateScore(curScore float64, member string, newScore float64) *skiplistNode {
+	var update [skiplistMaxLevel]*skiplistNode
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(curScore, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+
+	// Fast path if the node stays at the same position
+	if (x.backward == nil || x.backward.less(newScore, member)) &&
+		(x.level[0].forward == nil || !x.level[0].forward.less(newScore, member)) {
+		x.score = newScore
+		return x
+	}
+
+	// Otherwise remove and re-insert
+	sl.deleteNode(x, update[:])
+	return sl.insert(newScore, member)
+}
+
+// rank returns the 1-based rank of the node with the specified score and
+// member, or 0 if it is not found.
+func (sl *skiplist) rank(score float64, member string) int64 {
+	var rank int64
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && (x.level[i].forward.less(score, member) ||
+			(x.level[i].forward.score == score && x.level[i].forward.member == member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != sl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns the node at the specified 1-based rank, or nil if
+// the rank is out of range.
+func (sl *skiplist) byRank(rank int64) *skiplistNode {
+	var traversed int64
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstInRange returns the first node matched by the range r, or nil.
+func (sl *skiplist) firstInRange(r rangeSpec) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if x == nil || !r.lteMax(x) {
+		return nil
+	}
+	return x
+}
+
+// lastInRange returns the last node matched by the range r, or nil.
+func (sl *skiplist) lastInRange(r rangeSpec) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.lteMax(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	if x == sl.header || !r.gteMin(x) {
+		return nil
+	}
+	return x
+}
+
+// deleteRange removes all nodes matched by the range r, calling fn for each
+// removed node. It returns the number of nodes removed.
+func (sl *skiplist) deleteRange(r rangeSpec, fn func(*skiplistNode)) int64 {
+	var update [skiplistMaxLevel]*skiplistNode
+	var removed int64
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	for x != nil && r.lteMax(x) {
+		next := x.level[0].forward
+		sl.deleteNode(x, update[:])
+		fn(x)
+		removed++
+		x = next
+	}
+	return removed
+}
+
+// deleteRangeByRank removes all nodes between the 1-based ranks start and
+// stop, inclusive, calling fn for each removed node. It returns the number
+// of nodes removed.
+func (sl *skiplist) deleteRangeByRank(start, stop int64, fn func(*skiplistNode)) int64 {
+	var update [skiplistMaxLevel]*skiplistNode
+	var traversed, removed int64
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span < start {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	traversed++
+	x = x.level[0].forward
+	for x != nil && traversed <= stop {
+		next := x.level[0].forward
+		sl.deleteNode(x, update[:])
+		fn(x)
+		removed++
+		traversed++
+		x = next
+	}
+	return removed
+}
+
+// rangeSpec defines the methods required to select a contiguous range of
+// nodes in the skiplist.
+type rangeSpec interface {
+	gteMin(*skiplistNode) bool
+	lteMax(*skiplistNode) bool
+}
+
+func (r ScoreRange) gteMin(n *skiplistNode) bool {
+	if r.MinExclusive {
+		return n.score > r.Min
+	}
+	return n.score >= r.Min
+}
+
+func (r ScoreRange) lteMax(n *skiplistNode) bool {
+	if r.MaxExclusive {
+		return n.score < r.Max
+	}
+	return n.score <= r.Max
+}
+
+func (r LexRange) gteMin(n *skiplistNode) bool {
+	switch r.Min.Inf {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+	if r.Min.Exclusive {
+		return n.member > r.Min.Value
+	}
+	return n.member >= r.Min.Value
+}
+
+func (r LexRange) lteMax(n *skiplistNode) bool {
+	switch r.Max.Inf {
+	case -1:
+		return false
+	case 1:
+		return true
+	}
+	if r.Max.Exclusive {
+		return n.member < r.Max.Value
+	}
+	return n.member <= r.Max.Value
+}
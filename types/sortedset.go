@@ -0,0 +1,351 @@
+package types
+
+import "math"
+
+// SortedSet defines the methods required to implement a SortedSet.
+type SortedSet interface {
+	Value
+
+	ZAdd(float64, string) bool
+	ZCard() int64
+	ZCount(ScoreRange) int64
+	ZIncrBy(float64, string) (float64, bool)
+	ZLexCount(LexRange) int64
+	ZRange(int64, int64, bool) []ScoreMember
+	ZRangeByLex(LexRange, bool, int64, int64) []ScoreMember
+	ZRangeByScore(ScoreRange, bool, int64, int64) []ScoreMember
+	ZRank(string, bool) (int64, bool)
+	ZRem(...string) int64
+	ZRemRangeByLex(LexRange) int64
+	ZRemRangeByRank(int64, int64) int64
+	ZRemRangeByScore(ScoreRange) int64
+	ZScan(uint64, int64, string) (uint64, []ScoreMember)
+	ZScore(string) (float64, bool)
+}
+
+// ScoreMember is a member of a sorted set along with its score.
+type ScoreMember struct {
+	Score  float64
+	Member string
+}
+
+// ScoreRange defines a range of scores, each bound being inclusive unless
+// the corresponding exclusive flag is set.
+type ScoreRange struct {
+	Min, Max                   float64
+	MinExclusive, MaxExclusive bool
+}
+
+// LexBound is a bound of a lexicographical range. If Inf is -1, the bound
+// is the negative infinite string ("-"), if it is 1, it is the positive
+// infinite string ("+"), otherwise Value is the bound, exclusive if
+// Exclusive is set.
+type LexBound struct {
+	Value     string
+	Exclusive bool
+	Inf       int
+}
+
+// LexRange defines a lexicographical range of members. It is only meaningful
+// when all members of the sorted set have the same score.
+type LexRange struct {
+	Min, Max LexBound
+}
+
+// Static type check to validate that *sortedSet implements SortedSet.
+var _ SortedSet = (*sortedSet)(nil)
+
+// sortedSet is the internal implementation of a SortedSet. It holds the
+// scores in a map for O(1) lookup by member, the ordered members in a
+// skiplist for O(log n) lookup by rank and by score, and the members in an
+// Index for incremental scans.
+type sortedSet struct {
+	scores map[string]float64
+	sl     *skiplist
+	mbrs   Index
+}
+
+// NewSortedSet creates a new SortedSet.
+func NewSortedSet() SortedSet {
+	return &sortedSet{
+		scores: make(map[string]float64),
+		sl:     newSkiplist(),
+	}
+}
+
+// Type returns the type of the value, which is "zset".
+func (z *sortedSet) Type() string {
+	return "zset"
+}
+
+// Clone returns a copy of the sorted set.
+func (z *sortedSet) Clone() Value {
+	c := NewSortedSet()
+	for n := z.sl.header.level[0].forward; n != nil; n = n.level[0].forward {
+		c.ZAdd(n.score, n.member)
+	}
+	return c
+}
+
+// MemoryUsage returns the estimated number of bytes used by the sorted set,
+// extrapolated from the size of its first samples members.
+func (z *sortedSet) MemoryUsage(samples int) int64 {
+	// A node has on average 1/(1-skiplistP) levels
+	const nodeSize = stringHeaderSize + 2*wordSize + sliceHeaderSize + 2*wordSize*4/3
+
+	n := z.sl.length
+	sampled := make([]string, 0, sampleCount(n, samples))
+	for nd := z.sl.header.level[0].forward; nd != nil && len(sampled) < cap(sampled); nd = nd.level[0].forward {
+		sampled = append(sampled, nd.member)
+	}
+	size := int64(2*wordSize) + 4*wordSize + skiplistMaxLevel*2*wordSize
+	size += n*(mapEntrySize(stringHeaderSize+wordSize)+nodeSize) + z.mbrs.memoryUsage()
+	return size + sampledSize(n, sampled)
+}
+
+// ZAdd adds the member with the specified score, or updates its score if
+// it is already in the sorted set. It returns true if the member was added.
+func (z *sortedSet) ZAdd(score float64, member string) bool {
+	if cur, ok := z.scores[member]; ok {
+		if cur != score {
+			z.sl.updateScore(cur, member, score)
+			z.scores[member] = score
+		}
+		return false
+	}
+	z.sl.insert(score, member)
+	z.scores[member] = score
+	z.mbrs.Add(member)
+	return true
+}
+
+// ZCard returns the number of members in the sorted set.
+func (z *sortedSet) ZCard() int64 {
+	return z.sl.length
+}
+
+// ZCount returns the number of members with a score in the range r.
+func (z *sortedSet) ZCount(r ScoreRange) int64 {
+	return z.countInRange(r)
+}
+
+// ZIncrBy increments the score of member by inc, adding it with a score
+// of inc if it does not exist. It returns the new score, and false as second
+// value if the resulting score is not a number, in which case the sorted set
+// is left unmodified.
+func (z *sortedSet) ZIncrBy(inc float64, member string) (float64, bool) {
+	score := inc
+	if cur, ok := z.scores[member]; ok {
+		score += cur
+	}
+	if math.IsNaN(score) {
+		return 0, false
+	}
+	z.ZAdd(score, member)
+	return score, true
+}
+
+// ZLexCount returns the number of members in the lexicographical range r.
+func (z *sortedSet) ZLexCount(r LexRange) int64 {
+	return z.countInRange(r)
+}
+
+// countInRange returns the number of nodes matched by the range r.
+func (z *sortedSet) countInRange(r rangeSpec) int64 {
+	first := z.sl.firstInRange(r)
+	if first == nil {
+		return 0
+	}
+	last := z.sl.lastInRange(r)
+	return z.sl.rank(last.score, last.member) - z.sl.rank(first.score, first.member) + 1
+}
+
+// ZRange returns the members between the ranks start and stop, inclusive.
+// Negative ranks are relative to the end of the sorted set. If rev is true,
+// ranks are ordered from the highest to the lowest score.
+func (z *sortedSet) ZRange(start, stop int64, rev bool) []ScoreMember {
+	ln := z.sl.length
+	if start < 0 {
+		start += ln
+	}
+	if stop < 0 {
+		stop += ln
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > stop || start >= ln {
+		return []ScoreMember{}
+	}
+	if stop >= ln {
+		stop = ln - 1
+	}
+
+	ret := make([]ScoreMember, 0, stop-start+1)
+	var x *skiplistNode
+	if rev {
+		x = z.sl.byRank(ln - start)
+	} else {
+		x = z.sl.byRank(start + 1)
+	}
+	for i := start; i <= stop; i++ {
+		ret = append(ret, ScoreMember{x.score, x.member})
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return ret
+}
+
+// ZRangeByLex returns the members in the lexicographical range r, skipping
+// the first offset members and returning at most count members (or all
+// remaining members if count is negative). If rev is true, members are
+// returned in reverse order.
+func (z *sortedSet) ZRangeByLex(r LexRange, rev bool, offset, count int64) []ScoreMember {
+	return z.rangeSpec(r, rev, offset, count)
+}
+
+// ZRangeByScore returns the members with a score in the range r, skipping
+// the first offset members and returning at most count members (or all
+// remaining members if count is negative). If rev is true, members are
+// returned from the highest to the lowest score.
+func (z *sortedSet) ZRangeByScore(r ScoreRange, rev bool, offset, count int64) []ScoreMember {
+	return z.rangeSpec(r, rev, offset, count)
+}
+
+// rangeSpec returns the members matched by the range r, as documented by
+// ZRangeByScore.
+func (z *sortedSet) rangeSpec(r rangeSpec, rev bool, offset, count int64) []ScoreMember {
+	ret := []ScoreMember{}
+	if offset < 0 {
+		return ret
+	}
+
+	var x *skiplistNode
+	if rev {
+		x = z.sl.lastInRange(r)
+	} else {
+		x = z.sl.firstInRange(r)
+	}
+
+	// Skip the offset, then collect matching members
+	for ; x != nil && offset > 0; offset-- {
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	for ; x != nil && count != 0; count-- {
+		if rev {
+			if !r.gteMin(x) {
+				break
+			}
+		} else if !r.lteMax(x) {
+			break
+		}
+		ret = append(ret, ScoreMember{x.score, x.member})
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return ret
+}
+
+// ZRank returns the 0-based rank of the member, ordered from the lowest to
+// the highest score, or from the highest to the lowest if rev is true. It
+// returns false as second value if member is not in the sorted set.
+func (z *sortedSet) ZRank(member string, rev bool) (int64, bool) {
+	score, ok := z.scores[member]
+	if !ok {
+		return 0, false
+	}
+	rank := z.sl.rank(score, member)
+	if rev {
+		return z.sl.length - rank, true
+	}
+	return rank - 1, true
+}
+
+// ZRem removes the members from the sorted set. It returns the number of
+// members actually removed.
+func (z *sortedSet) ZRem(members ...string) int64 {
+	var cnt int64
+	for _, m := range members {
+		if score, ok := z.scores[m]; ok {
+			z.sl.delete(score, m)
+			delete(z.scores, m)
+			z.mbrs.Remove(m)
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// ZRemRangeByLex removes the members in the lexicographical range r. It
+// returns the number of members removed.
+func (z *sortedSet) ZRemRangeByLex(r LexRange) int64 {
+	return z.sl.deleteRange(r, z.forget)
+}
+
+// ZRemRangeByRank removes the members between the 0-based ranks start and
+// stop, inclusive. Negative ranks are relative to the end of the sorted set.
+// It returns the number of members removed.
+func (z *sortedSet) ZRemRangeByRank(start, stop int64) int64 {
+	ln := z.sl.length
+	if start < 0 {
+		start += ln
+	}
+	if stop < 0 {
+		stop += ln
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > stop || start >= ln {
+		return 0
+	}
+	if stop >= ln {
+		stop = ln - 1
+	}
+	return z.sl.deleteRangeByRank(start+1, stop+1, z.forget)
+}
+
+// ZRemRangeByScore removes the members with a score in the range r. It
+// returns the number of members removed.
+func (z *sortedSet) ZRemRangeByScore(r ScoreRange) int64 {
+	return z.sl.deleteRange(r, z.forget)
+}
+
+// forget removes the member of the node from the scores map and the
+// index, once it has been deleted from the skiplist.
+func (z *sortedSet) forget(n *skiplistNode) {
+	delete(z.scores, n.member)
+	z.mbrs.Remove(n.member)
+}
+
+// ZScan returns the members and scores of one page of a cursor-based
+// iteration over the sorted set, starting at cursor and examining about
+// count members, along with the cursor of the next page (0 when the
+// iteration is done). If match is not empty, only the members that match
+// this glob-style pattern are returned.
+func (z *sortedSet) ZScan(cursor uint64, count int64, match string) (uint64, []ScoreMember) {
+	sms := []ScoreMember{}
+	cur := z.mbrs.Scan(cursor, count, func(m string) {
+		if match == "" || MatchGlob(match, m) {
+			sms = append(sms, ScoreMember{z.scores[m], m})
+		}
+	})
+	return cur, sms
+}
+
+// ZScore returns the score of the member, and false as second value if
+// member is not in the sorted set.
+func (z *sortedSet) ZScore(member string) (float64, bool) {
+	score, ok := z.scores[member]
+	return score, ok
+}
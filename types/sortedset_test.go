@@ -0,0 +1,364 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+var zscase = []ScoreMember{
+	{1, "a"},
+	{2, "b"},
+	{2, "c"},
+	{3, "d"},
+	{5, "e"},
+}
+
+func zsetFromScores(sms []ScoreMember) SortedSet {
+	zs := NewSortedSet()
+	for _, sm := range sms {
+		zs.ZAdd(sm.Score, sm.Member)
+	}
+	return zs
+}
+
+func TestSortedSetType(t *testing.T) {
+	zs := NewSortedSet()
+	tp := zs.Type()
+	if tp != "zset" {
+		t.Errorf("expected %q, got %q", "zset", tp)
+	}
+}
+
+func TestSortedSetClone(t *testing.T) {
+	zs := zsetFromScores(zscase)
+	c := zs.Clone().(SortedSet)
+	c.ZRem("a")
+	c.ZIncrBy(10, "b")
+	if got := zs.ZRange(0, -1, false); !reflect.DeepEqual(got, zscase) {
+		t.Errorf("expected %v, got %v", zscase, got)
+	}
+	exp := []ScoreMember{{2, "c"}, {3, "d"}, {5, "e"}, {12, "b"}}
+	if got := c.ZRange(0, -1, false); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestSortedSetZAdd(t *testing.T) {
+	zs := NewSortedSet()
+	cases := []struct {
+		score  float64
+		member string
+		exp    bool
+		card   int64
+	}{
+		0: {1, "a", true, 1},
+		1: {1, "a", false, 1},
+		2: {2, "a", false, 1},
+		3: {0, "b", true, 2},
+		4: {math.Inf(-1), "c", true, 3},
+	}
+	for i, c := range cases {
+		got := zs.ZAdd(c.score, c.member)
+		if got != c.exp {
+			t.Errorf("%d: expected %t, got %t", i, c.exp, got)
+		}
+		if card := zs.ZCard(); card != c.card {
+			t.Errorf("%d: expected cardinality to be %d, got %d", i, c.card, card)
+		}
+		if score, _ := zs.ZScore(c.member); score != c.score {
+			t.Errorf("%d: expected score to be %f, got %f", i, c.score, score)
+		}
+	}
+	exp := []ScoreMember{{math.Inf(-1), "c"}, {0, "b"}, {2, "a"}}
+	if got := zs.ZRange(0, -1, false); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestSortedSetZCount(t *testing.T) {
+	zs := zsetFromScores(zscase)
+	cases := []struct {
+		r   ScoreRange
+		exp int64
+	}{
+		0: {ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}, 5},
+		1: {ScoreRange{Min: 2, Max: 3}, 3},
+		2: {ScoreRange{Min: 2, Max: 3, MinExclusive: true}, 1},
+		3: {ScoreRange{Min: 2, Max: 3, MaxExclusive: true}, 2},
+		4: {ScoreRange{Min: 3, Max: 2}, 0},
+		5: {ScoreRange{Min: 6, Max: 10}, 0},
+		6: {ScoreRange{Min: 2, Max: 2, MinExclusive: true}, 0},
+	}
+	for i, c := range cases {
+		got := zs.ZCount(c.r)
+		if got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+	}
+}
+
+func TestSortedSetZIncrBy(t *testing.T) {
+	zs := zsetFromScores(zscase)
+	cases := []struct {
+		inc    float64
+		member string
+		exp    float64
+		ok     bool
+	}{
+		0: {1, "a", 2, true},
+		1: {-3, "a", -1, true},
+		2: {4, "z", 4, true},
+		3: {math.Inf(1), "z", math.Inf(1), true},
+		4: {math.Inf(-1), "z", 0, false},
+	}
+	for i, c := range cases {
+		got, ok := zs.ZIncrBy(c.inc, c.member)
+		if got != c.exp {
+			t.Errorf("%d: expected %f, got %f", i, c.exp, got)
+		}
+		if ok != c.ok {
+			t.Errorf("%d: expected %t, got %t", i, c.ok, ok)
+		}
+	}
+	if rank, _ := zs.ZRank("a", false); rank != 0 {
+		t.Errorf("expected rank of a to be 0, got %d", rank)
+	}
+	if rank, _ := zs.ZRank("z", false); rank != 5 {
+		t.Errorf("expected rank of z to be 5, got %d", rank)
+	}
+}
+
+func TestSortedSetZRange(t *testing.T) {
+	zs := zsetFromScores(zscase)
+	cases := []struct {
+		start, stop int64
+		rev         bool
+		exp         []string
+	}{
+		0: {0, -1, false, []string{"a", "b", "c", "d", "e"}},
+		1: {0, -1, true, []string{"e", "d", "c", "b", "a"}},
+		2: {1, 2, false, []string{"b", "c"}},
+		3: {1, 2, true, []string{"d", "c"}},
+		4: {-2, 10, false, []string{"d", "e"}},
+		5: {-10, 0, false, []string{"a"}},
+		6: {3, 1, false, []string{}},
+		7: {5, 10, false, []string{}},
+	}
+	for i, c := range cases {
+		got := members(zs.ZRange(c.start, c.stop, c.rev))
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
+		}
+	}
+}
+
+func TestSortedSetZRangeByScore(t *testing.T) {
+	zs := zsetFromScores(zscase)
+	cases := []struct {
+		r             ScoreRange
+		rev           bool
+		offset, count int64
+		exp           []string
+	}{
+		0: {ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}, false, 0, -1, []string{"a", "b", "c", "d", "e"}},
+		1: {ScoreRange{Min: 2, Max: 3}, false, 0, -1, []string{"b", "c", "d"}},
+		2: {ScoreRange{Min: 2, Max: 3}, true, 0, -1, []string{"d", "c", "b"}},
+		3: {ScoreRange{Min: 2, Max: 3, MinExclusive: true}, false, 0, -1, []string{"d"}},
+		4: {ScoreRange{Min: 1, Max: 5}, false, 1, 2, []string{"b", "c"}},
+		5: {ScoreRange{Min: 1, Max: 5}, true, 1, 2, []string{"d", "c"}},
+		6: {ScoreRange{Min: 1, Max: 5}, false, 4, 10, []string{"e"}},
+		7: {ScoreRange{Min: 1, Max: 5}, false, 5, 10, []string{}},
+		8: {ScoreRange{Min: 1, Max: 5}, false, -1, 10, []string{}},
+		9: {ScoreRange{Min: 4, Max: 4}, false, 0, -1, []string{}},
+	}
+	for i, c := range cases {
+		got := members(zs.ZRangeByScore(c.r, c.rev, c.offset, c.count))
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
+		}
+	}
+}
+
+func TestSortedSetZRangeByLex(t *testing.T) {
+	zs := zsetFromScores([]ScoreMember{{0, "a"}, {0, "b"}, {0, "c"}, {0, "d"}})
+	cases := []struct {
+		r   LexRange
+		rev bool
+		exp []string
+	}{
+		0: {LexRange{LexBound{Inf: -1}, LexBound{Inf: 1}}, false, []string{"a", "b", "c", "d"}},
+		1: {LexRange{LexBound{Inf: -1}, LexBound{Inf: 1}}, true, []string{"d", "c", "b", "a"}},
+		2: {LexRange{LexBound{Value: "b"}, LexBound{Value: "c"}}, false, []string{"b", "c"}},
+		3: {LexRange{LexBound{Value: "b", Exclusive: true}, LexBound{Inf: 1}}, false, []string{"c", "d"}},
+		4: {LexRange{LexBound{Inf: -1}, LexBound{Value: "c", Exclusive: true}}, true, []string{"b", "a"}},
+		5: {LexRange{LexBound{Inf: 1}, LexBound{Inf: -1}}, false, []string{}},
+		6: {LexRange{LexBound{Value: "bb"}, LexBound{Value: "zz"}}, false, []string{"c", "d"}},
+	}
+	for i, c := range cases {
+		got := members(zs.ZRangeByLex(c.r, c.rev, 0, -1))
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
+		}
+		if cnt := zs.ZLexCount(c.r); cnt != int64(len(c.exp)) {
+			t.Errorf("%d: expected count to be %d, got %d", i, len(c.exp), cnt)
+		}
+	}
+}
+
+func TestSortedSetZRank(t *testing.T) {
+	zs := zsetFromScores(zscase)
+	cases := []struct {
+		member string
+		rev    bool
+		exp    int64
+		ok     bool
+	}{
+		0: {"a", false, 0, true},
+		1: {"a", true, 4, true},
+		2: {"c", false, 2, true},
+		3: {"c", true, 2, true},
+		4: {"e", false, 4, true},
+		5: {"z", false, 0, false},
+	}
+	for i, c := range cases {
+		got, ok := zs.ZRank(c.member, c.rev)
+		if got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+		if ok != c.ok {
+			t.Errorf("%d: expected %t, got %t", i, c.ok, ok)
+		}
+	}
+}
+
+func TestSortedSetZScan(t *testing.T) {
+	zs := zsetFromScores(zscase)
+	cases := []struct {
+		count int64
+		match string
+		exp   map[string]float64
+	}{
+		0: {10, "", map[string]float64{"a": 1, "b": 2, "c": 2, "d": 3, "e": 5}},
+		1: {2, "", map[string]float64{"a": 1, "b": 2, "c": 2, "d": 3, "e": 5}},
+		2: {1, "[a-c]", map[string]float64{"a": 1, "b": 2, "c": 2}},
+		3: {1, "x", map[string]float64{}},
+	}
+	for i, c := range cases {
+		got := make(map[string]float64)
+		cur := uint64(0)
+		for {
+			var sms []ScoreMember
+			cur, sms = zs.ZScan(cur, c.count, c.match)
+			for _, sm := range sms {
+				got[sm.Member] = sm.Score
+			}
+			if cur == 0 {
+				break
+			}
+		}
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
+		}
+	}
+
+	// A page of a large sorted set only visits about count members
+	large := NewSortedSet()
+	for i := 0; i < 1000; i++ {
+		large.ZAdd(float64(i), fmt.Sprint(i))
+	}
+	cur, sms := large.ZScan(0, 10, "")
+	if cur == 0 || len(sms) < 10 || len(sms) > 30 {
+		t.Errorf("expected about 10 members, got %d with cursor %d", len(sms), cur)
+	}
+}
+
+func TestSortedSetZRem(t *testing.T) {
+	zs := zsetFromScores(zscase)
+	cases := []struct {
+		members []string
+		exp     int64
+		res     []string
+	}{
+		0: {[]string{"z"}, 0, []string{"a", "b", "c", "d", "e"}},
+		1: {[]string{"b", "b", "z"}, 1, []string{"a", "c", "d", "e"}},
+		2: {[]string{"a", "e"}, 2, []string{"c", "d"}},
+		3: {[]string{"c", "d"}, 2, []string{}},
+	}
+	for i, c := range cases {
+		got := zs.ZRem(c.members...)
+		if got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+		if res := members(zs.ZRange(0, -1, false)); !reflect.DeepEqual(res, c.res) {
+			t.Errorf("%d: expected %v, got %v", i, c.res, res)
+		}
+	}
+}
+
+func TestSortedSetZRemRange(t *testing.T) {
+	cases := []struct {
+		fn  func(SortedSet) int64
+		exp int64
+		res []string
+	}{
+		0: {func(zs SortedSet) int64 { return zs.ZRemRangeByRank(1, 2) }, 2, []string{"a", "d", "e"}},
+		1: {func(zs SortedSet) int64 { return zs.ZRemRangeByRank(-2, -1) }, 2, []string{"a", "b", "c"}},
+		2: {func(zs SortedSet) int64 { return zs.ZRemRangeByRank(3, 1) }, 0, []string{"a", "b", "c", "d", "e"}},
+		3: {func(zs SortedSet) int64 { return zs.ZRemRangeByScore(ScoreRange{Min: 2, Max: 3}) }, 3, []string{"a", "e"}},
+		4: {func(zs SortedSet) int64 {
+			return zs.ZRemRangeByScore(ScoreRange{Min: 1, Max: 5, MinExclusive: true, MaxExclusive: true})
+		}, 3, []string{"a", "e"}},
+		5: {func(zs SortedSet) int64 {
+			return zs.ZRemRangeByLex(LexRange{LexBound{Inf: -1}, LexBound{Value: "b"}})
+		}, 2, []string{"c", "d", "e"}},
+	}
+	for i, c := range cases {
+		zs := zsetFromScores(zscase)
+		got := c.fn(zs)
+		if got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+		if res := members(zs.ZRange(0, -1, false)); !reflect.DeepEqual(res, c.res) {
+			t.Errorf("%d: expected %v, got %v", i, c.res, res)
+		}
+		if card := zs.ZCard(); card != int64(len(c.res)) {
+			t.Errorf("%d: expected cardinality to be %d, got %d", i, len(c.res), card)
+		}
+	}
+}
+
+func TestSortedSetLarge(t *testing.T) {
+	const n = 1000
+
+	zs := NewSortedSet()
+	for i := n - 1; i >= 0; i-- {
+		zs.ZAdd(float64(i), fmt.Sprintf("m%04d", i))
+	}
+	for i := 0; i < n; i += 7 {
+		m := fmt.Sprintf("m%04d", i)
+		if rank, _ := zs.ZRank(m, false); rank != int64(i) {
+			t.Fatalf("expected rank of %s to be %d, got %d", m, i, rank)
+		}
+	}
+	got := zs.ZRange(500, 502, false)
+	exp := []ScoreMember{{500, "m0500"}, {501, "m0501"}, {502, "m0502"}}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+	if cnt := zs.ZRemRangeByRank(0, n/2-1); cnt != n/2 {
+		t.Errorf("expected %d removed, got %d", n/2, cnt)
+	}
+	if rank, _ := zs.ZRank("m0999", false); rank != n/2-1 {
+		t.Errorf("expected rank of m0999 to be %d, got %d", n/2-1, rank)
+	}
+}
+
+func members(sms []ScoreMember) []string {
+	ret := make([]string, len(sms))
+	for i, sm := range sms {
+		ret[i] = sm.Member
+	}
+	return ret
+}
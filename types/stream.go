@@ -0,0 +1,503 @@
+package types
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Stream defines the methods required to implement a Stream.
+type Stream interface {
+	Value
+
+	LastID() StreamID
+	XAdd(StreamID, []string) bool
+	XDel(...StreamID) int64
+	XLen() int64
+	XRange(StreamID, StreamID, int64, bool) []StreamEntry
+	XTrimMaxLen(int64, bool, int64) int64
+	XTrimMinID(StreamID, bool, int64) int64
+
+	CreateGroup(string, StreamID) bool
+	DestroyGroup(string) bool
+	Group(string) (StreamGroup, bool)
+	Groups() []StreamGroup
+	Info() StreamInfo
+}
+
+// StreamID is the ID of a Stream entry, made of a milliseconds timestamp
+// and a sequence number.
+type StreamID struct {
+	Ms, Seq uint64
+}
+
+// MaxStreamID is the greatest possible StreamID.
+var MaxStreamID = StreamID{math.MaxUint64, math.MaxUint64}
+
+// ErrInvalidStreamID is returned by ParseStreamID when the string is not
+// a valid StreamID.
+var ErrInvalidStreamID = errors.New("invalid stream ID")
+
+// ParseStreamID parses the string s as a StreamID in the "ms-seq" format.
+// If the sequence part is missing, it is set to defSeq.
+func ParseStreamID(s string, defSeq uint64) (StreamID, error) {
+	var id StreamID
+	var err error
+
+	ms, seq := s, ""
+	i := strings.IndexByte(s, '-')
+	if i >= 0 {
+		ms, seq = s[:i], s[i+1:]
+	}
+	if id.Ms, err = strconv.ParseUint(ms, 10, 64); err != nil {
+		return id, ErrInvalidStreamID
+	}
+	if i < 0 {
+		id.Seq = defSeq
+	} else if id.Seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+		return id, ErrInvalidStreamID
+	}
+	return id, nil
+}
+
+// String returns the string representation of the ID, in the "ms-seq" format.
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less returns true if id is lower than other.
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+// Next returns the ID that immediately follows id. It returns false if id
+// is MaxStreamID.
+func (id StreamID) Next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{id.Ms, id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{id.Ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// Prev returns the ID that immediately precedes id. It returns false if
+// id is the zero ID.
+func (id StreamID) Prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{id.Ms, id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{id.Ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// StreamEntry is an entry of a Stream, holding its ID and its list of
+// field-value pairs.
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string
+}
+
+// StreamInfo holds the information about a Stream returned by Info.
+type StreamInfo struct {
+	Length       int64
+	Nodes        int64
+	Groups       int64
+	LastID       StreamID
+	MaxDeletedID StreamID
+	EntriesAdded int64
+}
+
+// streamNodeMaxEntries is the maximum number of entries held by a node of
+// the stream.
+const streamNodeMaxEntries = 100
+
+// Static type check to validate that *stream implements Stream.
+var _ Stream = (*stream)(nil)
+
+// stream is the internal implementation of a Stream. The entries are
+// stored in a list of nodes ordered by ID, each holding up to
+// streamNodeMaxEntries entries ordered by ID, so that a range scan requires
+// a binary search on the nodes and then on the entries of the first node.
+// Entries are only ever appended to the last node.
+type stream struct {
+	nodes  []*streamNode
+	length int64
+	lastID StreamID
+	groups map[string]*streamGroup
+
+	maxDeletedID StreamID
+	entriesAdded int64
+}
+
+// streamNode is a node of a stream.
+type streamNode struct {
+	entries []StreamEntry
+}
+
+// NewStream creates a new Stream.
+func NewStream() Stream {
+	return &stream{}
+}
+
+// Type returns the type of the value, which is "stream".
+func (s *stream) Type() string {
+	return "stream"
+}
+
+// Clone returns a copy of the stream, including its consumer groups. The
+// field-value pairs of the entries are never modified once added, so they
+// are shared with the original stream.
+func (s *stream) Clone() Value {
+	c := *s
+	c.nodes = make([]*streamNode, len(s.nodes))
+	for i, n := range s.nodes {
+		entries := make([]StreamEntry, len(n.entries), cap(n.entries))
+		copy(entries, n.entries)
+		c.nodes[i] = &streamNode{entries: entries}
+	}
+	if s.groups != nil {
+		c.groups = make(map[string]*streamGroup, len(s.groups))
+		for name, g := range s.groups {
+			c.groups[name] = g.clone(&c)
+		}
+	}
+	return &c
+}
+
+// MemoryUsage returns the estimated number of bytes used by the stream,
+// extrapolated from the size of its first samples entries. The consumer
+// groups are always fully accounted for.
+func (s *stream) MemoryUsage(samples int) int64 {
+	const (
+		idSize       = 2 * wordSize
+		entrySize    = idSize + sliceHeaderSize
+		groupSize    = stringHeaderSize + wordSize + idSize + wordSize + sliceHeaderSize + wordSize
+		pendingSize  = idSize + stringHeaderSize + 2*wordSize
+		consumerSize = stringHeaderSize + 2*wordSize
+	)
+
+	size := int64(sliceHeaderSize + 3*idSize + 3*wordSize)
+	var sampled []string
+	var sampledEntries, fieldSlots int64
+	max := sampleCount(s.length, samples)
+	for _, n := range s.nodes {
+		size += wordSize + sliceHeaderSize + int64(cap(n.entries))*entrySize
+		for _, e := range n.entries {
+			if sampledEntries == max {
+				break
+			}
+			sampledEntries++
+			fieldSlots += int64(cap(e.Fields))
+			sampled = append(sampled, e.Fields...)
+		}
+	}
+	if sampledEntries > 0 {
+		fields := fieldSlots * s.length / sampledEntries
+		size += fields*stringHeaderSize + sampledSize(int64(len(sampled))*s.length/sampledEntries, sampled)
+	}
+
+	// The names of the groups and consumers share their data with the keys
+	// of the maps that index them.
+	for name, g := range s.groups {
+		size += mapEntrySize(stringHeaderSize+wordSize) + groupSize + int64(len(name))
+		size += int64(len(g.pel)) * (mapEntrySize(idSize+wordSize) + pendingSize + idSize)
+		for cname := range g.consumers {
+			size += mapEntrySize(stringHeaderSize+wordSize) + consumerSize + int64(len(cname))
+		}
+	}
+	return size
+}
+
+// LastID returns the ID of the last entry added to the stream, even if
+// it was deleted since then, or the zero ID if no entry was ever added.
+func (s *stream) LastID() StreamID {
+	return s.lastID
+}
+
+// XAdd appends an entry with the specified ID and field-value pairs. It
+// returns false if the ID is not greater than the last ID of the stream,
+// in which case the entry is not added.
+func (s *stream) XAdd(id StreamID, fields []string) bool {
+	if !s.lastID.Less(id) {
+		return false
+	}
+
+	var last *streamNode
+	if n := len(s.nodes); n > 0 {
+		last = s.nodes[n-1]
+	}
+	if last == nil || len(last.entries) >= streamNodeMaxEntries {
+		last = &streamNode{entries: make([]StreamEntry, 0, 1)}
+		s.nodes = append(s.nodes, last)
+	}
+	last.entries = append(last.entries, StreamEntry{id, fields})
+	s.lastID = id
+	s.length++
+	s.entriesAdded++
+	return true
+}
+
+// XDel deletes the entries with the specified IDs. It returns the number
+// of entries deleted.
+func (s *stream) XDel(ids ...StreamID) int64 {
+	var cnt int64
+	for _, id := range ids {
+		ni, ei, ok := s.find(id)
+		if !ok {
+			continue
+		}
+		n := s.nodes[ni]
+		n.entries = append(n.entries[:ei], n.entries[ei+1:]...)
+		if len(n.entries) == 0 {
+			s.removeNodes(ni, ni+1)
+		}
+		s.length--
+		cnt++
+		if s.maxDeletedID.Less(id) {
+			s.maxDeletedID = id
+		}
+	}
+	return cnt
+}
+
+// XLen returns the number of entries in the stream.
+func (s *stream) XLen() int64 {
+	return s.length
+}
+
+// XRange returns at most count entries (or all entries if count is
+// negative) with an ID between start and end, inclusive. If rev is true,
+// the entries are returned from the highest to the lowest ID.
+func (s *stream) XRange(start, end StreamID, count int64, rev bool) []StreamEntry {
+	ret := []StreamEntry{}
+	if end.Less(start) || count == 0 {
+		return ret
+	}
+
+	if rev {
+		ni, ei := s.seek(end)
+		if ni == len(s.nodes) {
+			// All entries are lower than end, start from the last one
+			if ni == 0 {
+				return ret
+			}
+			ni--
+			ei = len(s.nodes[ni].entries)
+		} else if s.nodes[ni].entries[ei].ID == end {
+			ei++
+		}
+		for ; ni >= 0; ni-- {
+			ents := s.nodes[ni].entries
+			if ei < 0 {
+				ei = len(ents)
+			}
+			for i := ei - 1; i >= 0; i-- {
+				if ents[i].ID.Less(start) {
+					return ret
+				}
+				ret = append(ret, ents[i])
+				if int64(len(ret)) == count {
+					return ret
+				}
+			}
+			ei = -1
+		}
+		return ret
+	}
+
+	ni, ei := s.seek(start)
+	for ; ni < len(s.nodes); ni++ {
+		ents := s.nodes[ni].entries
+		for i := ei; i < len(ents); i++ {
+			if end.Less(ents[i].ID) {
+				return ret
+			}
+			ret = append(ret, ents[i])
+			if int64(len(ret)) == count {
+				return ret
+			}
+		}
+		ei = 0
+	}
+	return ret
+}
+
+// XTrimMaxLen trims the oldest entries so that the stream holds at most
+// maxLen entries. If approx is true, only whole nodes are removed, so the
+// stream may hold more entries than maxLen, and at most limit entries are
+// removed (no limit if limit is 0). It returns the number of entries
+// removed.
+func (s *stream) XTrimMaxLen(maxLen int64, approx bool, limit int64) int64 {
+	return s.trim(func(n *streamNode, whole bool) int {
+		excess := s.length - maxLen
+		if excess <= 0 {
+			return 0
+		}
+		if whole {
+			if excess >= int64(len(n.entries)) {
+				return len(n.entries)
+			}
+			return 0
+		}
+		return int(excess)
+	}, approx, limit)
+}
+
+// XTrimMinID trims the entries with an ID lower than minID. If approx is
+// true, only whole nodes are removed, so the stream may hold entries with
+// an ID lower than minID, and at most limit entries are removed (no limit
+// if limit is 0). It returns the number of entries removed.
+func (s *stream) XTrimMinID(minID StreamID, approx bool, limit int64) int64 {
+	return s.trim(func(n *streamNode, whole bool) int {
+		i := sort.Search(len(n.entries), func(i int) bool {
+			return !n.entries[i].ID.Less(minID)
+		})
+		if whole && i < len(n.entries) {
+			return 0
+		}
+		return i
+	}, approx, limit)
+}
+
+// CreateGroup creates a consumer group with the specified name and ID of
+// the last delivered entry. It returns false if the group already exists.
+func (s *stream) CreateGroup(name string, lastID StreamID) bool {
+	if _, ok := s.groups[name]; ok {
+		return false
+	}
+	if s.groups == nil {
+		s.groups = make(map[string]*streamGroup)
+	}
+	s.groups[name] = newStreamGroup(name, s, lastID)
+	return true
+}
+
+// DestroyGroup deletes the consumer group with the specified name. It
+// returns false if there is no such group.
+func (s *stream) DestroyGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+// Group returns the consumer group with the specified name. It returns
+// false if there is no such group.
+func (s *stream) Group(name string) (StreamGroup, bool) {
+	g, ok := s.groups[name]
+	if !ok {
+		return nil, false
+	}
+	return g, true
+}
+
+// Groups returns the consumer groups of the stream, sorted by name.
+func (s *stream) Groups() []StreamGroup {
+	names := make([]string, 0, len(s.groups))
+	for nm := range s.groups {
+		names = append(names, nm)
+	}
+	sort.Strings(names)
+	ret := make([]StreamGroup, len(names))
+	for i, nm := range names {
+		ret[i] = s.groups[nm]
+	}
+	return ret
+}
+
+// Info returns the information about the stream.
+func (s *stream) Info() StreamInfo {
+	return StreamInfo{
+		Length:       s.length,
+		Nodes:        int64(len(s.nodes)),
+		Groups:       int64(len(s.groups)),
+		LastID:       s.lastID,
+		MaxDeletedID: s.maxDeletedID,
+		EntriesAdded: s.entriesAdded,
+	}
+}
+
+// entry returns the entry with the specified ID. It returns false if there
+// is no such entry.
+func (s *stream) entry(id StreamID) (StreamEntry, bool) {
+	ni, ei, ok := s.find(id)
+	if !ok {
+		return StreamEntry{}, false
+	}
+	return s.nodes[ni].entries[ei], true
+}
+
+// trim removes entries from the first nodes of the stream, as long as
+// the function fn returns a number of entries to remove from the node.
+// The whole argument of fn indicates that only the whole node may be
+// removed. It returns the number of entries removed.
+func (s *stream) trim(fn func(*streamNode, bool) int, approx bool, limit int64) int64 {
+	var cnt int64
+	var ni int
+	for ; ni < len(s.nodes); ni++ {
+		n := s.nodes[ni]
+		rm := fn(n, approx)
+		if rm > len(n.entries) {
+			rm = len(n.entries)
+		}
+		if rm == 0 || (approx && limit > 0 && cnt+int64(rm) > limit) {
+			break
+		}
+		cnt += int64(rm)
+		s.length -= int64(rm)
+		if rm < len(n.entries) {
+			n.entries = append(n.entries[:0:0], n.entries[rm:]...)
+			break
+		}
+	}
+	s.removeNodes(0, ni)
+	return cnt
+}
+
+// removeNodes removes the nodes from index i to j, exclusive.
+func (s *stream) removeNodes(i, j int) {
+	if i >= j {
+		return
+	}
+	n := copy(s.nodes[i:], s.nodes[j:])
+	for k := i + n; k < len(s.nodes); k++ {
+		s.nodes[k] = nil
+	}
+	s.nodes = s.nodes[:i+n]
+}
+
+// seek returns the index of the node and the index of the entry in this
+// node of the first entry with an ID greater than or equal to id. The node
+// index is len(s.nodes) if there is no such entry.
+func (s *stream) seek(id StreamID) (int, int) {
+	ni := sort.Search(len(s.nodes), func(i int) bool {
+		ents := s.nodes[i].entries
+		return !ents[len(ents)-1].ID.Less(id)
+	})
+	if ni == len(s.nodes) {
+		return ni, 0
+	}
+	ents := s.nodes[ni].entries
+	ei := sort.Search(len(ents), func(i int) bool {
+		return !ents[i].ID.Less(id)
+	})
+	return ni, ei
+}
+
+// find returns the index of the node and the index of the entry in this
+// node of the entry with the specified ID. It returns false if there is
+// no such entry.
+func (s *stream) find(id StreamID) (int, int, bool) {
+	ni, ei := s.seek(id)
+	if ni == len(s.nodes) || s.nodes[ni].entries[ei].ID != id {
+		return 0, 0, false
+	}
+	return ni, ei, true
+}
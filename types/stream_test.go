@@ -0,0 +1,276 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+// streamFromIDs creates a stream holding entries with IDs 1-0 to n-0.
+func streamFromIDs(n int) Stream {
+	s := NewStream()
+	for i := 1; i <= n; i++ {
+		s.XAdd(StreamID{uint64(i), 0}, []string{"f", "v"})
+	}
+	return s
+}
+
+// ids returns the milliseconds part of the IDs of the entries.
+func ids(ents []StreamEntry) []uint64 {
+	ret := make([]uint64, len(ents))
+	for i, e := range ents {
+		ret[i] = e.ID.Ms
+	}
+	return ret
+}
+
+func TestStreamType(t *testing.T) {
+	s := NewStream()
+	tp := s.Type()
+	if tp != "stream" {
+		t.Errorf("expected %q, got %q", "stream", tp)
+	}
+}
+
+func TestStreamClone(t *testing.T) {
+	s := streamFromIDs(3)
+	s.CreateGroup("g", StreamID{})
+	g, _ := s.Group("g")
+	g.ReadNew("c1", -1, false, 100)
+
+	c := s.Clone().(Stream)
+	c.XDel(StreamID{1, 0})
+	c.XAdd(StreamID{4, 0}, []string{"f", "v"})
+	cg, _ := c.Group("g")
+	cg.Ack(StreamID{2, 0})
+
+	if got := ids(s.XRange(StreamID{}, MaxStreamID, -1, false)); !reflect.DeepEqual(got, []uint64{1, 2, 3}) {
+		t.Errorf("expected [1 2 3], got %v", got)
+	}
+	if got := ids(c.XRange(StreamID{}, MaxStreamID, -1, false)); !reflect.DeepEqual(got, []uint64{2, 3, 4}) {
+		t.Errorf("expected [2 3 4], got %v", got)
+	}
+	if n, _, _, _ := g.PendingSummary(); n != 3 {
+		t.Errorf("expected 3 pending, got %d", n)
+	}
+	if n, _, _, _ := cg.PendingSummary(); n != 2 {
+		t.Errorf("expected 2 pending, got %d", n)
+	}
+}
+
+func TestParseStreamID(t *testing.T) {
+	cases := []struct {
+		s   string
+		def uint64
+		exp StreamID
+		err error
+	}{
+		0: {"1-2", 0, StreamID{1, 2}, nil},
+		1: {"1", 0, StreamID{1, 0}, nil},
+		2: {"1", 5, StreamID{1, 5}, nil},
+		3: {"0-0", 0, StreamID{0, 0}, nil},
+		4: {"18446744073709551615-18446744073709551615", 0, MaxStreamID, nil},
+		5: {"", 0, StreamID{}, ErrInvalidStreamID},
+		6: {"1-", 0, StreamID{}, ErrInvalidStreamID},
+		7: {"-1", 0, StreamID{}, ErrInvalidStreamID},
+		8: {"a-1", 0, StreamID{}, ErrInvalidStreamID},
+		9: {"1-2-3", 0, StreamID{}, ErrInvalidStreamID},
+	}
+	for i, c := range cases {
+		got, err := ParseStreamID(c.s, c.def)
+		if err != c.err {
+			t.Errorf("%d: expected error %v, got %v", i, c.err, err)
+			continue
+		}
+		if err == nil && got != c.exp {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, got)
+		}
+		if err == nil && got.String() != c.exp.String() {
+			t.Errorf("%d: expected %s, got %s", i, c.exp, got)
+		}
+	}
+}
+
+func TestStreamIDNextPrev(t *testing.T) {
+	id, ok := StreamID{1, 2}.Next()
+	if !ok || id != (StreamID{1, 3}) {
+		t.Errorf("expected 1-3, got %s", id)
+	}
+	id, ok = StreamID{1, MaxStreamID.Seq}.Next()
+	if !ok || id != (StreamID{2, 0}) {
+		t.Errorf("expected 2-0, got %s", id)
+	}
+	if _, ok = MaxStreamID.Next(); ok {
+		t.Errorf("expected no next ID")
+	}
+	id, ok = StreamID{2, 0}.Prev()
+	if !ok || id != (StreamID{1, MaxStreamID.Seq}) {
+		t.Errorf("expected 1-max, got %s", id)
+	}
+	if _, ok = (StreamID{}).Prev(); ok {
+		t.Errorf("expected no previous ID")
+	}
+}
+
+func TestStreamXAdd(t *testing.T) {
+	s := NewStream()
+	cases := []struct {
+		id  StreamID
+		exp bool
+		ln  int64
+	}{
+		0: {StreamID{0, 0}, false, 0},
+		1: {StreamID{0, 1}, true, 1},
+		2: {StreamID{0, 1}, false, 1},
+		3: {StreamID{5, 0}, true, 2},
+		4: {StreamID{4, 10}, false, 2},
+		5: {StreamID{5, 1}, true, 3},
+	}
+	for i, c := range cases {
+		got := s.XAdd(c.id, []string{"a", "b"})
+		if got != c.exp {
+			t.Errorf("%d: expected %t, got %t", i, c.exp, got)
+		}
+		if ln := s.XLen(); ln != c.ln {
+			t.Errorf("%d: expected length %d, got %d", i, c.ln, ln)
+		}
+	}
+	if id := s.LastID(); id != (StreamID{5, 1}) {
+		t.Errorf("expected last ID 5-1, got %s", id)
+	}
+}
+
+func TestStreamXRange(t *testing.T) {
+	s := streamFromIDs(250)
+	cases := []struct {
+		start, end uint64
+		count      int64
+		rev        bool
+		exp        []uint64
+	}{
+		0:  {1, 3, -1, false, []uint64{1, 2, 3}},
+		1:  {1, 3, -1, true, []uint64{3, 2, 1}},
+		2:  {99, 102, -1, false, []uint64{99, 100, 101, 102}},
+		3:  {99, 102, -1, true, []uint64{102, 101, 100, 99}},
+		4:  {99, 102, 2, false, []uint64{99, 100}},
+		5:  {99, 102, 2, true, []uint64{102, 101}},
+		6:  {249, 1000, -1, false, []uint64{249, 250}},
+		7:  {249, 1000, -1, true, []uint64{250, 249}},
+		8:  {0, 0, -1, false, []uint64{}},
+		9:  {300, 400, -1, true, []uint64{}},
+		10: {3, 1, -1, false, []uint64{}},
+		11: {1, 3, 0, false, []uint64{}},
+	}
+	for i, c := range cases {
+		got := s.XRange(StreamID{c.start, 0}, StreamID{c.end, 0}, c.count, c.rev)
+		if !reflect.DeepEqual(ids(got), c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, ids(got))
+		}
+	}
+
+	// The whole range returns all entries
+	got := s.XRange(StreamID{}, MaxStreamID, -1, false)
+	if len(got) != 250 {
+		t.Errorf("expected %d entries, got %d", 250, len(got))
+	}
+	got = s.XRange(StreamID{}, MaxStreamID, -1, true)
+	if len(got) != 250 || got[0].ID.Ms != 250 {
+		t.Errorf("expected %d entries in reverse order, got %d", 250, len(got))
+	}
+}
+
+func TestStreamXDel(t *testing.T) {
+	s := streamFromIDs(150)
+	got := s.XDel(StreamID{1, 0}, StreamID{1, 0}, StreamID{200, 0}, StreamID{150, 0})
+	if got != 2 {
+		t.Errorf("expected %d, got %d", 2, got)
+	}
+	if ln := s.XLen(); ln != 148 {
+		t.Errorf("expected length %d, got %d", 148, ln)
+	}
+	if id := s.LastID(); id != (StreamID{150, 0}) {
+		t.Errorf("expected last ID 150-0, got %s", id)
+	}
+
+	// Delete a whole node
+	for i := 101; i < 150; i++ {
+		s.XDel(StreamID{uint64(i), 0})
+	}
+	ents := s.XRange(StreamID{99, 0}, MaxStreamID, -1, false)
+	if !reflect.DeepEqual(ids(ents), []uint64{99, 100}) {
+		t.Errorf("expected [99 100], got %v", ids(ents))
+	}
+	ents = s.XRange(StreamID{}, MaxStreamID, 2, true)
+	if !reflect.DeepEqual(ids(ents), []uint64{100, 99}) {
+		t.Errorf("expected [100 99], got %v", ids(ents))
+	}
+	if s.XAdd(StreamID{150, 0}, nil) {
+		t.Errorf("expected XAdd with a deleted ID to fail")
+	}
+}
+
+func TestStreamXTrimMaxLen(t *testing.T) {
+	cases := []struct {
+		n      int
+		maxLen int64
+		approx bool
+		limit  int64
+		exp    int64
+		first  uint64
+	}{
+		0: {10, 20, false, 0, 0, 1},
+		1: {10, 5, false, 0, 5, 6},
+		2: {10, 0, false, 0, 10, 0},
+		3: {250, 120, true, 0, 100, 101},
+		4: {250, 140, true, 0, 100, 101},
+		5: {250, 160, true, 0, 0, 1},
+		6: {250, 0, true, 0, 250, 0},
+		7: {250, 0, true, 150, 100, 101},
+		8: {250, 120, false, 0, 130, 131},
+	}
+	for i, c := range cases {
+		s := streamFromIDs(c.n)
+		got := s.XTrimMaxLen(c.maxLen, c.approx, c.limit)
+		if got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+		if ln := s.XLen(); ln != int64(c.n)-c.exp {
+			t.Errorf("%d: expected length %d, got %d", i, int64(c.n)-c.exp, ln)
+		}
+		ents := s.XRange(StreamID{}, MaxStreamID, 1, false)
+		if c.first == 0 {
+			if len(ents) != 0 {
+				t.Errorf("%d: expected empty stream, got %v", i, ids(ents))
+			}
+		} else if len(ents) != 1 || ents[0].ID.Ms != c.first {
+			t.Errorf("%d: expected first ID %d, got %v", i, c.first, ids(ents))
+		}
+	}
+}
+
+func TestStreamXTrimMinID(t *testing.T) {
+	cases := []struct {
+		n      int
+		minID  uint64
+		approx bool
+		limit  int64
+		exp    int64
+	}{
+		0: {10, 1, false, 0, 0},
+		1: {10, 6, false, 0, 5},
+		2: {10, 20, false, 0, 10},
+		3: {250, 150, true, 0, 100},
+		4: {250, 201, true, 0, 200},
+		5: {250, 201, true, 100, 100},
+		6: {250, 150, false, 0, 149},
+	}
+	for i, c := range cases {
+		s := streamFromIDs(c.n)
+		got := s.XTrimMinID(StreamID{c.minID, 0}, c.approx, c.limit)
+		if got != c.exp {
+			t.Errorf("%d: expected %d, got %d", i, c.exp, got)
+		}
+		if ln := s.XLen(); ln != int64(c.n)-c.exp {
+			t.Errorf("%d: expected length %d, got %d", i, int64(c.n)-c.exp, ln)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package types
+
+import "sort"
+
+// StreamGroup defines the methods required to implement a consumer group
+// of a Stream. Times are expressed as milliseconds since the Unix epoch.
+type StreamGroup interface {
+	Name() string
+	LastID() StreamID
+	SetLastID(StreamID)
+
+	Consumers() []StreamConsumer
+	CreateConsumer(string, int64) bool
+	DelConsumer(string) int64
+
+	ReadNew(string, int64, bool, int64) []StreamEntry
+	ReadHistory(string, StreamID, int64, int64) []StreamEntry
+	Ack(...StreamID) int64
+
+	PendingSummary() (int64, StreamID, StreamID, []StreamConsumer)
+	Pending(StreamID, StreamID, int64, string, int64, int64) []PendingEntry
+	Claim(string, []StreamID, int64, ClaimOptions, int64) ([]StreamEntry, []StreamID)
+	AutoClaim(string, StreamID, int64, int64, bool, int64) (StreamID, []StreamEntry, []StreamID)
+}
+
+// StreamConsumer holds the information about a consumer of a StreamGroup.
+type StreamConsumer struct {
+	Name     string
+	Pending  int64
+	SeenTime int64
+}
+
+// PendingEntry is an entry of the pending entries list (PEL) of a
+// StreamGroup, that was delivered to a consumer but not yet acknowledged.
+type PendingEntry struct {
+	ID            StreamID
+	Consumer      string
+	DeliveryTime  int64
+	DeliveryCount int64
+}
+
+// ClaimOptions holds the options of StreamGroup.Claim. DeliveryTime and
+// RetryCount are ignored if they are negative.
+type ClaimOptions struct {
+	DeliveryTime int64
+	RetryCount   int64
+	Force        bool
+	JustID       bool
+}
+
+// Static type check to validate that *streamGroup implements StreamGroup.
+var _ StreamGroup = (*streamGroup)(nil)
+
+// streamGroup is the internal implementation of a StreamGroup. The PEL
+// holds the pending entries by ID, and the sorted list of their IDs is
+// kept for range scans. Each consumer only keeps its number of pending
+// entries, its own entries are found by scanning the PEL of the group.
+type streamGroup struct {
+	name      string
+	s         *stream
+	lastID    StreamID
+	pel       map[StreamID]*PendingEntry
+	pelIDs    []StreamID
+	consumers map[string]*StreamConsumer
+}
+
+func newStreamGroup(name string, s *stream, lastID StreamID) *streamGroup {
+	return &streamGroup{
+		name:      name,
+		s:         s,
+		lastID:    lastID,
+		pel:       make(map[StreamID]*PendingEntry),
+		consumers: make(map[string]*StreamConsumer),
+	}
+}
+
+// clone returns a copy of the group that belongs to the stream s.
+func (g *streamGroup) clone(s *stream) *streamGroup {
+	c := newStreamGroup(g.name, s, g.lastID)
+	for id, pe := range g.pel {
+		cpe := *pe
+		c.pel[id] = &cpe
+	}
+	c.pelIDs = make([]StreamID, len(g.pelIDs))
+	copy(c.pelIDs, g.pelIDs)
+	for name, sc := range g.consumers {
+		csc := *sc
+		c.consumers[name] = &csc
+	}
+	return c
+}
+
+// Name returns the name of the group.
+func (g *streamGroup) Name() string {
+	return g.name
+}
+
+// LastID returns the ID of the last entry delivered to the group.
+func (g *streamGroup) LastID() StreamID {
+	return g.lastID
+}
+
+// SetLastID sets the ID of the last entry delivered to the group.
+func (g *streamGroup) SetLastID(id StreamID) {
+	g.lastID = id
+}
+
+// Consumers returns the consumers of the group, sorted by name.
+func (g *streamGroup) Consumers() []StreamConsumer {
+	ret := make([]StreamConsumer, 0, len(g.consumers))
+	for _, c := range g.consumers {
+		ret = append(ret, *c)
+	}
+	sort.Sort(consumersByName(ret))
+	return ret
+}
+
+// CreateConsumer creates the consumer if it does not exist, and returns
+// true if it was created.
+func (g *streamGroup) CreateConsumer(name string, now int64) bool {
+	if _, ok := g.consumers[name]; ok {
+		return false
+	}
+	g.consumers[name] = &StreamConsumer{Name: name, SeenTime: now}
+	return true
+}
+
+// DelConsumer deletes the consumer and its pending entries. It returns the
+// number of pending entries the consumer had.
+func (g *streamGroup) DelConsumer(name string) int64 {
+	c, ok := g.consumers[name]
+	if !ok {
+		return 0
+	}
+	if c.Pending > 0 {
+		ids := g.pelIDs[:0]
+		for _, id := range g.pelIDs {
+			if g.pel[id].Consumer == name {
+				delete(g.pel, id)
+				continue
+			}
+			ids = append(ids, id)
+		}
+		g.pelIDs = ids
+	}
+	delete(g.consumers, name)
+	return c.Pending
+}
+
+// ReadNew returns at most count entries (or all entries if count is
+// negative) that were never delivered to the group, and delivers them to
+// the consumer. Unless noack is true, they are added to the PEL.
+func (g *streamGroup) ReadNew(consumer string, count int64, noack bool, now int64) []StreamEntry {
+	c := g.consumer(consumer, now)
+	start, ok := g.lastID.Next()
+	if !ok {
+		return []StreamEntry{}
+	}
+	ents := g.s.XRange(start, MaxStreamID, count, false)
+	for _, e := range ents {
+		g.lastID = e.ID
+		if !noack {
+			pe := g.pending(e.ID)
+			g.assign(pe, c)
+			pe.DeliveryTime = now
+			pe.DeliveryCount = 1
+		}
+	}
+	return ents
+}
+
+// ReadHistory returns at most count entries (or all entries if count is
+// negative) of the consumer's pending entries with an ID greater than
+// after, and increments their delivery count. An entry that was deleted
+// from the stream is returned with nil fields.
+func (g *streamGroup) ReadHistory(consumer string, after StreamID, count int64, now int64) []StreamEntry {
+	g.consumer(consumer, now)
+	ret := []StreamEntry{}
+	start, ok := after.Next()
+	if !ok {
+		return ret
+	}
+	for _, id := range g.pelIDs[g.searchPEL(start):] {
+		if int64(len(ret)) == count {
+			break
+		}
+		pe := g.pel[id]
+		if pe.Consumer != consumer {
+			continue
+		}
+		e, ok := g.s.entry(id)
+		if !ok {
+			ret = append(ret, StreamEntry{ID: id})
+			continue
+		}
+		pe.DeliveryTime = now
+		pe.DeliveryCount++
+		ret = append(ret, e)
+	}
+	return ret
+}
+
+// Ack removes the entries with the specified IDs from the PEL. It returns
+// the number of entries removed.
+func (g *streamGroup) Ack(ids ...StreamID) int64 {
+	var cnt int64
+	for _, id := range ids {
+		if g.removePending(id) {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// PendingSummary returns the number of pending entries, the lowest and
+// highest IDs of the pending entries, and the consumers that have pending
+// entries, sorted by name.
+func (g *streamGroup) PendingSummary() (int64, StreamID, StreamID, []StreamConsumer) {
+	n := len(g.pelIDs)
+	if n == 0 {
+		return 0, StreamID{}, StreamID{}, nil
+	}
+	var cs []StreamConsumer
+	for _, c := range g.Consumers() {
+		if c.Pending > 0 {
+			cs = append(cs, c)
+		}
+	}
+	return int64(n), g.pelIDs[0], g.pelIDs[n-1], cs
+}
+
+// Pending returns at most count pending entries (or all pending entries if
+// count is negative) with an ID between start and end, inclusive, that
+// are idle for at least minIdle milliseconds. If consumer is not empty,
+// only the entries of this consumer are returned.
+func (g *streamGroup) Pending(start, end StreamID, count int64, consumer string, minIdle, now int64) []PendingEntry {
+	ret := []PendingEntry{}
+	for _, id := range g.pelIDs[g.searchPEL(start):] {
+		if end.Less(id) || int64(len(ret)) == count {
+			break
+		}
+		pe := g.pel[id]
+		if (consumer != "" && pe.Consumer != consumer) || now-pe.DeliveryTime < minIdle {
+			continue
+		}
+		ret = append(ret, *pe)
+	}
+	return ret
+}
+
+// Claim changes the ownership of the pending entries with the specified
+// IDs to the consumer, if they are idle for at least minIdle milliseconds.
+// Pending entries that were deleted from the stream are removed from the
+// PEL. It returns the claimed entries, with nil fields if opts.JustID is
+// true, and the IDs of the pending entries removed because they were
+// deleted from the stream.
+func (g *streamGroup) Claim(consumer string, ids []StreamID, minIdle int64, opts ClaimOptions, now int64) ([]StreamEntry, []StreamID) {
+	c := g.consumer(consumer, now)
+	dt := now
+	if opts.DeliveryTime >= 0 && opts.DeliveryTime < now {
+		dt = opts.DeliveryTime
+	}
+
+	ret, deleted := []StreamEntry{}, []StreamID{}
+	for _, id := range ids {
+		e, exists := g.s.entry(id)
+		pe, ok := g.pel[id]
+		if !ok {
+			if !opts.Force || !exists {
+				continue
+			}
+			pe = g.pending(id)
+			pe.DeliveryTime = now
+		} else if !exists {
+			g.removePending(id)
+			deleted = append(deleted, id)
+			continue
+		} else if now-pe.DeliveryTime < minIdle {
+			continue
+		}
+
+		g.assign(pe, c)
+		pe.DeliveryTime = dt
+		if opts.RetryCount >= 0 {
+			pe.DeliveryCount = opts.RetryCount
+		} else if !opts.JustID {
+			pe.DeliveryCount++
+		}
+		if opts.JustID {
+			e = StreamEntry{ID: id}
+		}
+		ret = append(ret, e)
+	}
+	return ret, deleted
+}
+
+// AutoClaim claims at most count pending entries with an ID greater than
+// or equal to start that are idle for at least minIdle milliseconds, by
+// scanning at most 10 times count pending entries. It returns the ID to
+// use as start of the next call (the zero ID if the whole PEL was
+// scanned), the claimed entries (with nil fields if justID is true) and
+// the IDs of the pending entries removed because they were deleted from
+// the stream.
+func (g *streamGroup) AutoClaim(consumer string, start StreamID, minIdle, count int64, justID bool, now int64) (StreamID, []StreamEntry, []StreamID) {
+	c := g.consumer(consumer, now)
+
+	claimed, deleted := []StreamEntry{}, []StreamID{}
+	attempts := count * 10
+	i := g.searchPEL(start)
+	for ; i < len(g.pelIDs) && attempts > 0 && int64(len(claimed)) < count; attempts-- {
+		id := g.pelIDs[i]
+		e, ok := g.s.entry(id)
+		if !ok {
+			g.removePending(id)
+			deleted = append(deleted, id)
+			continue
+		}
+		i++
+
+		pe := g.pel[id]
+		if now-pe.DeliveryTime < minIdle {
+			continue
+		}
+		g.assign(pe, c)
+		pe.DeliveryTime = now
+		if !justID {
+			pe.DeliveryCount++
+		} else {
+			e = StreamEntry{ID: id}
+		}
+		claimed = append(claimed, e)
+	}
+
+	var next StreamID
+	if i < len(g.pelIDs) {
+		next = g.pelIDs[i]
+	}
+	return next, claimed, deleted
+}
+
+// consumer returns the consumer with the specified name, creating it if it
+// does not exist, and sets its seen time to now.
+func (g *streamGroup) consumer(name string, now int64) *StreamConsumer {
+	g.CreateConsumer(name, now)
+	c := g.consumers[name]
+	c.SeenTime = now
+	return c
+}
+
+// assign assigns the pending entry to the consumer.
+func (g *streamGroup) assign(pe *PendingEntry, c *StreamConsumer) {
+	if pe.Consumer == c.Name {
+		return
+	}
+	if old, ok := g.consumers[pe.Consumer]; ok {
+		old.Pending--
+	}
+	pe.Consumer = c.Name
+	c.Pending++
+}
+
+// pending returns the pending entry with the specified ID, adding it to
+// the PEL if it does not exist. A new entry is not assigned to any
+// consumer.
+func (g *streamGroup) pending(id StreamID) *PendingEntry {
+	if pe, ok := g.pel[id]; ok {
+		return pe
+	}
+	pe := &PendingEntry{ID: id}
+	g.pel[id] = pe
+	i := g.searchPEL(id)
+	g.pelIDs = append(g.pelIDs, StreamID{})
+	copy(g.pelIDs[i+1:], g.pelIDs[i:])
+	g.pelIDs[i] = id
+	return pe
+}
+
+// removePending removes the entry with the specified ID from the PEL. It
+// returns false if there is no such entry.
+func (g *streamGroup) removePending(id StreamID) bool {
+	pe, ok := g.pel[id]
+	if !ok {
+		return false
+	}
+	if c, ok := g.consumers[pe.Consumer]; ok {
+		c.Pending--
+	}
+	delete(g.pel, id)
+	i := g.searchPEL(id)
+	g.pelIDs = append(g.pelIDs[:i], g.pelIDs[i+1:]...)
+	return true
+}
+
+// searchPEL returns the index of the first ID of the PEL greater than or
+// equal to id.
+func (g *streamGroup) searchPEL(id StreamID) int {
+	return sort.Search(len(g.pelIDs), func(i int) bool {
+		return !g.pelIDs[i].Less(id)
+	})
+}
+
+// consumersByName sorts a list of consumers by name.
+type consumersByName []StreamConsumer
+
+func (c consumersByName) Len() int           { return len(c) }
+func (c consumersByName) Less(i, j int) bool { return c[i].Name < c[j].Name }
+func (c consumersByName) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
@@ -0,0 +1,251 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+// pendingIDs returns the milliseconds part of the IDs of the pending entries.
+func pendingIDs(pes []PendingEntry) []uint64 {
+	ret := make([]uint64, len(pes))
+	for i, pe := range pes {
+		ret[i] = pe.ID.Ms
+	}
+	return ret
+}
+
+func TestStreamGroups(t *testing.T) {
+	s := streamFromIDs(3)
+	if !s.CreateGroup("b", StreamID{}) {
+		t.Errorf("expected group b to be created")
+	}
+	if !s.CreateGroup("a", StreamID{2, 0}) {
+		t.Errorf("expected group a to be created")
+	}
+	if s.CreateGroup("a", StreamID{}) {
+		t.Errorf("expected group a to already exist")
+	}
+
+	gs := s.Groups()
+	if len(gs) != 2 || gs[0].Name() != "a" || gs[1].Name() != "b" {
+		t.Errorf("expected groups [a b], got %v", gs)
+	}
+	g, ok := s.Group("a")
+	if !ok || g.LastID() != (StreamID{2, 0}) {
+		t.Errorf("expected group a with last ID 2-0, got %v %t", g, ok)
+	}
+	if info := s.Info(); info.Groups != 2 || info.Length != 3 || info.EntriesAdded != 3 {
+		t.Errorf("expected 2 groups, 3 entries and 3 entries added, got %+v", info)
+	}
+
+	if !s.DestroyGroup("a") {
+		t.Errorf("expected group a to be destroyed")
+	}
+	if s.DestroyGroup("a") {
+		t.Errorf("expected group a to not exist")
+	}
+	if _, ok := s.Group("a"); ok {
+		t.Errorf("expected group a to not exist")
+	}
+}
+
+func TestStreamGroupReadNew(t *testing.T) {
+	s := streamFromIDs(5)
+	s.CreateGroup("g", StreamID{1, 0})
+	g, _ := s.Group("g")
+
+	cases := []struct {
+		consumer string
+		count    int64
+		noack    bool
+		exp      []uint64
+		pending  int64
+	}{
+		0: {"c1", 2, false, []uint64{2, 3}, 2},
+		1: {"c2", 1, true, []uint64{4}, 2},
+		2: {"c2", -1, false, []uint64{5}, 3},
+		3: {"c1", -1, false, []uint64{}, 3},
+	}
+	for i, c := range cases {
+		got := g.ReadNew(c.consumer, c.count, c.noack, 100)
+		if !reflect.DeepEqual(ids(got), c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, ids(got))
+		}
+		if n, _, _, _ := g.PendingSummary(); n != c.pending {
+			t.Errorf("%d: expected %d pending, got %d", i, c.pending, n)
+		}
+	}
+	if id := g.LastID(); id != (StreamID{5, 0}) {
+		t.Errorf("expected last ID 5-0, got %s", id)
+	}
+
+	n, min, max, cs := g.PendingSummary()
+	exp := []StreamConsumer{{"c1", 2, 100}, {"c2", 1, 100}}
+	if n != 3 || min != (StreamID{2, 0}) || max != (StreamID{5, 0}) || !reflect.DeepEqual(cs, exp) {
+		t.Errorf("unexpected summary %d %s %s %v", n, min, max, cs)
+	}
+}
+
+func TestStreamGroupReadHistory(t *testing.T) {
+	s := streamFromIDs(5)
+	s.CreateGroup("g", StreamID{})
+	g, _ := s.Group("g")
+	g.ReadNew("c1", 3, false, 100)
+	g.ReadNew("c2", -1, false, 100)
+	s.XDel(StreamID{2, 0})
+
+	got := g.ReadHistory("c1", StreamID{}, -1, 200)
+	if !reflect.DeepEqual(ids(got), []uint64{1, 2, 3}) {
+		t.Errorf("expected [1 2 3], got %v", ids(got))
+	}
+	if got[1].Fields != nil {
+		t.Errorf("expected nil fields for deleted entry, got %v", got[1].Fields)
+	}
+	got = g.ReadHistory("c1", StreamID{1, 0}, 1, 200)
+	if !reflect.DeepEqual(ids(got), []uint64{2}) {
+		t.Errorf("expected [2], got %v", ids(got))
+	}
+
+	pes := g.Pending(StreamID{}, MaxStreamID, -1, "c1", 0, 300)
+	if !reflect.DeepEqual(pendingIDs(pes), []uint64{1, 2, 3}) {
+		t.Errorf("expected [1 2 3], got %v", pendingIDs(pes))
+	}
+	if pes[0].DeliveryCount != 2 || pes[0].DeliveryTime != 200 {
+		t.Errorf("expected 2 deliveries at 200, got %+v", pes[0])
+	}
+	if pes[1].DeliveryCount != 1 || pes[1].DeliveryTime != 100 {
+		t.Errorf("expected 1 delivery at 100, got %+v", pes[1])
+	}
+}
+
+func TestStreamGroupAckPending(t *testing.T) {
+	s := streamFromIDs(10)
+	s.CreateGroup("g", StreamID{})
+	g, _ := s.Group("g")
+	g.ReadNew("c1", 5, false, 100)
+	g.ReadNew("c2", -1, false, 200)
+
+	if n := g.Ack(StreamID{1, 0}, StreamID{1, 0}, StreamID{6, 0}, StreamID{20, 0}); n != 2 {
+		t.Errorf("expected %d, got %d", 2, n)
+	}
+
+	cases := []struct {
+		start, end uint64
+		count      int64
+		consumer   string
+		minIdle    int64
+		exp        []uint64
+	}{
+		0: {0, 100, -1, "", 0, []uint64{2, 3, 4, 5, 7, 8, 9, 10}},
+		1: {3, 7, -1, "", 0, []uint64{3, 4, 5, 7}},
+		2: {0, 100, 2, "", 0, []uint64{2, 3}},
+		3: {0, 100, -1, "c2", 0, []uint64{7, 8, 9, 10}},
+		4: {0, 100, -1, "", 250, []uint64{2, 3, 4, 5}},
+		5: {0, 100, -1, "c3", 0, []uint64{}},
+		6: {0, 100, 0, "", 0, []uint64{}},
+	}
+	for i, c := range cases {
+		got := g.Pending(StreamID{c.start, 0}, StreamID{c.end, 0}, c.count, c.consumer, c.minIdle, 400)
+		if !reflect.DeepEqual(pendingIDs(got), c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, pendingIDs(got))
+		}
+	}
+
+	if n := g.DelConsumer("c1"); n != 4 {
+		t.Errorf("expected %d, got %d", 4, n)
+	}
+	if n, _, _, _ := g.PendingSummary(); n != 4 {
+		t.Errorf("expected %d pending, got %d", 4, n)
+	}
+	if cs := g.Consumers(); len(cs) != 1 || cs[0].Name != "c2" {
+		t.Errorf("expected consumers [c2], got %v", cs)
+	}
+}
+
+func TestStreamGroupClaim(t *testing.T) {
+	s := streamFromIDs(5)
+	s.CreateGroup("g", StreamID{})
+	g, _ := s.Group("g")
+	g.ReadNew("c1", 3, false, 100)
+	s.XDel(StreamID{3, 0})
+
+	cases := []struct {
+		ids     []uint64
+		minIdle int64
+		opts    ClaimOptions
+		exp     []uint64
+		count   int64
+	}{
+		0: {[]uint64{1, 2}, 500, ClaimOptions{-1, -1, false, false}, []uint64{}, 1},
+		1: {[]uint64{1, 3}, 50, ClaimOptions{-1, -1, false, false}, []uint64{1}, 2},
+		2: {[]uint64{2}, 0, ClaimOptions{-1, 5, false, true}, []uint64{2}, 5},
+		3: {[]uint64{4, 5}, 0, ClaimOptions{-1, -1, false, false}, []uint64{}, 0},
+		4: {[]uint64{4}, 0, ClaimOptions{-1, -1, true, false}, []uint64{4}, 1},
+		5: {[]uint64{3}, 0, ClaimOptions{-1, -1, true, false}, []uint64{}, 0},
+	}
+	for i, c := range cases {
+		cids := make([]StreamID, len(c.ids))
+		for j, id := range c.ids {
+			cids[j] = StreamID{id, 0}
+		}
+		got, deleted := g.Claim("c2", cids, c.minIdle, c.opts, 200)
+		if !reflect.DeepEqual(ids(got), c.exp) {
+			t.Errorf("%d: expected %v, got %v", i, c.exp, ids(got))
+		}
+		if exp := map[int]int{1: 1}[i]; len(deleted) != exp {
+			t.Errorf("%d: expected %d deleted entries, got %v", i, exp, deleted)
+		}
+		if len(got) > 0 {
+			pes := g.Pending(got[0].ID, got[0].ID, 1, "c2", 0, 200)
+			if len(pes) != 1 || pes[0].DeliveryCount != c.count {
+				t.Errorf("%d: expected delivery count %d, got %v", i, c.count, pes)
+			}
+		}
+	}
+
+	// The deleted entry 3 was removed from the PEL
+	pes := g.Pending(StreamID{}, MaxStreamID, -1, "", 0, 200)
+	if !reflect.DeepEqual(pendingIDs(pes), []uint64{1, 2, 4}) {
+		t.Errorf("expected [1 2 4], got %v", pendingIDs(pes))
+	}
+	for _, c := range g.Consumers() {
+		exp := map[string]int64{"c1": 0, "c2": 3}[c.Name]
+		if c.Pending != exp {
+			t.Errorf("expected %d pending for %s, got %d", exp, c.Name, c.Pending)
+		}
+	}
+}
+
+func TestStreamGroupAutoClaim(t *testing.T) {
+	s := streamFromIDs(30)
+	s.CreateGroup("g", StreamID{})
+	g, _ := s.Group("g")
+	g.ReadNew("c1", 10, false, 100)
+	g.ReadNew("c1", -1, false, 300)
+	s.XDel(StreamID{2, 0}, StreamID{3, 0})
+
+	next, got, deleted := g.AutoClaim("c2", StreamID{}, 150, 3, false, 400)
+	if next != (StreamID{6, 0}) {
+		t.Errorf("expected next 6-0, got %s", next)
+	}
+	if !reflect.DeepEqual(ids(got), []uint64{1, 4, 5}) {
+		t.Errorf("expected [1 4 5], got %v", ids(got))
+	}
+	if len(deleted) != 2 || deleted[0] != (StreamID{2, 0}) || deleted[1] != (StreamID{3, 0}) {
+		t.Errorf("expected deleted [2-0 3-0], got %v", deleted)
+	}
+
+	// Scans at most 10 times count entries
+	next, got, _ = g.AutoClaim("c2", next, 150, 1, true, 400)
+	if next != (StreamID{7, 0}) || !reflect.DeepEqual(ids(got), []uint64{6}) || got[0].Fields != nil {
+		t.Errorf("expected next 7-0 and [6] without fields, got %s %v", next, got)
+	}
+	next, got, _ = g.AutoClaim("c2", StreamID{11, 0}, 150, 1, false, 400)
+	if next != (StreamID{21, 0}) || len(got) != 0 {
+		t.Errorf("expected next 21-0 and no entry, got %s %v", next, ids(got))
+	}
+	next, got, _ = g.AutoClaim("c2", StreamID{7, 0}, 0, 100, false, 400)
+	if next != (StreamID{}) || len(got) != 24 {
+		t.Errorf("expected next 0-0 and 24 entries, got %s %d", next, len(got))
+	}
+}
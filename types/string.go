@@ -5,10 +5,17 @@ type String interface {
 	Value
 
 	Append(string) int64
+	BitCount(BitRange) int64
+	BitFieldGet(BitField) int64
+	BitFieldIncrBy(BitField, int64, BitFieldOverflow) (int64, bool)
+	BitFieldSet(BitField, int64, BitFieldOverflow) (int64, bool)
+	BitPos(int, BitRange, bool) int64
 	Get() string
+	GetBit(int64) int
 	GetRange(int64, int64) string
 	GetSet(string) string
 	Set(string)
+	SetBit(int64, int) int
 	SetRange(int64, string) int64
 	StrLen() int64
 }
@@ -27,6 +34,17 @@ func (s *stringval) Type() string {
 	return "string"
 }
 
+// Clone returns a copy of the string.
+func (s *stringval) Clone() Value {
+	c := *s
+	return &c
+}
+
+// MemoryUsage returns the estimated number of bytes used by the string.
+func (s *stringval) MemoryUsage(samples int) int64 {
+	return stringHeaderSize + int64(len(*s))
+}
+
 // Append appends the value v to the current string value.
 // It returns the new length of the string.
 func (s *stringval) Append(v string) int64 {
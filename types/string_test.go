@@ -10,6 +10,18 @@ func TestStringType(t *testing.T) {
 	}
 }
 
+func TestStringClone(t *testing.T) {
+	s := NewString("abc")
+	c := s.Clone().(String)
+	c.Append("d")
+	if got := s.Get(); got != "abc" {
+		t.Errorf("expected %q, got %q", "abc", got)
+	}
+	if got := c.Get(); got != "abcd" {
+		t.Errorf("expected %q, got %q", "abcd", got)
+	}
+}
+
 func TestStringAppend(t *testing.T) {
 	cases := []struct {
 		s   string
@@ -7,6 +7,16 @@ package types
 // Value is the common interface implemented by all Redis values.
 type Value interface {
 	Type() string
+
+	// Clone returns a deep copy of the value, that shares no mutable
+	// state with the original.
+	Clone() Value
+
+	// MemoryUsage returns the estimated number of bytes used by the value.
+	// The size of the elements of an aggregate value is extrapolated from
+	// that of its first samples elements, or computed from all elements if
+	// samples is 0.
+	MemoryUsage(samples int) int64
 }
 
 // empty is allocated once and reused by all commands that must return